@@ -33,6 +33,12 @@ func NewGateway(d *daemon.Daemon, v *visor.Visor, w *wallet.Service, m *kvstorag
 	}
 }
 
+// GetWalletBalance returns the balance of a wallet from the visor.
+// wallet.Service has a method with the same name, so the visor method is selected explicitly.
+func (gw *Gateway) GetWalletBalance(wltID string) (wallet.BalancePair, wallet.AddressBalances, error) {
+	return gw.Visor.GetWalletBalance(wltID)
+}
+
 // Gatewayer interface for Gateway methods
 type Gatewayer interface {
 	Daemoner
@@ -0,0 +1,167 @@
+package encrypt
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+
+	"github.com/amherag/skycoin/src/cipher"
+	"github.com/amherag/skycoin/src/cipher/chacha20poly1305"
+	"github.com/amherag/skycoin/src/cipher/pbkdf2"
+)
+
+const (
+	pbkdf2Chacha20MetaLengthSize = 2  // meta data length field size in bytes
+	pbkdf2Chacha20SaltSize       = 32 // salt bytes number
+)
+
+// Default pbkdf2 paramenters
+const (
+	// Pbkdf2Iterations: PBKDF2-HMAC-SHA256 iteration count, the OWASP recommendation for PBKDF2-HMAC-SHA256.
+	Pbkdf2Iterations = 600000
+	// Pbkdf2KeyLen: The length of returned byte slice that can be used as cryptographic key.
+	Pbkdf2KeyLen = 32
+)
+
+// DefaultPbkdf2Chacha20poly1305 default Pbkdf2Chacha20poly1305 encryptor
+var DefaultPbkdf2Chacha20poly1305 = Pbkdf2Chacha20poly1305{
+	Iterations: Pbkdf2Iterations,
+	KeyLen:     Pbkdf2KeyLen,
+}
+
+// Pbkdf2Chacha20poly1305 provides methods for encryption/decryption with PBKDF2-HMAC-SHA256 and chacha20poly1305,
+// for environments where scrypt is not an approved key derivation function
+type Pbkdf2Chacha20poly1305 struct {
+	Iterations int
+	KeyLen     int
+}
+
+type pbkdf2Meta struct {
+	Iterations int    `json:"iterations"`
+	KeyLen     int    `json:"keyLen"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+}
+
+// Encrypt encrypts data with password,
+// 1. PBKDF2-HMAC-SHA256 derives the key from password
+// 2. Chacha20poly1305 generates AEAD from the derived key
+// 3. Puts pbkdf2 paramenters, salt and nonce into metadata, json serialize it and get the serialized metadata length
+// 4. AEAD.Seal encrypts the data, and use [length][metadata] as additional data
+// 5. Final format: base64([[length][metadata]][ciphertext]), length is 2 bytes.
+func (s Pbkdf2Chacha20poly1305) Encrypt(data, password []byte) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, errors.New("missing password")
+	}
+
+	if s.Iterations <= 0 {
+		return nil, errors.New("invalid pbkdf2 iterations")
+	}
+
+	// PBKDF2 derives key from password
+	salt := cipher.RandByte(pbkdf2Chacha20SaltSize)
+	dk := pbkdf2.Key(password, salt, s.Iterations, s.KeyLen, sha256.New)
+
+	// Prepare metadata
+	m := pbkdf2Meta{
+		Iterations: s.Iterations,
+		KeyLen:     s.KeyLen,
+		Salt:       salt,
+		Nonce:      cipher.RandByte(chacha20poly1305.NonceSize),
+	}
+	// json serialize the metadata
+	ms, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ms) > math.MaxUint16 {
+		return nil, errors.New("metadata length beyond the math.MaxUint16")
+	}
+
+	length := make([]byte, pbkdf2Chacha20MetaLengthSize)
+	binary.LittleEndian.PutUint16(length, uint16(len(ms)))
+
+	// Additional data for AEAD
+	ad := append(length, ms...)
+	aead, err := chacha20poly1305.New(dk)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := aead.Seal(nil, m.Nonce, data, ad)
+
+	// Base64 encode the [[length][metadata]][ciphertext]
+	rawData := append(ad, ciphertext...)
+	enc := base64.StdEncoding
+	buf := make([]byte, enc.EncodedLen(len(rawData)))
+	enc.Encode(buf, rawData)
+	return buf, nil
+}
+
+// Decrypt decrypts the data with password
+// 1. Base64 decodes the data
+// 2. Reads the first [metaLengthSize] bytes data to get the metadata length, and reads out the metadata.
+// 3. PBKDF2-HMAC-SHA256 derives key from password and paramenters in metadata
+// 4. Chacha20poly1305 geneates AEAD
+// 5. AEAD decrypts ciphertext with nonce in metadata and [length][metadata] as additional data.
+func (s Pbkdf2Chacha20poly1305) Decrypt(data, password []byte) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, errors.New("missing password")
+	}
+
+	enc := base64.StdEncoding
+	encData := make([]byte, enc.DecodedLen(len(data)))
+	n, err := enc.Decode(encData, data)
+	if err != nil {
+		return nil, err
+	}
+	encData = encData[:n]
+
+	if len(encData) < pbkdf2Chacha20MetaLengthSize {
+		return nil, errors.New("invalid metadata length")
+	}
+
+	length := binary.LittleEndian.Uint16(encData[:pbkdf2Chacha20MetaLengthSize])
+	if int(pbkdf2Chacha20MetaLengthSize+length) > len(encData) {
+		return nil, errors.New("invalid metadata length")
+	}
+
+	var m pbkdf2Meta
+	if err := json.Unmarshal(encData[pbkdf2Chacha20MetaLengthSize:pbkdf2Chacha20MetaLengthSize+length], &m); err != nil {
+		return nil, err
+	}
+
+	if m.Iterations <= 0 {
+		return nil, errors.New("invalid pbkdf2 iterations")
+	}
+
+	ad := encData[:pbkdf2Chacha20MetaLengthSize+length]
+	// PBKDF2 derives key
+	dk := pbkdf2.Key(password, m.Salt, m.Iterations, m.KeyLen, sha256.New)
+
+	// Geneates AEAD
+	aead, err := chacha20poly1305.New(dk)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Open(nil, m.Nonce, encData[pbkdf2Chacha20MetaLengthSize+length:], ad)
+}
+
+// Pbkdf2Chacha20poly1305Params returns the PBKDF2 parameters data was encrypted with,
+// read from its metadata without decrypting it
+func Pbkdf2Chacha20poly1305Params(data []byte) (Pbkdf2Chacha20poly1305, error) {
+	var m pbkdf2Meta
+	if err := readMetadata(data, pbkdf2Chacha20MetaLengthSize, &m); err != nil {
+		return Pbkdf2Chacha20poly1305{}, err
+	}
+
+	return Pbkdf2Chacha20poly1305{
+		Iterations: m.Iterations,
+		KeyLen:     m.KeyLen,
+	}, nil
+}
@@ -0,0 +1,120 @@
+package encrypt
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPbkdf2Chacha20poly1305Encrypt(t *testing.T) {
+	for _, iter := range []int{1, 1000, 10000} {
+		name := fmt.Sprintf("iterations=%v keyLen=%v", iter, 32)
+		t.Run(name, func(t *testing.T) {
+			crypto := Pbkdf2Chacha20poly1305{Iterations: iter, KeyLen: 32}
+			encData, err := crypto.Encrypt([]byte("plaintext"), []byte("password"))
+			require.NoError(t, err)
+
+			data, err := base64.StdEncoding.DecodeString(string(encData))
+			require.NoError(t, err)
+			// Checks the prefix
+			ml := binary.LittleEndian.Uint16(data[:pbkdf2Chacha20MetaLengthSize])
+			require.True(t, int(pbkdf2Chacha20MetaLengthSize+ml) <= len(data))
+			var m pbkdf2Meta
+			require.NoError(t, json.Unmarshal(data[pbkdf2Chacha20MetaLengthSize:pbkdf2Chacha20MetaLengthSize+ml], &m))
+			require.Equal(t, iter, m.Iterations)
+			require.Equal(t, 32, m.KeyLen)
+			require.Len(t, m.Salt, pbkdf2Chacha20SaltSize)
+
+			// Round trip
+			plaintext, err := crypto.Decrypt(encData, []byte("password"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("plaintext"), plaintext)
+		})
+	}
+
+	_, err := Pbkdf2Chacha20poly1305{KeyLen: 32}.Encrypt([]byte("plaintext"), []byte("password"))
+	require.Equal(t, errors.New("invalid pbkdf2 iterations"), err)
+	_, err = DefaultPbkdf2Chacha20poly1305.Encrypt([]byte("plaintext"), nil)
+	require.Equal(t, errors.New("missing password"), err)
+}
+
+func TestPbkdf2Chacha20poly1305Decrypt(t *testing.T) {
+	// Encrypted with Pbkdf2Chacha20poly1305{Iterations: 1000, KeyLen: 32}
+	encData := []byte("cAB7Iml0ZXJhdGlvbnMiOjEwMDAsImtleUxlbiI6MzIsInNhbHQiOiJ4OHczakVsaW84SHB3eE5CWWh1OHovK1Ura0xiZWNEdVpUbkg4OFpUa2VrPSIsIm5vbmNlIjoiS3puMDhaeHZtS2wyQ1RRNSJ9PQFAf2XajlEi40H/pHxSwhKP1iX9pFYCxw==")
+
+	tt := []struct {
+		name    string
+		data    []byte
+		encData []byte
+		decPwd  []byte
+		err     error
+	}{
+		{
+			name:    "ok",
+			data:    []byte("plaintext"),
+			encData: encData,
+			decPwd:  []byte("pwd"),
+			err:     nil,
+		},
+		{
+			name:    "invalid password",
+			data:    []byte("plaintext"),
+			encData: encData,
+			decPwd:  []byte("wrong password"),
+			err:     errors.New("chacha20poly1305: message authentication failed"),
+		},
+		{
+			name:    "missing password",
+			data:    []byte("plaintext"),
+			encData: encData,
+			decPwd:  nil,
+			err:     errors.New("missing password"),
+		},
+		{
+			name:    "truncated",
+			encData: []byte("cA=="),
+			decPwd:  []byte("pwd"),
+			err:     errors.New("invalid metadata length"),
+		},
+		{
+			name:    "zero iterations",
+			encData: []byte(base64.StdEncoding.EncodeToString(append([]byte{16, 0}, []byte(`{"iterations":0}`)...))),
+			decPwd:  []byte("pwd"),
+			err:     errors.New("invalid pbkdf2 iterations"),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// The parameters are read from the encrypted data
+			crypto := Pbkdf2Chacha20poly1305{}
+			data, err := crypto.Decrypt(tc.encData, tc.decPwd)
+			require.Equal(t, tc.err, err)
+			if err != nil {
+				return
+			}
+
+			require.Equal(t, tc.data, data)
+		})
+	}
+}
+
+func TestPbkdf2Chacha20poly1305Params(t *testing.T) {
+	crypto := Pbkdf2Chacha20poly1305{Iterations: 1000, KeyLen: 32}
+	encData, err := crypto.Encrypt([]byte("plaintext"), []byte("password"))
+	require.NoError(t, err)
+
+	params, err := Pbkdf2Chacha20poly1305Params(encData)
+	require.NoError(t, err)
+	require.Equal(t, crypto, params)
+
+	_, err = Pbkdf2Chacha20poly1305Params([]byte("AA=="))
+	require.Equal(t, errors.New("invalid metadata length"), err)
+	_, err = Pbkdf2Chacha20poly1305Params([]byte("!"))
+	require.Error(t, err)
+}
@@ -4,6 +4,7 @@ Package encrypt provides encryption methods.
 Encryption methods provided:
 
 * chacha20-poly1305 with scrypt key derivation
+* chacha20-poly1305 with PBKDF2-HMAC-SHA256 key derivation
 * sha256xor with sha256 key derivation
 
 The latter is insecure due to the insecure key derivation so should not be used.
@@ -169,3 +170,41 @@ func (s ScryptChacha20poly1305) Decrypt(data, password []byte) ([]byte, error) {
 
 	return aead.Open(nil, m.Nonce, encData[scryptChacha20MetaLengthSize+length:], ad)
 }
+
+// ScryptChacha20poly1305Params returns the scrypt parameters data was encrypted with,
+// read from its metadata without decrypting it
+func ScryptChacha20poly1305Params(data []byte) (ScryptChacha20poly1305, error) {
+	var m meta
+	if err := readMetadata(data, scryptChacha20MetaLengthSize, &m); err != nil {
+		return ScryptChacha20poly1305{}, err
+	}
+
+	return ScryptChacha20poly1305{
+		N:      m.N,
+		R:      m.R,
+		P:      m.P,
+		KeyLen: m.KeyLen,
+	}, nil
+}
+
+// readMetadata base64 decodes data and json deserializes its [length][metadata] prefix into m
+func readMetadata(data []byte, lengthSize int, m interface{}) error {
+	enc := base64.StdEncoding
+	encData := make([]byte, enc.DecodedLen(len(data)))
+	n, err := enc.Decode(encData, data)
+	if err != nil {
+		return err
+	}
+	encData = encData[:n]
+
+	if len(encData) < lengthSize {
+		return errors.New("invalid metadata length")
+	}
+
+	length := int(binary.LittleEndian.Uint16(encData[:lengthSize]))
+	if lengthSize+length > len(encData) {
+		return errors.New("invalid metadata length")
+	}
+
+	return json.Unmarshal(encData[lengthSize:lengthSize+length], m)
+}
@@ -83,3 +83,16 @@ func TestScryptChacha20poly1305Decrypt(t *testing.T) {
 		})
 	}
 }
+
+func TestScryptChacha20poly1305Params(t *testing.T) {
+	// Encrypted with N: 1<<19, R: 8, P: 1, KeyLen: 32
+	encData := []byte("dQB7Im4iOjUyNDI4OCwiciI6OCwicCI6MSwia2V5TGVuIjozMiwic2FsdCI6ImpiejUrSFNjTFFLWkI5T0tYblNNRmt2WDBPY3JxVGZ0ZFpDNm9KUFpaeHc9Iiwibm9uY2UiOiJLTlhOQmRQa1ZUWHZYNHdoIn3PQFmOot0ETxTuv//skTG7Q57UVamGCgG5")
+	params, err := ScryptChacha20poly1305Params(encData)
+	require.NoError(t, err)
+	require.Equal(t, ScryptChacha20poly1305{N: 1 << 19, R: 8, P: 1, KeyLen: 32}, params)
+
+	_, err = ScryptChacha20poly1305Params([]byte("AA=="))
+	require.Equal(t, errors.New("invalid metadata length"), err)
+	_, err = ScryptChacha20poly1305Params([]byte("!"))
+	require.Error(t, err)
+}
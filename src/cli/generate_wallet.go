@@ -46,7 +46,7 @@ If no wallet name is specified a generic name will be selected.`)
 	walletCreateCmd.Flags().StringP("label", "l", "", "Label used to idetify your wallet.")
 	walletCreateCmd.Flags().BoolP("encrypt", "e", false, "Create encrypted wallet.")
 	walletCreateCmd.Flags().StringP("crypto-type", "x", string(wallet.CryptoTypeScryptChacha20poly1305),
-		"The crypto type for wallet encryption, can be scrypt-chacha20poly1305 or sha256-xor")
+		"The crypto type for wallet encryption, can be scrypt-chacha20poly1305, pbkdf2-chacha20poly1305 or sha256-xor")
 	walletCreateCmd.Flags().StringP("password", "p", "", "Wallet password")
 
 	return walletCreateCmd
@@ -0,0 +1,97 @@
+package bitmapfont
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func render(img *image.Gray) string {
+	b := img.Bounds()
+	rows := make([]string, b.Dy())
+	for y := range rows {
+		var sb strings.Builder
+		for x := 0; x < b.Dx(); x++ {
+			if img.GrayAt(b.Min.X+x, b.Min.Y+y).Y == 0 {
+				sb.WriteByte('#')
+			} else {
+				sb.WriteByte('.')
+			}
+		}
+		rows[y] = sb.String()
+	}
+	return strings.Join(rows, "\n")
+}
+
+func newImage(w, h int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	return img
+}
+
+func TestGlyphs(t *testing.T) {
+	for r, g := range glyphs {
+		require.True(t, len(g) <= GlyphHeight, "%q", r)
+		for _, row := range g {
+			require.Len(t, row, GlyphWidth, "%q", r)
+			require.Empty(t, strings.Trim(row, ".#"), "%q", r)
+		}
+	}
+
+	for r := 'a'; r <= 'z'; r++ {
+		require.True(t, Supported(r))
+		require.True(t, Supported(r-'a'+'A'))
+	}
+	for r := '0'; r <= '9'; r++ {
+		require.True(t, Supported(r))
+	}
+	require.False(t, Supported('~'))
+	require.False(t, Supported('é'))
+}
+
+func TestWidth(t *testing.T) {
+	require.Equal(t, 0, Width("", 1))
+	require.Equal(t, GlyphWidth, Width("a", 1))
+	require.Equal(t, 2*Advance+GlyphWidth, Width("abc", 1))
+	require.Equal(t, 3*(2*Advance+GlyphWidth), Width("abc", 3))
+	require.Equal(t, Width("ab", 1), Width("aé", 1))
+}
+
+func TestDraw(t *testing.T) {
+	img := newImage(Width("Hi", 1), GlyphHeight)
+	Draw(img, 0, 0, "Hi", 1, color.Black)
+	require.Equal(t, strings.Join([]string{
+		"#...#...#..",
+		"#...#......",
+		"#...#..##..",
+		"#####...#..",
+		"#...#...#..",
+		"#...#...#..",
+		"#...#..###.",
+		"...........",
+		"...........",
+	}, "\n"), render(img))
+
+	// Scaled and offset
+	img = newImage(GlyphWidth*2+2, GlyphHeight*2+1)
+	Draw(img, 1, 1, "-", 2, color.Black)
+	expect := make([]string, GlyphHeight*2+1)
+	for i := range expect {
+		expect[i] = strings.Repeat(".", GlyphWidth*2+2)
+	}
+	expect[7] = "." + strings.Repeat("#", GlyphWidth*2) + "."
+	expect[8] = expect[7]
+	require.Equal(t, strings.Join(expect, "\n"), render(img))
+
+	// Unsupported characters are drawn as '?'
+	img = newImage(GlyphWidth, GlyphHeight)
+	img2 := newImage(GlyphWidth, GlyphHeight)
+	Draw(img, 0, 0, "é", 1, color.Black)
+	Draw(img2, 0, 0, "?", 1, color.Black)
+	require.Equal(t, render(img2), render(img))
+}
@@ -149,22 +149,74 @@ func SaveJSONSafe(filename string, thing interface{}, mode os.FileMode) error {
 	return err
 }
 
-// SaveBinary persists data into given file in binary,
-// backup the previous file, if there was one
+// SaveBinary persists data into given file in binary.
+// The data is written and synced to a temporary file in the same directory, which is then renamed
+// over the file and the directory is synced, so that after a crash or while another save of the same
+// file is in progress the file has either the old or the new data, never a part of it.
 func SaveBinary(filename string, data []byte, mode os.FileMode) error {
-	// Write the new file to a temporary
-	tmpname := filename + ".tmp"
-	if err := ioutil.WriteFile(tmpname, data, mode); err != nil {
+	// Write the new file to a temporary, named uniquely so that concurrent saves don't share it
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, base+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpname := f.Name()
+
+	if err := writeSync(f, data, mode); err != nil {
+		if removeErr := os.Remove(tmpname); removeErr != nil {
+			logger.WithError(removeErr).Warningf("os.Remove(%s) failed", tmpname)
+		}
+		return err
+	}
+
+	// Replace the target file with the temporary
+	if err := os.Rename(tmpname, filename); err != nil {
+		if removeErr := os.Remove(tmpname); removeErr != nil {
+			logger.WithError(removeErr).Warningf("os.Remove(%s) failed", tmpname)
+		}
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// writeSync writes data to f, sets its mode, syncs it to disk and closes it
+func writeSync(f *os.File, data []byte, mode os.FileMode) error {
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
 		return err
 	}
 
-	// Write the new file to the target wallet file
-	if err := ioutil.WriteFile(filename, data, mode); err != nil {
+	return f.Close()
+}
+
+// syncDir syncs a directory, so that a rename of a file in it is persisted.
+// Directories can't be synced on Windows, where this does nothing.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
 		return err
 	}
+	defer d.Close()
 
-	// Remove the tmp file
-	return os.Remove(tmpname)
+	return d.Sync()
 }
 
 //TODO: require file named after application and then hashcode, in static directory
@@ -3,9 +3,12 @@ package file
 import (
 	"bytes"
 	"crypto/rand"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	"encoding/json"
@@ -212,3 +215,65 @@ func TestSaveBinary(t *testing.T) {
 	requireFileMode(t, fn, 0644)
 	// requireFileMode(t, fn+".bak", 0644)
 }
+
+func TestSaveBinaryConcurrent(t *testing.T) {
+	fn := "test.bin"
+	defer cleanup(fn)
+
+	datas := make([][]byte, 8)
+	for i := range datas {
+		datas[i] = bytes.Repeat([]byte{byte('a' + i)}, 1<<20)
+	}
+	require.NoError(t, SaveBinary(fn, datas[0], 0644))
+
+	// Readers always see the complete data of one of the saves
+	done := make(chan struct{})
+	readErrs := make(chan error, 1)
+	go func() {
+		defer close(readErrs)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			b, err := ioutil.ReadFile(fn)
+			if err != nil {
+				readErrs <- err
+				return
+			}
+			if len(b) != 1<<20 || !bytes.Equal(b, bytes.Repeat(b[:1], len(b))) {
+				readErrs <- fmt.Errorf("read partial file of length %d", len(b))
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	saveErrs := make(chan error, len(datas))
+	for _, data := range datas {
+		wg.Add(1)
+		go func(data []byte) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if err := SaveBinary(fn, data, 0644); err != nil {
+					saveErrs <- err
+					return
+				}
+			}
+		}(data)
+	}
+	wg.Wait()
+	close(done)
+	close(saveErrs)
+	for err := range saveErrs {
+		require.NoError(t, err)
+	}
+	require.NoError(t, <-readErrs)
+
+	tmps, err := filepath.Glob(fn + ".tmp*")
+	require.NoError(t, err)
+	require.Empty(t, tmps)
+	requireFileMode(t, fn, 0644)
+}
@@ -0,0 +1,636 @@
+// Package qrcode implements a QR code encoder for binary data (byte mode)
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// Level is the error correction level of a QR code
+type Level int
+
+// Error correction levels
+const (
+	// Low recovers ~7% of the codewords
+	Low Level = iota
+	// Medium recovers ~15% of the codewords
+	Medium
+	// Quartile recovers ~25% of the codewords
+	Quartile
+	// High recovers ~30% of the codewords
+	High
+)
+
+const (
+	minVersion = 1
+	maxVersion = 40
+	// quietZone is the number of light modules drawn around the code in images
+	quietZone = 4
+)
+
+var (
+	// ErrDataTooLong is returned if the data does not fit in the largest QR code at the given level
+	ErrDataTooLong = errors.New("data too long for a QR code")
+	// ErrInvalidLevel is returned for unknown error correction levels
+	ErrInvalidLevel = errors.New("invalid error correction level")
+	// ErrInvalidScale is returned if the image scale is not positive
+	ErrInvalidScale = errors.New("scale must be positive")
+)
+
+// formatBits are the error correction level bits of the format information
+var formatBits = [4]uint{1, 0, 3, 2}
+
+// eccCodewordsPerBlock is indexed by level and version-1
+var eccCodewordsPerBlock = [4][40]int{
+	{7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// numErrorCorrectionBlocks is indexed by level and version-1
+var numErrorCorrectionBlocks = [4][40]int{
+	{1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// Code is an encoded QR code
+type Code struct {
+	version  int
+	size     int
+	level    Level
+	modules  [][]bool
+	function [][]bool
+}
+
+// Encode encodes data as a QR code with the given error correction level,
+// using the smallest version that fits the data
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < Low || level > High {
+		return nil, ErrInvalidLevel
+	}
+
+	for version := minVersion; version <= maxVersion; version++ {
+		if dataBits(version, len(data)) <= numDataCodewords(version, level)*8 {
+			return encode(data, version, level, -1), nil
+		}
+	}
+
+	return nil, ErrDataTooLong
+}
+
+// Version returns the version of the QR code, between 1 and 40
+func (c *Code) Version() int {
+	return c.version
+}
+
+// Size returns the width and height of the QR code in modules, excluding the quiet zone
+func (c *Code) Size() int {
+	return c.size
+}
+
+// Dark returns true if the module at x, y is dark. Coordinates outside of the code are light.
+func (c *Code) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.size || y >= c.size {
+		return false
+	}
+	return c.modules[y][x]
+}
+
+// Image renders the QR code with a quiet zone, drawing each module as a scale x scale square
+func (c *Code) Image(scale int) (image.Image, error) {
+	if scale <= 0 {
+		return nil, ErrInvalidScale
+	}
+
+	n := (c.size + 2*quietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, n, n))
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			v := color.White
+			if c.Dark(x/scale-quietZone, y/scale-quietZone) {
+				v = color.Black
+			}
+			img.SetGray(x, y, color.GrayModel.Convert(v).(color.Gray))
+		}
+	}
+
+	return img, nil
+}
+
+// PNG renders the QR code as a PNG image, see Image
+func (c *Code) PNG(scale int) ([]byte, error) {
+	img, err := c.Image(scale)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encode builds the QR code of a version that is known to fit the data.
+// If mask is negative, the mask with the lowest penalty is chosen.
+func encode(data []byte, version int, level Level, mask int) *Code {
+	c := &Code{
+		version: version,
+		size:    version*4 + 17,
+		level:   level,
+	}
+
+	c.modules = make([][]bool, c.size)
+	c.function = make([][]bool, c.size)
+	for i := range c.modules {
+		c.modules[i] = make([]bool, c.size)
+		c.function[i] = make([]bool, c.size)
+	}
+
+	c.drawFunctionPatterns()
+	c.drawCodewords(addEccAndInterleave(encodeData(data, version, level), version, level))
+
+	if mask < 0 {
+		minPenalty := -1
+		for i := 0; i < 8; i++ {
+			c.applyMask(i)
+			c.drawFormatBits(i)
+			if penalty := c.penalty(); minPenalty < 0 || penalty < minPenalty {
+				mask = i
+				minPenalty = penalty
+			}
+			// applying a mask twice reverts it
+			c.applyMask(i)
+		}
+	}
+
+	c.applyMask(mask)
+	c.drawFormatBits(mask)
+	c.function = nil
+
+	return c
+}
+
+// charCountBits returns the length of the character count field in byte mode
+func charCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// dataBits returns the number of bits used by n bytes of data in byte mode
+func dataBits(version, n int) int {
+	if n >= 1<<uint(charCountBits(version)) {
+		return 1 << 30
+	}
+	return 4 + charCountBits(version) + n*8
+}
+
+// numRawDataModules returns the number of modules available to store data and error correction codewords
+func numRawDataModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		n -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// numDataCodewords returns the number of 8-bit data codewords of the given version and level
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 -
+		eccCodewordsPerBlock[level][version-1]*numErrorCorrectionBlocks[level][version-1]
+}
+
+// encodeData builds the padded data codewords for data in byte mode
+func encodeData(data []byte, version int, level Level) []byte {
+	var bb bitBuffer
+	bb.append(4, 4)
+	bb.append(uint(len(data)), charCountBits(version))
+	for _, b := range data {
+		bb.append(uint(b), 8)
+	}
+
+	capacity := numDataCodewords(version, level) * 8
+
+	// Terminator and padding to a byte boundary
+	terminator := capacity - bb.len()
+	if terminator > 4 {
+		terminator = 4
+	}
+	bb.append(0, terminator)
+	bb.append(0, (8-bb.len()%8)%8)
+
+	// Pad bytes
+	for pad := uint(0xEC); bb.len() < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	return bb.bytes()
+}
+
+// addEccAndInterleave splits the data into blocks, appends the error correction codewords
+// to each block and interleaves the blocks
+func addEccAndInterleave(data []byte, version int, level Level) []byte {
+	numBlocks := numErrorCorrectionBlocks[level][version-1]
+	blockEccLen := eccCodewordsPerBlock[level][version-1]
+	rawCodewords := numRawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := rsDivisor(blockEccLen)
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := range blocks {
+		n := shortBlockLen - blockEccLen
+		if i >= numShortBlocks {
+			n++
+		}
+
+		block := make([]byte, 0, shortBlockLen+1)
+		block = append(block, data[k:k+n]...)
+		k += n
+
+		ecc := rsRemainder(block, divisor)
+		if i < numShortBlocks {
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := 0; i <= shortBlockLen; i++ {
+		for j, block := range blocks {
+			// Skip the padding byte of short blocks
+			if i != shortBlockLen-blockEccLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+
+	return result
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	// Timing patterns
+	for i := 0; i < c.size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns, overwriting some of the timing modules
+	c.drawFinderPattern(3, 3)
+	c.drawFinderPattern(c.size-4, 3)
+	c.drawFinderPattern(3, c.size-4)
+
+	// Alignment patterns, except where they would overlap with the finder patterns
+	pos := alignmentPatternPositions(c.version)
+	n := len(pos)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if (i == 0 && j == 0) || (i == 0 && j == n-1) || (i == n-1 && j == 0) {
+				continue
+			}
+			c.drawAlignmentPattern(pos[i], pos[j])
+		}
+	}
+
+	// Reserve the format bits, they are drawn after the mask is chosen
+	c.drawFormatBits(0)
+	c.drawVersion()
+}
+
+func (c *Code) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= c.size || yy < 0 || yy >= c.size {
+				continue
+			}
+			dist := abs(dx)
+			if abs(dy) > dist {
+				dist = abs(dy)
+			}
+			c.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			dist := abs(dx)
+			if abs(dy) > dist {
+				dist = abs(dy)
+			}
+			c.setFunction(x+dx, y+dy, dist != 1)
+		}
+	}
+}
+
+// alignmentPatternPositions returns the ascending center coordinates of the alignment patterns
+func alignmentPatternPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	pos := make([]int, numAlign)
+	pos[0] = 6
+	for i, p := numAlign-1, version*4+17-7; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+func (c *Code) drawFormatBits(mask int) {
+	data := formatBits[c.level]<<3 | uint(mask)
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+
+	// First copy, around the top left finder pattern
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(bits, i))
+	}
+	c.setFunction(8, 7, bit(bits, 6))
+	c.setFunction(8, 8, bit(bits, 7))
+	c.setFunction(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(bits, i))
+	}
+
+	// Second copy, split between the top right and bottom left finder patterns
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.size-15+i, bit(bits, i))
+	}
+
+	// Always dark
+	c.setFunction(8, c.size-8, true)
+}
+
+func (c *Code) drawVersion() {
+	if c.version < 7 {
+		return
+	}
+
+	rem := uint(c.version)
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := uint(c.version)<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		a := c.size - 11 + i%3
+		b := i / 3
+		c.setFunction(a, b, bit(bits, i))
+		c.setFunction(b, a, bit(bits, i))
+	}
+}
+
+// drawCodewords draws the codewords in the zigzag order over the non-function modules
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.size - 1; right >= 1; right -= 2 {
+		// Skip the vertical timing pattern
+		if right == 6 {
+			right = 5
+		}
+
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if upward {
+					y = c.size - 1 - vert
+				}
+
+				if !c.function[y][x] && i < len(data)*8 {
+					c.modules[y][x] = bit(uint(data[i>>3]), 7-(i&7))
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the non-function modules selected by the mask pattern
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+
+			if invert && !c.function[y][x] {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the current modules, lower is better
+func (c *Code) penalty() int {
+	const (
+		n1 = 3
+		n2 = 3
+		n3 = 40
+		n4 = 10
+	)
+
+	score := 0
+
+	// Runs of five or more modules of the same color in rows and columns
+	for i := 0; i < c.size; i++ {
+		for _, horizontal := range []bool{true, false} {
+			run := 0
+			var prev bool
+			for j := 0; j < c.size; j++ {
+				d := c.modules[i][j]
+				if !horizontal {
+					d = c.modules[j][i]
+				}
+
+				if j > 0 && d == prev {
+					run++
+				} else {
+					if run >= 5 {
+						score += n1 + run - 5
+					}
+					run = 1
+					prev = d
+				}
+			}
+			if run >= 5 {
+				score += n1 + run - 5
+			}
+		}
+	}
+
+	// 2x2 blocks of the same color
+	for y := 0; y < c.size-1; y++ {
+		for x := 0; x < c.size-1; x++ {
+			d := c.modules[y][x]
+			if d == c.modules[y][x+1] && d == c.modules[y+1][x] && d == c.modules[y+1][x+1] {
+				score += n2
+			}
+		}
+	}
+
+	// Finder-like patterns preceded or followed by four light modules
+	patterns := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+	for i := 0; i < c.size; i++ {
+		for j := 0; j+11 <= c.size; j++ {
+			for _, p := range patterns {
+				row, col := true, true
+				for k, d := range p {
+					row = row && c.modules[i][j+k] == d
+					col = col && c.modules[j+k][i] == d
+				}
+				if row {
+					score += n3
+				}
+				if col {
+					score += n3
+				}
+			}
+		}
+	}
+
+	// Balance of dark and light modules
+	dark := 0
+	for _, row := range c.modules {
+		for _, d := range row {
+			if d {
+				dark++
+			}
+		}
+	}
+	total := c.size * c.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	score += k * n4
+
+	return score
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the given degree,
+// excluding the leading coefficient
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = rsMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = rsMultiply(root, 0x02)
+	}
+
+	return result
+}
+
+// rsRemainder returns the Reed-Solomon error correction codewords of data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= rsMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// rsMultiply multiplies two elements of GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func rsMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int(y>>uint(i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// bitBuffer is an append-only sequence of bits
+type bitBuffer struct {
+	bits []bool
+}
+
+func (bb *bitBuffer) append(v uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		bb.bits = append(bb.bits, bit(v, i))
+	}
+}
+
+func (bb *bitBuffer) len() int {
+	return len(bb.bits)
+}
+
+func (bb *bitBuffer) bytes() []byte {
+	b := make([]byte, (len(bb.bits)+7)/8)
+	for i, d := range bb.bits {
+		if d {
+			b[i>>3] |= 1 << uint(7-i&7)
+		}
+	}
+	return b
+}
+
+func bit(v uint, i int) bool {
+	return (v>>uint(i))&1 != 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
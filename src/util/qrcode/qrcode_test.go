@@ -0,0 +1,173 @@
+package qrcode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func render(c *Code) string {
+	rows := make([]string, c.Size())
+	for y := range rows {
+		var b strings.Builder
+		for x := 0; x < c.Size(); x++ {
+			if c.Dark(x, y) {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		rows[y] = b.String()
+	}
+	return strings.Join(rows, "\n")
+}
+
+func makeData(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*7 + 3)
+	}
+	return b
+}
+
+func TestEncodeMatrix(t *testing.T) {
+	c := encode([]byte("skycoin"), 1, Medium, 2)
+	expect := strings.Join([]string{
+		"#######.......#######",
+		"#.....#...###.#.....#",
+		"#.###.#.####..#.###.#",
+		"#.###.#.##.##.#.###.#",
+		"#.###.#.##..#.#.###.#",
+		"#.....#.#.##..#.....#",
+		"#######.#.#.#.#######",
+		"........##...........",
+		"#.#####..#.#..#####..",
+		"##.#.#...#.#####....#",
+		"###.#####...#.##.#.#.",
+		"##......##.##########",
+		"#.##.##..#..#...##...",
+		"........###.#..##..##",
+		"#######..#.#.#...###.",
+		"#.....#.##.......####",
+		"#.###.#.#..#.#.##..#.",
+		"#.###.#.#..######.#..",
+		"#.###.#.#.#.#.#..#...",
+		"#.....#..#.####.###..",
+		"#######.###.#...#..#.",
+	}, "\n")
+	require.Equal(t, expect, render(c))
+
+	// Larger versions include version information, multiple alignment patterns and interleaved blocks
+	cases := []struct {
+		version int
+		level   Level
+		mask    int
+		n       int
+		digest  string
+	}{
+		{7, Low, 3, 150, "9a48d11706ae0da4a099ce3181a9b85eb2d1e5596cae4008a97d08704ce77a80"},
+		{15, High, 5, 160, "4fde69ac0720ba92dc34eb82832d3cdb1041a6a4943110db26c6be27937eb7b6"},
+		{40, Medium, 7, 2000, "91861153819c317e8f9298099651a0ffbe25503dd8b92cd9e5d92049fcd0f41c"},
+	}
+
+	for _, tc := range cases {
+		c := encode(makeData(tc.n), tc.version, tc.level, tc.mask)
+		h := sha256.Sum256([]byte(render(c)))
+		require.Equal(t, tc.digest, hex.EncodeToString(h[:]), "version=%d", tc.version)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	cases := []struct {
+		name    string
+		n       int
+		level   Level
+		version int
+		err     error
+	}{
+		{
+			name:    "empty",
+			level:   Low,
+			version: 1,
+		},
+		{
+			name:    "fits version 1",
+			n:       14,
+			level:   Medium,
+			version: 1,
+		},
+		{
+			name:    "needs version 2",
+			n:       15,
+			level:   Medium,
+			version: 2,
+		},
+		{
+			name:    "largest",
+			n:       2953,
+			level:   Low,
+			version: 40,
+		},
+		{
+			name:  "too long",
+			n:     2954,
+			level: Low,
+			err:   ErrDataTooLong,
+		},
+		{
+			name:  "invalid level",
+			level: Level(4),
+			err:   ErrInvalidLevel,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := Encode(makeData(tc.n), tc.level)
+			require.Equal(t, tc.err, err)
+			if err != nil {
+				return
+			}
+
+			require.Equal(t, tc.version, c.Version())
+			require.Equal(t, tc.version*4+17, c.Size())
+
+			// The finder patterns are dark at the corners
+			require.True(t, c.Dark(0, 0))
+			require.True(t, c.Dark(c.Size()-1, 0))
+			require.True(t, c.Dark(0, c.Size()-1))
+			require.False(t, c.Dark(-1, 0))
+			require.False(t, c.Dark(0, c.Size()))
+		})
+	}
+}
+
+func TestPNG(t *testing.T) {
+	c, err := Encode([]byte("skycoin"), Medium)
+	require.NoError(t, err)
+
+	_, err = c.PNG(0)
+	require.Equal(t, ErrInvalidScale, err)
+
+	b, err := c.PNG(3)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+
+	n := (c.Size() + 2*quietZone) * 3
+	require.Equal(t, n, img.Bounds().Dx())
+	require.Equal(t, n, img.Bounds().Dy())
+
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			r, _, _, _ := img.At(x, y).RGBA()
+			require.Equal(t, c.Dark(x/3-quietZone, y/3-quietZone), r == 0)
+		}
+	}
+}
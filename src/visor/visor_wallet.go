@@ -318,8 +318,13 @@ func (vs *Visor) walletCreateTransaction(methodName string, w *wallet.Wallet, p
 
 	addrs := wp.Addresses
 	if len(addrs) == 0 {
-		// Use all wallet addresses if no addresses or uxouts specified
-		addrs = walletAddresses
+		// Use all wallet addresses that are not frozen if no addresses or uxouts specified
+		addrs = make([]cipher.Address, 0, len(walletAddresses))
+		for i, e := range w.Entries {
+			if !e.Frozen {
+				addrs = append(addrs, walletAddresses[i])
+			}
+		}
 	} else {
 		// Check that requested addresses are in the wallet
 		for _, a := range addrs {
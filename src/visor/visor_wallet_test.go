@@ -492,6 +492,8 @@ func TestWalletCreateTransaction(t *testing.T) {
 		getUnspentHashesOfAddrsErr error
 
 		verifyErr error
+
+		frozen []cipher.Address
 	}
 
 	baseCases := []testCase{
@@ -510,6 +512,40 @@ func TestWalletCreateTransaction(t *testing.T) {
 			inputs:         inputs,
 		},
 
+		{
+			name:           "all wallet addresses, frozen addresses skipped",
+			p:              validParams,
+			wp:             CreateTransactionParams{},
+			walletID:       "foo.wlt",
+			blockchainHead: headBlock,
+			frozen:         []cipher.Address{addrs[0], addrs[2]},
+			getUnspentHashesOfAddrs: blockdb.AddressHashes{
+				addrs[1]: uxOuts,
+			},
+			getArrayInputs: uxOuts,
+			getArray:       getArrayRet,
+			txn:            txn,
+			inputs:         inputs,
+		},
+
+		{
+			name: "specific frozen wallet addresses",
+			p:    validParams,
+			wp: CreateTransactionParams{
+				Addresses: addrs,
+			},
+			walletID:       "foo.wlt",
+			blockchainHead: headBlock,
+			frozen:         addrs,
+			getUnspentHashesOfAddrs: blockdb.AddressHashes{
+				addrs[1]: uxOuts,
+			},
+			getArrayInputs: uxOuts,
+			getArray:       getArrayRet,
+			txn:            txn,
+			inputs:         inputs,
+		},
+
 		{
 			name: "specific wallet addresses",
 			p:    validParams,
@@ -666,6 +702,11 @@ func TestWalletCreateTransaction(t *testing.T) {
 			})
 			require.NoError(t, err)
 
+			for _, a := range tc.frozen {
+				err := ws.FreezeAddress(tc.walletID, a, true)
+				require.NoError(t, err)
+			}
+
 			walletAddrs, err := ws.GetSkycoinAddresses(tc.walletID)
 			require.NoError(t, err)
 
@@ -678,7 +719,17 @@ func TestWalletCreateTransaction(t *testing.T) {
 			ut := &MockUnconfirmedTransactionPooler{}
 			up := &MockUnspentPooler{}
 
-			addrs := walletAddrs
+			frozen := make(map[cipher.Address]struct{}, len(tc.frozen))
+			for _, a := range tc.frozen {
+				frozen[a] = struct{}{}
+			}
+
+			var addrs []cipher.Address
+			for _, a := range walletAddrs {
+				if _, ok := frozen[a]; !ok {
+					addrs = append(addrs, a)
+				}
+			}
 			if len(tc.wp.Addresses) != 0 {
 				addrs = tc.wp.Addresses
 			}
@@ -0,0 +1,84 @@
+package wallet
+
+import (
+	"errors"
+)
+
+// WalletArchiveVersion is the current version of the encrypted wallet archive format
+const WalletArchiveVersion byte = 1
+
+var (
+	// ErrMalformedArchive is returned if encrypted wallet archive data can't be parsed
+	ErrMalformedArchive = NewError(errors.New("malformed encrypted wallet archive"))
+	// ErrUnsupportedArchiveVersion is returned if encrypted wallet archive data has an unknown version
+	ErrUnsupportedArchiveVersion = NewError(errors.New("unsupported encrypted wallet archive version"))
+)
+
+// walletArchive is an encrypted wallet archive, see Service.ExportEncryptedArchive.
+// Its serialized format is [version:1][len(cryptoType):1][cryptoType][data].
+// data is the wallet in the format of the wallet files, encrypted with the archive password.
+// It carries the key derivation parameters and salt of the crypto type, so that it can be decrypted
+// with the archive password alone.
+type walletArchive struct {
+	Version    byte
+	CryptoType CryptoType
+	Data       []byte
+}
+
+func (a *walletArchive) serialize() []byte {
+	b := make([]byte, 0, 2+len(a.CryptoType)+len(a.Data))
+	b = append(b, a.Version)
+	b = append(b, byte(len(a.CryptoType)))
+	b = append(b, a.CryptoType...)
+	return append(b, a.Data...)
+}
+
+// parseWalletArchive parses serialized encrypted wallet archive data
+func parseWalletArchive(b []byte) (*walletArchive, error) {
+	if len(b) == 0 {
+		return nil, ErrMalformedArchive
+	}
+
+	if b[0] != WalletArchiveVersion {
+		return nil, ErrUnsupportedArchiveVersion
+	}
+	b = b[1:]
+
+	if len(b) == 0 || len(b) < 1+int(b[0]) {
+		return nil, ErrMalformedArchive
+	}
+	cryptoType, err := CryptoTypeFromString(string(b[1 : 1+int(b[0])]))
+	if err != nil {
+		return nil, ErrMalformedArchive
+	}
+	b = b[1+int(b[0]):]
+
+	if len(b) == 0 {
+		return nil, ErrMalformedArchive
+	}
+
+	return &walletArchive{
+		Version:    WalletArchiveVersion,
+		CryptoType: cryptoType,
+		Data:       b,
+	}, nil
+}
+
+// decrypt decrypts the archived wallet data with the password
+func (a *walletArchive) decrypt(password []byte) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, ErrMissingPassword
+	}
+
+	crypto, err := getCrypto(a.CryptoType)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := crypto.Decrypt(a.Data, password)
+	if err != nil {
+		return nil, ErrInvalidPassword
+	}
+
+	return data, nil
+}
@@ -1,6 +1,11 @@
 package wallet
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/amherag/skycoin/src/cipher"
 	"github.com/amherag/skycoin/src/coin"
 	"github.com/amherag/skycoin/src/util/mathutil"
 )
@@ -16,6 +21,29 @@ type BalancePair struct {
 	Predicted Balance
 }
 
+// AddressBalance records the balance of an address
+type AddressBalance struct {
+	Address cipher.Address
+	Balance BalancePair
+}
+
+// WalletErrors records the errors of individual wallets of a request for several wallets, by wallet id
+type WalletErrors map[string]error
+
+func (e WalletErrors) Error() string {
+	ids := make([]string, 0, len(e))
+	for id := range e {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	msgs := make([]string, len(ids))
+	for i, id := range ids {
+		msgs[i] = fmt.Sprintf("%s: %v", id, e[id])
+	}
+	return strings.Join(msgs, "; ")
+}
+
 // AddressBalances represents a map of address balances
 type AddressBalances map[string]BalancePair
 
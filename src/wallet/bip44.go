@@ -0,0 +1,339 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/amherag/skycoin/src/cipher"
+	"github.com/amherag/skycoin/src/cipher/base58"
+	"github.com/amherag/skycoin/src/cipher/bip32"
+)
+
+const (
+	// Bip44CoinTypeSkycoin is the SLIP-44 coin type of Skycoin, used in the BIP44 derivation path
+	Bip44CoinTypeSkycoin = 8000
+
+	// HWWatchOnlyVersion is the current version of the hardware wallet watch-only export format, see HWWatchOnly
+	HWWatchOnlyVersion = 1
+	// HWAddressTypeP2PKH is the address type of Skycoin addresses, which pay to a public key hash
+	HWAddressTypeP2PKH = "p2pkh"
+
+	// bip44ExternalChain is the BIP44 change level of the receive addresses
+	bip44ExternalChain = 0
+)
+
+var (
+	// ErrBip44NotBip39 is returned when creating a BIP44 wallet without Options.Bip39
+	ErrBip44NotBip39 = NewError(errors.New("bip44 derivation requires a bip39 seed"))
+	// ErrBip44Coin is returned when creating a BIP44 wallet for a coin other than Skycoin
+	ErrBip44Coin = NewError(errors.New("bip44 derivation is only supported for skycoin wallets"))
+	// ErrBip44SeedDeriver is returned when creating a BIP44 wallet with Options.SeedDeriver
+	ErrBip44SeedDeriver = NewError(errors.New("seed deriver is not supported for bip44 wallets"))
+	// ErrWalletNotBip44 is returned by operations that need a wallet derived along a BIP44 path
+	ErrWalletNotBip44 = NewError(errors.New("wallet is not a bip44 wallet"))
+	// ErrHardenedNotBip44 is returned when creating a wallet with Options.Hardened, but without Options.Bip44
+	ErrHardenedNotBip44 = NewError(errors.New("hardened derivation is only supported for bip44 wallets"))
+	// ErrHardenedDerivation is returned when exporting the xpub of a wallet with hardened address derivation,
+	// whose addresses can't be derived from the xpub
+	ErrHardenedDerivation = NewError(errors.New("addresses of a wallet with hardened derivation can't be derived from its xpub"))
+	// ErrInvalidAddressCount is returned if the number of addresses to derive from an xpub is not positive
+	ErrInvalidAddressCount = NewError(errors.New("address count must be positive"))
+	// ErrMalformedHWWatchOnly is returned if hardware wallet watch-only data can't be parsed
+	ErrMalformedHWWatchOnly = NewError(errors.New("malformed hardware wallet watch-only data"))
+)
+
+// HWWatchOnly is the watch-only export of a BIP44 wallet, in the format used to pair
+// the wallet with a hardware wallet or import it as a watch-only wallet elsewhere:
+//
+//	{
+//	    "version": 1,
+//	    "coin": "skycoin",
+//	    "xpub": "xpub6C...",
+//	    "derivation_path": "m/44'/8000'/0'",
+//	    "address_type": "p2pkh"
+//	}
+//
+// xpub is the base58 BIP32 extended public key of the account at derivation_path.
+// The receive addresses are its non-hardened children <derivation_path>/0/i.
+type HWWatchOnly struct {
+	Version        int      `json:"version"`
+	Coin           CoinType `json:"coin"`
+	XPub           string   `json:"xpub"`
+	DerivationPath string   `json:"derivation_path"`
+	AddressType    string   `json:"address_type"`
+}
+
+// ParseHWWatchOnly parses and validates hardware wallet watch-only data, see HWWatchOnly
+func ParseHWWatchOnly(b []byte) (*HWWatchOnly, error) {
+	var hw HWWatchOnly
+	if err := json.Unmarshal(b, &hw); err != nil {
+		return nil, NewError(fmt.Errorf("%v: %v", ErrMalformedHWWatchOnly, err))
+	}
+
+	if err := hw.validate(); err != nil {
+		return nil, err
+	}
+
+	return &hw, nil
+}
+
+func (hw *HWWatchOnly) validate() error {
+	malformed := func(format string, args ...interface{}) error {
+		return NewError(fmt.Errorf("%v: %s", ErrMalformedHWWatchOnly, fmt.Sprintf(format, args...)))
+	}
+
+	if hw.Version != HWWatchOnlyVersion {
+		return malformed("unsupported version %d", hw.Version)
+	}
+
+	if hw.Coin != CoinTypeSkycoin {
+		return malformed("unsupported coin %q", hw.Coin)
+	}
+
+	if hw.AddressType != HWAddressTypeP2PKH {
+		return malformed("unsupported address type %q", hw.AddressType)
+	}
+
+	account, err := parseBip44AccountPath(hw.DerivationPath)
+	if err != nil {
+		return malformed("%v", err)
+	}
+
+	k, err := hw.accountKey()
+	if err != nil {
+		return malformed("invalid xpub: %v", err)
+	}
+
+	if k.Depth != 3 || k.ChildNumber() != bip32.FirstHardenedChild+account {
+		return malformed("xpub is not the key of derivation path %s", hw.DerivationPath)
+	}
+
+	return nil
+}
+
+// accountKey decodes the extended public key of the account
+func (hw *HWWatchOnly) accountKey() (*bip32.PublicKey, error) {
+	if hw.XPub == "" {
+		return nil, errors.New("xpub missing")
+	}
+
+	b, err := base58.Decode(hw.XPub)
+	if err != nil {
+		return nil, err
+	}
+
+	return bip32.DeserializePublicKey(b)
+}
+
+// Addresses derives the first n receive addresses of the account
+func (hw *HWWatchOnly) Addresses(n int) ([]cipher.Address, error) {
+	k, err := hw.accountKey()
+	if err != nil {
+		return nil, err
+	}
+
+	external, err := k.NewPublicChildKey(bip44ExternalChain)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]cipher.Address, 0, n)
+	for i := uint32(0); len(addrs) < n; i++ {
+		if i >= bip32.FirstHardenedChild {
+			return nil, errors.New("bip44 address index overflow")
+		}
+
+		ck, err := external.NewPublicChildKey(i)
+		if err != nil {
+			if bip32.IsImpossibleChildError(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		pk, err := cipher.NewPubKey(ck.Key)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, cipher.AddressFromPubKey(pk))
+	}
+
+	return addrs, nil
+}
+
+// bip44AccountPath returns the BIP44 derivation path of a Skycoin account
+func bip44AccountPath(account uint32) string {
+	return fmt.Sprintf("m/44'/%d'/%d'", Bip44CoinTypeSkycoin, account)
+}
+
+// parseBip44AccountPath parses a BIP44 Skycoin account path m/44'/8000'/account', returning the account
+func parseBip44AccountPath(p string) (uint32, error) {
+	path, err := bip32.ParsePath(p)
+	if err != nil {
+		return 0, err
+	}
+
+	nodes := path.Elements
+	if len(nodes) != 4 || !nodes[0].Master ||
+		nodes[1].ChildNumber != bip32.FirstHardenedChild+44 ||
+		nodes[2].ChildNumber != bip32.FirstHardenedChild+Bip44CoinTypeSkycoin ||
+		!nodes[3].Hardened() {
+		return 0, fmt.Errorf("derivation path %q is not a skycoin bip44 account path m/44'/%d'/<account>'", p, Bip44CoinTypeSkycoin)
+	}
+
+	return nodes[3].ChildNumber - bip32.FirstHardenedChild, nil
+}
+
+// IsBip44 checks whether the wallet's addresses are derived along the BIP44 path, see Options.Bip44
+func (w *Wallet) IsBip44() bool {
+	return w.Meta[metaBip44] != ""
+}
+
+// XPub returns the base58 extended public key of the wallet's first BIP44 account, empty if the wallet is not a BIP44 wallet.
+// It is not secret and is available when the wallet is encrypted.
+func (w *Wallet) XPub() string {
+	return w.Meta[metaBip44]
+}
+
+// MasterPublicKey returns the public key of the BIP32 master key of a BIP44 wallet, which is the root
+// of its derivation tree, in compressed form. It is derived from the seed, so the wallet must be decrypted.
+func (w *Wallet) MasterPublicKey() (cipher.PubKey, error) {
+	if w.IsWatchOnly() {
+		return cipher.PubKey{}, ErrWalletIsWatchOnly
+	}
+
+	if !w.IsBip44() {
+		return cipher.PubKey{}, ErrWalletNotBip44
+	}
+
+	if w.IsEncrypted() {
+		return cipher.PubKey{}, ErrWalletEncrypted
+	}
+
+	seed, err := w.chainSeed()
+	if err != nil {
+		return cipher.PubKey{}, err
+	}
+
+	k, err := bip32.NewMasterKey(seed)
+	if err != nil {
+		return cipher.PubKey{}, err
+	}
+
+	return cipher.NewPubKey(k.PublicKey().Key)
+}
+
+// IsHardened checks whether the BIP44 change and address levels of the wallet are hardened, see Options.Hardened
+func (w *Wallet) IsHardened() bool {
+	b, _ := strconv.ParseBool(w.Meta[metaHardened]) // nolint: errcheck
+	return b
+}
+
+// bip44Child returns the child number of a change or address level index, hardened if the wallet is
+func (w *Wallet) bip44Child(i uint32) uint32 {
+	if w.IsHardened() {
+		return i + bip32.FirstHardenedChild
+	}
+	return i
+}
+
+// bip44ChainPath returns the BIP44 derivation path of an account's receive chain
+func (w *Wallet) bip44ChainPath(account uint32) string {
+	if w.IsHardened() {
+		return fmt.Sprintf("%s/%d'", bip44AccountPath(account), bip44ExternalChain)
+	}
+	return fmt.Sprintf("%s/%d", bip44AccountPath(account), bip44ExternalChain)
+}
+
+// bip44AccountKey derives the extended private key of a BIP44 account from the seed
+func (w *Wallet) bip44AccountKey(account uint32) (*bip32.PrivateKey, error) {
+	seed, err := w.chainSeed()
+	if err != nil {
+		return nil, err
+	}
+
+	return bip32.NewPrivateKeyFromPath(seed, bip44AccountPath(account))
+}
+
+// generateBip44Addresses appends num entries of an account's BIP44 receive chain, starting at chain index
+// from and skipping indexes rejected by the index filter, and returns the next chain index
+func (w *Wallet) generateBip44Addresses(account uint32, from, num uint64) ([]cipher.Addresser, uint64, error) {
+	k, err := w.bip44AccountKey(account)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	external, err := k.NewPrivateChildKey(w.bip44Child(bip44ExternalChain))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	index := from
+	addrs := make([]cipher.Addresser, 0, num)
+	makeAddress := w.addressConstructor()
+	for uint64(len(addrs)) < num {
+		i := index
+		index++
+		if i >= uint64(bip32.FirstHardenedChild) {
+			return nil, 0, errors.New("bip44 address index overflow")
+		}
+
+		if account == 0 && w.indexFilter != nil && !w.indexFilter(i) {
+			continue
+		}
+
+		ck, err := external.NewPrivateChildKey(w.bip44Child(uint32(i)))
+		if err != nil {
+			// BIP32 skips the rare indexes that don't derive a valid key
+			if bip32.IsImpossibleChildError(err) {
+				continue
+			}
+			return nil, 0, err
+		}
+
+		s, err := cipher.NewSecKey(ck.Key)
+		if err != nil {
+			return nil, 0, err
+		}
+		p, err := cipher.PubKeyFromSecKey(s)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		a := makeAddress(p)
+		addrs = append(addrs, a)
+		w.Entries = append(w.Entries, Entry{
+			Address: a,
+			Secret:  s,
+			Public:  p,
+			Account: account,
+		})
+	}
+
+	return addrs, index, nil
+}
+
+// hwWatchOnly returns the watch-only export of the wallet's first BIP44 account
+func (w *Wallet) hwWatchOnly() (*HWWatchOnly, error) {
+	if w.IsWatchOnly() {
+		return nil, ErrWalletIsWatchOnly
+	}
+
+	if !w.IsBip44() {
+		return nil, ErrWalletNotBip44
+	}
+
+	if w.IsHardened() {
+		return nil, ErrHardenedDerivation
+	}
+
+	return &HWWatchOnly{
+		Version:        HWWatchOnlyVersion,
+		Coin:           w.coin(),
+		XPub:           w.XPub(),
+		DerivationPath: bip44AccountPath(0),
+		AddressType:    HWAddressTypeP2PKH,
+	}, nil
+}
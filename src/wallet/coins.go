@@ -0,0 +1,113 @@
+package wallet
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/amherag/skycoin/src/cipher"
+)
+
+// coinTypes records the supported coin types and their address constructors.
+// New coin types are added with RegisterCoinType.
+var coinTypes = struct {
+	sync.RWMutex
+	m map[CoinType]func(cipher.PubKey) cipher.Addresser
+}{
+	m: map[CoinType]func(cipher.PubKey) cipher.Addresser{
+		CoinTypeSkycoin: func(pk cipher.PubKey) cipher.Addresser {
+			return cipher.AddressFromPubKey(pk)
+		},
+		CoinTypeBitcoin: func(pk cipher.PubKey) cipher.Addresser {
+			return cipher.BitcoinAddressFromPubKey(pk)
+		},
+	},
+}
+
+// RegisterCoinType registers a coin type and the constructor of its addresses, so that wallets can be created for it.
+// Registered coin types use the Skycoin address and secret key encodings in wallet files.
+// Panics if the coin type is empty, already registered or the constructor is nil.
+func RegisterCoinType(ct CoinType, addressConstructor func(cipher.PubKey) cipher.Address) {
+	if ct == "" {
+		logger.Panic("RegisterCoinType: coin type is empty")
+	}
+	if addressConstructor == nil {
+		logger.Panicf("RegisterCoinType: address constructor of coin type %q is nil", ct)
+	}
+
+	coinTypes.Lock()
+	defer coinTypes.Unlock()
+
+	if _, ok := coinTypes.m[ct]; ok {
+		logger.Panicf("RegisterCoinType: coin type %q is already registered", ct)
+	}
+
+	coinTypes.m[ct] = func(pk cipher.PubKey) cipher.Addresser {
+		return addressConstructor(pk)
+	}
+}
+
+// SupportedCoinTypes returns all registered coin types, sorted by name
+func SupportedCoinTypes() []CoinType {
+	coinTypes.RLock()
+	defer coinTypes.RUnlock()
+
+	cts := make([]CoinType, 0, len(coinTypes.m))
+	for ct := range coinTypes.m {
+		cts = append(cts, ct)
+	}
+
+	sort.Slice(cts, func(i, j int) bool {
+		return cts[i] < cts[j]
+	})
+
+	return cts
+}
+
+// ValidateAddressString decodes and validates an address string of the given coin type.
+// Bitcoin addresses are returned as cipher.BitcoinAddress, addresses of the other registered
+// coin types as cipher.Address, since they use the Skycoin encoding.
+// Returns ErrUnknownCoinType if the coin type is not registered.
+func ValidateAddressString(coin CoinType, s string) (cipher.Addresser, error) {
+	if err := validateCoinType(coin); err != nil {
+		return nil, err
+	}
+
+	var addr cipher.Addresser
+	var err error
+	if coin == CoinTypeBitcoin {
+		addr, err = cipher.DecodeBase58BitcoinAddress(s)
+	} else {
+		addr, err = cipher.DecodeBase58Address(s)
+	}
+	if err != nil {
+		return nil, NewError(fmt.Errorf("invalid %s address %q: %v", coin, s, err))
+	}
+
+	return addr, nil
+}
+
+// validateCoinType returns ErrUnknownCoinType if the coin type is not registered
+func validateCoinType(ct CoinType) error {
+	if _, err := getAddressConstructor(ct); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getAddressConstructor returns the address constructor of a registered coin type
+func getAddressConstructor(ct CoinType) (func(cipher.PubKey) cipher.Addresser, error) {
+	coinTypes.RLock()
+	defer coinTypes.RUnlock()
+
+	f, ok := coinTypes.m[ct]
+	if !ok {
+		return nil, ErrUnknownCoinType
+	}
+	return f, nil
+}
+
+// usesSkycoinAddresses returns true if the coin type's addresses and secret keys are encoded like Skycoin's
+func usesSkycoinAddresses(ct CoinType) bool {
+	return ct != CoinTypeBitcoin && validateCoinType(ct) == nil
+}
@@ -0,0 +1,109 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/amherag/skycoin/src/cipher"
+)
+
+func TestRegisterCoinType(t *testing.T) {
+	ct := CoinType("testcoin")
+	defer func() {
+		coinTypes.Lock()
+		delete(coinTypes.m, ct)
+		coinTypes.Unlock()
+	}()
+
+	require.Equal(t, []CoinType{CoinTypeBitcoin, CoinTypeSkycoin}, SupportedCoinTypes())
+
+	_, err := ResolveCoinType(string(ct))
+	require.Equal(t, ErrInvalidCoinType, err)
+
+	_, err = NewWallet("t.wlt", Options{
+		Coin: ct,
+		Seed: "seed",
+	})
+	require.Equal(t, ErrUnknownCoinType, err)
+
+	var calls int
+	makeAddress := func(pk cipher.PubKey) cipher.Address {
+		calls++
+		return cipher.AddressFromPubKey(pk)
+	}
+	RegisterCoinType(ct, makeAddress)
+
+	require.Equal(t, []CoinType{CoinTypeBitcoin, CoinTypeSkycoin, ct}, SupportedCoinTypes())
+
+	rct, err := ResolveCoinType(string(ct))
+	require.NoError(t, err)
+	require.Equal(t, ct, rct)
+
+	w, err := NewWallet("t.wlt", Options{
+		Coin:      ct,
+		Seed:      "seed",
+		GenerateN: 2,
+	})
+	require.NoError(t, err)
+	require.Len(t, w.Entries, 2)
+	require.Equal(t, 2, calls)
+	for _, e := range w.Entries {
+		require.Equal(t, makeAddress(e.Public), e.Address)
+	}
+
+	// The wallet can be converted to and from its readable format
+	w2, err := NewReadableWallet(w).ToWallet()
+	require.NoError(t, err)
+	require.Equal(t, w.Entries, w2.Entries)
+
+	require.Panics(t, func() {
+		RegisterCoinType(ct, makeAddress)
+	})
+	require.Panics(t, func() {
+		RegisterCoinType(CoinTypeSkycoin, makeAddress)
+	})
+	require.Panics(t, func() {
+		RegisterCoinType("", makeAddress)
+	})
+	require.Panics(t, func() {
+		RegisterCoinType("foocoin", nil)
+	})
+}
+
+func TestValidateAddressString(t *testing.T) {
+	pk, _ := cipher.GenerateKeyPair()
+	skyAddr := cipher.AddressFromPubKey(pk)
+	btcAddr := cipher.BitcoinAddressFromPubKey(pk)
+
+	addr, err := ValidateAddressString(CoinTypeSkycoin, skyAddr.String())
+	require.NoError(t, err)
+	require.Equal(t, skyAddr, addr)
+
+	addr, err = ValidateAddressString(CoinTypeBitcoin, btcAddr.String())
+	require.NoError(t, err)
+	require.Equal(t, btcAddr, addr)
+
+	// Addresses of the other coin type are rejected
+	_, err = ValidateAddressString(CoinTypeBitcoin, skyAddr.String())
+	require.Error(t, err)
+	_, err = ValidateAddressString(CoinTypeSkycoin, btcAddr.String())
+	require.Error(t, err)
+
+	// Corrupted checksum
+	b := []byte(skyAddr.String())
+	if b[len(b)-1] == '2' {
+		b[len(b)-1] = '3'
+	} else {
+		b[len(b)-1] = '2'
+	}
+	_, err = ValidateAddressString(CoinTypeSkycoin, string(b))
+	require.Error(t, err)
+	require.IsType(t, Error{}, err)
+
+	_, err = ValidateAddressString(CoinTypeSkycoin, "")
+	require.Error(t, err)
+
+	_, err = ValidateAddressString("foocoin", skyAddr.String())
+	require.Equal(t, ErrUnknownCoinType, err)
+}
@@ -0,0 +1,162 @@
+package wallet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// CompactionPolicy are the retention rules applied by Service.CompactStorage to the backups of the wallet files
+// and to the wallet files in QuarantineDir. A limit that is zero or less is not applied.
+type CompactionPolicy struct {
+	// MaxAge removes the files that were last modified longer ago than this
+	MaxAge time.Duration
+	// MaxPerWallet is the number of files kept per wallet in each directory, the most recent ones are kept
+	MaxPerWallet int
+	// MaxTotalSize is the total size in bytes of the files kept, the oldest files are removed first
+	MaxTotalSize int64
+}
+
+// CompactionReport lists the files removed by Service.CompactStorage
+type CompactionReport struct {
+	// Removed are the paths of the removed files, oldest first
+	Removed []string
+	// RemovedBytes is the total size of the removed files
+	RemovedBytes int64
+}
+
+var (
+	// backupFileRe matches the backups of wallet files, <name>.wlt.bak and the rotated <name>.wlt.bak.N
+	backupFileRe = regexp.MustCompile(`^(.+\.` + WalletExt + `)\.bak(\.[0-9]+)?$`)
+	// quarantinedFileRe matches the wallet files in QuarantineDir, <name>.wlt and <name>.wlt.N
+	quarantinedFileRe = regexp.MustCompile(`^(.+\.` + WalletExt + `)(\.[0-9]+)?$`)
+)
+
+// storedFile is a backup or quarantined wallet file considered for compaction
+type storedFile struct {
+	path    string
+	group   string // directory and wallet filename, MaxPerWallet applies to each group
+	modTime time.Time
+	size    int64
+}
+
+// listStoredFiles returns the files in dir whose name matches re, grouped by the wallet filename matched by re
+func listStoredFiles(dir string, re *regexp.Regexp) ([]storedFile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []storedFile
+	for _, e := range entries {
+		if !e.Mode().IsRegular() {
+			continue
+		}
+
+		m := re.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+
+		files = append(files, storedFile{
+			path:    filepath.Join(dir, e.Name()),
+			group:   filepath.Join(dir, m[1]),
+			modTime: e.ModTime(),
+			size:    e.Size(),
+		})
+	}
+
+	return files, nil
+}
+
+// compactStorage applies the policy to the backups in walletDir and the files in its QuarantineDir
+func compactStorage(walletDir string, policy CompactionPolicy, now time.Time) (CompactionReport, error) {
+	backups, err := listStoredFiles(walletDir, backupFileRe)
+	if err != nil {
+		return CompactionReport{}, err
+	}
+
+	quarantined, err := listStoredFiles(filepath.Join(walletDir, QuarantineDir), quarantinedFileRe)
+	if err != nil {
+		return CompactionReport{}, err
+	}
+
+	files := append(backups, quarantined...)
+
+	// Oldest first
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].modTime.Equal(files[j].modTime) {
+			return files[i].modTime.Before(files[j].modTime)
+		}
+		return files[i].path < files[j].path
+	})
+
+	remove := make(map[string]bool)
+
+	if policy.MaxAge > 0 {
+		for _, f := range files {
+			if now.Sub(f.modTime) > policy.MaxAge {
+				remove[f.path] = true
+			}
+		}
+	}
+
+	if policy.MaxPerWallet > 0 {
+		kept := make(map[string]int)
+		for i := len(files) - 1; i >= 0; i-- {
+			f := files[i]
+			if remove[f.path] {
+				continue
+			}
+
+			if kept[f.group] >= policy.MaxPerWallet {
+				remove[f.path] = true
+				continue
+			}
+			kept[f.group]++
+		}
+	}
+
+	if policy.MaxTotalSize > 0 {
+		var total int64
+		for _, f := range files {
+			if !remove[f.path] {
+				total += f.size
+			}
+		}
+
+		for _, f := range files {
+			if total <= policy.MaxTotalSize {
+				break
+			}
+			if remove[f.path] {
+				continue
+			}
+
+			remove[f.path] = true
+			total -= f.size
+		}
+	}
+
+	var report CompactionReport
+	for _, f := range files {
+		if !remove[f.path] {
+			continue
+		}
+
+		if err := os.Remove(f.path); err != nil {
+			return report, err
+		}
+
+		report.Removed = append(report.Removed, f.path)
+		report.RemovedBytes += f.size
+	}
+
+	return report, nil
+}
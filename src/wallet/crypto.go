@@ -24,6 +24,8 @@ func CryptoTypeFromString(s string) (CryptoType, error) {
 		return CryptoTypeScryptChacha20poly1305, nil
 	case CryptoTypeScryptChacha20poly1305Insecure:
 		return CryptoTypeScryptChacha20poly1305Insecure, nil
+	case CryptoTypePbkdf2Chacha20poly1305:
+		return CryptoTypePbkdf2Chacha20poly1305, nil
 	default:
 		return "", errors.New("unknown crypto type")
 	}
@@ -37,6 +39,9 @@ const (
 	CryptoTypeScryptChacha20poly1305 = CryptoType("scrypt-chacha20poly1305")
 	// CryptoTypeScryptChacha20poly1305Insecure uses chacha20poly1305 + scrypt key derivation with a weak work factor (unsafe)
 	CryptoTypeScryptChacha20poly1305Insecure = CryptoType("scrypt-chacha20poly1305-insecure")
+	// CryptoTypePbkdf2Chacha20poly1305 uses chacha20poly1305 + PBKDF2-HMAC-SHA256 key derivation, for environments where scrypt is not approved.
+	// The iterations are stored with the encrypted data, so wallets encrypted with other iterations can still be decrypted.
+	CryptoTypePbkdf2Chacha20poly1305 = CryptoType("pbkdf2-chacha20poly1305")
 )
 
 // cryptoTable records all supported wallet crypto methods
@@ -50,6 +55,36 @@ var cryptoTable = map[CryptoType]cryptor{
 		P:      encrypt.ScryptP,
 		KeyLen: encrypt.ScryptKeyLen,
 	},
+	CryptoTypePbkdf2Chacha20poly1305: encrypt.DefaultPbkdf2Chacha20poly1305,
+}
+
+// newScryptCryptor returns a scrypt chacha20poly1305 cryptor with the given scrypt parameters.
+// Zero parameters are taken from the CryptoTypeScryptChacha20poly1305 cryptor.
+func newScryptCryptor(n, r, p, keyLen int) (cryptor, error) {
+	c := cryptoTable[CryptoTypeScryptChacha20poly1305].(encrypt.ScryptChacha20poly1305)
+	if n != 0 {
+		c.N = n
+	}
+	if r != 0 {
+		c.R = r
+	}
+	if p != 0 {
+		c.P = p
+	}
+	if keyLen != 0 {
+		c.KeyLen = keyLen
+	}
+
+	switch {
+	case c.N <= 1 || c.N&(c.N-1) != 0:
+		return nil, errors.New("scrypt N must be a power of two greater than 1")
+	case c.R <= 0 || c.P <= 0 || uint64(c.R)*uint64(c.P) >= 1<<30:
+		return nil, errors.New("scrypt r and p must be positive and r*p must be less than 2^30")
+	case c.KeyLen <= 0:
+		return nil, errors.New("scrypt key length must be positive")
+	}
+
+	return c, nil
 }
 
 // getCrypto gets crypto of given type
@@ -61,3 +96,70 @@ func getCrypto(cryptoType CryptoType) (cryptor, error) {
 
 	return c, nil
 }
+
+// Key derivation functions reported by EncryptionInfo
+const (
+	// KDFSha256 derives the key with a single SHA256 hash of the password, used by CryptoTypeSha256Xor
+	KDFSha256 = "sha256"
+	// KDFScrypt derives the key with scrypt
+	KDFScrypt = "scrypt"
+	// KDFPbkdf2HmacSha256 derives the key with PBKDF2-HMAC-SHA256
+	KDFPbkdf2HmacSha256 = "pbkdf2-hmac-sha256"
+)
+
+// EncryptionInfo describes how a wallet is encrypted. It has no secret data.
+// Encrypted is false for unencrypted wallets, and the other fields are empty.
+type EncryptionInfo struct {
+	Encrypted  bool
+	CryptoType CryptoType
+	// KDF is the key derivation function that derives the encryption key from the password
+	KDF string
+	// ScryptN, ScryptR and ScryptP are the scrypt parameters, if KDF is KDFScrypt
+	ScryptN int
+	ScryptR int
+	ScryptP int
+	// Iterations is the number of PBKDF2 iterations, if KDF is KDFPbkdf2HmacSha256
+	Iterations int
+	// KeyLen is the length in bytes of the derived key, if KDF is KDFScrypt or KDFPbkdf2HmacSha256
+	KeyLen int
+}
+
+// EncryptionInfo returns how the wallet is encrypted, reading the key derivation parameters
+// from the metadata of the encrypted secrets. The wallet is not decrypted.
+func (w *Wallet) EncryptionInfo() (EncryptionInfo, error) {
+	if !w.IsEncrypted() {
+		return EncryptionInfo{}, nil
+	}
+
+	info := EncryptionInfo{
+		Encrypted:  true,
+		CryptoType: w.cryptoType(),
+	}
+
+	switch info.CryptoType {
+	case CryptoTypeSha256Xor:
+		info.KDF = KDFSha256
+	case CryptoTypeScryptChacha20poly1305, CryptoTypeScryptChacha20poly1305Insecure:
+		p, err := encrypt.ScryptChacha20poly1305Params([]byte(w.secrets()))
+		if err != nil {
+			return EncryptionInfo{}, fmt.Errorf("read scrypt parameters failed: %v", err)
+		}
+		info.KDF = KDFScrypt
+		info.ScryptN = p.N
+		info.ScryptR = p.R
+		info.ScryptP = p.P
+		info.KeyLen = p.KeyLen
+	case CryptoTypePbkdf2Chacha20poly1305:
+		p, err := encrypt.Pbkdf2Chacha20poly1305Params([]byte(w.secrets()))
+		if err != nil {
+			return EncryptionInfo{}, fmt.Errorf("read pbkdf2 parameters failed: %v", err)
+		}
+		info.KDF = KDFPbkdf2HmacSha256
+		info.Iterations = p.Iterations
+		info.KeyLen = p.KeyLen
+	default:
+		return EncryptionInfo{}, fmt.Errorf("can not find crypto %v in crypto table", info.CryptoType)
+	}
+
+	return info, nil
+}
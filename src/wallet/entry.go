@@ -2,6 +2,7 @@ package wallet
 
 import (
 	"errors"
+	"time"
 
 	"github.com/amherag/skycoin/src/cipher"
 )
@@ -11,6 +12,16 @@ type Entry struct {
 	Address cipher.Addresser
 	Public  cipher.PubKey
 	Secret  cipher.SecKey
+	Frozen  bool   // excluded from automatic coin selection
+	Account uint32 // account whose address chain the entry belongs to, 0 for the wallet's main chain
+	Label   string // label of the address, not secret
+
+	// Imported is set if the secret key was imported into a collection wallet instead of derived from the seed,
+	// see WalletTypeCollection. Imported entries are not part of any address chain.
+	Imported bool
+	// Expires is the time after which an imported entry is removed by Service.ReapExpired, zero if it doesn't expire.
+	// It is stored with a precision of seconds.
+	Expires time.Time
 }
 
 // SkycoinAddress returns the Skycoin address of an entry. Panics if Address is not a Skycoin address
@@ -0,0 +1,172 @@
+package wallet
+
+import (
+	"sync"
+	"time"
+)
+
+// WalletEventKind is the kind of change of a wallet reported by a WalletEvent
+type WalletEventKind int
+
+// Wallet event kinds
+const (
+	// WalletCreated is reported when a wallet is created or imported
+	WalletCreated WalletEventKind = iota + 1
+	// WalletAddressesAdded is reported when addresses are generated in a wallet or secret keys are imported into it
+	WalletAddressesAdded
+	// WalletEncrypted is reported when a wallet is encrypted, including automatic re-encryption
+	WalletEncrypted
+	// WalletDecrypted is reported when a wallet is decrypted
+	WalletDecrypted
+	// WalletUnloaded is reported when a wallet is unloaded
+	WalletUnloaded
+	// WalletLabelChanged is reported when the label of a wallet is changed
+	WalletLabelChanged
+	// WalletRenamed is reported with the new id of a renamed wallet, see WalletEvent.PreviousID
+	WalletRenamed
+	// WalletReloaded is reported when a wallet is reloaded from its file
+	WalletReloaded
+	// WalletAddressScanned is reported after each batch of addresses scanned for a balance while a wallet
+	// is created with Options.ScanN, before the wallet is saved, see WalletEvent.Scanned.
+	// Consecutive scan events of a wallet that are not delivered yet are coalesced into the latest one.
+	WalletAddressScanned
+	// WalletAddressesRemoved is reported when expired imported entries are removed from a wallet, see Service.ReapExpired
+	WalletAddressesRemoved
+	// WalletSeedAttached is reported when a seed is attached to a collection wallet, see Service.AttachSeed
+	WalletSeedAttached
+)
+
+func (k WalletEventKind) String() string {
+	switch k {
+	case WalletCreated:
+		return "created"
+	case WalletAddressesAdded:
+		return "addresses-added"
+	case WalletEncrypted:
+		return "encrypted"
+	case WalletDecrypted:
+		return "decrypted"
+	case WalletUnloaded:
+		return "unloaded"
+	case WalletLabelChanged:
+		return "label-changed"
+	case WalletRenamed:
+		return "renamed"
+	case WalletReloaded:
+		return "reloaded"
+	case WalletAddressScanned:
+		return "address-scanned"
+	case WalletAddressesRemoved:
+		return "addresses-removed"
+	case WalletSeedAttached:
+		return "seed-attached"
+	default:
+		return "unknown"
+	}
+}
+
+// WalletEvent describes a change of a wallet, see Service.Subscribe
+type WalletEvent struct {
+	WalletID   string
+	Kind       WalletEventKind
+	Time       time.Time
+	PreviousID string // id of the wallet before it was renamed, only set for WalletRenamed
+	Scanned    uint64 // number of addresses scanned so far, only set for WalletAddressScanned
+	Found      uint64 // number of scanned addresses with a balance, only set for WalletAddressScanned
+}
+
+// eventBus delivers wallet events to the subscribers in order, from a separate goroutine,
+// so that publishing never waits for the subscribers
+type eventBus struct {
+	sync.Mutex
+	subs       map[uint64]func(WalletEvent)
+	nextID     uint64
+	queue      []WalletEvent
+	delivering bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subs: make(map[uint64]func(WalletEvent)),
+	}
+}
+
+// subscribe adds a subscriber and returns the function that removes it
+func (b *eventBus) subscribe(fn func(WalletEvent)) func() {
+	b.Lock()
+	defer b.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = fn
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.Lock()
+			defer b.Unlock()
+			delete(b.subs, id)
+		})
+	}
+}
+
+// publish queues an event, starting the delivery goroutine if it is not running
+func (b *eventBus) publish(wltID string, kind WalletEventKind) {
+	b.publishEvent(WalletEvent{
+		WalletID: wltID,
+		Kind:     kind,
+	})
+}
+
+// publishEvent is publish for an event with more fields than the wallet id and kind
+func (b *eventBus) publishEvent(ev WalletEvent) {
+	b.Lock()
+	defer b.Unlock()
+
+	if len(b.subs) == 0 {
+		return
+	}
+
+	ev.Time = time.Now()
+
+	// Replace a scan event that is not delivered yet instead of flooding the subscribers
+	if ev.Kind == WalletAddressScanned && len(b.queue) != 0 {
+		last := &b.queue[len(b.queue)-1]
+		if last.Kind == WalletAddressScanned && last.WalletID == ev.WalletID {
+			*last = ev
+			return
+		}
+	}
+
+	b.queue = append(b.queue, ev)
+
+	if !b.delivering {
+		b.delivering = true
+		go b.deliver()
+	}
+}
+
+// deliver calls the subscribers with the queued events until the queue is empty
+func (b *eventBus) deliver() {
+	for {
+		b.Lock()
+		if len(b.queue) == 0 {
+			b.queue = nil
+			b.delivering = false
+			b.Unlock()
+			return
+		}
+
+		ev := b.queue[0]
+		b.queue = b.queue[1:]
+		subs := make([]func(WalletEvent), 0, len(b.subs))
+		for _, fn := range b.subs {
+			subs = append(subs, fn)
+		}
+		b.Unlock()
+
+		for _, fn := range subs {
+			fn(ev)
+		}
+	}
+}
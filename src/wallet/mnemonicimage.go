@@ -0,0 +1,93 @@
+package wallet
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+
+	"github.com/amherag/skycoin/src/util/bitmapfont"
+)
+
+const (
+	// mnemonicImageScale is the size in pixels of each font pixel in exported mnemonic images
+	mnemonicImageScale = 3
+
+	// mnemonicImageMargin is the margin around the text of exported mnemonic images, in font pixels
+	mnemonicImageMargin = 12
+
+	// mnemonicImageColumns is the number of columns the mnemonic words are laid out in
+	mnemonicImageColumns = 3
+)
+
+// mnemonicSheet is the content of a printable mnemonic image
+type mnemonicSheet struct {
+	Coin           CoinType
+	DerivationPath string
+	FirstAddr      string
+	Words          []string
+}
+
+// lines returns the lines of text of the sheet, with the words numbered and laid out in columns
+func (s mnemonicSheet) lines() []string {
+	lines := []string{
+		"Wallet recovery sheet",
+		"",
+		fmt.Sprintf("Coin: %s", s.Coin),
+		fmt.Sprintf("Derivation path: %s", s.DerivationPath),
+		"First address:",
+		s.FirstAddr,
+		"",
+		"Recovery words:",
+	}
+
+	labels := make([]string, len(s.Words))
+	var colWidth int
+	for i, w := range s.Words {
+		labels[i] = fmt.Sprintf("%2d. %s", i+1, w)
+		if len(labels[i]) > colWidth {
+			colWidth = len(labels[i])
+		}
+	}
+
+	for i := 0; i < len(labels); i += mnemonicImageColumns {
+		var row []string
+		for j := i; j < i+mnemonicImageColumns && j < len(labels); j++ {
+			row = append(row, fmt.Sprintf("%-*s", colWidth, labels[j]))
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(row, "   "), " "))
+	}
+
+	return lines
+}
+
+// png renders the sheet as black text on a white PNG image
+func (s mnemonicSheet) png() ([]byte, error) {
+	lines := s.lines()
+
+	var width int
+	for _, l := range lines {
+		if w := bitmapfont.Width(l, mnemonicImageScale); w > width {
+			width = w
+		}
+	}
+
+	margin := mnemonicImageMargin * mnemonicImageScale
+	lineHeight := bitmapfont.LineHeight * mnemonicImageScale
+	img := image.NewGray(image.Rect(0, 0, width+2*margin, len(lines)*lineHeight+2*margin))
+	draw.Draw(img, img.Bounds(), image.White, image.ZP, draw.Src)
+
+	for i, l := range lines {
+		bitmapfont.Draw(img, margin, margin+i*lineHeight, l, mnemonicImageScale, color.Black)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
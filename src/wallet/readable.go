@@ -3,6 +3,8 @@ package wallet
 import (
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/amherag/skycoin/src/cipher"
 	"github.com/amherag/skycoin/src/util/file"
@@ -13,11 +15,26 @@ type ReadableEntry struct {
 	Address string `json:"address"`
 	Public  string `json:"public_key"`
 	Secret  string `json:"secret_key"`
+	Frozen  bool   `json:"frozen,omitempty"`
+	Account uint32 `json:"account,omitempty"`
+	Label   string `json:"label,omitempty"`
+
+	Imported bool  `json:"imported,omitempty"`
+	Expires  int64 `json:"expires,omitempty"` // unix time in seconds
 }
 
 // NewReadableEntry creates readable wallet entry
 func NewReadableEntry(coinType CoinType, w Entry) ReadableEntry {
-	re := ReadableEntry{}
+	re := ReadableEntry{
+		Frozen:  w.Frozen,
+		Account: w.Account,
+		Label:   w.Label,
+
+		Imported: w.Imported,
+	}
+	if !w.Expires.IsZero() {
+		re.Expires = w.Expires.Unix()
+	}
 	if !w.Address.Null() {
 		re.Address = w.Address.String()
 	}
@@ -27,11 +44,11 @@ func NewReadableEntry(coinType CoinType, w Entry) ReadableEntry {
 	}
 
 	if !w.Secret.Null() {
-		switch coinType {
-		case CoinTypeSkycoin:
-			re.Secret = w.Secret.Hex()
-		case CoinTypeBitcoin:
+		switch {
+		case coinType == CoinTypeBitcoin:
 			re.Secret = cipher.BitcoinWalletImportFormatFromSeckey(w.Secret)
+		case usesSkycoinAddresses(coinType):
+			re.Secret = w.Secret.Hex()
 		default:
 			logger.Panicf("Invalid coin type %q", coinType)
 		}
@@ -70,11 +87,11 @@ func newEntryFromReadable(coinType CoinType, w *ReadableEntry) (*Entry, error) {
 	var a cipher.Addresser
 	var err error
 
-	switch coinType {
-	case CoinTypeSkycoin:
-		a, err = cipher.DecodeBase58Address(w.Address)
-	case CoinTypeBitcoin:
+	switch {
+	case coinType == CoinTypeBitcoin:
 		a, err = cipher.DecodeBase58BitcoinAddress(w.Address)
+	case usesSkycoinAddresses(coinType):
+		a, err = cipher.DecodeBase58Address(w.Address)
 	default:
 		logger.Panicf("Invalid coin type %q", coinType)
 	}
@@ -83,19 +100,23 @@ func newEntryFromReadable(coinType CoinType, w *ReadableEntry) (*Entry, error) {
 		return nil, err
 	}
 
-	p, err := cipher.PubKeyFromHex(w.Public)
-	if err != nil {
-		return nil, err
+	// Entries of watch-only wallets have no public key
+	var p cipher.PubKey
+	if w.Public != "" {
+		p, err = cipher.PubKeyFromHex(w.Public)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Decodes the secret hex string if any
 	var secret cipher.SecKey
 	if w.Secret != "" {
-		switch coinType {
-		case CoinTypeSkycoin:
-			secret, err = cipher.SecKeyFromHex(w.Secret)
-		case CoinTypeBitcoin:
+		switch {
+		case coinType == CoinTypeBitcoin:
 			secret, err = cipher.SecKeyFromBitcoinWalletImportFormat(w.Secret)
+		case usesSkycoinAddresses(coinType):
+			secret, err = cipher.SecKeyFromHex(w.Secret)
 		default:
 			logger.Panicf("Invalid coin type %q", coinType)
 		}
@@ -104,10 +125,21 @@ func newEntryFromReadable(coinType CoinType, w *ReadableEntry) (*Entry, error) {
 		}
 	}
 
+	var expires time.Time
+	if w.Expires != 0 {
+		expires = time.Unix(w.Expires, 0)
+	}
+
 	return &Entry{
 		Address: a,
 		Public:  p,
 		Secret:  secret,
+		Frozen:  w.Frozen,
+		Account: w.Account,
+		Label:   w.Label,
+
+		Imported: w.Imported,
+		Expires:  expires,
 	}, nil
 }
 
@@ -181,6 +213,25 @@ func (rw *ReadableWallet) timestamp() int64 {
 	return x
 }
 
+// normalizeCoinType normalizes the coin type, older wallets used different names for the coin type
+func (rw *ReadableWallet) normalizeCoinType() {
+	if coin, ok := rw.Meta[metaCoin]; ok {
+		rw.Meta[metaCoin] = string(normalizeCoinType(coin))
+	}
+}
+
+// normalizeCoinType returns the coin type for the names used by older wallets, other names are returned unchanged
+func normalizeCoinType(coin string) CoinType {
+	switch strings.ToLower(coin) {
+	case "sky", "skycoin":
+		return CoinTypeSkycoin
+	case "btc", "bitcoin":
+		return CoinTypeBitcoin
+	default:
+		return CoinType(coin)
+	}
+}
+
 func (rw *ReadableWallet) filename() string {
 	return rw.Meta[metaFilename]
 }
@@ -4,8 +4,9 @@ import "encoding/json"
 
 // secrets key name
 const (
-	secretSeed     = "seed"
-	secretLastSeed = "lastSeed"
+	secretSeed           = "seed"
+	secretLastSeed       = "lastSeed"
+	secretSeedPassphrase = "seedPassphrase"
 )
 
 type secrets map[string]string
@@ -33,3 +34,10 @@ func (s secrets) erase() {
 		delete(s, k)
 	}
 }
+
+// eraseBytes wipes b
+func eraseBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,108 @@
+package wallet
+
+import "sync"
+
+// SecretStore holds the decrypted secrets of encrypted wallets while they are unlocked, see Config.SecretStore.
+// The secrets are stored by wallet id, serialized as they are before encryption. Implementations can keep
+// them outside of the Go heap, e.g. in secure hardware.
+// Put must copy data, as the caller erases it afterwards, and the caller erases the data returned by Get.
+// The methods are called with a lock held and don't need to be safe for concurrent use of the same wallet id.
+type SecretStore interface {
+	// Put stores the secrets of a wallet, replacing any stored secrets of the wallet
+	Put(wltID string, data []byte) error
+	// Get returns a copy of the stored secrets of a wallet, or ErrSecretsNotFound
+	Get(wltID string) ([]byte, error)
+	// Erase wipes the stored secrets of a wallet. Erasing secrets that are not stored is not an error.
+	Erase(wltID string) error
+}
+
+// memorySecretStore is the default SecretStore, which keeps the secrets in memory
+type memorySecretStore struct {
+	sync.Mutex
+	secrets map[string][]byte
+}
+
+// NewMemorySecretStore creates a SecretStore that keeps the secrets in memory
+func NewMemorySecretStore() SecretStore {
+	return &memorySecretStore{
+		secrets: make(map[string][]byte),
+	}
+}
+
+func (s *memorySecretStore) Put(wltID string, data []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	s.eraseLocked(wltID)
+	s.secrets[wltID] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *memorySecretStore) Get(wltID string) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	data, ok := s.secrets[wltID]
+	if !ok {
+		return nil, ErrSecretsNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (s *memorySecretStore) Erase(wltID string) error {
+	s.Lock()
+	defer s.Unlock()
+	s.eraseLocked(wltID)
+	return nil
+}
+
+func (s *memorySecretStore) eraseLocked(wltID string) {
+	eraseBytes(s.secrets[wltID])
+	delete(s.secrets, wltID)
+}
+
+// sharedSecretStore lets the unlock cache and the guarded calls of the wallets of a service, which may run
+// concurrently for the same wallet, hold the secrets of a wallet in the same SecretStore. The secrets of a wallet
+// are decrypted from the same encrypted secrets by every holder, and are erased from the store with the last holder.
+type sharedSecretStore struct {
+	sync.Mutex
+	store SecretStore
+	refs  map[string]int
+}
+
+// newSharedSecretStore shares store, returns nil if store is nil
+func newSharedSecretStore(store SecretStore) SecretStore {
+	if store == nil {
+		return nil
+	}
+
+	return &sharedSecretStore{
+		store: store,
+		refs:  make(map[string]int),
+	}
+}
+
+func (s *sharedSecretStore) Put(wltID string, data []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	if err := s.store.Put(wltID, data); err != nil {
+		return err
+	}
+	s.refs[wltID]++
+	return nil
+}
+
+func (s *sharedSecretStore) Get(wltID string) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.store.Get(wltID)
+}
+
+func (s *sharedSecretStore) Erase(wltID string) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.refs[wltID] > 1 {
+		s.refs[wltID]--
+		return nil
+	}
+	delete(s.refs, wltID)
+	return s.store.Erase(wltID)
+}
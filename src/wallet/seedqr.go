@@ -0,0 +1,159 @@
+package wallet
+
+import (
+	"errors"
+
+	"github.com/amherag/skycoin/src/util/qrcode"
+)
+
+const (
+	// SeedQRVersion is the current version of the encrypted seed QR payload format
+	SeedQRVersion byte = 1
+
+	// seedQRScale is the size in pixels of each QR code module in exported images
+	seedQRScale = 8
+
+	// seedQRScanN is the number of addresses scanned ahead for a balance when importing from a seed QR code
+	seedQRScanN = 20
+)
+
+var (
+	// ErrMalformedSeedQR is returned if encrypted seed QR code data can't be parsed
+	ErrMalformedSeedQR = NewError(errors.New("malformed encrypted seed QR code data"))
+	// ErrUnsupportedSeedQRVersion is returned if encrypted seed QR code data has an unknown version
+	ErrUnsupportedSeedQRVersion = NewError(errors.New("unsupported encrypted seed QR code version"))
+	// ErrSeedQRBip39 is returned when exporting a BIP39 wallet to a seed QR code, which can't record the BIP39 derivation
+	ErrSeedQRBip39 = NewError(errors.New("encrypted seed QR codes of bip39 wallets are not supported"))
+)
+
+// seedQRPayload is the data encoded in an encrypted seed QR code.
+// Its serialized format is [version:1][len(cryptoType):1][cryptoType][len(coin):1][coin][secrets].
+// secrets is the wallet's encrypted secrets blob, which carries the key derivation
+// parameters and salt of the crypto type, so that it can be decrypted with the password alone.
+type seedQRPayload struct {
+	Version    byte
+	CryptoType CryptoType
+	Coin       CoinType
+	Secrets    []byte
+}
+
+// newSeedQRPayload creates the payload for an encrypted wallet
+func newSeedQRPayload(w *Wallet) (*seedQRPayload, error) {
+	if !w.IsEncrypted() {
+		return nil, ErrWalletNotEncrypted
+	}
+
+	if w.IsBip39() {
+		return nil, ErrSeedQRBip39
+	}
+
+	return &seedQRPayload{
+		Version:    SeedQRVersion,
+		CryptoType: w.cryptoType(),
+		Coin:       w.coin(),
+		Secrets:    []byte(w.secrets()),
+	}, nil
+}
+
+func (p *seedQRPayload) serialize() []byte {
+	b := make([]byte, 0, 3+len(p.CryptoType)+len(p.Coin)+len(p.Secrets))
+	b = append(b, p.Version)
+	b = append(b, byte(len(p.CryptoType)))
+	b = append(b, p.CryptoType...)
+	b = append(b, byte(len(p.Coin)))
+	b = append(b, p.Coin...)
+	return append(b, p.Secrets...)
+}
+
+// png renders the serialized payload as a QR code PNG image
+func (p *seedQRPayload) png() ([]byte, error) {
+	c, err := qrcode.Encode(p.serialize(), qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.PNG(seedQRScale)
+}
+
+// parseSeedQRPayload parses serialized seed QR code data
+func parseSeedQRPayload(b []byte) (*seedQRPayload, error) {
+	if len(b) == 0 {
+		return nil, ErrMalformedSeedQR
+	}
+
+	if b[0] != SeedQRVersion {
+		return nil, ErrUnsupportedSeedQRVersion
+	}
+	b = b[1:]
+
+	readField := func() (string, error) {
+		if len(b) == 0 || len(b) < 1+int(b[0]) {
+			return "", ErrMalformedSeedQR
+		}
+		v := string(b[1 : 1+int(b[0])])
+		b = b[1+int(b[0]):]
+		return v, nil
+	}
+
+	ct, err := readField()
+	if err != nil {
+		return nil, err
+	}
+	cryptoType, err := CryptoTypeFromString(ct)
+	if err != nil {
+		return nil, ErrMalformedSeedQR
+	}
+
+	coin, err := readField()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) == 0 {
+		return nil, ErrMalformedSeedQR
+	}
+
+	return &seedQRPayload{
+		Version:    SeedQRVersion,
+		CryptoType: cryptoType,
+		Coin:       CoinType(coin),
+		Secrets:    b,
+	}, nil
+}
+
+// decrypt decrypts the secrets with the password,
+// returning the seed and the number of address entries the wallet had when exported
+func (p *seedQRPayload) decrypt(password []byte) (string, uint64, error) {
+	if len(password) == 0 {
+		return "", 0, ErrMissingPassword
+	}
+
+	crypto, err := getCrypto(p.CryptoType)
+	if err != nil {
+		return "", 0, err
+	}
+
+	sb, err := crypto.Decrypt(p.Secrets, password)
+	if err != nil {
+		return "", 0, ErrInvalidPassword
+	}
+
+	ss := make(secrets)
+	defer ss.erase()
+	if err := ss.deserialize(sb); err != nil {
+		return "", 0, ErrMalformedSeedQR
+	}
+
+	seed, ok := ss.get(secretSeed)
+	if !ok || seed == "" {
+		return "", 0, ErrMalformedSeedQR
+	}
+
+	// The secrets contain the seed, the last seed and the secret key of each address
+	var n uint64
+	if len(ss) > 2 {
+		n = uint64(len(ss) - 2)
+	}
+
+	return seed, n, nil
+}
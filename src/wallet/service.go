@@ -1,18 +1,46 @@
 package wallet
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/amherag/skycoin/src/cipher"
+	"github.com/amherag/skycoin/src/cipher/bip39"
+	"github.com/amherag/skycoin/src/cipher/encrypt"
+	"github.com/amherag/skycoin/src/coin"
+	"github.com/amherag/skycoin/src/util/droplet"
+	"github.com/amherag/skycoin/src/util/file"
+	"github.com/amherag/skycoin/src/util/mathutil"
 )
 
+// newAddressesProgressBatch is the number of addresses generated between progress updates in NewAddressesProgress
+const newAddressesProgressBatch = 100
+
+// recoverAllScanN is the number of addresses scanned ahead for a balance by RecoverAllFromSeedPhrase
+const recoverAllScanN = 20
+
 // BalanceGetter interface for getting the balance of given addresses
 type BalanceGetter interface {
 	GetBalanceOfAddrs(addrs []cipher.Address) ([]BalancePair, error)
 }
 
+// Faucet interface for requesting coins to be sent to an address, e.g. from a test node
+type Faucet interface {
+	Fund(addr cipher.Address) error
+}
+
 // Service wallet service struct
 type Service struct {
 	sync.RWMutex
@@ -20,6 +48,46 @@ type Service struct {
 	config  Config
 	// firstAddrIDMap Key: first address in wallet; Value: wallet id
 	firstAddrIDMap map[string]string
+	// addrIDsMap Key: address of any wallet; Value: sorted ids of the wallets containing the address
+	addrIDsMap map[string][]string
+	// walletAddrs Key: wallet id; Value: the addresses of the wallet in addrIDsMap
+	walletAddrs map[string]map[string]struct{}
+	// lazyWallets wallets that have been indexed but not loaded yet, only used if Config.LazyLoad is true
+	lazyWallets lazyWallets
+	// addressPools Key: wallet id; Value: address pool policy, see SetAddressPoolPolicy
+	addressPools map[string]addressPoolPolicy
+	// fileHashes Key: wallet id; Value: hash of the wallet file when it was last loaded or saved
+	fileHashes map[string]cipher.SHA256
+	// cache evicts the least recently used lazy wallets, only used if Config.MaxCachedWallets > 0
+	cache *walletCache
+	// unlockCache keeps decrypted secrets for ViewSecrets, only used if Config.UnlockCacheTTL > 0
+	unlockCache *unlockCache
+	// pendingSaves Key: wallet id; Value: wallet waiting to be written to disk, only used if Config.SaveDebounce > 0
+	pendingSaves map[string]*pendingSave
+	// pendingReencrypts Key: wallet id; Value: decrypted wallet waiting to be re-encrypted, only used if Config.AutoReencryptAfter > 0
+	pendingReencrypts map[string]*pendingReencrypt
+	// events delivers wallet events to the subscribers, see Subscribe
+	events *eventBus
+}
+
+// pendingSave is a debounced write of a wallet, see Config.SaveDebounce
+type pendingSave struct {
+	w      *Wallet
+	timer  *time.Timer
+	events []WalletEventKind // events of the changes waiting to be written, see publishSaved
+}
+
+// pendingReencrypt is a scheduled re-encryption of a decrypted wallet, see Config.AutoReencryptAfter
+type pendingReencrypt struct {
+	password []byte
+	timer    *time.Timer
+}
+
+// addressPoolPolicy configures the automatic address generation of NextUnusedAddress
+type addressPoolPolicy struct {
+	minUnused uint64
+	refillTo  uint64
+	bg        BalanceGetter
 }
 
 // Config wallet service config
@@ -28,6 +96,111 @@ type Config struct {
 	CryptoType      CryptoType
 	EnableWalletAPI bool
 	EnableSeedAPI   bool
+	// AllowDuplicateSeeds permits loading multiple wallets that were created from the same seed,
+	// as long as their filenames differ. This is disabled by default. When enabled, the wallets
+	// share the same addresses, so balances will be reported once per wallet and operations on one
+	// wallet (e.g. generating new addresses) are not reflected in the others.
+	AllowDuplicateSeeds bool
+	// MergeDuplicatesOnLoad makes loading the wallets resolve wallet files that share their first address,
+	// instead of failing. Of each set of duplicates, the wallet with the most addresses is kept, then the one
+	// with the most metadata. The others are moved to the QuarantineDir subdirectory of WalletDir,
+	// and a warning is logged for each of them. Ignored if AllowDuplicateSeeds is set.
+	MergeDuplicatesOnLoad bool
+	// LazyLoad makes NewService only index the wallet files by filename and first address.
+	// Each wallet file is fully loaded the first time the wallet is accessed.
+	LazyLoad bool
+	// StrictWalletIDs disables the normalization of wallet ids. By default, a wallet id that
+	// doesn't match any wallet exactly is trimmed and matched case-insensitively.
+	StrictWalletIDs bool
+	// KeepBackups is the number of .wlt.bak files retained per wallet when starting the service.
+	// Instead of being removed, the backups are rotated to .wlt.bak.1, .wlt.bak.2, etc. and only the
+	// oldest backups beyond KeepBackups are removed. If zero or less, the .wlt.bak files are removed.
+	KeepBackups int
+	// UniqueLabels makes wallet creation and UpdateWalletLabel reject a label that is already
+	// used by another wallet with ErrLabelInUse. Empty labels are not considered.
+	UniqueLabels bool
+	// MaxCachedWallets limits the number of parsed wallets kept in memory. If greater than zero,
+	// the wallets are lazily loaded as with LazyLoad, and the least recently used wallets are
+	// dropped from memory and parsed again from their files when accessed. The wallet ids and
+	// first addresses always stay in memory.
+	MaxCachedWallets int
+	// EnableDevAPI enables helpers meant for local development against a test node, e.g. GenerateAndFund.
+	// It must not be enabled in production.
+	EnableDevAPI bool
+	// SeedDeriver replaces the standard key pair derivation for the seeds of the deterministic chain,
+	// for forks that derive keys differently. Nil uses cipher.GenerateDeterministicKeyPair.
+	SeedDeriver func(seed []byte) (cipher.PubKey, cipher.SecKey, error)
+	// IndexFilter is the Options.IndexFilter of the deterministic wallets of the service. Like SeedDeriver,
+	// it is applied to every wallet whenever it is loaded, so that a wallet keeps skipping the same indexes
+	// after it is reloaded or dropped from the cache. Service.CreateWallet rejects Options.IndexFilter
+	// with ErrIndexFilterOption. Nil stores every index.
+	IndexFilter func(index uint64) bool
+	// BalanceFetchBatchSize splits the addresses of a balance request into batches of at most this many
+	// addresses, each requested from the BalanceGetter in a separate call. If zero or less, all addresses
+	// are requested in a single call.
+	BalanceFetchBatchSize int
+	// BalanceFetchConcurrency is the maximum number of batches of a balance request fetched at the same
+	// time, see BalanceFetchBatchSize. If greater than one, the BalanceGetter must be safe for concurrent use.
+	// If zero or less, the batches are fetched one at a time.
+	BalanceFetchConcurrency int
+	// WalletNotesMaxLength is the maximum length in characters of the notes set with SetWalletNotes.
+	// If zero or less, DefaultWalletNotesMaxLength is used.
+	WalletNotesMaxLength int
+	// UnlockCacheTTL enables caching the decrypted copy of an encrypted wallet in ViewSecrets for this long,
+	// so that repeated calls with the same password skip the expensive key derivation. The decrypted copy is
+	// keyed by a salted hash of the password, and is erased when it expires, when the wallet changes and on Close.
+	// Caching keeps secrets in memory and is disabled if zero or less, which is the default.
+	UnlockCacheTTL time.Duration
+	// SecretStore holds the decrypted secrets of the encrypted wallets of the service while they are unlocked:
+	// during Wallet.GuardView and Wallet.GuardUpdate, which back ViewSecrets, UpdateSecrets and the other methods
+	// taking a password, and while they are cached by ViewSecrets, see UnlockCacheTTL. It can keep them outside
+	// of the Go heap, e.g. in secure hardware. If nil, the secrets are kept in memory.
+	// The decrypted wallets passed to the functions of ViewSecrets and UpdateSecrets are always kept in memory.
+	SecretStore SecretStore
+	// SaveDebounce delays writing a changed wallet to disk by this long, so that successive changes to the
+	// same wallet are written at once. Changes are visible in memory immediately. Pending writes are done
+	// by Flush and Close, and before a wallet is unloaded. New wallets and changes to the encryption of a
+	// wallet are always written immediately. Writes are not delayed if zero or less, which is the default.
+	SaveDebounce time.Duration
+	// MaxWallets is the maximum number of wallets, creating or loading more wallets returns ErrWalletLimitReached.
+	// If more wallet files are found on startup, a warning is logged, or loading fails if MaxWalletsStrict is set.
+	// If zero or less, the number of wallets is unlimited.
+	MaxWallets int
+	// MaxWalletsStrict makes loading the wallets fail if there are more than MaxWallets wallet files
+	MaxWalletsStrict bool
+	// MaxSearchResults is the maximum number of addresses returned by SearchAddresses.
+	// If zero or less, DefaultMaxSearchResults is used.
+	MaxSearchResults int
+	// ImportRenameOnCollision makes ImportEncryptedSeedQR and ImportWallet save the wallet under a new unique filename
+	// if a wallet with the given filename exists, instead of failing with ErrWalletNameConflict. Wallets with a seed
+	// that is already used are still rejected, unless AllowDuplicateSeeds is set.
+	ImportRenameOnCollision bool
+	// AutoReencryptAfter makes DecryptWallet remember the password in memory, and encrypt the wallet with it again
+	// after this long, so that a decrypted wallet is not left unencrypted on disk. The re-encryption can be
+	// canceled with CancelAutoReencrypt, and is done immediately by Close. If the wallet can't be re-encrypted,
+	// e.g. because it was made read-only, a warning is logged. Disabled if zero or less, which is the default.
+	AutoReencryptAfter time.Duration
+	// AddressGenBatchSize makes NewAddresses and its variants generate more than this many addresses in batches,
+	// releasing the service lock between batches so that other operations are not stalled while many addresses
+	// are generated. Each batch is saved on its own, so the wallet file is always updated atomically per batch,
+	// and if a batch fails, e.g. because the wallet was unloaded in the meantime, the earlier batches are kept.
+	// Encrypted wallets are decrypted again for every batch, so small batches make these slower.
+	// Disabled if zero, which is the default.
+	AddressGenBatchSize uint64
+	// PasswordValidator is called with every new wallet password before it is used, by CreateWallet with
+	// Options.Encrypt, EncryptWallet, TestEncrypt and RecoverWallet. If it returns an error, the operation
+	// is aborted with that error. It is not called for passwords that unlock an already encrypted wallet.
+	// No passwords are rejected if nil, which is the default.
+	PasswordValidator func(password []byte) error
+	// ScryptN, ScryptR, ScryptP and ScryptKeyLen are the scrypt parameters of wallets encrypted with
+	// CryptoTypeScryptChacha20poly1305, by CreateWallet, EncryptWallet and ChangePassword. NewConfig sets them to
+	// the defaults of the encrypt package, which are also used for zero values. Lower them on slow devices, raise them
+	// for more security. The parameters are stored with the encrypted secrets of each wallet, so wallets encrypted
+	// with other parameters are still decrypted.
+	ScryptN      int
+	ScryptR      int
+	ScryptP      int
+	ScryptKeyLen int
 }
 
 // NewConfig creates a default Config
@@ -37,48 +210,244 @@ func NewConfig() Config {
 		CryptoType:      CryptoTypeScryptChacha20poly1305,
 		EnableWalletAPI: false,
 		EnableSeedAPI:   false,
+		ScryptN:         encrypt.ScryptN,
+		ScryptR:         encrypt.ScryptR,
+		ScryptP:         encrypt.ScryptP,
+		ScryptKeyLen:    encrypt.ScryptKeyLen,
 	}
 }
 
 // NewService new wallet service
 func NewService(c Config) (*Service, error) {
+	c.SecretStore = newSharedSecretStore(c.SecretStore)
 	serv := &Service{
-		config:         c,
-		firstAddrIDMap: make(map[string]string),
+		config:            c,
+		firstAddrIDMap:    make(map[string]string),
+		addrIDsMap:        make(map[string][]string),
+		walletAddrs:       make(map[string]map[string]struct{}),
+		addressPools:      make(map[string]addressPoolPolicy),
+		fileHashes:        make(map[string]cipher.SHA256),
+		cache:             newWalletCache(c.MaxCachedWallets),
+		unlockCache:       newUnlockCache(c.UnlockCacheTTL, c.SecretStore),
+		pendingSaves:      make(map[string]*pendingSave),
+		pendingReencrypts: make(map[string]*pendingReencrypt),
+		events:            newEventBus(),
+	}
+
+	if c.CryptoType == CryptoTypeScryptChacha20poly1305 {
+		if _, err := serv.cryptor(); err != nil {
+			return nil, fmt.Errorf("invalid scrypt parameters: %v", err)
+		}
 	}
 
 	if !serv.config.EnableWalletAPI {
 		return serv, nil
 	}
 
-	if err := os.MkdirAll(c.WalletDir, os.FileMode(0700)); err != nil {
-		return nil, fmt.Errorf("failed to create wallet directory %s: %v", c.WalletDir, err)
+	if err := serv.loadWallets(); err != nil {
+		return nil, err
+	}
+
+	return serv, nil
+}
+
+// EnableAPI enables the wallet API of a service created with Config.EnableWalletAPI false,
+// creating the wallet directory and loading the wallets like NewService does.
+// Returns ErrWalletAPIEnabled if the wallet API is already enabled.
+// If loading fails, the wallet API stays disabled.
+func (serv *Service) EnableAPI() error {
+	serv.Lock()
+	defer serv.Unlock()
+	if serv.config.EnableWalletAPI {
+		return ErrWalletAPIEnabled
+	}
+
+	if err := serv.loadWallets(); err != nil {
+		serv.wallets = nil
+		serv.lazyWallets = nil
+		serv.firstAddrIDMap = make(map[string]string)
+		serv.addrIDsMap = make(map[string][]string)
+		serv.walletAddrs = make(map[string]map[string]struct{})
+		serv.fileHashes = make(map[string]cipher.SHA256)
+		return err
+	}
+
+	serv.config.EnableWalletAPI = true
+	return nil
+}
+
+// loadWallets creates the wallet directory and loads or indexes the wallets in it
+func (serv *Service) loadWallets() error {
+	if err := os.MkdirAll(serv.config.WalletDir, os.FileMode(0700)); err != nil {
+		return fmt.Errorf("failed to create wallet directory %s: %v", serv.config.WalletDir, err)
 	}
 
 	// Removes .wlt.bak files before loading wallets
-	if err := removeBackupFiles(serv.config.WalletDir); err != nil {
-		return nil, fmt.Errorf("remove .wlt.bak files in %v failed: %v", serv.config.WalletDir, err)
+	if err := removeBackupFiles(serv.config.WalletDir, serv.config.KeepBackups); err != nil {
+		return fmt.Errorf("remove .wlt.bak files in %v failed: %v", serv.config.WalletDir, err)
+	}
+
+	if serv.config.MergeDuplicatesOnLoad && !serv.config.AllowDuplicateSeeds {
+		if err := serv.quarantineDuplicates(); err != nil {
+			return err
+		}
+	}
+
+	if serv.lazyLoad() {
+		if err := serv.indexWallets(); err != nil {
+			return err
+		}
+		return serv.checkMaxWallets()
 	}
 
 	// Load wallets from disk
 	w, err := LoadWallets(serv.config.WalletDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load all wallets: %v", err)
+		return fmt.Errorf("failed to load all wallets: %v", err)
 	}
 
 	// Abort if there are duplicate wallets on disk
-	if wltID, addr, hasDup := w.containsDuplicate(); hasDup {
-		return nil, fmt.Errorf("duplicate wallet found with initial address %s in file %q", addr, wltID)
+	if !serv.config.AllowDuplicateSeeds {
+		if wltID, addr, hasDup := w.containsDuplicate(); hasDup {
+			return fmt.Errorf("duplicate wallet found with initial address %s in file %q", addr, wltID)
+		}
 	}
 
-	// Abort if there are empty wallets on disk
-	if wltID, hasEmpty := w.containsEmpty(); hasEmpty {
-		return nil, fmt.Errorf("empty wallet file found: %q", wltID)
+	serv.setWallets(w)
+
+	for wltID := range w {
+		if err := serv.recordFileHash(wltID); err != nil {
+			return err
+		}
 	}
 
-	serv.setWallets(w)
+	return serv.checkMaxWallets()
+}
 
-	return serv, nil
+// quarantineDuplicates moves the wallet files that duplicate another wallet file to QuarantineDir,
+// see Config.MergeDuplicatesOnLoad
+func (serv *Service) quarantineDuplicates() error {
+	dups, err := findDuplicateWallets(serv.config.WalletDir)
+	if err != nil {
+		return fmt.Errorf("failed to check for duplicate wallets: %v", err)
+	}
+
+	if len(dups) == 0 {
+		return nil
+	}
+
+	dir := filepath.Join(serv.config.WalletDir, QuarantineDir)
+	if err := os.MkdirAll(dir, os.FileMode(0700)); err != nil {
+		return fmt.Errorf("failed to create quarantine directory %s: %v", dir, err)
+	}
+
+	kept := make([]string, 0, len(dups))
+	for wltID := range dups {
+		kept = append(kept, wltID)
+	}
+	sort.Strings(kept)
+
+	for _, wltID := range kept {
+		for _, dupID := range dups[wltID] {
+			dst := filepath.Join(dir, dupID)
+			for i := 1; ; i++ {
+				if ok, err := file.Exists(dst); err != nil {
+					return err
+				} else if !ok {
+					break
+				}
+				dst = filepath.Join(dir, fmt.Sprintf("%s.%d", dupID, i))
+			}
+
+			if err := os.Rename(filepath.Join(serv.config.WalletDir, dupID), dst); err != nil {
+				return fmt.Errorf("failed to quarantine duplicate wallet %s: %v", dupID, err)
+			}
+
+			logger.Warningf("Wallet %s has the same first address as wallet %s, moved it to %s", dupID, wltID, dst)
+		}
+	}
+
+	return nil
+}
+
+// CompactStorage removes the backups of the wallet files, .wlt.bak and its rotations kept with Config.KeepBackups,
+// and the wallet files moved to QuarantineDir by Config.MergeDuplicatesOnLoad, that exceed the retention limits
+// of the policy. The oldest files are removed first. The wallet files themselves are never removed.
+// If removing a file fails, the files removed so far are reported with the error.
+func (serv *Service) CompactStorage(policy CompactionPolicy) (CompactionReport, error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return CompactionReport{}, ErrWalletAPIDisabled
+	}
+
+	report, err := compactStorage(serv.config.WalletDir, policy, time.Now())
+	for _, f := range report.Removed {
+		logger.Infof("Removed %s to compact the wallet storage", f)
+	}
+
+	return report, err
+}
+
+// checkMaxWallets warns, or fails if Config.MaxWalletsStrict is set, if there are more than Config.MaxWallets wallets
+func (serv *Service) checkMaxWallets() error {
+	if serv.config.MaxWallets <= 0 || serv.walletCount() <= serv.config.MaxWallets {
+		return nil
+	}
+
+	if serv.config.MaxWalletsStrict {
+		return fmt.Errorf("found %d wallets in %s, more than the maximum of %d", serv.walletCount(), serv.config.WalletDir, serv.config.MaxWallets)
+	}
+
+	logger.Warningf("Found %d wallets in %s, more than the maximum of %d, no more wallets can be added", serv.walletCount(), serv.config.WalletDir, serv.config.MaxWallets)
+	return nil
+}
+
+// walletCount returns the number of loaded and indexed wallets
+func (serv *Service) walletCount() int {
+	return len(serv.wallets) + len(serv.lazyWallets)
+}
+
+// canAddWallet returns ErrWalletLimitReached if adding a wallet would exceed Config.MaxWallets
+func (serv *Service) canAddWallet() error {
+	if serv.config.MaxWallets > 0 && serv.walletCount() >= serv.config.MaxWallets {
+		return ErrWalletLimitReached
+	}
+	return nil
+}
+
+// indexWallets indexes the wallets on disk without loading them
+func (serv *Service) indexWallets() error {
+	lw, err := indexWallets(serv.config.WalletDir)
+	if err != nil {
+		return fmt.Errorf("failed to index all wallets: %v", err)
+	}
+
+	// Abort if there are duplicate wallets on disk
+	if !serv.config.AllowDuplicateSeeds {
+		if wltID, addr, hasDup := lw.containsDuplicate(); hasDup {
+			return fmt.Errorf("duplicate wallet found with initial address %s in file %q", addr, wltID)
+		}
+	}
+
+	serv.wallets = Wallets{}
+	serv.lazyWallets = lw
+	for wltID, w := range lw {
+		if err := serv.recordFileHash(wltID); err != nil {
+			return err
+		}
+
+		// Empty wallets are kept so that they can be repaired, see RepairEmptyWallet
+		if w.firstAddr == "" {
+			logger.Warningf("Wallet %s has no addresses, it can't be checked for duplicate seeds", wltID)
+			continue
+		}
+
+		serv.firstAddrIDMap[w.firstAddr] = wltID
+		serv.indexAddresses(wltID, w.addrs)
+	}
+
+	return nil
 }
 
 // WalletDir returns the configured wallet directory
@@ -92,7 +461,8 @@ func (serv *Service) WalletDir() (string, error) {
 }
 
 // CreateWallet creates a wallet with the given wallet file name and options.
-// A address will be automatically generated by default.
+// options.GenerateN addresses are generated before the wallet is saved, so that the wallet is written once.
+// If options.GenerateN is zero, a single address is generated.
 func (serv *Service) CreateWallet(wltName string, options Options, bg BalanceGetter) (*Wallet, error) {
 	serv.Lock()
 	defer serv.Unlock()
@@ -103,263 +473,4135 @@ func (serv *Service) CreateWallet(wltName string, options Options, bg BalanceGet
 		wltName = serv.generateUniqueWalletFilename()
 	}
 
+	if options.Encrypt {
+		if err := serv.validatePassword(options.Password); err != nil {
+			return nil, err
+		}
+	}
+
 	return serv.loadWallet(wltName, options, bg)
 }
 
-// loadWallet loads wallet from seed and scan the first N addresses
-func (serv *Service) loadWallet(wltName string, options Options, bg BalanceGetter) (*Wallet, error) {
-	// service decides what crypto type the wallet should use.
-	if options.Encrypt {
-		options.CryptoType = serv.config.CryptoType
+// cryptor returns the cryptor that encrypts wallets with the configured crypto type,
+// using the configured scrypt parameters for CryptoTypeScryptChacha20poly1305
+func (serv *Service) cryptor() (cryptor, error) {
+	if serv.config.CryptoType == CryptoTypeScryptChacha20poly1305 {
+		return newScryptCryptor(serv.config.ScryptN, serv.config.ScryptR, serv.config.ScryptP, serv.config.ScryptKeyLen)
 	}
+	return getCrypto(serv.config.CryptoType)
+}
 
-	w, err := NewWalletScanAhead(wltName, options, bg)
+// lockWallet encrypts the wallet with the password and the configured crypto type
+func (serv *Service) lockWallet(w *Wallet, password []byte) error {
+	crypto, err := serv.cryptor()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return w.lock(password, serv.config.CryptoType, crypto)
+}
 
-	// Check for duplicate wallets by initial seed
-	if _, ok := serv.firstAddrIDMap[w.Entries[0].Address.String()]; ok {
-		return nil, ErrSeedUsed
+// validatePassword checks a new wallet password with Config.PasswordValidator
+func (serv *Service) validatePassword(password []byte) error {
+	if serv.config.PasswordValidator == nil {
+		return nil
 	}
+	return serv.config.PasswordValidator(password)
+}
 
-	if err := serv.wallets.add(w); err != nil {
-		return nil, err
+// CreateWalletReq is a wallet to create with CreateWallets
+type CreateWalletReq struct {
+	// Name is the wallet file name, a unique name is generated if empty
+	Name    string
+	Options Options
+}
+
+// CreateWallets creates several wallets like CreateWallet, holding the service lock once for the whole batch.
+// All the wallets are created and checked for conflicts with the existing wallets and with each other
+// before any of them is saved. If any wallet can't be created or saved, none of them is added and the
+// wallet files written so far are removed, and a WalletErrors error is returned with the errors by wallet name.
+// The wallets are returned in the order of reqs.
+func (serv *Service) CreateWallets(reqs []CreateWalletReq, bg BalanceGetter) ([]*Wallet, error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
 	}
 
-	if err := w.Save(serv.config.WalletDir); err != nil {
-		// If save fails, remove the added wallet
-		serv.wallets.remove(w.Filename())
-		return nil, err
+	if serv.config.MaxWallets > 0 && serv.walletCount()+len(reqs) > serv.config.MaxWallets {
+		return nil, ErrWalletLimitReached
 	}
 
-	serv.firstAddrIDMap[w.Entries[0].Address.String()] = w.Filename()
+	wlts := make([]*Wallet, len(reqs))
+	wltErrs := make(WalletErrors)
+	names := make(map[string]struct{}, len(reqs))
+	labels := make(map[string]struct{}, len(reqs))
+	firstAddrs := make(map[string]struct{}, len(reqs))
+	for i, req := range reqs {
+		name := req.Name
+		for name == "" {
+			name = serv.generateUniqueWalletFilename()
+			if _, ok := names[name]; ok {
+				name = ""
+			}
+		}
 
-	return w.clone(), nil
+		if _, ok := names[name]; ok {
+			wltErrs[name] = ErrWalletNameConflict
+			continue
+		}
+		names[name] = struct{}{}
+
+		if req.Options.Encrypt {
+			if err := serv.validatePassword(req.Options.Password); err != nil {
+				wltErrs[name] = err
+				continue
+			}
+		}
+
+		label := req.Options.Label
+		if _, ok := labels[label]; ok && serv.config.UniqueLabels && label != "" {
+			wltErrs[name] = ErrLabelInUse
+			continue
+		}
+		labels[label] = struct{}{}
+
+		w, err := serv.prepareWallet(name, req.Options, bg)
+		if err != nil {
+			wltErrs[name] = err
+			continue
+		}
+
+		addr := w.Entries[0].Address.String()
+		if _, ok := firstAddrs[addr]; ok && !serv.config.AllowDuplicateSeeds {
+			wltErrs[name] = ErrSeedUsed
+			continue
+		}
+		firstAddrs[addr] = struct{}{}
+
+		wlts[i] = w
+	}
+
+	if len(wltErrs) != 0 {
+		return nil, wltErrs
+	}
+
+	for i, w := range wlts {
+		if err := serv.saveWallet(w); err != nil {
+			// Roll back the wallet files written so far
+			for _, saved := range wlts[:i] {
+				path := filepath.Join(serv.config.WalletDir, saved.Filename())
+				if err := os.Remove(path); err != nil {
+					logger.WithError(err).Errorf("Failed to remove %s after failing to create wallet %s", path, w.Filename())
+				}
+				delete(serv.fileHashes, saved.Filename())
+			}
+
+			return nil, WalletErrors{
+				w.Filename(): err,
+			}
+		}
+	}
+
+	for i, w := range wlts {
+		serv.addWallet(w)
+		wlts[i] = w.clone()
+	}
+
+	return wlts, nil
 }
 
-func (serv *Service) generateUniqueWalletFilename() string {
-	wltName := NewWalletFilename()
-	for {
-		if w := serv.wallets.get(wltName); w == nil {
-			break
+// RecoverAllFromSeedPhrase recovers the wallets of seed for all supported coin types, see SupportedCoinTypes.
+// For each coin type, the addresses of seed are scanned for a balance with bg, and a wallet is created
+// only if any of its addresses has coins, with the addresses up to the last one with coins.
+// The wallets are encrypted with password if it is not empty, and created together like with CreateWallets.
+// The coin types that yielded wallets are reported by Wallet.Coin of the returned wallets, in the order of
+// SupportedCoinTypes. Addresses are derived with Config.SeedDeriver, like the addresses of created wallets.
+// Bitcoin wallets are never recovered, because bitcoin addresses can't be scanned for a balance;
+// create them with CreateWallet and Options.GenerateN instead.
+func (serv *Service) RecoverAllFromSeedPhrase(seed string, password []byte, bg BalanceGetter) ([]*Wallet, error) {
+	if seed == "" {
+		return nil, ErrMissingSeed
+	}
+
+	if bg == nil {
+		return nil, ErrNilBalanceGetter
+	}
+
+	serv.RLock()
+	enabled := serv.config.EnableWalletAPI
+	deriver := serv.config.SeedDeriver
+	filter := serv.config.IndexFilter
+	serv.RUnlock()
+	if !enabled {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	// Scan the addresses of each coin type without the service lock held
+	var reqs []CreateWalletReq
+	for _, ct := range SupportedCoinTypes() {
+		// Only skycoin wallets can be scanned for a balance, see Options.ScanN
+		if ct != CoinTypeSkycoin {
+			logger.Infof("RecoverAllFromSeedPhrase: skipping coin type %s, its addresses can't be scanned", ct)
+			continue
 		}
-		wltName = NewWalletFilename()
+
+		n, err := serv.fundedAddressCount(ct, seed, deriver, filter, bg)
+		if err != nil {
+			return nil, err
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		logger.Infof("RecoverAllFromSeedPhrase: found %d addresses of coin type %s", n, ct)
+		reqs = append(reqs, CreateWalletReq{
+			Options: Options{
+				Coin:      ct,
+				Seed:      seed,
+				GenerateN: n,
+				Encrypt:   len(password) != 0,
+				Password:  password,
+			},
+		})
 	}
 
-	return wltName
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+
+	return serv.CreateWallets(reqs, bg)
 }
 
-// EncryptWallet encrypts wallet with password
-func (serv *Service) EncryptWallet(wltID string, password []byte) (*Wallet, error) {
+// fundedAddressCount returns the number of addresses of seed and coin type up to the last one with coins,
+// scanning recoverAllScanN addresses ahead of the last one with coins. The addresses are derived with deriver and
+// filtered with filter if not nil.
+func (serv *Service) fundedAddressCount(ct CoinType, seed string, deriver func(seed []byte) (cipher.PubKey, cipher.SecKey, error), filter func(index uint64) bool, bg BalanceGetter) (uint64, error) {
+	w, err := NewWalletScanAhead("", Options{
+		Coin:        ct,
+		Seed:        seed,
+		ScanN:       recoverAllScanN,
+		SeedDeriver: deriver,
+		IndexFilter: filter,
+	}, bg)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Erase()
+
+	addrs, err := w.GetSkycoinAddresses()
+	if err != nil {
+		return 0, err
+	}
+
+	// The scan keeps the first address even if it has no coins
+	bals, err := serv.getBalances(bg, addrs)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := len(bals); i > 0; i-- {
+		if bals[i-1].Confirmed.Coins > 0 || bals[i-1].Predicted.Coins > 0 {
+			return uint64(i), nil
+		}
+	}
+
+	return 0, nil
+}
+
+// GenerateAndFund creates an unencrypted wallet from a new random seed and requests coins
+// for its first address from the faucet. It requires Config.EnableDevAPI.
+// If the faucet fails, the created wallet and its address are returned with the error.
+func (serv *Service) GenerateAndFund(wltName string, faucet Faucet) (*Wallet, cipher.Address, error) {
+	serv.RLock()
+	enabled := serv.config.EnableDevAPI
+	serv.RUnlock()
+	if !enabled {
+		return nil, cipher.Address{}, ErrDevAPIDisabled
+	}
+
+	if faucet == nil {
+		return nil, cipher.Address{}, ErrNilFaucet
+	}
+
+	seed, err := bip39.NewDefaultMnemonic()
+	if err != nil {
+		return nil, cipher.Address{}, err
+	}
+
+	w, err := serv.CreateWallet(wltName, Options{
+		Seed: seed,
+	}, nil)
+	if err != nil {
+		return nil, cipher.Address{}, err
+	}
+
+	addr := w.Entries[0].SkycoinAddress()
+	if err := faucet.Fund(addr); err != nil {
+		return w, addr, err
+	}
+
+	return w, addr, nil
+}
+
+// CreateWalletFromAddresses creates a watch-only wallet with the given addresses, which can be used to track
+// their balances and transactions. The wallet has no seed or secret keys, so it can't generate addresses,
+// sign transactions or be encrypted, see ErrWalletIsWatchOnly.
+// The wallet is saved as wltName, or under a new unique filename if wltName is empty.
+// Returns ErrSeedUsed if a wallet with the same first address exists, unless AllowDuplicateSeeds is set.
+func (serv *Service) CreateWalletFromAddresses(wltName string, addrs []cipher.Address) (*Wallet, error) {
 	serv.Lock()
 	defer serv.Unlock()
 	if !serv.config.EnableWalletAPI {
 		return nil, ErrWalletAPIDisabled
 	}
 
-	w, err := serv.getWallet(wltID)
-	if err != nil {
-		return nil, err
-	}
+	return serv.createWalletFromAddresses(wltName, addrs)
+}
 
-	if w.IsEncrypted() {
-		return nil, ErrWalletEncrypted
+// ImportWatchOnlyFromXPubFile creates a watch-only wallet from a hardware wallet watch-only export file, see
+// HWWatchOnly and ExportHWWatchOnly. The first addressCount receive addresses are derived from the xpub in
+// the file. Malformed files return an error describing the problem, prefixed with the ErrMalformedHWWatchOnly message.
+// The wallet is saved like with CreateWalletFromAddresses.
+func (serv *Service) ImportWatchOnlyFromXPubFile(wltName, path string, addressCount int) (*Wallet, error) {
+	if addressCount <= 0 {
+		return nil, ErrInvalidAddressCount
 	}
 
-	if err := w.Lock(password, serv.config.CryptoType); err != nil {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
 		return nil, err
 	}
 
-	// Save to disk first
-	if err := w.Save(serv.config.WalletDir); err != nil {
+	hw, err := ParseHWWatchOnly(b)
+	if err != nil {
 		return nil, err
 	}
 
-	// Sets the encrypted wallet
-	serv.wallets.set(w)
-	return w, nil
-}
+	addrs, err := hw.Addresses(addressCount)
+	if err != nil {
+		return nil, err
+	}
 
-// DecryptWallet decrypts wallet with password
-func (serv *Service) DecryptWallet(wltID string, password []byte) (*Wallet, error) {
 	serv.Lock()
 	defer serv.Unlock()
 	if !serv.config.EnableWalletAPI {
 		return nil, ErrWalletAPIDisabled
 	}
 
-	w, err := serv.getWallet(wltID)
+	return serv.createWalletFromAddresses(wltName, addrs)
+}
+
+// createWalletFromAddresses is CreateWalletFromAddresses, with the lock held
+func (serv *Service) createWalletFromAddresses(wltName string, addrs []cipher.Address) (*Wallet, error) {
+	if wltName == "" {
+		wltName = serv.generateUniqueWalletFilename()
+	}
+
+	if err := serv.canAddWallet(); err != nil {
+		return nil, err
+	}
+
+	w, err := newWatchOnlyWallet(wltName, addrs)
 	if err != nil {
 		return nil, err
 	}
 
-	// Returns error if wallet is not encrypted
-	if !w.IsEncrypted() {
-		return nil, ErrWalletNotEncrypted
+	if _, ok := serv.firstAddrIDMap[w.Entries[0].Address.String()]; ok && !serv.config.AllowDuplicateSeeds {
+		return nil, ErrSeedUsed
 	}
 
-	// Unlocks the wallet
-	unlockWlt, err := w.Unlock(password)
+	if serv.hasWalletID(wltName) {
+		return nil, ErrWalletNameConflict
+	}
+
+	if err := serv.saveWallet(w); err != nil {
+		return nil, err
+	}
+
+	serv.addWallet(w)
+
+	return w.clone(), nil
+}
+
+// loadWallet loads wallet from seed and scan the first N addresses
+func (serv *Service) loadWallet(wltName string, options Options, bg BalanceGetter) (*Wallet, error) {
+	if err := serv.canAddWallet(); err != nil {
+		return nil, err
+	}
+
+	w, err := serv.prepareWallet(wltName, options, bg)
 	if err != nil {
 		return nil, err
 	}
 
-	// Updates the wallet file
-	if err := unlockWlt.Save(serv.config.WalletDir); err != nil {
-		return nil, err
+	if err := serv.saveWallet(w); err != nil {
+		return nil, err
+	}
+
+	serv.addWallet(w)
+
+	return w.clone(), nil
+}
+
+// prepareWallet creates a wallet from seed and scans the first N addresses, without saving it or adding it
+// to the service. Returns an error if the wallet can't be added to the service.
+func (serv *Service) prepareWallet(wltName string, options Options, bg BalanceGetter) (*Wallet, error) {
+	// The filter would be lost when the wallet is reloaded, see Config.IndexFilter
+	if options.IndexFilter != nil {
+		return nil, ErrIndexFilterOption
+	}
+
+	// Reject unknown coin types before deriving any addresses
+	if options.Coin != "" {
+		if err := validateCoinType(options.Coin); err != nil {
+			return nil, err
+		}
+	}
+
+	// service decides what crypto type the wallet should use.
+	if options.Encrypt {
+		options.CryptoType = serv.config.CryptoType
+		crypto, err := serv.cryptor()
+		if err != nil {
+			return nil, err
+		}
+		options.cryptor = crypto
+	}
+
+	options.SeedDeriver = serv.config.SeedDeriver
+	options.IndexFilter = serv.config.IndexFilter
+	options.scanProgress = func(scanned, found uint64) {
+		serv.events.publishEvent(WalletEvent{
+			WalletID: wltName,
+			Kind:     WalletAddressScanned,
+			Scanned:  scanned,
+			Found:    found,
+		})
+	}
+
+	if serv.labelInUse(options.Label, wltName) {
+		return nil, ErrLabelInUse
+	}
+
+	w, err := NewWalletScanAhead(wltName, options, bg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for duplicate wallets by initial seed
+	if _, ok := serv.firstAddrIDMap[w.Entries[0].Address.String()]; ok && !serv.config.AllowDuplicateSeeds {
+		return nil, ErrSeedUsed
+	}
+
+	if serv.hasWalletID(w.Filename()) {
+		return nil, ErrWalletNameConflict
+	}
+
+	return w, nil
+}
+
+// addWallet adds a saved wallet to the service
+func (serv *Service) addWallet(w *Wallet) {
+	if serv.lazyLoad() {
+		serv.lazyWallets[w.Filename()] = &lazyWallet{
+			path:      filepath.Join(serv.config.WalletDir, w.Filename()),
+			firstAddr: w.Entries[0].Address.String(),
+		}
+	}
+	serv.setWallet(w)
+
+	serv.firstAddrIDMap[w.Entries[0].Address.String()] = w.Filename()
+	serv.events.publish(w.Filename(), WalletCreated)
+}
+
+func (serv *Service) generateUniqueWalletFilename() string {
+	wltName := NewWalletFilename()
+	for {
+		if !serv.hasWallet(wltName) {
+			break
+		}
+		wltName = NewWalletFilename()
+	}
+
+	return wltName
+}
+
+// EncryptWallet encrypts wallet with password
+func (serv *Service) EncryptWallet(wltID string, password []byte) (*Wallet, error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.IsReadOnly() {
+		return nil, ErrWalletReadOnly
+	}
+
+	if w.IsWatchOnly() {
+		return nil, ErrWalletIsWatchOnly
+	}
+
+	if w.IsEncrypted() {
+		return nil, ErrWalletEncrypted
+	}
+
+	if err := serv.validatePassword(password); err != nil {
+		return nil, err
+	}
+
+	if err := serv.lockWallet(w, password); err != nil {
+		return nil, err
+	}
+
+	// Save to disk first
+	if err := serv.writeWallet(w); err != nil {
+		return nil, err
+	}
+
+	// Sets the encrypted wallet
+	serv.setWallet(w)
+	serv.cancelReencrypt(w.Filename())
+	serv.events.publish(w.Filename(), WalletEncrypted)
+	return w, nil
+}
+
+// TestEncrypt checks that the wallet can be encrypted with the password and the configured crypto type,
+// and decrypted again, without changing the wallet. It returns the error EncryptWallet would return.
+func (serv *Service) TestEncrypt(wltID string, password []byte) error {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return err
+	}
+
+	if w.IsReadOnly() {
+		return ErrWalletReadOnly
+	}
+
+	if w.IsWatchOnly() {
+		return ErrWalletIsWatchOnly
+	}
+
+	if w.IsEncrypted() {
+		return ErrWalletEncrypted
+	}
+
+	if err := serv.validatePassword(password); err != nil {
+		return err
+	}
+
+	locked := w.clone()
+	if err := serv.lockWallet(locked, password); err != nil {
+		return err
+	}
+
+	unlocked, err := locked.Unlock(password)
+	if err != nil {
+		return err
+	}
+	defer unlocked.Erase()
+	defer w.Erase()
+
+	if unlocked.seed() != w.seed() || unlocked.lastSeed() != w.lastSeed() || unlocked.seedPassphrase() != w.seedPassphrase() ||
+		!reflect.DeepEqual(unlocked.Entries, w.Entries) {
+		return ErrEncryptRoundTrip
+	}
+
+	return nil
+}
+
+// DecryptWallet decrypts wallet with password
+func (serv *Service) DecryptWallet(wltID string, password []byte) (*Wallet, error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.IsReadOnly() {
+		return nil, ErrWalletReadOnly
+	}
+
+	// Returns error if wallet is not encrypted
+	if !w.IsEncrypted() {
+		return nil, ErrWalletNotEncrypted
+	}
+
+	// Unlocks the wallet
+	unlockWlt, err := w.Unlock(password)
+	if err != nil {
+		return nil, err
+	}
+
+	// Updates the wallet file
+	if err := serv.writeWallet(unlockWlt); err != nil {
+		return nil, err
+	}
+
+	// Sets the decrypted wallet in memory
+	serv.setWallet(unlockWlt)
+	serv.unlockCache.remove(unlockWlt.Filename())
+	serv.scheduleReencrypt(unlockWlt.Filename(), password)
+	serv.events.publish(unlockWlt.Filename(), WalletDecrypted)
+	return unlockWlt, nil
+}
+
+// ChangePassword changes the password of an encrypted wallet. The wallet is decrypted in memory only
+// and encrypted again with newPassword and the configured crypto type, then the wallet file is replaced atomically,
+// so the decrypted wallet is never written to disk. If oldPassword is wrong the decryption error is returned
+// and the wallet file is not changed.
+func (serv *Service) ChangePassword(wltID string, oldPassword, newPassword []byte) (*Wallet, error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.IsReadOnly() {
+		return nil, ErrWalletReadOnly
+	}
+
+	if !w.IsEncrypted() {
+		return nil, ErrWalletNotEncrypted
+	}
+
+	if len(newPassword) == 0 {
+		return nil, ErrMissingPassword
+	}
+
+	if err := serv.validatePassword(newPassword); err != nil {
+		return nil, err
+	}
+
+	unlockWlt, err := w.Unlock(oldPassword)
+	if err != nil {
+		return nil, err
+	}
+	defer unlockWlt.Erase()
+
+	if err := serv.lockWallet(unlockWlt, newPassword); err != nil {
+		return nil, err
+	}
+
+	if err := serv.writeWallet(unlockWlt); err != nil {
+		return nil, err
+	}
+
+	serv.setWallet(unlockWlt)
+	serv.unlockCache.remove(unlockWlt.Filename())
+	return unlockWlt.clone(), nil
+}
+
+// CancelAutoReencrypt cancels the automatic re-encryption of a decrypted wallet, see Config.AutoReencryptAfter,
+// and erases the remembered password. Canceling when no re-encryption is scheduled is not an error.
+func (serv *Service) CancelAutoReencrypt(wltID string) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	wltID, err := serv.resolveWalletID(wltID)
+	if err != nil {
+		return err
+	}
+
+	serv.cancelReencrypt(wltID)
+	return nil
+}
+
+// scheduleReencrypt remembers the password of a decrypted wallet to encrypt it again after Config.AutoReencryptAfter
+func (serv *Service) scheduleReencrypt(wltID string, password []byte) {
+	if serv.config.AutoReencryptAfter <= 0 {
+		return
+	}
+
+	serv.cancelReencrypt(wltID)
+
+	p := &pendingReencrypt{
+		password: append([]byte(nil), password...),
+	}
+	p.timer = time.AfterFunc(serv.config.AutoReencryptAfter, func() {
+		serv.Lock()
+		defer serv.Unlock()
+		if serv.pendingReencrypts[wltID] != p {
+			return
+		}
+		serv.reencrypt(wltID)
+	})
+	serv.pendingReencrypts[wltID] = p
+}
+
+// reencrypt encrypts a decrypted wallet with its remembered password, logging a warning if it can't
+func (serv *Service) reencrypt(wltID string) {
+	p, ok := serv.pendingReencrypts[wltID]
+	if !ok {
+		return
+	}
+	defer serv.cancelReencrypt(wltID)
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		logger.WithError(err).Warningf("Wallet %s can't be re-encrypted", wltID)
+		return
+	}
+
+	// The wallet was encrypted since
+	if w.IsEncrypted() {
+		return
+	}
+
+	if w.IsReadOnly() {
+		logger.Warningf("Wallet %s is read-only, it is not re-encrypted", wltID)
+		return
+	}
+
+	if err := serv.lockWallet(w, p.password); err != nil {
+		logger.WithError(err).Warningf("Wallet %s can't be re-encrypted", wltID)
+		return
+	}
+
+	if err := serv.writeWallet(w); err != nil {
+		logger.WithError(err).Warningf("Wallet %s can't be re-encrypted", wltID)
+		return
+	}
+
+	serv.setWallet(w)
+	serv.events.publish(wltID, WalletEncrypted)
+	logger.Infof("Re-encrypted wallet %s", wltID)
+}
+
+// cancelReencrypt cancels the scheduled re-encryption of a wallet, if any, and erases the remembered password
+func (serv *Service) cancelReencrypt(wltID string) {
+	p, ok := serv.pendingReencrypts[wltID]
+	if !ok {
+		return
+	}
+
+	p.timer.Stop()
+	eraseBytes(p.password)
+	delete(serv.pendingReencrypts, wltID)
+}
+
+// DecryptToMemory returns a decrypted copy of an encrypted wallet without changing the wallet.
+// The wallet stays encrypted on disk and in memory. The caller must erase the copy when done.
+func (serv *Service) DecryptToMemory(wltID string, password []byte) (*Wallet, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !w.IsEncrypted() {
+		return nil, ErrWalletNotEncrypted
+	}
+
+	return w.Unlock(password)
+}
+
+// NewAddresses generate address entries in given wallet,
+// return nil if wallet does not exist.
+// Set password as nil if the wallet is not encrypted, otherwise the password must be provided.
+// See Config.AddressGenBatchSize to generate many addresses without holding the service lock throughout.
+func (serv *Service) NewAddresses(wltID string, password []byte, num uint64) ([]cipher.Address, error) {
+	return serv.newAddresses(wltID, 0, password, num, nil)
+}
+
+// NewAccountAddresses is like NewAddresses, but generates the addresses in the address chain of an account
+// of the wallet, see NewAccount. Account 0 is the wallet's main chain.
+// Returns ErrUnknownAccount if the wallet has no such account.
+func (serv *Service) NewAccountAddresses(wltID string, account uint32, password []byte, num uint64) ([]cipher.Address, error) {
+	return serv.newAddresses(wltID, account, password, num, nil)
+}
+
+// NewAccount adds an account to a wallet and generates its first address. Each account has its own address chain,
+// derived from the wallet's seed, and its own label. The accounts are stored in the wallet file,
+// and their addresses are used like the other addresses of the wallet. Returns the index of the account,
+// which is used to generate more addresses with NewAccountAddresses.
+// Set password as nil if the wallet is not encrypted, otherwise the password must be provided.
+func (serv *Service) NewAccount(wltID string, password []byte, label string) (uint32, error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return 0, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return 0, err
+	}
+
+	if w.IsReadOnly() {
+		return 0, ErrWalletReadOnly
+	}
+
+	if w.IsWatchOnly() {
+		return 0, ErrWalletIsWatchOnly
+	}
+
+	var account uint32
+	f := func(wlt *Wallet) error {
+		var err error
+		account, err = wlt.NewAccount(label)
+		if err != nil {
+			return err
+		}
+
+		_, err = wlt.generateSkycoinAccountAddresses(account, 1)
+		return err
+	}
+
+	if w.IsEncrypted() {
+		if err := w.GuardUpdate(password, f); err != nil {
+			return 0, err
+		}
+	} else {
+		if len(password) != 0 {
+			return 0, ErrWalletNotEncrypted
+		}
+
+		if err := f(w); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := serv.saveWallet(w); err != nil {
+		return 0, err
+	}
+
+	serv.setWallet(w)
+	serv.publishSaved(w.Filename(), WalletAddressesAdded)
+
+	return account, nil
+}
+
+// NewAddressesProgress is like NewAddresses, but calls progress with the number of addresses
+// derived so far while the addresses are generated.
+// progress is called from a separate goroutine without the service lock held, so it may call other Service methods.
+// Intermediate updates are skipped if progress is slower than the derivation,
+// but the final update with done == total is always delivered before NewAddressesProgress returns.
+func (serv *Service) NewAddressesProgress(wltID string, password []byte, num uint64, progress func(done, total uint64)) ([]cipher.Address, error) {
+	if progress == nil {
+		return serv.NewAddresses(wltID, password, num)
+	}
+
+	p := newAsyncProgress()
+	addrs, err := serv.newAddresses(wltID, 0, password, num, func(n uint64) {
+		p.send(func() {
+			progress(n, num)
+		})
+	})
+	p.close()
+
+	return addrs, err
+}
+
+// NewAddressesWithBalances is like NewAddresses, but also returns the balances of the new addresses,
+// requested from bg in a single call. The wallet is saved before the balances are requested,
+// so the addresses are kept even if bg returns an error.
+func (serv *Service) NewAddressesWithBalances(wltID string, password []byte, num uint64, bg BalanceGetter) ([]AddressBalance, error) {
+	addrs, err := serv.NewAddresses(wltID, password, num)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+
+	bals, err := serv.getBalances(bg, addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	addrBals := make([]AddressBalance, len(addrs))
+	for i, addr := range addrs {
+		addrBals[i] = AddressBalance{
+			Address: addr,
+			Balance: bals[i],
+		}
+	}
+
+	return addrBals, nil
+}
+
+// GetWalletCoinHours returns the total confirmed and predicted coin hours of the addresses of a wallet,
+// requested from bg like the balances of ExportBalancesCSV
+func (serv *Service) GetWalletCoinHours(wltID string, bg BalanceGetter) (confirmed, predicted uint64, err error) {
+	addrs, err := serv.GetSkycoinAddresses(wltID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	bals, err := serv.getBalances(bg, addrs)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, b := range bals {
+		if confirmed, err = mathutil.AddUint64(confirmed, b.Confirmed.Hours); err != nil {
+			return 0, 0, err
+		}
+		if predicted, err = mathutil.AddUint64(predicted, b.Predicted.Hours); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return confirmed, predicted, nil
+}
+
+// GetWalletBalance returns the total balance of the addresses of a wallet and the balance of each address,
+// in the order of the wallet entries, requested from bg like the balances of ExportBalancesCSV.
+// The addresses are collected under the service lock, bg is called without it.
+// bg is not called for a wallet without addresses. If bg fails, its error is returned without any balances.
+func (serv *Service) GetWalletBalance(wltID string, bg BalanceGetter) (BalancePair, []AddressBalance, error) {
+	addrs, err := serv.GetSkycoinAddresses(wltID)
+	if err != nil {
+		return BalancePair{}, nil, err
+	}
+	if len(addrs) == 0 {
+		return BalancePair{}, nil, nil
+	}
+
+	bals, err := serv.getBalances(bg, addrs)
+	if err != nil {
+		return BalancePair{}, nil, err
+	}
+
+	var total BalancePair
+	addrBals := make([]AddressBalance, len(addrs))
+	for i, addr := range addrs {
+		if total.Confirmed, err = total.Confirmed.Add(bals[i].Confirmed); err != nil {
+			return BalancePair{}, nil, err
+		}
+		if total.Predicted, err = total.Predicted.Add(bals[i].Predicted); err != nil {
+			return BalancePair{}, nil, err
+		}
+
+		addrBals[i] = AddressBalance{
+			Address: addr,
+			Balance: bals[i],
+		}
+	}
+
+	return total, addrBals, nil
+}
+
+// GetMultiWalletBalances returns the total balances of the given wallets, by wallet id,
+// requested from bg in a single batched call. If some of the wallets are unknown or are not
+// Skycoin wallets, the balances of the others are returned with a WalletErrors error.
+func (serv *Service) GetMultiWalletBalances(wltIDs []string, bg BalanceGetter) (map[string]BalancePair, error) {
+	wltAddrs, wltErrs, err := serv.multiWalletAddresses(wltIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []cipher.Address
+	for _, as := range wltAddrs {
+		addrs = append(addrs, as...)
+	}
+
+	bals, err := serv.getBalances(bg, addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	addrBals := make(map[cipher.Address]BalancePair, len(addrs))
+	for i, addr := range addrs {
+		addrBals[addr] = bals[i]
+	}
+
+	wltBals := make(map[string]BalancePair, len(wltAddrs))
+	for id, as := range wltAddrs {
+		var bal BalancePair
+		for _, addr := range as {
+			b := addrBals[addr]
+			if bal.Confirmed, err = bal.Confirmed.Add(b.Confirmed); err != nil {
+				return nil, err
+			}
+			if bal.Predicted, err = bal.Predicted.Add(b.Predicted); err != nil {
+				return nil, err
+			}
+		}
+		wltBals[id] = bal
+	}
+
+	if len(wltErrs) > 0 {
+		return wltBals, wltErrs
+	}
+
+	return wltBals, nil
+}
+
+// multiWalletAddresses returns the Skycoin addresses of the given wallets, and the errors of the wallets
+// whose addresses can't be returned
+func (serv *Service) multiWalletAddresses(wltIDs []string) (map[string][]cipher.Address, WalletErrors, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, nil, ErrWalletAPIDisabled
+	}
+
+	wltAddrs := make(map[string][]cipher.Address, len(wltIDs))
+	wltErrs := WalletErrors{}
+	for _, id := range wltIDs {
+		w, err := serv.loadedWallet(id)
+		if err != nil {
+			wltErrs[id] = err
+			continue
+		}
+
+		addrs, err := w.GetSkycoinAddresses()
+		if err != nil {
+			wltErrs[id] = err
+			continue
+		}
+		wltAddrs[id] = addrs
+	}
+
+	return wltAddrs, wltErrs, nil
+}
+
+// asyncProgress delivers progress updates in a separate goroutine.
+// If an update is sent before the previous one was delivered, the previous one is skipped,
+// so the sender never waits for the receiver. The last update is always delivered.
+type asyncProgress struct {
+	updates  chan func()
+	finished chan struct{}
+}
+
+func newAsyncProgress() *asyncProgress {
+	p := &asyncProgress{
+		updates:  make(chan func(), 1),
+		finished: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.finished)
+		for f := range p.updates {
+			f()
+		}
+	}()
+
+	return p
+}
+
+// send queues an update, replacing a pending update that hasn't been delivered yet
+func (p *asyncProgress) send(f func()) {
+	select {
+	case p.updates <- f:
+	default:
+		select {
+		case <-p.updates:
+		default:
+		}
+		p.updates <- f
+	}
+}
+
+// close waits until the last update has been delivered
+func (p *asyncProgress) close() {
+	close(p.updates)
+	<-p.finished
+}
+
+func (serv *Service) newAddresses(wltID string, account uint32, password []byte, num uint64, progress func(uint64)) ([]cipher.Address, error) {
+	batchSize := serv.config.AddressGenBatchSize
+	if batchSize == 0 || num <= batchSize {
+		return serv.newAddressesBatch(wltID, account, password, num, 0, progress)
+	}
+
+	// Generate the addresses in batches, releasing the lock in between.
+	// The wallet is looked up again for each batch, in case it was changed or unloaded.
+	addrs := make([]cipher.Address, 0, num)
+	for done := uint64(0); done < num; {
+		n := num - done
+		if n > batchSize {
+			n = batchSize
+		}
+
+		batch, err := serv.newAddressesBatch(wltID, account, password, n, done, progress)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs = append(addrs, batch...)
+		done += n
+	}
+
+	return addrs, nil
+}
+
+// newAddressesBatch generates num addresses in the account of the wallet and saves it, holding the lock throughout.
+// If progress is not nil, it is called with the number of addresses generated so far plus offset.
+func (serv *Service) newAddressesBatch(wltID string, account uint32, password []byte, num, offset uint64, progress func(uint64)) ([]cipher.Address, error) {
+	serv.Lock()
+	defer serv.Unlock()
+
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.IsReadOnly() {
+		return nil, ErrWalletReadOnly
+	}
+
+	if w.IsWatchOnly() {
+		return nil, ErrWalletIsWatchOnly
+	}
+
+	var addrs []cipher.Address
+	f := func(wlt *Wallet) error {
+		if progress == nil {
+			var err error
+			addrs, err = wlt.generateSkycoinAccountAddresses(account, num)
+			return err
+		}
+
+		// Generate the addresses in batches in order to report the progress
+		addrs = make([]cipher.Address, 0, num)
+		for done := uint64(0); done < num; {
+			n := num - done
+			if n > newAddressesProgressBatch {
+				n = newAddressesProgressBatch
+			}
+
+			batch, err := wlt.generateSkycoinAccountAddresses(account, n)
+			if err != nil {
+				return err
+			}
+
+			addrs = append(addrs, batch...)
+			done += n
+			progress(offset + done)
+		}
+		return nil
+	}
+
+	if w.IsEncrypted() {
+		if err := w.GuardUpdate(password, f); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(password) != 0 {
+			return nil, ErrWalletNotEncrypted
+		}
+
+		if err := f(w); err != nil {
+			return nil, err
+		}
+	}
+
+	// Save the wallet first
+	if err := serv.saveWallet(w); err != nil {
+		return nil, err
+	}
+
+	serv.setWallet(w)
+	serv.publishSaved(w.Filename(), WalletAddressesAdded)
+
+	return addrs, nil
+}
+
+// ImportKeysBatch imports secret keys into a collection wallet, unlocking the wallet once, see Wallet.ImportKeys.
+// Keys whose address is already in the wallet, or repeated in keys, are skipped and their addresses are
+// returned in skipped. The password is required if the wallet is encrypted.
+func (serv *Service) ImportKeysBatch(wltID string, password []byte, keys []cipher.SecKey) (imported int, skipped []cipher.Address, err error) {
+	serv.Lock()
+	defer serv.Unlock()
+
+	if !serv.config.EnableWalletAPI {
+		return 0, nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if w.IsReadOnly() {
+		return 0, nil, ErrWalletReadOnly
+	}
+
+	if w.IsWatchOnly() {
+		return 0, nil, ErrWalletIsWatchOnly
+	}
+
+	if !w.IsCollection() {
+		return 0, nil, ErrWalletNotCollection
+	}
+
+	if w.coin() != CoinTypeSkycoin {
+		return 0, nil, errors.New("Wallet coin type is not Skycoin")
+	}
+
+	var skippedAddrs []cipher.Addresser
+	f := func(wlt *Wallet) error {
+		var err error
+		imported, skippedAddrs, err = wlt.ImportKeys(keys)
+		return err
+	}
+
+	if w.IsEncrypted() {
+		if err := w.GuardUpdate(password, f); err != nil {
+			return 0, nil, err
+		}
+	} else {
+		if len(password) != 0 {
+			return 0, nil, ErrWalletNotEncrypted
+		}
+
+		if err := f(w); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	for _, a := range skippedAddrs {
+		skipped = append(skipped, a.(cipher.Address))
+	}
+
+	if imported == 0 {
+		return 0, skipped, nil
+	}
+
+	// Save the wallet first
+	if err := serv.saveWallet(w); err != nil {
+		return 0, nil, err
+	}
+
+	serv.setWallet(w)
+	serv.publishSaved(w.Filename(), WalletAddressesAdded)
+
+	return imported, skipped, nil
+}
+
+// AttachSeed sets the seed of a collection wallet, converting it to a deterministic wallet.
+// The imported entries are kept and addresses can be generated from the seed afterwards.
+// Returns ErrWalletHasSeed if the wallet is not a collection wallet, and ErrSeedUsed if another wallet
+// was created from the seed, unless AllowDuplicateSeeds is set.
+// Deterministic wallets with imported entries can't be recovered with RecoverWallet.
+func (serv *Service) AttachSeed(wltID string, seed string, password []byte) error {
+	serv.Lock()
+	defer serv.Unlock()
+
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return err
+	}
+
+	if w.IsReadOnly() {
+		return ErrWalletReadOnly
+	}
+
+	if w.IsWatchOnly() {
+		return ErrWalletIsWatchOnly
+	}
+
+	if !w.IsCollection() {
+		return ErrWalletHasSeed
+	}
+
+	if seed == "" {
+		return ErrMissingSeed
+	}
+
+	// Check for duplicate wallets by initial seed
+	sw, err := NewWallet(wltID, Options{
+		Coin:        w.coin(),
+		Seed:        seed,
+		SeedDeriver: serv.config.SeedDeriver,
+		IndexFilter: serv.config.IndexFilter,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, ok := serv.firstAddrIDMap[sw.Entries[0].Address.String()]; ok && !serv.config.AllowDuplicateSeeds {
+		return ErrSeedUsed
+	}
+
+	f := func(wlt *Wallet) error {
+		return wlt.AttachSeed(seed)
+	}
+
+	if w.IsEncrypted() {
+		if err := w.GuardUpdate(password, f); err != nil {
+			return err
+		}
+	} else {
+		if len(password) != 0 {
+			return ErrWalletNotEncrypted
+		}
+
+		if err := f(w); err != nil {
+			return err
+		}
+	}
+
+	// Save the wallet first
+	if err := serv.saveWallet(w); err != nil {
+		return err
+	}
+
+	serv.setWallet(w)
+	serv.publishSaved(w.Filename(), WalletSeedAttached)
+
+	return nil
+}
+
+// SetAddressPoolPolicy makes NextUnusedAddress keep a supply of unused addresses in the wallet.
+// Whenever NextUnusedAddress finds fewer than minUnused unused addresses, it generates addresses until
+// refillTo addresses are unused. Addresses are unused if they come after the last address with a balance,
+// as reported by bg. A nil bg removes the policy. The policy is kept in memory only.
+func (serv *Service) SetAddressPoolPolicy(wltID string, minUnused, refillTo uint64, bg BalanceGetter) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	wltID, err := serv.resolveWalletID(wltID)
+	if err != nil {
+		return err
+	}
+
+	if bg == nil {
+		delete(serv.addressPools, wltID)
+		return nil
+	}
+
+	if minUnused == 0 || refillTo < minUnused {
+		return ErrInvalidAddressPoolPolicy
+	}
+
+	serv.addressPools[wltID] = addressPoolPolicy{
+		minUnused: minUnused,
+		refillTo:  refillTo,
+		bg:        bg,
+	}
+	return nil
+}
+
+// NextUnusedAddress returns the first address of the wallet's main chain after the last address with a balance,
+// generating new addresses according to the wallet's address pool policy.
+// The password is only needed if the wallet is encrypted and addresses have to be generated.
+// The balances are fetched without holding the service lock, and fetched again if the wallet's
+// main chain changed in the meantime. Returns ErrWalletIsWatchOnly for watch-only wallets.
+func (serv *Service) NextUnusedAddress(wltID string, password []byte) (cipher.Address, error) {
+	for {
+		addrs, bg, err := serv.addressPool(wltID)
+		if err != nil {
+			return cipher.Address{}, err
+		}
+
+		bals, err := serv.getBalances(bg, addrs)
+		if err != nil {
+			return cipher.Address{}, err
+		}
+
+		addr, changed, err := serv.nextUnusedAddress(wltID, password, addrs, bals)
+		if err != nil {
+			return cipher.Address{}, err
+		}
+		if !changed {
+			return addr, nil
+		}
+	}
+}
+
+// addressPool returns the main chain addresses of a wallet and the BalanceGetter of its address pool policy
+func (serv *Service) addressPool(wltID string) ([]cipher.Address, BalanceGetter, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if w.IsWatchOnly() {
+		return nil, nil, ErrWalletIsWatchOnly
+	}
+
+	policy, ok := serv.addressPools[w.Filename()]
+	if !ok {
+		return nil, nil, ErrNoAddressPoolPolicy
+	}
+
+	addrs, err := w.accountSkycoinAddresses(0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return addrs, policy.bg, nil
+}
+
+// nextUnusedAddress finds the next unused address of the wallet's main chain from the balances of addrs,
+// refilling the address pool if needed. Returns changed=true without doing anything if the wallet's
+// main chain is no longer addrs.
+func (serv *Service) nextUnusedAddress(wltID string, password []byte, addrs []cipher.Address, bals []BalancePair) (addr cipher.Address, changed bool, err error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return cipher.Address{}, false, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return cipher.Address{}, false, err
+	}
+
+	if w.IsWatchOnly() {
+		return cipher.Address{}, false, ErrWalletIsWatchOnly
+	}
+
+	policy, ok := serv.addressPools[w.Filename()]
+	if !ok {
+		return cipher.Address{}, false, ErrNoAddressPoolPolicy
+	}
+
+	current, err := w.accountSkycoinAddresses(0)
+	if err != nil {
+		return cipher.Address{}, false, err
+	}
+
+	if len(current) != len(addrs) {
+		return cipher.Address{}, true, nil
+	}
+	for i := range current {
+		if current[i] != addrs[i] {
+			return cipher.Address{}, true, nil
+		}
+	}
+
+	// Find the first address after the last one with coins
+	next := len(bals)
+	for ; next > 0; next-- {
+		if bals[next-1].Confirmed.Coins > 0 || bals[next-1].Predicted.Coins > 0 {
+			break
+		}
+	}
+
+	unused := uint64(len(addrs) - next)
+	if unused >= policy.minUnused {
+		return addrs[next], false, nil
+	}
+
+	if w.IsReadOnly() {
+		return cipher.Address{}, false, ErrWalletReadOnly
+	}
+
+	var generated []cipher.Address
+	f := func(wlt *Wallet) error {
+		var err error
+		generated, err = wlt.GenerateSkycoinAddresses(policy.refillTo - unused)
+		return err
+	}
+
+	if w.IsEncrypted() {
+		if err := w.GuardUpdate(password, f); err != nil {
+			return cipher.Address{}, false, err
+		}
+	} else {
+		if len(password) != 0 {
+			return cipher.Address{}, false, ErrWalletNotEncrypted
+		}
+
+		if err := f(w); err != nil {
+			return cipher.Address{}, false, err
+		}
+	}
+
+	if err := serv.saveWallet(w); err != nil {
+		return cipher.Address{}, false, err
+	}
+
+	serv.setWallet(w)
+	serv.publishSaved(w.Filename(), WalletAddressesAdded)
+
+	if next < len(addrs) {
+		return addrs[next], false, nil
+	}
+	return generated[0], false, nil
+}
+
+// GetSkycoinAddresses returns all addresses in given wallet
+func (serv *Service) GetSkycoinAddresses(wltID string) ([]cipher.Address, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.GetSkycoinAddresses()
+}
+
+// GetWalletForAddress returns the id of the wallet containing addr, looked up in an index of the addresses
+// of all loaded and indexed wallets. If duplicate seeds are allowed and several wallets contain addr,
+// the smallest of their ids is returned. Returns ErrAddressNotFound if no wallet contains addr.
+func (serv *Service) GetWalletForAddress(addr cipher.Address) (string, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return "", ErrWalletAPIDisabled
+	}
+
+	ids, ok := serv.addrIDsMap[addr.String()]
+	if !ok {
+		return "", ErrAddressNotFound
+	}
+
+	return ids[0], nil
+}
+
+// CompareAddresses compares a list of addresses against the addresses of a wallet.
+// missing are the wallet's addresses that are not in the external list, in wallet order.
+// extra are the external addresses that are not in the wallet, in the order given.
+func (serv *Service) CompareAddresses(wltID string, external []cipher.Address) (missing, extra []cipher.Address, err error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	addrs, err := w.GetSkycoinAddresses()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	walletAddrs := make(map[cipher.Address]struct{}, len(addrs))
+	for _, a := range addrs {
+		walletAddrs[a] = struct{}{}
+	}
+
+	externalAddrs := make(map[cipher.Address]struct{}, len(external))
+	for _, a := range external {
+		if _, ok := externalAddrs[a]; ok {
+			continue
+		}
+		externalAddrs[a] = struct{}{}
+
+		if _, ok := walletAddrs[a]; !ok {
+			extra = append(extra, a)
+		}
+	}
+
+	for _, a := range addrs {
+		if _, ok := externalAddrs[a]; !ok {
+			missing = append(missing, a)
+		}
+	}
+
+	return missing, extra, nil
+}
+
+// GetWallet returns wallet by id
+func (serv *Service) GetWallet(wltID string) (*Wallet, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	return serv.getWallet(wltID)
+}
+
+// GetWalletProto returns the protobuf message of a wallet, see WalletProto and wallet.proto.
+// If includeSecrets is set, the seeds and secret keys are included, which requires EnableSeedAPI.
+// The secrets of encrypted wallets can't be included, ErrWalletEncrypted is returned for them.
+func (serv *Service) GetWalletProto(wltID string, includeSecrets bool) (*WalletProto, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	if includeSecrets && !serv.config.EnableSeedAPI {
+		return nil, ErrSeedAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	if includeSecrets && w.IsEncrypted() {
+		return nil, ErrWalletEncrypted
+	}
+
+	return NewWalletProto(w, includeSecrets), nil
+}
+
+// returns the clone of the wallet of given id
+func (serv *Service) getWallet(wltID string) (*Wallet, error) {
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+	w = w.clone()
+	w.seedDeriver = serv.config.SeedDeriver
+	w.indexFilter = serv.config.IndexFilter
+	w.secretStore = serv.config.SecretStore
+	return w, nil
+}
+
+// loadedWallet returns the wallet of given id, loading it from disk first if it was lazily indexed.
+// The returned wallet must not be modified.
+func (serv *Service) loadedWallet(wltID string) (*Wallet, error) {
+	wltID, err := serv.resolveWalletID(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	if w := serv.wallets.get(wltID); w != nil {
+		return w, nil
+	}
+
+	return serv.loadLazyWallet(wltID, serv.lazyWallets[wltID])
+}
+
+// lazyLoad returns true if the wallets are indexed on startup and only parsed when accessed
+func (serv *Service) lazyLoad() bool {
+	return serv.config.LazyLoad || serv.config.MaxCachedWallets > 0
+}
+
+// loadLazyWallet parses the lazy wallet if needed and marks it as recently used,
+// dropping the least recently used wallets from memory if there are too many.
+// The returned wallet must not be modified.
+func (serv *Service) loadLazyWallet(wltID string, lw *lazyWallet) (*Wallet, error) {
+	w, err := lw.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// Evicting writes pending saves, so it is left to the next caller holding the write lock, see evict
+	serv.cache.touch(wltID)
+
+	return w, nil
+}
+
+// rUnlock releases the read lock, then takes the write lock to evict the wallets that were evicted from
+// the cache while loading wallets with the read lock held, see evict
+func (serv *Service) rUnlock() {
+	serv.RUnlock()
+
+	if !serv.cache.hasEvicted() {
+		return
+	}
+
+	serv.Lock()
+	defer serv.Unlock()
+	serv.evict()
+}
+
+// evict drops the parsed wallets evicted from the cache from memory, writing their pending saves first.
+// It must be called with the write lock held.
+func (serv *Service) evict() {
+	for _, id := range serv.cache.drain() {
+		if lw, ok := serv.lazyWallets[id]; ok {
+			// Keep the wallet in memory if its changes can't be written yet
+			if err := serv.flushWallet(id); err != nil {
+				logger.WithError(err).Errorf("Failed to save wallet %s, not unloading it", id)
+				continue
+			}
+			lw.unload()
+		}
+	}
+}
+
+// setWallet stores a saved wallet in memory
+func (serv *Service) setWallet(w *Wallet) {
+	serv.indexWalletAddresses(w)
+
+	if lw, ok := serv.lazyWallets[w.Filename()]; ok {
+		lw.set(w.clone())
+		serv.cache.touch(w.Filename())
+		serv.evict()
+		return
+	}
+
+	serv.wallets.set(w)
+}
+
+// hasWallet returns true if a wallet of given id is loaded or indexed
+func (serv *Service) hasWallet(wltID string) bool {
+	_, err := serv.resolveWalletID(wltID)
+	return err == nil
+}
+
+// hasWalletID returns true if a wallet with exactly the given id is loaded or indexed
+func (serv *Service) hasWalletID(wltID string) bool {
+	if w := serv.wallets.get(wltID); w != nil {
+		return true
+	}
+	_, ok := serv.lazyWallets[wltID]
+	return ok
+}
+
+// resolveWalletID returns the id of the wallet matching wltID.
+// wltID can also be the stable id of a wallet, see StableID.
+// Unless Config.StrictWalletIDs is set, wltID is trimmed and matched case-insensitively
+// if no wallet has exactly that id. Returns ErrAmbiguousWalletID if it matches several wallets.
+func (serv *Service) resolveWalletID(wltID string) (string, error) {
+	if serv.hasWalletID(wltID) {
+		return wltID, nil
+	}
+
+	if ids := serv.walletIDsWithStableID(wltID); len(ids) > 0 {
+		if len(ids) > 1 {
+			return "", ErrAmbiguousWalletID
+		}
+		return ids[0], nil
+	}
+
+	if serv.config.StrictWalletIDs {
+		return "", ErrWalletNotExist
+	}
+
+	id := strings.TrimSpace(wltID)
+	if serv.hasWalletID(id) {
+		return id, nil
+	}
+
+	matches := make(map[string]struct{})
+	for k := range serv.wallets {
+		if strings.EqualFold(k, id) {
+			matches[k] = struct{}{}
+		}
+	}
+	for k := range serv.lazyWallets {
+		if strings.EqualFold(k, id) {
+			matches[k] = struct{}{}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", ErrWalletNotExist
+	case 1:
+		for k := range matches {
+			return k, nil
+		}
+	}
+
+	return "", ErrAmbiguousWalletID
+}
+
+// labelInUse returns true if Config.UniqueLabels is set and a wallet other than wltID has the label
+func (serv *Service) labelInUse(label, wltID string) bool {
+	if !serv.config.UniqueLabels || label == "" {
+		return false
+	}
+
+	for _, id := range serv.walletIDsWithLabel(label) {
+		if id != wltID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// walletIDsWithStableID returns the ids of the loaded or indexed wallets with the given stable id.
+// There is more than one if a wallet file was copied.
+func (serv *Service) walletIDsWithStableID(stableID string) []string {
+	if stableID == "" {
+		return nil
+	}
+
+	var ids []string
+	for id, w := range serv.wallets {
+		if w.StableID() == stableID {
+			ids = append(ids, id)
+		}
+	}
+
+	for id, lw := range serv.lazyWallets {
+		if _, ok := serv.wallets[id]; ok {
+			continue
+		}
+		if lw.stableID == stableID {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// walletIDsWithLabel returns the ids of the loaded or indexed wallets with the given label
+func (serv *Service) walletIDsWithLabel(label string) []string {
+	var ids []string
+	for id, w := range serv.wallets {
+		if w.Label() == label {
+			ids = append(ids, id)
+		}
+	}
+
+	for id, lw := range serv.lazyWallets {
+		if _, ok := serv.wallets[id]; ok {
+			continue
+		}
+		if lw.label == label {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// ResolveWallet returns the wallet referenced by ref, which can be a wallet id or a wallet label.
+// Wallet ids are matched like in other methods, see Config.StrictWalletIDs.
+// Returns ErrAmbiguousReference if ref matches more than one wallet.
+func (serv *Service) ResolveWallet(ref string) (*Wallet, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	matches := make(map[string]struct{})
+	id, err := serv.resolveWalletID(ref)
+	switch err {
+	case nil:
+		matches[id] = struct{}{}
+	case ErrWalletNotExist:
+	default:
+		return nil, err
+	}
+
+	if ref != "" {
+		for _, id := range serv.walletIDsWithLabel(ref) {
+			matches[id] = struct{}{}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, ErrWalletNotExist
+	case 1:
+		for id := range matches {
+			return serv.getWallet(id)
+		}
+	}
+
+	return nil, ErrAmbiguousReference
+}
+
+// GetWalletChecksum returns a hex encoded hash of the wallet's non-secret data.
+// Callers can cache the value and compare it later to detect if the wallet changed.
+func (serv *Service) GetWalletChecksum(wltID string) (string, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return "", ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return "", err
+	}
+
+	h, err := w.checksum()
+	if err != nil {
+		return "", err
+	}
+
+	return h.Hex(), nil
+}
+
+// GetWalletTimestamps returns the Unix times when the wallet was created and last saved by the service.
+// The save time is recorded in the wallet when it is saved, so changes of the file by other programs
+// are not taken into account. A wallet never saved by the service reports its creation time.
+func (serv *Service) GetWalletTimestamps(wltID string) (created, modified int64, err error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return 0, 0, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return w.timestamp(), w.modified(), nil
+}
+
+// GetWalletVersion returns the format version of the wallet file of given id.
+// Wallet files created before the format was versioned have an empty version.
+func (serv *Service) GetWalletVersion(wltID string) (string, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return "", ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return "", err
+	}
+
+	return w.Version(), nil
+}
+
+// StableID returns the immutable identifier of the wallet of given id, which unlike the filename never changes
+// and can be used in place of the wallet id. A wallet created before stable ids were introduced is given one,
+// which is saved.
+func (serv *Service) StableID(wltID string) (string, error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return "", ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return "", err
+	}
+
+	if id := w.StableID(); id != "" {
+		return id, nil
+	}
+
+	w = w.clone()
+	if err := serv.saveWallet(w); err != nil {
+		return "", err
+	}
+
+	serv.setWallet(w)
+	return w.StableID(), nil
+}
+
+// GetAddressIndex returns the position of an address among the entries of its account in the wallet of given id,
+// which is its derivation index. Returns ErrEntryNotFound if the address is not in the wallet.
+func (serv *Service) GetAddressIndex(wltID string, addr cipher.Address) (uint64, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return 0, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return 0, err
+	}
+
+	index := make(map[uint32]uint64)
+	for _, e := range w.Entries {
+		if e.SkycoinAddress() == addr {
+			return index[e.Account], nil
+		}
+		index[e.Account]++
+	}
+
+	return 0, ErrEntryNotFound
+}
+
+// AddressBelongsTo returns true if an address is in the entries of the wallet of given id
+func (serv *Service) AddressBelongsTo(wltID string, addr cipher.Address) (bool, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return false, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return false, err
+	}
+
+	return w.HasEntry(addr), nil
+}
+
+// VerifyTransactionInputsOwned classifies the addresses of the inputs of a transaction by whether they are
+// in the wallet of given id, so that inputs not owned by the wallet can be detected before signing.
+// inputAddrs are the addresses of the unspent outputs spent by the transaction, in the order of tx.In.
+// Each address is returned once, in the order of the inputs.
+func (serv *Service) VerifyTransactionInputsOwned(wltID string, tx *coin.Transaction, inputAddrs []cipher.Address) (owned, notOwned []cipher.Address, err error) {
+	if len(inputAddrs) != len(tx.In) {
+		return nil, nil, errors.New("len(inputAddrs) != len(tx.In)")
+	}
+
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[cipher.Address]struct{}, len(inputAddrs))
+	for _, addr := range inputAddrs {
+		if _, ok := seen[addr]; ok {
+			continue
+		}
+		seen[addr] = struct{}{}
+
+		if w.HasEntry(addr) {
+			owned = append(owned, addr)
+		} else {
+			notOwned = append(notOwned, addr)
+		}
+	}
+
+	return owned, notOwned, nil
+}
+
+// RecordSignedTransaction records a signed transaction as pending in the wallet of given id, e.g. after it was
+// signed offline, so that the wallet is aware of its in-flight spends. The pending transactions are saved
+// in the wallet file until they are removed with RemovePendingTransaction. Recording a transaction that is
+// already pending does nothing. Returns ErrTransactionNotSigned if the transaction is not fully signed.
+// The wallet does not need to be decrypted.
+func (serv *Service) RecordSignedTransaction(wltID string, tx *coin.Transaction) error {
+	return serv.updatePendingTransactions(wltID, func(w *Wallet) error {
+		return w.AddPendingTransaction(tx)
+	})
+}
+
+// RemovePendingTransaction removes a pending transaction from the wallet of given id, e.g. once it is confirmed.
+// Returns ErrPendingTransactionNotFound if the wallet has no pending transaction with the given id.
+func (serv *Service) RemovePendingTransaction(wltID string, txid cipher.SHA256) error {
+	return serv.updatePendingTransactions(wltID, func(w *Wallet) error {
+		return w.RemovePendingTransaction(txid)
+	})
+}
+
+// PruneConfirmedPending removes the pending transactions of the wallet of given id that confirmed reports
+// as confirmed, and returns the number of transactions removed. confirmed is called with the hex encoded
+// id of each pending transaction while the service is locked, so it must not call the Service.
+func (serv *Service) PruneConfirmedPending(wltID string, confirmed func(txid string) bool) (int, error) {
+	var n int
+	if err := serv.updatePendingTransactions(wltID, func(w *Wallet) error {
+		n = w.PrunePendingTransactions(func(txid cipher.SHA256) bool {
+			return confirmed(txid.Hex())
+		})
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// updatePendingTransactions applies f to the wallet of given id and saves it
+func (serv *Service) updatePendingTransactions(wltID string, f func(*Wallet) error) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return err
+	}
+
+	if w.IsReadOnly() {
+		return ErrWalletReadOnly
+	}
+
+	if err := f(w); err != nil {
+		return err
+	}
+
+	if err := serv.saveWallet(w); err != nil {
+		return err
+	}
+
+	serv.setWallet(w)
+	return nil
+}
+
+// PendingTransactions returns the pending transactions of the wallet of given id, see RecordSignedTransaction
+func (serv *Service) PendingTransactions(wltID string) ([]*coin.Transaction, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.PendingTransactions(), nil
+}
+
+// DerivationPath returns the derivation path used for address generation by the wallet of given id
+func (serv *Service) DerivationPath(wltID string) (string, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return "", ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return "", err
+	}
+
+	return w.DerivationPath()
+}
+
+// GetWalletDerivationState returns how far the addresses of the wallet of given id were generated and scanned,
+// see SetWalletDerivationState
+func (serv *Service) GetWalletDerivationState(wltID string) (DerivationState, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return DerivationState{}, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return DerivationState{}, err
+	}
+
+	return w.DerivationState(), nil
+}
+
+// SetWalletDerivationState records the scan progress of the wallet of given id, so that scanning can be
+// resumed after a restart. The wallet does not need to be decrypted.
+// Returns ErrInvalidDerivationState if state.NextIndex differs from the wallet's, which means the
+// wallet's addresses changed since the state was read, or if state.ScanIndex is beyond state.NextIndex.
+func (serv *Service) SetWalletDerivationState(wltID string, state DerivationState) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return err
+	}
+
+	if w.IsReadOnly() {
+		return ErrWalletReadOnly
+	}
+
+	if err := w.setDerivationState(state); err != nil {
+		return err
+	}
+
+	if err := serv.saveWallet(w); err != nil {
+		return err
+	}
+
+	serv.setWallet(w)
+	return nil
+}
+
+// GetWalletEncryptionInfo returns the crypto type and key derivation parameters of the wallet of given id,
+// without decrypting it. For unencrypted wallets, EncryptionInfo.Encrypted is false.
+func (serv *Service) GetWalletEncryptionInfo(wltID string) (EncryptionInfo, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return EncryptionInfo{}, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return EncryptionInfo{}, err
+	}
+
+	return w.EncryptionInfo()
+}
+
+// GetWalletCapabilities returns the operations supported by the wallet of given id
+func (serv *Service) GetWalletCapabilities(wltID string) (Capabilities, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return Capabilities{}, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	return w.Capabilities()
+}
+
+// GetWalletGraph returns the accounts of the wallet of given id and their address chains, see Wallet.Graph.
+// The wallet does not need to be decrypted.
+func (serv *Service) GetWalletGraph(wltID string) (WalletGraph, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return WalletGraph{}, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return WalletGraph{}, err
+	}
+
+	return w.Graph(), nil
+}
+
+// GetRecoveryChecklist returns what is needed to recover the wallet of given id with RecoverWallet.
+// The wallet does not need to be decrypted.
+func (serv *Service) GetRecoveryChecklist(wltID string) (RecoveryChecklist, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return RecoveryChecklist{}, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return RecoveryChecklist{}, err
+	}
+
+	return w.RecoveryChecklist()
+}
+
+// ExportEncryptedSeedQR returns a PNG image of a QR code containing the encrypted secrets of the wallet,
+// for paper backups. The wallet can be restored from the QR code data and the password.
+// Returns ErrWalletNotEncrypted if the wallet is not encrypted, so that a plaintext seed is never exported.
+func (serv *Service) ExportEncryptedSeedQR(wltID string) ([]byte, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := newSeedQRPayload(w)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.png()
+}
+
+// ExportHWWatchOnly returns the watch-only export of a BIP44 wallet as JSON, see HWWatchOnly,
+// for pairing the wallet with a hardware wallet manager. The export only contains the account's
+// extended public key, so the wallet does not need to be decrypted.
+// Returns ErrWalletNotBip44 if the wallet was not created with Options.Bip44, and ErrHardenedDerivation
+// if it was created with Options.Hardened.
+func (serv *Service) ExportHWWatchOnly(wltID string) ([]byte, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	hw, err := w.hwWatchOnly()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(hw, "", "    ")
+}
+
+// ExportMnemonicImage returns a printable PNG image of the wallet's seed words, numbered, together with
+// the coin type, the derivation path and the first address of the wallet, for paper backups.
+// The wallet is only decrypted in memory, the plaintext seed is never written to disk.
+// Like GetWalletSeed, it requires EnableSeedAPI and returns ErrWalletNotEncrypted if the wallet is not encrypted.
+func (serv *Service) ExportMnemonicImage(wltID string, password []byte) ([]byte, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	if !serv.config.EnableSeedAPI {
+		return nil, ErrSeedAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !w.IsEncrypted() {
+		return nil, ErrWalletNotEncrypted
+	}
+
+	if len(w.Entries) == 0 {
+		return nil, ErrWalletEmpty
+	}
+
+	path, err := w.DerivationPath()
+	if err != nil {
+		return nil, err
+	}
+	if serv.config.SeedDeriver != nil {
+		path += " (custom key pairs)"
+	}
+
+	sheet := mnemonicSheet{
+		Coin:           w.coin(),
+		DerivationPath: path,
+		FirstAddr:      w.Entries[0].Address.String(),
+	}
+
+	if err := w.GuardView(password, func(wlt *Wallet) error {
+		sheet.Words = strings.Fields(wlt.seed())
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return sheet.png()
+}
+
+// ImportEncryptedSeedQR creates a wallet from the data of an encrypted seed QR code created by ExportEncryptedSeedQR.
+// The seed is decrypted with the password, and the new wallet is encrypted with the same password.
+// At least as many addresses as the wallet had when exported are generated, and more addresses are scanned ahead for a balance.
+// If a wallet named wltName exists and Config.ImportRenameOnCollision is set, the wallet is saved under a new unique filename,
+// which is returned as the filename of the wallet. Returns ErrInvalidWalletFilename if wltName is not empty
+// and is not the name of a .wlt file.
+func (serv *Service) ImportEncryptedSeedQR(wltName string, blob []byte, password []byte, bg BalanceGetter) (*Wallet, error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	if wltName != "" {
+		if err := validateWalletFilename(wltName); err != nil {
+			return nil, err
+		}
+	}
+
+	p, err := parseSeedQRPayload(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	seed, n, err := p.decrypt(password)
+	if err != nil {
+		return nil, err
+	}
+
+	if wltName == "" || (serv.config.ImportRenameOnCollision && serv.hasWallet(wltName)) {
+		wltName = serv.generateUniqueWalletFilename()
+	}
+
+	return serv.loadWallet(wltName, Options{
+		Coin:      p.Coin,
+		Seed:      seed,
+		Encrypt:   true,
+		Password:  password,
+		GenerateN: n,
+		ScanN:     n + seedQRScanN,
+	}, bg)
+}
+
+// ExportWallet returns the wallet of given id in the format of the wallet files, see ImportWallet.
+// Encrypted wallets are exported as they are, and password must be empty.
+// If a password is provided, an unencrypted wallet is exported encrypted with it and the configured crypto type,
+// the wallet itself stays unencrypted. Exporting an unencrypted wallet without a password reveals its seed,
+// so it requires EnableSeedAPI.
+func (serv *Service) ExportWallet(wltID string, password []byte) ([]byte, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Erase()
+
+	switch {
+	case w.IsEncrypted():
+		if len(password) != 0 {
+			return nil, ErrWalletEncrypted
+		}
+	case len(password) != 0:
+		if err := serv.validatePassword(password); err != nil {
+			return nil, err
+		}
+		if err := serv.lockWallet(w, password); err != nil {
+			return nil, err
+		}
+	case !serv.config.EnableSeedAPI:
+		return nil, ErrSeedAPIDisabled
+	}
+
+	return json.MarshalIndent(NewReadableWallet(w), "", "    ")
+}
+
+// ImportWallet adds a wallet from data in the format of the wallet files, e.g. exported with ExportWallet.
+// The wallet is saved as wltName, or under a new unique filename if wltName is empty. It keeps its encryption.
+// Returns ErrInvalidWalletFilename if wltName is not empty and is not the name of a .wlt file, see RenameWallet.
+// Returns ErrSeedUsed if a wallet with the same first address exists, unless AllowDuplicateSeeds is set.
+func (serv *Service) ImportWallet(wltName string, data []byte) (*Wallet, error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	return serv.importWallet(wltName, data)
+}
+
+// importWallet adds a wallet from data in the format of the wallet files, see ImportWallet
+func (serv *Service) importWallet(wltName string, data []byte) (*Wallet, error) {
+	var rw ReadableWallet
+	if err := json.Unmarshal(data, &rw); err != nil {
+		return nil, fmt.Errorf("invalid wallet data: %v", err)
+	}
+	rw.normalizeCoinType()
+
+	if wltName != "" {
+		if err := validateWalletFilename(wltName); err != nil {
+			return nil, err
+		}
+	}
+
+	if wltName == "" || (serv.config.ImportRenameOnCollision && serv.hasWallet(wltName)) {
+		wltName = serv.generateUniqueWalletFilename()
+	}
+	if rw.Meta == nil {
+		rw.Meta = make(map[string]string)
+	}
+	rw.Meta[metaFilename] = wltName
+
+	w, err := rw.ToWallet()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateCoinType(w.coin()); err != nil {
+		return nil, err
+	}
+
+	if len(w.Entries) == 0 {
+		return nil, ErrWalletEmpty
+	}
+
+	if err := serv.canAddWallet(); err != nil {
+		return nil, err
+	}
+
+	if serv.labelInUse(w.Label(), wltName) {
+		return nil, ErrLabelInUse
+	}
+
+	if _, ok := serv.firstAddrIDMap[w.Entries[0].Address.String()]; ok && !serv.config.AllowDuplicateSeeds {
+		return nil, ErrSeedUsed
+	}
+
+	if serv.hasWalletID(wltName) {
+		return nil, ErrWalletNameConflict
+	}
+
+	if err := serv.saveWallet(w); err != nil {
+		return nil, err
+	}
+
+	serv.addWallet(w)
+
+	return w.clone(), nil
+}
+
+// ExportEncryptedArchive returns the wallet of given id in the format of the wallet files, like ExportWallet,
+// encrypted as a whole with archivePassword and the configured crypto type, see ImportEncryptedArchive.
+// An encrypted wallet keeps its own encryption inside the archive, so its seed is encrypted twice.
+// archivePassword is checked with Config.PasswordValidator.
+func (serv *Service) ExportEncryptedArchive(wltID string, archivePassword []byte) ([]byte, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	if len(archivePassword) == 0 {
+		return nil, ErrMissingPassword
+	}
+
+	if err := serv.validatePassword(archivePassword); err != nil {
+		return nil, err
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Erase()
+
+	crypto, err := serv.cryptor()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(NewReadableWallet(w))
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := crypto.Encrypt(data, archivePassword)
+	if err != nil {
+		return nil, err
+	}
+
+	a := walletArchive{
+		Version:    WalletArchiveVersion,
+		CryptoType: serv.config.CryptoType,
+		Data:       enc,
+	}
+	return a.serialize(), nil
+}
+
+// ImportEncryptedArchive adds a wallet from an archive created with ExportEncryptedArchive, decrypted with archivePassword.
+// The wallet is added like with ImportWallet and keeps its own encryption.
+// Returns ErrInvalidPassword if archivePassword is wrong.
+func (serv *Service) ImportEncryptedArchive(wltName string, archive, archivePassword []byte) (*Wallet, error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	if wltName != "" {
+		if err := validateWalletFilename(wltName); err != nil {
+			return nil, err
+		}
+	}
+
+	a, err := parseWalletArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := a.decrypt(archivePassword)
+	if err != nil {
+		return nil, err
+	}
+	return serv.importWallet(wltName, data)
+}
+
+// GetWallets returns all wallet clones
+func (serv *Service) GetWallets() (Wallets, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	wlts := make(Wallets, len(serv.wallets)+len(serv.lazyWallets))
+	for k, w := range serv.wallets {
+		wlts[k] = w.clone()
+	}
+
+	for k, lw := range serv.lazyWallets {
+		if _, ok := wlts[k]; ok {
+			continue
+		}
+
+		w, err := serv.loadLazyWallet(k, lw)
+		if err != nil {
+			return nil, err
+		}
+		wlts[k] = w.clone()
+	}
+
+	return wlts, nil
+}
+
+// WalletFilter selects the wallets returned by ListWallets. Zero fields match every wallet.
+type WalletFilter struct {
+	LabelContains string // the wallet label contains this substring
+	Encrypted     *bool  // the wallet is encrypted, or not encrypted
+	Type          string // the wallet type, e.g. WalletTypeDeterministic
+	MinEntries    int    // the wallet has at least this many addresses
+}
+
+// match returns true if the wallet is selected by the filter
+func (f WalletFilter) match(w *Wallet) bool {
+	if f.LabelContains != "" && !strings.Contains(w.Label(), f.LabelContains) {
+		return false
+	}
+	if f.Encrypted != nil && w.IsEncrypted() != *f.Encrypted {
+		return false
+	}
+	if f.Type != "" && w.Type() != f.Type {
+		return false
+	}
+	return len(w.Entries) >= f.MinEntries
+}
+
+// ListWallets returns clones of the wallets selected by the filter.
+// Only the selected wallets are cloned, so this is cheaper than filtering the result of GetWallets.
+func (serv *Service) ListWallets(filter WalletFilter) (Wallets, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	wlts := make(Wallets)
+	for k, w := range serv.wallets {
+		if filter.match(w) {
+			wlts[k] = w.clone()
+		}
+	}
+
+	for k, lw := range serv.lazyWallets {
+		if serv.wallets.get(k) != nil {
+			continue
+		}
+
+		w, err := serv.loadLazyWallet(k, lw)
+		if err != nil {
+			return nil, err
+		}
+		if filter.match(w) {
+			wlts[k] = w.clone()
+		}
+	}
+
+	return wlts, nil
+}
+
+// ListWalletsByCoin returns the ids of all wallets grouped by coin type, sorted by id.
+// Wallets that were not accessed yet in lazy loading mode are grouped by their indexed coin type, without loading them.
+func (serv *Service) ListWalletsByCoin() (map[CoinType][]string, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	wlts := make(map[CoinType][]string)
+	for id, w := range serv.wallets {
+		wlts[w.coin()] = append(wlts[w.coin()], id)
+	}
+
+	for id, lw := range serv.lazyWallets {
+		if _, ok := serv.wallets[id]; ok {
+			continue
+		}
+		wlts[lw.coin] = append(wlts[lw.coin], id)
+	}
+
+	for _, ids := range wlts {
+		sort.Strings(ids)
+	}
+
+	return wlts, nil
+}
+
+// getBalances requests the balances of addrs from bg, split into batches according to
+// Config.BalanceFetchBatchSize and Config.BalanceFetchConcurrency.
+// The balances are returned in the same order as addrs.
+func (serv *Service) getBalances(bg BalanceGetter, addrs []cipher.Address) ([]BalancePair, error) {
+	batchSize := serv.config.BalanceFetchBatchSize
+	if batchSize <= 0 || len(addrs) <= batchSize {
+		batchSize = len(addrs)
+	}
+
+	concurrency := serv.config.BalanceFetchConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	bals := make([]BalancePair, len(addrs))
+	fetch := func(start, end int) error {
+		b, err := bg.GetBalanceOfAddrs(addrs[start:end])
+		if err != nil {
+			return err
+		}
+		if len(b) != end-start {
+			return errors.New("balance getter returned wrong number of balances")
+		}
+		copy(bals[start:end], b)
+		return nil
+	}
+
+	if batchSize == len(addrs) {
+		if err := fetch(0, len(addrs)); err != nil {
+			return nil, err
+		}
+		return bals, nil
+	}
+
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var fetchErr error
+	sem := make(chan struct{}, concurrency)
+
+	for start := 0; start < len(addrs); start += batchSize {
+		end := start + batchSize
+		if end > len(addrs) {
+			end = len(addrs)
+		}
+
+		sem <- struct{}{}
+
+		// Don't request more batches once a batch failed
+		errMu.Lock()
+		failed := fetchErr != nil
+		errMu.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			defer func() {
+				<-sem
+			}()
+
+			if err := fetch(start, end); err != nil {
+				errMu.Lock()
+				if fetchErr == nil {
+					fetchErr = err
+				}
+				errMu.Unlock()
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+
+	return bals, nil
+}
+
+// ExportBalancesCSV writes the confirmed and predicted coin balance of every wallet to out as CSV,
+// one row per wallet sorted by wallet id, followed by a row with the totals.
+// The balances of all wallets are requested from bg in a single call.
+func (serv *Service) ExportBalancesCSV(bg BalanceGetter, out io.Writer) error {
+	wlts, err := serv.GetWallets()
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(wlts))
+	var addrs []cipher.Address
+	for id, w := range wlts {
+		ids = append(ids, id)
+		for _, e := range w.Entries {
+			addrs = append(addrs, e.SkycoinAddress())
+		}
+	}
+	sort.Strings(ids)
+
+	bals, err := serv.getBalances(bg, addrs)
+	if err != nil {
+		return err
+	}
+
+	addrBals := make(map[cipher.Address]BalancePair, len(addrs))
+	for i, addr := range addrs {
+		addrBals[addr] = bals[i]
+	}
+
+	cw := csv.NewWriter(out)
+	writeRow := func(id, label string, bal BalancePair) error {
+		confirmed, err := droplet.ToString(bal.Confirmed.Coins)
+		if err != nil {
+			return err
+		}
+		predicted, err := droplet.ToString(bal.Predicted.Coins)
+		if err != nil {
+			return err
+		}
+		return cw.Write([]string{id, label, confirmed, predicted})
+	}
+
+	if err := cw.Write([]string{"wallet_id", "label", "confirmed", "predicted"}); err != nil {
+		return err
+	}
+
+	var total BalancePair
+	for _, id := range ids {
+		w := wlts[id]
+
+		var bal BalancePair
+		for _, e := range w.Entries {
+			b := addrBals[e.SkycoinAddress()]
+			if bal.Confirmed, err = bal.Confirmed.Add(b.Confirmed); err != nil {
+				return err
+			}
+			if bal.Predicted, err = bal.Predicted.Add(b.Predicted); err != nil {
+				return err
+			}
+		}
+
+		if err := writeRow(id, w.Label(), bal); err != nil {
+			return err
+		}
+
+		if total.Confirmed, err = total.Confirmed.Add(bal.Confirmed); err != nil {
+			return err
+		}
+		if total.Predicted, err = total.Predicted.Add(bal.Predicted); err != nil {
+			return err
+		}
+	}
+
+	if err := writeRow("total", "", total); err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// UpdateWalletLabel updates the wallet label
+func (serv *Service) UpdateWalletLabel(wltID, label string) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	return serv.updateWalletLabel(wltID, label)
+}
+
+// UpdateWalletLabels updates the labels of several wallets, given by wallet id, and returns the ids of the
+// updated wallets. The labels are updated in the order of the wallet ids, so with Config.UniqueLabels set
+// a wallet can take the label of a wallet that was relabeled before it. If some of the labels can't be updated,
+// the others are still updated and the errors are returned as a WalletErrors error.
+func (serv *Service) UpdateWalletLabels(labels map[string]string) ([]string, error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	ids := make([]string, 0, len(labels))
+	for id := range labels {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var updated []string
+	wltErrs := WalletErrors{}
+	for _, id := range ids {
+		if err := serv.updateWalletLabel(id, labels[id]); err != nil {
+			wltErrs[id] = err
+			continue
+		}
+		updated = append(updated, id)
+	}
+
+	if len(wltErrs) > 0 {
+		return updated, wltErrs
+	}
+
+	return updated, nil
+}
+
+func (serv *Service) updateWalletLabel(wltID, label string) error {
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return err
+	}
+
+	if w.IsReadOnly() {
+		return ErrWalletReadOnly
+	}
+
+	if serv.labelInUse(label, w.Filename()) {
+		return ErrLabelInUse
+	}
+
+	w.setLabel(label)
+
+	if err := serv.saveWallet(w); err != nil {
+		return err
+	}
+
+	serv.setWallet(w)
+	serv.publishSaved(w.Filename(), WalletLabelChanged)
+	return nil
+}
+
+// RenameWallet changes the filename, and so the id, of a wallet. The wallet is written under newFilename,
+// then its old file and the backup of the old file are removed. If the old files can't be removed,
+// the new file is removed and the old file is restored.
+// newFilename must end in .wlt and must not be the id of another wallet, otherwise ErrInvalidWalletFilename
+// or ErrWalletNameConflict is returned. The stable id of the wallet does not change, see StableID.
+// Secrets of the wallet cached by ViewSecrets are dropped.
+func (serv *Service) RenameWallet(wltID, newFilename string) (*Wallet, error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	if err := validateWalletFilename(newFilename); err != nil {
+		return nil, err
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.IsReadOnly() {
+		return nil, ErrWalletReadOnly
+	}
+
+	oldID := w.Filename()
+	if newFilename == oldID {
+		return w, nil
+	}
+
+	newPath := filepath.Join(serv.config.WalletDir, newFilename)
+	if serv.hasWalletID(newFilename) {
+		return nil, ErrWalletNameConflict
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return nil, ErrWalletNameConflict
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Keep the old file, to restore it if it can't be removed along with its backup
+	oldPath := filepath.Join(serv.config.WalletDir, oldID)
+	oldData, err := ioutil.ReadFile(oldPath)
+	if err != nil {
+		return nil, err
+	}
+
+	w.setFilename(newFilename)
+	if err := serv.writeWallet(w); err != nil {
+		delete(serv.fileHashes, newFilename)
+		return nil, err
+	}
+
+	if err := removeFiles(oldPath, oldPath+".bak"); err != nil {
+		if _, statErr := os.Stat(oldPath); os.IsNotExist(statErr) {
+			if writeErr := file.SaveBinary(oldPath, oldData, 0600); writeErr != nil {
+				logger.WithError(writeErr).Errorf("Failed to restore wallet file %s", oldPath)
+			}
+		}
+		if removeErr := os.Remove(newPath); removeErr != nil {
+			logger.WithError(removeErr).Errorf("Failed to remove wallet file %s", newPath)
+		}
+		delete(serv.fileHashes, newFilename)
+		return nil, err
+	}
+
+	// Move the state of the wallet to the new id.
+	// The changes of the pending save of the old id were written to the new file.
+	addr := serv.firstAddr(oldID)
+	serv.unindexAddresses(oldID)
+	if p, ok := serv.pendingSaves[oldID]; ok {
+		for _, kind := range p.events {
+			serv.events.publish(oldID, kind)
+		}
+	}
+	serv.dropPendingSave(oldID)
+	delete(serv.fileHashes, oldID)
+	serv.unlockCache.remove(oldID)
+
+	if lw, ok := serv.lazyWallets[oldID]; ok {
+		delete(serv.lazyWallets, oldID)
+		serv.cache.remove(oldID)
+		lw.path = newPath
+		serv.lazyWallets[newFilename] = lw
+	} else {
+		serv.wallets.remove(oldID)
+	}
+	serv.setWallet(w)
+
+	if id, ok := serv.firstAddrIDMap[addr]; ok && id == oldID {
+		serv.firstAddrIDMap[addr] = newFilename
+	}
+
+	if p, ok := serv.addressPools[oldID]; ok {
+		delete(serv.addressPools, oldID)
+		serv.addressPools[newFilename] = p
+	}
+
+	if p, ok := serv.pendingReencrypts[oldID]; ok {
+		password := append([]byte(nil), p.password...)
+		serv.cancelReencrypt(oldID)
+		serv.scheduleReencrypt(newFilename, password)
+		eraseBytes(password)
+	}
+
+	serv.events.publishEvent(WalletEvent{
+		WalletID:   newFilename,
+		Kind:       WalletRenamed,
+		PreviousID: oldID,
+	})
+
+	return w.clone(), nil
+}
+
+// removeFiles removes the files, ignoring those that don't exist
+func removeFiles(paths ...string) error {
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// FreezeAddress sets whether an address of a wallet is excluded from automatic coin selection.
+// Frozen addresses can still be spent from by choosing them explicitly.
+func (serv *Service) FreezeAddress(wltID string, addr cipher.Address, frozen bool) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return err
+	}
+
+	if w.IsReadOnly() {
+		return ErrWalletReadOnly
+	}
+
+	if err := w.SetFrozen(addr, frozen); err != nil {
+		return err
+	}
+
+	if err := serv.saveWallet(w); err != nil {
+		return err
+	}
+
+	serv.setWallet(w)
+	return nil
+}
+
+// SetEntryExpiry sets the time after which an imported entry of a wallet is removed by ReapExpired,
+// a zero time removes the expiry, see Wallet.SetEntryExpiry. The wallet does not need to be decrypted.
+func (serv *Service) SetEntryExpiry(wltID string, addr cipher.Address, at time.Time) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return err
+	}
+
+	if w.IsReadOnly() {
+		return ErrWalletReadOnly
+	}
+
+	if err := w.SetEntryExpiry(addr, at); err != nil {
+		return err
+	}
+
+	if err := serv.saveWallet(w); err != nil {
+		return err
+	}
+
+	serv.setWallet(w)
+	return nil
+}
+
+// ReapExpired removes the expired imported entries of a wallet, see SetEntryExpiry, together with their secret keys,
+// and returns their addresses. Entries whose address has coins, confirmed or predicted, according to bg are kept
+// until they are empty. The balances are requested without holding the service lock, and an entry is only removed
+// if it is still expired afterwards. The password is required if the wallet is encrypted, to remove the secret keys.
+func (serv *Service) ReapExpired(wltID string, password []byte, bg BalanceGetter) ([]cipher.Address, error) {
+	if bg == nil {
+		return nil, ErrNilBalanceGetter
+	}
+
+	now := time.Now()
+	expired, err := serv.expiredAddresses(wltID, now)
+	if err != nil || len(expired) == 0 {
+		return nil, err
+	}
+
+	bals, err := serv.getBalances(bg, expired)
+	if err != nil {
+		return nil, err
+	}
+
+	empty := make(map[cipher.Address]struct{}, len(expired))
+	for i, b := range bals {
+		if b.Confirmed.Coins == 0 && b.Predicted.Coins == 0 {
+			empty[expired[i]] = struct{}{}
+		}
+	}
+	if len(empty) == 0 {
+		return nil, nil
+	}
+
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.IsReadOnly() {
+		return nil, ErrWalletReadOnly
+	}
+
+	var removed []cipher.Address
+	f := func(wlt *Wallet) error {
+		removed = wlt.removeExpiredEntries(now, empty)
+		return nil
+	}
+
+	if w.IsEncrypted() {
+		if err := w.GuardUpdate(password, f); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(password) != 0 {
+			return nil, ErrWalletNotEncrypted
+		}
+
+		if err := f(w); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(removed) == 0 {
+		return nil, nil
+	}
+
+	// Save the wallet first
+	if err := serv.saveWallet(w); err != nil {
+		return nil, err
+	}
+
+	serv.unindexAddresses(w.Filename())
+	serv.setWallet(w)
+	serv.publishSaved(w.Filename(), WalletAddressesRemoved)
+
+	return removed, nil
+}
+
+// expiredAddresses returns the addresses of the entries of a wallet that expired at or before now
+func (serv *Service) expiredAddresses(wltID string, now time.Time) ([]cipher.Address, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	if w.IsReadOnly() {
+		return nil, ErrWalletReadOnly
+	}
+
+	if w.coin() != CoinTypeSkycoin {
+		return nil, errors.New("Wallet coin type is not Skycoin")
+	}
+
+	return w.expiredAddresses(now), nil
+}
+
+// GetFrozenAddresses returns the addresses of a wallet that are excluded from automatic coin selection
+func (serv *Service) GetFrozenAddresses(wltID string) ([]cipher.Address, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.FrozenAddresses(), nil
+}
+
+// ListFrozenAddresses returns the frozen addresses of all wallets, grouped by wallet id.
+// Wallets without frozen addresses are omitted.
+func (serv *Service) ListFrozenAddresses() (map[string][]cipher.Address, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	frozen := make(map[string][]cipher.Address)
+	add := func(wltID string, w *Wallet) {
+		if addrs := w.FrozenAddresses(); len(addrs) > 0 {
+			frozen[wltID] = addrs
+		}
+	}
+
+	for k, w := range serv.wallets {
+		add(k, w)
+	}
+
+	for k, lw := range serv.lazyWallets {
+		if w := serv.wallets.get(k); w != nil {
+			continue
+		}
+
+		w, err := serv.loadLazyWallet(k, lw)
+		if err != nil {
+			return nil, err
+		}
+		add(k, w)
+	}
+
+	return frozen, nil
+}
+
+// SetAddressLabel sets the label of an address of a wallet, an empty label removes it.
+// The label is stored unencrypted, the wallet does not need to be decrypted.
+func (serv *Service) SetAddressLabel(wltID string, addr cipher.Address, label string) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return err
+	}
+
+	if w.IsReadOnly() {
+		return ErrWalletReadOnly
+	}
+
+	if err := w.SetAddressLabel(addr, label); err != nil {
+		return err
+	}
+
+	if err := serv.saveWallet(w); err != nil {
+		return err
+	}
+
+	serv.setWallet(w)
+	return nil
+}
+
+// LabeledAddress is an address of a wallet with its label, see ListAddressesWithLabels
+type LabeledAddress struct {
+	Address cipher.Addresser
+	// Account is the account whose address chain the address belongs to
+	Account uint32
+	// Index is the position of the address in the address chain of its account
+	Index uint64
+	// Label is the label of the address, empty if it has none
+	Label string
+}
+
+// ListAddressesWithLabels returns the addresses of a wallet with their labels, in the order of the wallet's entries
+func (serv *Service) ListAddressesWithLabels(wltID string) ([]LabeledAddress, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]LabeledAddress, len(w.Entries))
+	index := make(map[uint32]uint64)
+	for i, e := range w.Entries {
+		addrs[i] = LabeledAddress{
+			Address: e.Address,
+			Account: e.Account,
+			Index:   index[e.Account],
+			Label:   e.Label,
+		}
+		index[e.Account]++
+	}
+
+	return addrs, nil
+}
+
+// ExportedPublicKey is an address and its public key written by ExportPublicKeysJSON
+type ExportedPublicKey struct {
+	// Index is the position of the address in the address chain of its account
+	Index uint64 `json:"index"`
+	// Account is the account whose address chain the address belongs to
+	Account   uint32 `json:"account,omitempty"`
+	Address   string `json:"address"`
+	PublicKey string `json:"public_key"`
+}
+
+// ExportPublicKeysJSON writes the addresses of a wallet and their public keys to out as a JSON array
+// of ExportedPublicKey, in the order of the wallet. No secrets are written, so the output can be used
+// to build transactions on a machine that does not have the wallet's secret keys.
+// The wallet does not need to be decrypted.
+func (serv *Service) ExportPublicKeysJSON(wltID string, out io.Writer) error {
+	w, err := serv.GetWallet(wltID)
+	if err != nil {
+		return err
+	}
+	defer w.Erase()
+
+	if _, err := io.WriteString(out, "["); err != nil {
+		return err
+	}
+
+	index := make(map[uint32]uint64)
+	for i, e := range w.Entries {
+		b, err := json.Marshal(ExportedPublicKey{
+			Index:     index[e.Account],
+			Account:   e.Account,
+			Address:   e.Address.String(),
+			PublicKey: e.Public.Hex(),
+		})
+		if err != nil {
+			return err
+		}
+		index[e.Account]++
+
+		sep := ",\n"
+		if i == 0 {
+			sep = "\n"
+		}
+		if _, err := io.WriteString(out, sep); err != nil {
+			return err
+		}
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(out, "\n]\n")
+	return err
+}
+
+// AddressMatch is an address found by SearchAddresses
+type AddressMatch struct {
+	WalletID string
+	Address  cipher.Addresser
+	// Account is the account whose address chain the address belongs to
+	Account uint32
+	// Index is the position of the address in the address chain of its account
+	Index uint64
+}
+
+// SearchAddresses returns the addresses of all wallets whose string representation contains fragment,
+// sorted by wallet id and then by their order in the wallet. At most Config.MaxSearchResults addresses are returned.
+// Returns ErrEmptySearchFragment if fragment is empty or only whitespace.
+func (serv *Service) SearchAddresses(fragment string) ([]AddressMatch, error) {
+	fragment = strings.TrimSpace(fragment)
+	if fragment == "" {
+		return nil, ErrEmptySearchFragment
+	}
+
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	limit := serv.config.MaxSearchResults
+	if limit <= 0 {
+		limit = DefaultMaxSearchResults
+	}
+
+	ids := make([]string, 0, serv.walletCount())
+	for id := range serv.wallets {
+		ids = append(ids, id)
+	}
+	for id := range serv.lazyWallets {
+		if _, ok := serv.wallets[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var matches []AddressMatch
+	for _, id := range ids {
+		w, err := serv.loadedWallet(id)
+		if err != nil {
+			return nil, err
+		}
+
+		index := make(map[uint32]uint64)
+		for _, e := range w.Entries {
+			if strings.Contains(e.Address.String(), fragment) {
+				matches = append(matches, AddressMatch{
+					WalletID: id,
+					Address:  e.Address,
+					Account:  e.Account,
+					Index:    index[e.Account],
+				})
+				if len(matches) == limit {
+					return matches, nil
+				}
+			}
+			index[e.Account]++
+		}
+	}
+
+	return matches, nil
+}
+
+// SetSeedPassphraseHint sets a hint to help the user remember the seed passphrase.
+// The hint is stored unencrypted, so it must not contain the passphrase itself. An empty hint clears it.
+func (serv *Service) SetSeedPassphraseHint(wltID, hint string) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	if utf8.RuneCountInString(hint) > SeedPassphraseHintMaxLength {
+		return ErrSeedPassphraseHintTooLong
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return err
+	}
+
+	if w.IsReadOnly() {
+		return ErrWalletReadOnly
+	}
+
+	w.setSeedPassphraseHint(hint)
+
+	if err := serv.saveWallet(w); err != nil {
+		return err
+	}
+
+	serv.setWallet(w)
+	return nil
+}
+
+// GetSeedPassphraseHint returns the seed passphrase hint of a wallet. The wallet does not need to be decrypted.
+func (serv *Service) GetSeedPassphraseHint(wltID string) (string, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return "", ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return "", err
+	}
+
+	return w.SeedPassphraseHint(), nil
+}
+
+// SetWalletNotes sets free-text notes about a wallet, separate from its label.
+// The notes are stored unencrypted. Empty notes clear them.
+// Returns ErrWalletNotesTooLong if the notes are longer than Config.WalletNotesMaxLength.
+func (serv *Service) SetWalletNotes(wltID, notes string) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	maxLen := serv.config.WalletNotesMaxLength
+	if maxLen <= 0 {
+		maxLen = DefaultWalletNotesMaxLength
+	}
+	if utf8.RuneCountInString(notes) > maxLen {
+		return ErrWalletNotesTooLong
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return err
+	}
+
+	if w.IsReadOnly() {
+		return ErrWalletReadOnly
+	}
+
+	w.setNotes(notes)
+
+	if err := serv.saveWallet(w); err != nil {
+		return err
+	}
+
+	serv.setWallet(w)
+	return nil
+}
+
+// SetWalletTags sets the tags of a wallet, replacing its existing tags. Duplicate tags are only kept once.
+// The tags are stored unencrypted. Returns ErrEmptyWalletTag if a tag is empty.
+func (serv *Service) SetWalletTags(wltID string, tags []string) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	var uniq []string
+	seen := make(map[string]struct{}, len(tags))
+	for _, t := range tags {
+		if t == "" {
+			return ErrEmptyWalletTag
+		}
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		uniq = append(uniq, t)
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return err
+	}
+
+	if w.IsReadOnly() {
+		return ErrWalletReadOnly
+	}
+
+	w.setTags(uniq)
+
+	if err := serv.saveWallet(w); err != nil {
+		return err
+	}
+
+	serv.setWallet(w)
+	return nil
+}
+
+// GetWalletNotes returns the notes of a wallet. The wallet does not need to be decrypted.
+func (serv *Service) GetWalletNotes(wltID string) (string, error) {
+	serv.RLock()
+	defer serv.rUnlock()
+	if !serv.config.EnableWalletAPI {
+		return "", ErrWalletAPIDisabled
+	}
+
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return "", err
+	}
+
+	return w.Notes(), nil
+}
+
+// MergeMetadata copies the user annotations of the wallet fromID into the wallet intoID.
+// The annotations are the wallet label, notes, tags and seed passphrase hint, and the labels of the addresses
+// that both wallets have; address entries and secrets are never touched. When both wallets have a non-empty
+// value for the same annotation, the value of the destination wallet is kept. Tags are merged, the tags of the
+// source wallet that the destination wallet doesn't have are added.
+func (serv *Service) MergeMetadata(intoID, fromID string) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(intoID)
+	if err != nil {
+		return err
+	}
+
+	from, err := serv.loadedWallet(fromID)
+	if err != nil {
+		return err
+	}
+
+	if w.IsReadOnly() {
+		return ErrWalletReadOnly
+	}
+
+	label := w.Label()
+	if !w.mergeMetadata(from) {
+		return nil
+	}
+
+	if w.Label() != label && serv.labelInUse(w.Label(), w.Filename()) {
+		return ErrLabelInUse
+	}
+
+	if err := serv.saveWallet(w); err != nil {
+		return err
+	}
+
+	serv.setWallet(w)
+	return nil
+}
+
+// SetWalletReadOnly sets or clears the read-only flag of a wallet.
+// Read-only wallets can't be encrypted, decrypted, relabeled, recovered or have new addresses generated.
+// The flag can only be changed with this method, Update and UpdateSecrets can't change it.
+func (serv *Service) SetWalletReadOnly(wltID string, readOnly bool) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return err
+	}
+
+	w.setReadOnly(readOnly)
+
+	if err := serv.saveWallet(w); err != nil {
+		return err
+	}
+
+	serv.setWallet(w)
+	return nil
+}
+
+// Subscribe registers fn to be called with an event for each change of a wallet, see WalletEventKind,
+// and returns the function that unregisters it. Events are reported after the change is written to disk,
+// so if saves are debounced they are delayed until the wallet is written, see Config.SaveDebounce.
+// Events of changes that are never written, e.g. discarded by ReloadWallet, are not reported.
+// fn is called from a separate goroutine without the service lock held, so it may call other Service methods.
+// Events are delivered in order, and a slow fn delays the following events but not the changes of the wallets.
+func (serv *Service) Subscribe(fn func(ev WalletEvent)) func() {
+	return serv.events.subscribe(fn)
+}
+
+// UnloadWallet removes wallet of given wallet id from the service
+func (serv *Service) UnloadWallet(wltID string) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	if id, err := serv.resolveWalletID(wltID); err == nil {
+		wltID = id
+	}
+
+	if err := serv.flushWallet(wltID); err != nil {
+		return err
+	}
+
+	serv.unloadWallet(wltID)
+	serv.events.publish(wltID, WalletUnloaded)
+	return nil
+}
+
+// unloadWallet removes the wallet from memory, discarding any pending save of it
+func (serv *Service) unloadWallet(wltID string) {
+	addr := serv.firstAddr(wltID)
+
+	serv.dropPendingSave(wltID)
+	serv.unindexAddresses(wltID)
+	serv.wallets.remove(wltID)
+	delete(serv.lazyWallets, wltID)
+	delete(serv.addressPools, wltID)
+	delete(serv.fileHashes, wltID)
+	serv.cache.remove(wltID)
+	serv.unlockCache.remove(wltID)
+	serv.cancelReencrypt(wltID)
+
+	if addr != "" {
+		serv.removeFirstAddr(addr, wltID)
+	}
+}
+
+// firstAddr returns the first address of a loaded or indexed wallet, or an empty string if it has none
+func (serv *Service) firstAddr(wltID string) string {
+	if wlt := serv.wallets.get(wltID); wlt != nil && len(wlt.Entries) > 0 {
+		return wlt.Entries[0].Address.String()
+	} else if lw, ok := serv.lazyWallets[wltID]; ok {
+		return lw.firstAddr
+	}
+	return ""
+}
+
+// ReloadWallet replaces the wallet of given id in memory with the wallet file on disk,
+// discarding any changes not written yet, see Config.SaveDebounce.
+// A wallet file that is not loaded yet is loaded, and a loaded wallet whose file was deleted is unloaded
+// and ErrWalletNotExist is returned. Returns ErrWalletNotExist if the wallet is neither loaded nor on disk,
+// and ErrSeedUsed if the wallet file has the seed of another loaded wallet.
+// A scheduled re-encryption of the wallet is cancelled, see Config.AutoReencryptAfter.
+func (serv *Service) ReloadWallet(wltID string) (*Wallet, error) {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return nil, ErrWalletAPIDisabled
+	}
+
+	if id, err := serv.resolveWalletID(wltID); err == nil {
+		wltID = id
+	}
+
+	// Only reload wallet files in the wallet dir
+	if filepath.Base(wltID) != wltID || !strings.HasSuffix(wltID, WalletExt) {
+		return nil, ErrWalletNotExist
+	}
+
+	path := filepath.Join(serv.config.WalletDir, wltID)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if !serv.hasWalletID(wltID) {
+			return nil, ErrWalletNotExist
+		}
+		serv.unloadWallet(wltID)
+		serv.events.publish(wltID, WalletUnloaded)
+		return nil, ErrWalletNotExist
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !serv.hasWalletID(wltID) {
+		if err := serv.canAddWallet(); err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := loadWallet(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var addr string
+	if len(w.Entries) > 0 {
+		addr = w.Entries[0].Address.String()
+	} else {
+		logger.Warningf("Wallet %s has no addresses, it can't be checked for duplicate seeds", wltID)
+	}
+
+	if id, ok := serv.firstAddrIDMap[addr]; ok && addr != "" && id != wltID && !serv.config.AllowDuplicateSeeds {
+		return nil, ErrSeedUsed
+	}
+
+	oldAddr := serv.firstAddr(wltID)
+
+	serv.dropPendingSave(wltID)
+	serv.cancelReencrypt(wltID)
+	serv.unlockCache.remove(wltID)
+	serv.unindexAddresses(wltID)
+	serv.indexWalletAddresses(w)
+
+	if serv.lazyLoad() {
+		lw, ok := serv.lazyWallets[wltID]
+		if !ok {
+			lw = &lazyWallet{path: path}
+			serv.lazyWallets[wltID] = lw
+		}
+		lw.set(w)
+		serv.cache.touch(wltID)
+		serv.evict()
+	} else {
+		serv.wallets.set(w)
+	}
+
+	// The old first address is removed after the swap, so that it is not pointed back to the old wallet
+	if oldAddr != "" && oldAddr != addr {
+		serv.removeFirstAddr(oldAddr, wltID)
+	}
+
+	if _, ok := serv.firstAddrIDMap[addr]; !ok && addr != "" {
+		serv.firstAddrIDMap[addr] = wltID
+	}
+
+	if err := serv.recordFileHash(wltID); err != nil {
+		return nil, err
+	}
+
+	serv.events.publish(wltID, WalletReloaded)
+
+	return w.clone(), nil
+}
+
+// removeFirstAddr removes the first address of a wallet from firstAddrIDMap.
+// If duplicate seeds are allowed, another loaded wallet may share the same first address,
+// in which case the map entry is pointed to that wallet instead.
+func (serv *Service) removeFirstAddr(addr, wltID string) {
+	if id, ok := serv.firstAddrIDMap[addr]; !ok || id != wltID {
+		return
+	}
+
+	delete(serv.firstAddrIDMap, addr)
+
+	if !serv.config.AllowDuplicateSeeds {
+		return
+	}
+
+	for id, w := range serv.wallets {
+		if len(w.Entries) > 0 && w.Entries[0].Address.String() == addr {
+			serv.firstAddrIDMap[addr] = id
+			return
+		}
+	}
+
+	for id, lw := range serv.lazyWallets {
+		if lw.firstAddr == addr {
+			serv.firstAddrIDMap[addr] = id
+			return
+		}
+	}
+}
+
+// indexWalletAddresses adds the addresses of a wallet to addrIDsMap
+func (serv *Service) indexWalletAddresses(w *Wallet) {
+	addrs := make([]string, len(w.Entries))
+	for i, e := range w.Entries {
+		addrs[i] = e.Address.String()
+	}
+	serv.indexAddresses(w.Filename(), addrs)
+}
+
+// indexAddresses adds the addresses of a wallet to addrIDsMap, keeping the wallet ids of each address sorted
+func (serv *Service) indexAddresses(wltID string, addrs []string) {
+	set, ok := serv.walletAddrs[wltID]
+	if !ok {
+		set = make(map[string]struct{}, len(addrs))
+		serv.walletAddrs[wltID] = set
+	}
+
+	for _, addr := range addrs {
+		set[addr] = struct{}{}
+
+		ids := serv.addrIDsMap[addr]
+		i := sort.SearchStrings(ids, wltID)
+		if i < len(ids) && ids[i] == wltID {
+			continue
+		}
+
+		ids = append(ids, "")
+		copy(ids[i+1:], ids[i:])
+		ids[i] = wltID
+		serv.addrIDsMap[addr] = ids
+	}
+}
+
+// unindexAddresses removes all addresses of a wallet from addrIDsMap
+func (serv *Service) unindexAddresses(wltID string) {
+	for addr := range serv.walletAddrs[wltID] {
+		ids := serv.addrIDsMap[addr]
+		i := sort.SearchStrings(ids, wltID)
+		if i == len(ids) || ids[i] != wltID {
+			continue
+		}
+
+		if len(ids) == 1 {
+			delete(serv.addrIDsMap, addr)
+			continue
+		}
+
+		serv.addrIDsMap[addr] = append(ids[:i:i], ids[i+1:]...)
 	}
 
-	// Sets the decrypted wallet in memory
-	serv.wallets.set(unlockWlt)
-	return unlockWlt, nil
+	delete(serv.walletAddrs, wltID)
 }
 
-// NewAddresses generate address entries in given wallet,
-// return nil if wallet does not exist.
-// Set password as nil if the wallet is not encrypted, otherwise the password must be provided.
-func (serv *Service) NewAddresses(wltID string, password []byte, num uint64) ([]cipher.Address, error) {
-	serv.Lock()
-	defer serv.Unlock()
+// saveWallet saves the wallet to the wallet directory and records the hash of the written file
+func (serv *Service) saveWallet(w *Wallet) error {
+	w.assignStableID()
 
-	if !serv.config.EnableWalletAPI {
-		return nil, ErrWalletAPIDisabled
+	if serv.config.SaveDebounce <= 0 {
+		return serv.writeWallet(w)
 	}
 
-	w, err := serv.getWallet(wltID)
-	if err != nil {
-		return nil, err
+	// New wallet files are written immediately
+	if _, ok := serv.fileHashes[w.Filename()]; !ok {
+		return serv.writeWallet(w)
 	}
 
-	var addrs []cipher.Address
-	f := func(wlt *Wallet) error {
-		var err error
-		addrs, err = wlt.GenerateSkycoinAddresses(num)
-		return err
+	// The wallet keeps the time of the change, not of the delayed write
+	w.setModified(time.Now().Unix())
+
+	wltID := w.Filename()
+	if p, ok := serv.pendingSaves[wltID]; ok {
+		p.w = w
+		return nil
 	}
 
-	if w.IsEncrypted() {
-		if err := w.GuardUpdate(password, f); err != nil {
-			return nil, err
+	p := &pendingSave{w: w}
+	p.timer = time.AfterFunc(serv.config.SaveDebounce, func() {
+		serv.Lock()
+		defer serv.Unlock()
+		if serv.pendingSaves[wltID] != p {
+			return
 		}
-	} else {
-		if len(password) != 0 {
-			return nil, ErrWalletNotEncrypted
+		if err := serv.writeWallet(p.w); err != nil {
+			logger.WithError(err).Errorf("Failed to save wallet %s", wltID)
 		}
+	})
+	serv.pendingSaves[wltID] = p
 
-		if err := f(w); err != nil {
-			return nil, err
-		}
+	return nil
+}
+
+// writeWallet writes the wallet to disk, replacing any pending save of it.
+// The pending save is kept if the write fails, so that Flush can retry it.
+func (serv *Service) writeWallet(w *Wallet) error {
+	// A flushed pending save keeps the time recorded by saveWallet
+	if p, ok := serv.pendingSaves[w.Filename()]; !ok || p.w != w {
+		w.setModified(time.Now().Unix())
 	}
 
-	// Save the wallet first
 	if err := w.Save(serv.config.WalletDir); err != nil {
-		return nil, err
+		return err
 	}
 
-	serv.wallets.set(w)
+	var events []WalletEventKind
+	if p, ok := serv.pendingSaves[w.Filename()]; ok {
+		events = p.events
+	}
+	serv.dropPendingSave(w.Filename())
 
-	return addrs, nil
+	for _, kind := range events {
+		serv.events.publish(w.Filename(), kind)
+	}
+
+	return serv.recordFileHash(w.Filename())
 }
 
-// GetSkycoinAddresses returns all addresses in given wallet
-func (serv *Service) GetSkycoinAddresses(wltID string) ([]cipher.Address, error) {
-	serv.RLock()
-	defer serv.RUnlock()
-	if !serv.config.EnableWalletAPI {
-		return nil, ErrWalletAPIDisabled
+// publishSaved publishes an event of a change saved with saveWallet. If the save is debounced,
+// the event is published once the wallet is written, and dropped if the pending save is discarded.
+func (serv *Service) publishSaved(wltID string, kind WalletEventKind) {
+	if p, ok := serv.pendingSaves[wltID]; ok {
+		p.events = append(p.events, kind)
+		return
 	}
 
-	w, err := serv.getWallet(wltID)
-	if err != nil {
-		return nil, err
+	serv.events.publish(wltID, kind)
+}
+
+// dropPendingSave discards the pending save of a wallet, if any
+func (serv *Service) dropPendingSave(wltID string) {
+	if p, ok := serv.pendingSaves[wltID]; ok {
+		p.timer.Stop()
+		delete(serv.pendingSaves, wltID)
 	}
+}
 
-	return w.GetSkycoinAddresses()
+// flushWallet writes the pending save of a wallet, if any
+func (serv *Service) flushWallet(wltID string) error {
+	p, ok := serv.pendingSaves[wltID]
+	if !ok {
+		return nil
+	}
+
+	return serv.writeWallet(p.w)
 }
 
-// GetWallet returns wallet by id
-func (serv *Service) GetWallet(wltID string) (*Wallet, error) {
-	serv.RLock()
-	defer serv.RUnlock()
-	if !serv.config.EnableWalletAPI {
-		return nil, ErrWalletAPIDisabled
+// Flush writes the changed wallets whose saves are delayed by Config.SaveDebounce
+func (serv *Service) Flush() error {
+	serv.Lock()
+	defer serv.Unlock()
+	return serv.flush()
+}
+
+func (serv *Service) flush() error {
+	var firstErr error
+	for wltID := range serv.pendingSaves {
+		if err := serv.flushWallet(wltID); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// recordFileHash records the hash of the wallet file, see WalletFileChanged
+func (serv *Service) recordFileHash(wltID string) error {
+	h, err := serv.walletFileHash(wltID)
+	if err != nil {
+		return err
 	}
 
-	return serv.getWallet(wltID)
+	serv.fileHashes[wltID] = h
+	return nil
 }
 
-// returns the clone of the wallet of given id
-func (serv *Service) getWallet(wltID string) (*Wallet, error) {
-	w := serv.wallets.get(wltID)
-	if w == nil {
-		return nil, ErrWalletNotExist
+// walletFileHash returns the hash of the wallet file on disk
+func (serv *Service) walletFileHash(wltID string) (cipher.SHA256, error) {
+	b, err := ioutil.ReadFile(filepath.Join(serv.config.WalletDir, wltID))
+	if err != nil {
+		return cipher.SHA256{}, err
 	}
-	return w.clone(), nil
+
+	return cipher.SumSHA256(b), nil
 }
 
-// GetWallets returns all wallet clones
-func (serv *Service) GetWallets() (Wallets, error) {
+// DiffAgainstDisk returns true if the wallet in memory differs from its wallet file,
+// e.g. because a save is pending, see Config.SaveDebounce, or failed, or because the file was changed
+// or deleted by another program. Unlike WalletFileChanged, the contents of the file are compared.
+func (serv *Service) DiffAgainstDisk(wltID string) (bool, error) {
 	serv.RLock()
-	defer serv.RUnlock()
+	defer serv.rUnlock()
 	if !serv.config.EnableWalletAPI {
-		return nil, ErrWalletAPIDisabled
+		return false, ErrWalletAPIDisabled
 	}
 
-	wlts := make(Wallets, len(serv.wallets))
-	for k, w := range serv.wallets {
-		wlts[k] = w.clone()
+	w, err := serv.loadedWallet(wltID)
+	if err != nil {
+		return false, err
 	}
-	return wlts, nil
+
+	path := filepath.Join(serv.config.WalletDir, w.Filename())
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return true, nil
+	}
+
+	rw, err := LoadReadableWallet(path)
+	if err != nil {
+		return false, err
+	}
+	if rw.Meta == nil {
+		return true, nil
+	}
+	rw.Meta[metaFilename] = w.Filename()
+
+	return !reflect.DeepEqual(NewReadableWallet(w), rw), nil
 }
 
-// UpdateWalletLabel updates the wallet label
-func (serv *Service) UpdateWalletLabel(wltID, label string) error {
-	serv.Lock()
-	defer serv.Unlock()
+// WalletFileChanged returns true if the wallet file was modified on disk since the service last loaded or saved it.
+// Callers can use it to reload the wallet before overwriting changes made by other programs.
+func (serv *Service) WalletFileChanged(wltID string) (bool, error) {
+	serv.RLock()
+	defer serv.rUnlock()
 	if !serv.config.EnableWalletAPI {
-		return ErrWalletAPIDisabled
+		return false, ErrWalletAPIDisabled
 	}
 
-	w, err := serv.getWallet(wltID)
+	wltID, err := serv.resolveWalletID(wltID)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	w.setLabel(label)
-
-	if err := w.Save(serv.config.WalletDir); err != nil {
-		return err
+	h, err := serv.walletFileHash(wltID)
+	if err != nil {
+		return false, err
 	}
 
-	serv.wallets.set(w)
-	return nil
+	return h != serv.fileHashes[wltID], nil
 }
 
-// UnloadWallet removes wallet of given wallet id from the service
-func (serv *Service) UnloadWallet(wltID string) error {
+// RecomputeFirstAddrMap rebuilds the map of first addresses to wallet ids that is used to detect
+// wallets created from the same seed. It is meant for repair tooling, e.g. after wallet files were edited manually.
+// Wallets sharing a first address don't abort the rebuild, they are returned in conflicts, keyed by
+// the first address with the wallet ids sorted. The first address is mapped to the first of these wallet ids.
+func (serv *Service) RecomputeFirstAddrMap() (conflicts map[string][]string, err error) {
 	serv.Lock()
 	defer serv.Unlock()
 	if !serv.config.EnableWalletAPI {
-		return ErrWalletAPIDisabled
+		return nil, ErrWalletAPIDisabled
 	}
 
-	wlt := serv.wallets.get(wltID)
-	if wlt != nil && len(wlt.Entries) > 0 {
-		addr := wlt.Entries[0].Address.String()
-		delete(serv.firstAddrIDMap, addr)
+	ids := make(map[string][]string)
+	for wltID, w := range serv.wallets {
+		if len(w.Entries) == 0 {
+			continue
+		}
+		addr := w.Entries[0].Address.String()
+		ids[addr] = append(ids[addr], wltID)
 	}
 
-	serv.wallets.remove(wltID)
-	return nil
+	for wltID, lw := range serv.lazyWallets {
+		if _, ok := serv.wallets[wltID]; ok || lw.firstAddr == "" {
+			continue
+		}
+		ids[lw.firstAddr] = append(ids[lw.firstAddr], wltID)
+	}
+
+	conflicts = make(map[string][]string)
+	firstAddrIDMap := make(map[string]string, len(ids))
+	for addr, wltIDs := range ids {
+		sort.Strings(wltIDs)
+		firstAddrIDMap[addr] = wltIDs[0]
+		if len(wltIDs) > 1 {
+			conflicts[addr] = wltIDs
+		}
+	}
+
+	serv.firstAddrIDMap = firstAddrIDMap
+
+	return conflicts, nil
 }
 
 func (serv *Service) setWallets(wlts Wallets) {
 	serv.wallets = wlts
 
 	for wltID, wlt := range wlts {
+		// Empty wallets are kept so that they can be repaired, see RepairEmptyWallet
+		if len(wlt.Entries) == 0 {
+			logger.Warningf("Wallet %s has no addresses, it can't be checked for duplicate seeds", wltID)
+			continue
+		}
 		addr := wlt.Entries[0].Address.String()
 		serv.firstAddrIDMap[addr] = wltID
+		serv.indexWalletAddresses(wlt)
 	}
 }
 
@@ -367,7 +4609,7 @@ func (serv *Service) setWallets(wlts Wallets) {
 // Returns ErrWalletNotEncrypted if it's not encrypted
 func (serv *Service) GetWalletSeed(wltID string, password []byte) (string, error) {
 	serv.RLock()
-	defer serv.RUnlock()
+	defer serv.rUnlock()
 	if !serv.config.EnableWalletAPI {
 		return "", ErrWalletAPIDisabled
 	}
@@ -381,6 +4623,14 @@ func (serv *Service) GetWalletSeed(wltID string, password []byte) (string, error
 		return "", err
 	}
 
+	if w.IsWatchOnly() {
+		return "", ErrWalletIsWatchOnly
+	}
+
+	if w.IsCollection() {
+		return "", ErrWalletNotDeterministic
+	}
+
 	if !w.IsEncrypted() {
 		return "", ErrWalletNotEncrypted
 	}
@@ -396,6 +4646,52 @@ func (serv *Service) GetWalletSeed(wltID string, password []byte) (string, error
 	return seed, nil
 }
 
+// SignMultipleMessages signs a batch of messages with the addresses of a wallet, unlocking the wallet once.
+// The signatures are returned in the same order as the items, see Wallet.SignMessages.
+// The password is required if the wallet is encrypted.
+func (serv *Service) SignMultipleMessages(wltID string, password []byte, items []MessageToSign) ([]cipher.Sig, error) {
+	var sigs []cipher.Sig
+	if err := serv.ViewSecrets(wltID, password, func(w *Wallet) error {
+		var err error
+		sigs, err = w.SignMessages(items)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return sigs, nil
+}
+
+// GetAuxKey derives the auxiliary key of given index from the seed of a wallet, see Options.AuxDerivation.
+// The password is required if the wallet is encrypted.
+func (serv *Service) GetAuxKey(wltID string, password []byte, index uint64) (cipher.SecKey, error) {
+	var sk cipher.SecKey
+	if err := serv.ViewSecrets(wltID, password, func(w *Wallet) error {
+		var err error
+		sk, err = w.AuxKey(index)
+		return err
+	}); err != nil {
+		return cipher.SecKey{}, err
+	}
+
+	return sk, nil
+}
+
+// MasterPublicKey returns the public key of the BIP32 master key of a BIP44 wallet, see Wallet.MasterPublicKey.
+// It is distinct from the public keys of the wallet's addresses. The password is required if the wallet is encrypted.
+func (serv *Service) MasterPublicKey(wltID string, password []byte) (cipher.PubKey, error) {
+	var pk cipher.PubKey
+	if err := serv.ViewSecrets(wltID, password, func(w *Wallet) error {
+		var err error
+		pk, err = w.MasterPublicKey()
+		return err
+	}); err != nil {
+		return cipher.PubKey{}, err
+	}
+
+	return pk, nil
+}
+
 // UpdateSecrets opens a wallet for modification of secret data and saves it safely
 func (serv *Service) UpdateSecrets(wltID string, password []byte, f func(*Wallet) error) error {
 	serv.Lock()
@@ -409,6 +4705,9 @@ func (serv *Service) UpdateSecrets(wltID string, password []byte, f func(*Wallet
 		return err
 	}
 
+	readOnly := w.IsReadOnly()
+	stableID := w.StableID()
+
 	if w.IsEncrypted() {
 		if err := w.GuardUpdate(password, f); err != nil {
 			return err
@@ -421,12 +4720,20 @@ func (serv *Service) UpdateSecrets(wltID string, password []byte, f func(*Wallet
 		}
 	}
 
+	if w.IsReadOnly() != readOnly {
+		return ErrReadOnlyFlagChanged
+	}
+
+	if w.StableID() != stableID {
+		return ErrStableIDChanged
+	}
+
 	// Save the wallet first
-	if err := w.Save(serv.config.WalletDir); err != nil {
+	if err := serv.saveWallet(w); err != nil {
 		return err
 	}
 
-	serv.wallets.set(w)
+	serv.setWallet(w)
 
 	return nil
 }
@@ -444,16 +4751,27 @@ func (serv *Service) Update(wltID string, f func(*Wallet) error) error {
 		return err
 	}
 
+	readOnly := w.IsReadOnly()
+	stableID := w.StableID()
+
 	if err := f(w); err != nil {
 		return err
 	}
 
+	if w.IsReadOnly() != readOnly {
+		return ErrReadOnlyFlagChanged
+	}
+
+	if w.StableID() != stableID {
+		return ErrStableIDChanged
+	}
+
 	// Save the wallet first
-	if err := w.Save(serv.config.WalletDir); err != nil {
+	if err := serv.saveWallet(w); err != nil {
 		return err
 	}
 
-	serv.wallets.set(w)
+	serv.setWallet(w)
 
 	return nil
 }
@@ -461,7 +4779,7 @@ func (serv *Service) Update(wltID string, f func(*Wallet) error) error {
 // ViewSecrets opens a wallet for reading secret data
 func (serv *Service) ViewSecrets(wltID string, password []byte, f func(*Wallet) error) error {
 	serv.RLock()
-	defer serv.RUnlock()
+	defer serv.rUnlock()
 	if !serv.config.EnableWalletAPI {
 		return ErrWalletAPIDisabled
 	}
@@ -472,7 +4790,7 @@ func (serv *Service) ViewSecrets(wltID string, password []byte, f func(*Wallet)
 	}
 
 	if w.IsEncrypted() {
-		return w.GuardView(password, f)
+		return serv.guardView(w, password, f)
 	} else if len(password) != 0 {
 		return ErrWalletNotEncrypted
 	} else {
@@ -480,10 +4798,52 @@ func (serv *Service) ViewSecrets(wltID string, password []byte, f func(*Wallet)
 	}
 }
 
+// guardView is Wallet.GuardView, using the unlock cache
+func (serv *Service) guardView(w *Wallet, password []byte, f func(*Wallet) error) error {
+	if serv.unlockCache == nil {
+		return w.GuardView(password, f)
+	}
+
+	if len(password) == 0 {
+		return ErrMissingPassword
+	}
+
+	wlt := serv.unlockCache.get(w, password)
+	if wlt == nil {
+		sb, err := w.decryptSecrets(password)
+		if err != nil {
+			return err
+		}
+		defer eraseBytes(sb)
+
+		wlt, err = w.unlockSecrets(sb)
+		if err != nil {
+			return err
+		}
+		serv.unlockCache.put(w, password, sb)
+	}
+	defer wlt.Erase()
+
+	return f(wlt)
+}
+
+// Close re-encrypts the wallets waiting to be re-encrypted, see Config.AutoReencryptAfter, writes the pending
+// saves of changed wallets, see Config.SaveDebounce, and erases the decrypted wallets cached by ViewSecrets,
+// see Config.UnlockCacheTTL
+func (serv *Service) Close() error {
+	serv.Lock()
+	defer serv.Unlock()
+	for wltID := range serv.pendingReencrypts {
+		serv.reencrypt(wltID)
+	}
+	serv.unlockCache.clear()
+	return serv.flush()
+}
+
 // View opens a wallet for reading non-secret data
 func (serv *Service) View(wltID string, f func(*Wallet) error) error {
 	serv.RLock()
-	defer serv.RUnlock()
+	defer serv.rUnlock()
 	if !serv.config.EnableWalletAPI {
 		return ErrWalletAPIDisabled
 	}
@@ -498,7 +4858,15 @@ func (serv *Service) View(wltID string, f func(*Wallet) error) error {
 
 // RecoverWallet recovers an encrypted wallet from seed.
 // The recovered wallet will be encrypted with the new password, if provided.
+// BIP39 wallets with a seed passphrase are recovered with RecoverWalletWithPassphrase.
 func (serv *Service) RecoverWallet(wltName, seed string, password []byte) (*Wallet, error) {
+	return serv.RecoverWalletWithPassphrase(wltName, seed, "", password)
+}
+
+// RecoverWalletWithPassphrase is like RecoverWallet, for BIP39 wallets created with Options.SeedPassphrase.
+// The seed of a BIP39 wallet must be a valid mnemonic, otherwise ErrInvalidMnemonic is returned.
+// If the seed or the seed passphrase is wrong, ErrWalletRecoverSeedWrong is returned.
+func (serv *Service) RecoverWalletWithPassphrase(wltName, seed, seedPassphrase string, password []byte) (*Wallet, error) {
 	serv.Lock()
 	defer serv.Unlock()
 	if !serv.config.EnableWalletAPI {
@@ -510,6 +4878,10 @@ func (serv *Service) RecoverWallet(wltName, seed string, password []byte) (*Wall
 		return nil, err
 	}
 
+	if w.IsReadOnly() {
+		return nil, ErrWalletReadOnly
+	}
+
 	if !w.IsEncrypted() {
 		return nil, ErrWalletNotEncrypted
 	}
@@ -518,42 +4890,129 @@ func (serv *Service) RecoverWallet(wltName, seed string, password []byte) (*Wall
 		return nil, ErrWalletNotDeterministic
 	}
 
-	// Generate the first address from the seed
-	var pk cipher.PubKey
-	pk, _, err = cipher.GenerateDeterministicKeyPair([]byte(seed))
-	if err != nil {
-		return nil, err
+	// The seed is verified against the first address
+	if len(w.Entries) == 0 {
+		return nil, ErrWalletEmpty
 	}
-	addr := w.addressConstructor()(pk)
 
-	// Compare to the wallet's first address
-	if addr != w.Entries[0].Address {
-		return nil, ErrWalletRecoverSeedWrong
+	// The secret keys of imported entries would be lost
+	if len(w.importedAddresses()) != 0 {
+		return nil, ErrWalletHasImportedEntries
+	}
+
+	if len(password) != 0 {
+		if err := serv.validatePassword(password); err != nil {
+			return nil, err
+		}
 	}
 
-	// Create a new wallet with the same number of addresses, encrypting if needed
+	// Create a new wallet with the same number of addresses
 	w2, err := NewWallet(wltName, Options{
-		Coin:       w.coin(),
-		Label:      w.Label(),
-		Seed:       seed,
-		Encrypt:    len(password) != 0,
-		Password:   password,
-		CryptoType: w.cryptoType(),
-		GenerateN:  uint64(len(w.Entries)),
+		Coin:           w.coin(),
+		Label:          w.Label(),
+		Seed:           seed,
+		GenerateN:      w.accountEntries(0),
+		IndexFilter:    w.indexFilter,
+		SeedDeriver:    w.seedDeriver,
+		AuxDerivation:  w.AuxDerivation(),
+		Bip39:          w.IsBip39(),
+		SeedPassphrase: seedPassphrase,
+		Bip44:          w.IsBip44(),
+		Hardened:       w.IsHardened(),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Preserve the timestamp of the old wallet
+	// Compare to the wallet's first address
+	if w2.Entries[0].Address != w.Entries[0].Address {
+		return nil, ErrWalletRecoverSeedWrong
+	}
+
+	// Preserve the accounts, timestamp, stable id, pending transactions and address flags and labels of the old wallet
+	if err := w2.regenerateAccounts(w); err != nil {
+		return nil, err
+	}
 	w2.setTimestamp(w.timestamp())
+	w2.setStableID(w.StableID())
+	w2.setPendingTransactions(w.PendingTransactions())
+	w2.copyEntryMeta(w)
+
+	// Encrypt if needed
+	if len(password) != 0 {
+		if err := w2.Lock(password, w.cryptoType()); err != nil {
+			return nil, err
+		}
+	}
 
 	// Save to disk
-	if err := w2.Save(serv.config.WalletDir); err != nil {
+	if err := serv.saveWallet(w2); err != nil {
 		return nil, err
 	}
 
-	serv.wallets.set(w2)
+	serv.setWallet(w2)
 
 	return w2.clone(), nil
 }
+
+// RepairEmptyWallet regenerates the first address of a wallet that has no addresses, and saves it.
+// Wallet files with no addresses are loaded, but their addresses are only indexed once they are repaired.
+// The password is required if the wallet is encrypted.
+func (serv *Service) RepairEmptyWallet(wltID string, password []byte) error {
+	serv.Lock()
+	defer serv.Unlock()
+	if !serv.config.EnableWalletAPI {
+		return ErrWalletAPIDisabled
+	}
+
+	w, err := serv.getWallet(wltID)
+	if err != nil {
+		return err
+	}
+
+	if len(w.Entries) != 0 {
+		return ErrWalletNotEmpty
+	}
+
+	if w.IsReadOnly() {
+		return ErrWalletReadOnly
+	}
+
+	f := func(wlt *Wallet) error {
+		wlt.reset()
+		_, err := wlt.GenerateAddresses(1)
+		return err
+	}
+
+	if w.IsEncrypted() {
+		if err := w.GuardUpdate(password, f); err != nil {
+			return err
+		}
+	} else {
+		if len(password) != 0 {
+			return ErrWalletNotEncrypted
+		}
+
+		if err := f(w); err != nil {
+			return err
+		}
+	}
+
+	// The first address may still be registered to this wallet
+	addr := w.Entries[0].Address.String()
+	if id, ok := serv.firstAddrIDMap[addr]; ok && id != w.Filename() && !serv.config.AllowDuplicateSeeds {
+		return ErrSeedUsed
+	}
+
+	if err := serv.saveWallet(w); err != nil {
+		return err
+	}
+
+	serv.setWallet(w)
+
+	if _, ok := serv.firstAddrIDMap[addr]; !ok {
+		serv.firstAddrIDMap[addr] = w.Filename()
+	}
+
+	return nil
+}
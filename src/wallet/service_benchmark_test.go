@@ -0,0 +1,93 @@
+package wallet
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func prepareBenchmarkWltDir(b *testing.B, n int) string {
+	dir := prepareWltDir()
+	wlts, err := GenerateTestWallets(n, Options{
+		Label:     "bench",
+		GenerateN: 20,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, w := range wlts {
+		if err := w.Save(dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func benchmarkNewService(b *testing.B, lazyLoad bool) {
+	dir := prepareBenchmarkWltDir(b, 100)
+	defer os.RemoveAll(dir)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewService(Config{
+			WalletDir:       dir,
+			EnableWalletAPI: true,
+			LazyLoad:        lazyLoad,
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewServiceEager(b *testing.B) {
+	benchmarkNewService(b, false)
+}
+
+func BenchmarkNewServiceLazy(b *testing.B) {
+	benchmarkNewService(b, true)
+}
+
+// benchmarkGetWallets accesses every wallet and logs the heap retained by the service afterwards
+func benchmarkGetWallets(b *testing.B, maxCached int) {
+	dir := prepareBenchmarkWltDir(b, 100)
+	defer os.RemoveAll(dir)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	s, err := NewService(Config{
+		WalletDir:        dir,
+		EnableWalletAPI:  true,
+		LazyLoad:         true,
+		MaxCachedWallets: maxCached,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for id := range s.lazyWallets {
+			if _, err := s.GetWallet(id); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.StopTimer()
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(s)
+	b.Logf("retained heap: %d bytes", int64(after.HeapAlloc)-int64(before.HeapAlloc))
+}
+
+func BenchmarkGetWalletsUncapped(b *testing.B) {
+	benchmarkGetWallets(b, 0)
+}
+
+func BenchmarkGetWalletsMaxCached10(b *testing.B) {
+	benchmarkGetWallets(b, 10)
+}
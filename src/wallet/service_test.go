@@ -1,19 +1,37 @@
 package wallet
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"image/png"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/require"
 
 	"github.com/amherag/skycoin/src/cipher"
+	"github.com/amherag/skycoin/src/cipher/bip32"
+	"github.com/amherag/skycoin/src/cipher/bip39"
+	"github.com/amherag/skycoin/src/cipher/encrypt"
+	secp256k1 "github.com/amherag/skycoin/src/cipher/secp256k1-go"
+	"github.com/amherag/skycoin/src/coin"
 	"github.com/amherag/skycoin/src/testutil"
+	"github.com/amherag/skycoin/src/util/mathutil"
 )
 
 func prepareWltDir() string {
@@ -76,12 +94,372 @@ func TestNewServiceDupWallets(t *testing.T) {
 	require.True(t, strings.HasPrefix(err.Error(), "duplicate wallet found with initial address 2M755W9o7933roLASK9PZTmqRsjQUsVen9y in file"), err.Error())
 }
 
+func TestNewServiceAllowDuplicateSeeds(t *testing.T) {
+	s, err := NewService(Config{
+		WalletDir:           "./testdata/duplicate_wallets",
+		EnableWalletAPI:     true,
+		AllowDuplicateSeeds: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(s.wallets))
+	require.Equal(t, 1, len(s.firstAddrIDMap))
+}
+
+func TestNewServiceMergeDuplicatesOnLoad(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+
+			save := func(name string, opts Options) {
+				w, err := NewWallet(name, opts)
+				require.NoError(t, err)
+				require.NoError(t, w.Save(dir))
+			}
+
+			// b.wlt has the most addresses, c.wlt has as many addresses as a.wlt but more metadata
+			save("a.wlt", Options{Seed: "seed"})
+			save("b.wlt", Options{Seed: "seed", GenerateN: 3})
+			save("c.wlt", Options{Seed: "seed", Label: "label"})
+			save("d.wlt", Options{Seed: "seed2"})
+
+			_, err := NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.Error(t, err)
+			require.True(t, strings.HasPrefix(err.Error(), "duplicate wallet found"), err.Error())
+
+			// A wallet with the same name is already quarantined
+			qdir := filepath.Join(dir, QuarantineDir)
+			require.NoError(t, os.MkdirAll(qdir, 0700))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(qdir, "a.wlt"), []byte("{}"), 0600))
+
+			s, err := NewService(Config{
+				WalletDir:             dir,
+				EnableWalletAPI:       true,
+				LazyLoad:              lazyLoad,
+				MergeDuplicatesOnLoad: true,
+			})
+			require.NoError(t, err)
+
+			wlts, err := s.GetWallets()
+			require.NoError(t, err)
+			require.Len(t, wlts, 2)
+			w, err := s.GetWallet("b.wlt")
+			require.NoError(t, err)
+			require.Len(t, w.Entries, 3)
+			_, err = s.GetWallet("d.wlt")
+			require.NoError(t, err)
+
+			for _, name := range []string{"a.wlt", "c.wlt"} {
+				_, err = s.GetWallet(name)
+				require.Equal(t, ErrWalletNotExist, err)
+				_, err = os.Stat(filepath.Join(dir, name))
+				require.True(t, os.IsNotExist(err))
+			}
+
+			_, err = Load(filepath.Join(qdir, "a.wlt.1"))
+			require.NoError(t, err)
+			w, err = Load(filepath.Join(qdir, "c.wlt"))
+			require.NoError(t, err)
+			require.Equal(t, "label", w.Label())
+
+			// The duplicates are gone, the service loads without merging
+			_, err = NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+		})
+	}
+}
+
 func TestNewServiceEmptyWallet(t *testing.T) {
-	_, err := NewService(Config{
-		WalletDir:       "./testdata/empty_wallet",
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			// Empty wallets are loaded so that they can be repaired, but not indexed
+			s, err := NewService(Config{
+				WalletDir:       "./testdata/empty_wallet",
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+			require.Empty(t, s.firstAddrIDMap)
+			require.Empty(t, s.addrIDsMap)
+
+			w, err := s.GetWallet("empty.wlt")
+			require.NoError(t, err)
+			require.Empty(t, w.Entries)
+		})
+	}
+}
+
+func TestServiceEnableAPI(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := filepath.Join(prepareWltDir(), "wallets")
+			s, err := NewService(Config{
+				WalletDir:  dir,
+				CryptoType: CryptoTypeSha256Xor,
+				LazyLoad:   lazyLoad,
+			})
+			require.NoError(t, err)
+
+			// The wallet dir is not created while the API is disabled
+			_, err = os.Stat(dir)
+			require.True(t, os.IsNotExist(err))
+			_, err = s.GetWallets()
+			require.Equal(t, ErrWalletAPIDisabled, err)
+
+			require.NoError(t, s.EnableAPI())
+			_, err = os.Stat(dir)
+			require.NoError(t, err)
+			require.Equal(t, ErrWalletAPIEnabled, s.EnableAPI())
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed: "seed",
+			}, nil)
+			require.NoError(t, err)
+
+			// The wallets on disk are loaded
+			s, err = NewService(Config{
+				WalletDir: "./testdata",
+				LazyLoad:  lazyLoad,
+			})
+			require.NoError(t, err)
+			require.NoError(t, s.EnableAPI())
+			wlts, err := s.GetWallets()
+			require.NoError(t, err)
+			require.Len(t, wlts, 6)
+			_, err = s.GetWallet("test1.wlt")
+			require.NoError(t, err)
+
+			// The API stays disabled if loading fails
+			s, err = NewService(Config{
+				WalletDir: "./testdata/duplicate_wallets",
+				LazyLoad:  lazyLoad,
+			})
+			require.NoError(t, err)
+			err = s.EnableAPI()
+			require.Error(t, err)
+			require.True(t, strings.HasPrefix(err.Error(), "duplicate wallet found with initial address"), err.Error())
+			_, err = s.GetWallets()
+			require.Equal(t, ErrWalletAPIDisabled, err)
+			require.Empty(t, s.firstAddrIDMap)
+			require.Empty(t, s.fileHashes)
+		})
+	}
+}
+
+func TestServiceMaxWallets(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+				MaxWallets:      2,
+			})
+			require.NoError(t, err)
+
+			for i := 0; i < 2; i++ {
+				_, err = s.CreateWallet(fmt.Sprintf("t%d.wlt", i), Options{
+					Seed: fmt.Sprintf("seed%d", i),
+				}, nil)
+				require.NoError(t, err)
+			}
+
+			_, err = s.CreateWallet("t2.wlt", Options{
+				Seed: "seed2",
+			}, nil)
+			require.Equal(t, ErrWalletLimitReached, err)
+			_, err = os.Stat(filepath.Join(dir, "t2.wlt"))
+			require.True(t, os.IsNotExist(err))
+
+			// Wallet files added to the wallet dir can't be loaded either
+			w, err := NewWallet("t2.wlt", Options{
+				Seed: "seed2",
+			})
+			require.NoError(t, err)
+			require.NoError(t, w.Save(dir))
+			_, err = s.ReloadWallet("t2.wlt")
+			require.Equal(t, ErrWalletLimitReached, err)
+
+			// Loaded wallets can be reloaded
+			_, err = s.ReloadWallet("t0.wlt")
+			require.NoError(t, err)
+
+			require.NoError(t, s.UnloadWallet("t0.wlt"))
+			_, err = s.ReloadWallet("t2.wlt")
+			require.NoError(t, err)
+
+			// Too many wallet files on startup
+			s, err = NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+				MaxWallets:      2,
+			})
+			require.NoError(t, err)
+			wlts, err := s.GetWallets()
+			require.NoError(t, err)
+			require.Len(t, wlts, 3)
+			_, err = s.CreateWallet("t3.wlt", Options{
+				Seed: "seed3",
+			}, nil)
+			require.Equal(t, ErrWalletLimitReached, err)
+
+			_, err = NewService(Config{
+				WalletDir:        dir,
+				CryptoType:       CryptoTypeSha256Xor,
+				EnableWalletAPI:  true,
+				LazyLoad:         lazyLoad,
+				MaxWallets:       2,
+				MaxWalletsStrict: true,
+			})
+			testutil.RequireError(t, err, fmt.Sprintf("found 3 wallets in %s, more than the maximum of 2", dir))
+		})
+	}
+}
+
+func TestNewServiceLazyLoad(t *testing.T) {
+	eager, err := NewService(Config{
+		WalletDir:       "./testdata",
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	s, err := NewService(Config{
+		WalletDir:       "./testdata",
+		EnableWalletAPI: true,
+		LazyLoad:        true,
+	})
+	require.NoError(t, err)
+
+	// Wallets are indexed but not loaded
+	require.Equal(t, 0, len(s.wallets))
+	require.Equal(t, 6, len(s.lazyWallets))
+	require.Equal(t, eager.firstAddrIDMap, s.firstAddrIDMap)
+	for _, lw := range s.lazyWallets {
+		require.Nil(t, lw.w)
+	}
+
+	// Wallets are loaded on access
+	w, err := s.GetWallet("test1.wlt")
+	require.NoError(t, err)
+	ew, err := eager.GetWallet("test1.wlt")
+	require.NoError(t, err)
+	require.Equal(t, ew, w)
+	require.NotNil(t, s.lazyWallets["test1.wlt"].w)
+
+	wlts, err := s.GetWallets()
+	require.NoError(t, err)
+	ewlts, err := eager.GetWallets()
+	require.NoError(t, err)
+	require.Equal(t, ewlts, wlts)
+
+	// Creating a wallet with the name of an indexed wallet fails
+	_, err = s.CreateWallet("test2.wlt", Options{
+		Seed: "lazy-load-seed",
+	}, nil)
+	require.Equal(t, ErrWalletNameConflict, err)
+
+	err = s.UnloadWallet("test2.wlt")
+	require.NoError(t, err)
+	_, err = s.GetWallet("test2.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+	require.Equal(t, len(eager.firstAddrIDMap)-1, len(s.firstAddrIDMap))
+
+	// Duplicate wallets are detected from the index
+	_, err = NewService(Config{
+		WalletDir:       "./testdata/duplicate_wallets",
 		EnableWalletAPI: true,
+		LazyLoad:        true,
+	})
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), "duplicate wallet found with initial address 2M755W9o7933roLASK9PZTmqRsjQUsVen9y in file"), err.Error())
+}
+
+func TestNewServiceMaxCachedWallets(t *testing.T) {
+	dir := prepareWltDir()
+	wlts, err := GenerateTestWallets(4, Options{
+		Label: "cached",
+	})
+	require.NoError(t, err)
+	for _, w := range wlts {
+		require.NoError(t, w.Save(dir))
+	}
+
+	s, err := NewService(Config{
+		WalletDir:        dir,
+		EnableWalletAPI:  true,
+		MaxCachedWallets: 2,
 	})
-	testutil.RequireError(t, err, "empty wallet file found: \"empty.wlt\"")
+	require.NoError(t, err)
+
+	// Wallets are indexed but not loaded
+	require.Equal(t, 0, len(s.wallets))
+	require.Equal(t, 4, len(s.lazyWallets))
+	require.Equal(t, 4, len(s.firstAddrIDMap))
+
+	cached := func() []string {
+		var ids []string
+		for id, lw := range s.lazyWallets {
+			if lw.w != nil {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	for _, id := range []string{"test_0000.wlt", "test_0001.wlt", "test_0002.wlt"} {
+		w, err := s.GetWallet(id)
+		require.NoError(t, err)
+		require.Equal(t, wlts[id], w)
+	}
+	require.Equal(t, []string{"test_0001.wlt", "test_0002.wlt"}, cached())
+
+	// Accessing a wallet makes it the most recently used
+	_, err = s.GetWallet("test_0001.wlt")
+	require.NoError(t, err)
+	_, err = s.GetWallet("test_0003.wlt")
+	require.NoError(t, err)
+	require.Equal(t, []string{"test_0001.wlt", "test_0003.wlt"}, cached())
+
+	// Updated wallets are reloaded from disk after eviction
+	require.NoError(t, s.UpdateWalletLabel("test_0000.wlt", "updated"))
+	require.Equal(t, []string{"test_0000.wlt", "test_0003.wlt"}, cached())
+	_, err = s.GetWallet("test_0001.wlt")
+	require.NoError(t, err)
+	_, err = s.GetWallet("test_0002.wlt")
+	require.NoError(t, err)
+	require.Equal(t, []string{"test_0001.wlt", "test_0002.wlt"}, cached())
+	w, err := s.GetWallet("test_0000.wlt")
+	require.NoError(t, err)
+	require.Equal(t, "updated", w.Label())
+
+	// Created wallets are cached too
+	_, err = s.CreateWallet("new.wlt", Options{
+		Seed: "max-cached-wallets-seed",
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"new.wlt", "test_0000.wlt"}, cached())
+	require.Equal(t, 0, len(s.wallets))
+
+	all, err := s.GetWallets()
+	require.NoError(t, err)
+	require.Len(t, all, 5)
+	require.Len(t, cached(), 2)
+
+	require.NoError(t, s.UnloadWallet("new.wlt"))
+	_, err = s.GetWallet("new.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
 }
 
 func TestServiceCreateWallet(t *testing.T) {
@@ -175,6 +553,259 @@ func TestServiceCreateWallet(t *testing.T) {
 	}
 }
 
+func TestServiceCreateWallets(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+				UniqueLabels:    true,
+			})
+			require.NoError(t, err)
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed:  "seed",
+				Label: "t",
+			}, nil)
+			require.NoError(t, err)
+
+			countFiles := func() int {
+				fs, err := filterDir(dir, "."+WalletExt)
+				require.NoError(t, err)
+				return len(fs)
+			}
+
+			// Conflicts with the existing wallets and within the batch are reported by wallet name,
+			// and no wallet is created
+			_, err = s.CreateWallets([]CreateWalletReq{
+				{Name: "a.wlt", Options: Options{Seed: "seed"}},
+				{Name: "b.wlt", Options: Options{Seed: "seed2"}},
+				{Name: "c.wlt", Options: Options{Seed: "seed2"}},
+				{Name: "t.wlt", Options: Options{Seed: "seed3"}},
+				{Name: "d.wlt", Options: Options{Seed: "seed4", Label: "t"}},
+				{Name: "e.wlt", Options: Options{Seed: "seed5", Label: "e"}},
+				{Name: "f.wlt", Options: Options{Seed: "seed6", Label: "e"}},
+				{Name: "g.wlt", Options: Options{Seed: "seed7"}},
+				{Name: "g.wlt", Options: Options{Seed: "seed8"}},
+			}, nil)
+			require.Equal(t, WalletErrors{
+				"a.wlt": ErrSeedUsed,
+				"c.wlt": ErrSeedUsed,
+				"t.wlt": ErrWalletNameConflict,
+				"d.wlt": ErrLabelInUse,
+				"f.wlt": ErrLabelInUse,
+				"g.wlt": ErrWalletNameConflict,
+			}, err)
+			require.Equal(t, 1, countFiles())
+			_, err = s.GetWallet("b.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			// A failed write removes the wallet files written before it
+			require.NoError(t, os.Mkdir(filepath.Join(dir, "b.wlt"), 0700))
+			_, err = s.CreateWallets([]CreateWalletReq{
+				{Name: "a.wlt", Options: Options{Seed: "seed2"}},
+				{Name: "b.wlt", Options: Options{Seed: "seed3"}},
+			}, nil)
+			require.IsType(t, WalletErrors{}, err)
+			require.Contains(t, err.(WalletErrors), "b.wlt")
+			require.Len(t, err.(WalletErrors), 1)
+			require.Equal(t, 1, countFiles())
+			_, err = s.GetWallet("a.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+			require.NoError(t, os.Remove(filepath.Join(dir, "b.wlt")))
+
+			wlts, err := s.CreateWallets([]CreateWalletReq{
+				{Name: "a.wlt", Options: Options{Seed: "seed2", Label: "a"}},
+				{Options: Options{Seed: "seed3", GenerateN: 2}},
+				{Name: "b.wlt", Options: Options{Seed: "seed4", Encrypt: true, Password: []byte("pwd")}},
+			}, nil)
+			require.NoError(t, err)
+			require.Len(t, wlts, 3)
+			require.Equal(t, "a.wlt", wlts[0].Filename())
+			require.Equal(t, "a", wlts[0].Label())
+			require.NotEmpty(t, wlts[1].Filename())
+			require.Len(t, wlts[1].Entries, 2)
+			require.True(t, wlts[2].IsEncrypted())
+			require.Equal(t, 4, countFiles())
+
+			for _, w := range wlts {
+				w2, err := s.GetWallet(w.Filename())
+				require.NoError(t, err)
+				require.Equal(t, w.Entries, w2.Entries)
+
+				w2, err = Load(filepath.Join(dir, w.Filename()))
+				require.NoError(t, err)
+				require.Equal(t, w.StableID(), w2.StableID())
+			}
+
+			// The wallets are checked against those created in the batch
+			_, err = s.CreateWallet("c.wlt", Options{Seed: "seed3"}, nil)
+			require.Equal(t, ErrSeedUsed, err)
+
+			s.config.MaxWallets = 5
+			_, err = s.CreateWallets([]CreateWalletReq{
+				{Name: "c.wlt", Options: Options{Seed: "seed5"}},
+				{Name: "d.wlt", Options: Options{Seed: "seed6"}},
+			}, nil)
+			require.Equal(t, ErrWalletLimitReached, err)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.CreateWallets(nil, nil)
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceRecoverAllFromSeedPhrase(t *testing.T) {
+	seed := "seed"
+	_, seckeys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte(seed), 10)
+	var addrs []cipher.Address
+	for _, s := range seckeys {
+		addrs = append(addrs, cipher.MustAddressFromSecKey(s))
+	}
+
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			_, err = s.RecoverAllFromSeedPhrase("", nil, mockBalanceGetter{})
+			require.Equal(t, ErrMissingSeed, err)
+			_, err = s.RecoverAllFromSeedPhrase(seed, nil, nil)
+			require.Equal(t, ErrNilBalanceGetter, err)
+
+			// No wallet is created if no address has coins
+			wlts, err := s.RecoverAllFromSeedPhrase(seed, []byte("pwd"), mockBalanceGetter{})
+			require.NoError(t, err)
+			require.Empty(t, wlts)
+			all, err := s.GetWallets()
+			require.NoError(t, err)
+			require.Empty(t, all)
+
+			wlts, err = s.RecoverAllFromSeedPhrase(seed, []byte("pwd"), mockBalanceGetter{
+				addrs[3]: BalancePair{Confirmed: Balance{Coins: 1e6, Hours: 100}},
+			})
+			require.NoError(t, err)
+			require.Len(t, wlts, 1)
+			w := wlts[0]
+			require.Equal(t, CoinTypeSkycoin, w.Coin())
+			require.True(t, w.IsEncrypted())
+			checkNoSensitiveData(t, w)
+			wAddrs, err := w.GetSkycoinAddresses()
+			require.NoError(t, err)
+			require.Equal(t, addrs[:4], wAddrs)
+
+			w2, err := s.GetWallet(w.Filename())
+			require.NoError(t, err)
+			require.Equal(t, w.Entries, w2.Entries)
+
+			// The seed is in use now
+			_, err = s.RecoverAllFromSeedPhrase(seed, nil, mockBalanceGetter{
+				addrs[0]: BalancePair{Predicted: Balance{Coins: 1e6}},
+			})
+			wltErrs, ok := err.(WalletErrors)
+			require.True(t, ok)
+			require.Len(t, wltErrs, 1)
+			for _, err := range wltErrs {
+				require.Equal(t, ErrSeedUsed, err)
+			}
+		})
+	}
+}
+
+func TestServiceCreateWalletUnknownCoinType(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Coin: CoinType("skycion"),
+		Seed: "seed",
+	}, nil)
+	require.Equal(t, ErrUnknownCoinType, err)
+	require.Empty(t, s.wallets)
+	dirIsEmpty(t, dir)
+}
+
+func TestServiceCreateWalletAllowDuplicateSeeds(t *testing.T) {
+	tt := []struct {
+		name                string
+		allowDuplicateSeeds bool
+		err                 error
+	}{
+		{
+			name: "duplicate seeds not allowed",
+			err:  ErrSeedUsed,
+		},
+		{
+			name:                "duplicate seeds allowed",
+			allowDuplicateSeeds: true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:           dir,
+				CryptoType:          CryptoTypeSha256Xor,
+				EnableWalletAPI:     true,
+				AllowDuplicateSeeds: tc.allowDuplicateSeeds,
+			})
+			require.NoError(t, err)
+
+			w1, err := s.CreateWallet("t1.wlt", Options{
+				Seed:  "seed",
+				Label: "view1",
+			}, nil)
+			require.NoError(t, err)
+
+			w2, err := s.CreateWallet("t2.wlt", Options{
+				Seed:  "seed",
+				Label: "view2",
+			}, nil)
+			require.Equal(t, tc.err, err)
+			if err != nil {
+				return
+			}
+
+			require.Equal(t, w1.Entries[0].Address, w2.Entries[0].Address)
+
+			// Filenames must still be unique
+			_, err = s.CreateWallet("t1.wlt", Options{
+				Seed: "seed",
+			}, nil)
+			require.Equal(t, ErrWalletNameConflict, err)
+
+			// Unloading one of the wallets keeps the first address mapped to the other
+			addr := w1.Entries[0].Address.String()
+			id := s.firstAddrIDMap[addr]
+			err = s.UnloadWallet(id)
+			require.NoError(t, err)
+			require.Contains(t, []string{"t1.wlt", "t2.wlt"}, s.firstAddrIDMap[addr])
+			require.NotEqual(t, id, s.firstAddrIDMap[addr])
+
+			err = s.UnloadWallet(s.firstAddrIDMap[addr])
+			require.NoError(t, err)
+			_, ok := s.firstAddrIDMap[addr]
+			require.False(t, ok)
+		})
+	}
+}
+
 func TestServiceLoadWallet(t *testing.T) {
 	// Prepare addresss
 	seed := "seed"
@@ -465,10 +1096,156 @@ func TestServiceNewAddress(t *testing.T) {
 	}
 }
 
-func TestServiceGetAddress(t *testing.T) {
-	for _, enableWalletAPI := range []bool{true, false} {
-		for ct := range cryptoTable {
-			t.Run(fmt.Sprintf("enable wallet api=%v crypto=%v", enableWalletAPI, ct), func(t *testing.T) {
+func TestServiceNewAddressesProgress(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypt=%v", encrypt), func(t *testing.T) {
+			var password []byte
+			if encrypt {
+				password = []byte("pwd")
+			}
+
+			newService := func() *Service {
+				s, err := NewService(Config{
+					WalletDir:       prepareWltDir(),
+					CryptoType:      CryptoTypeSha256Xor,
+					EnableWalletAPI: true,
+				})
+				require.NoError(t, err)
+
+				_, err = s.CreateWallet("t.wlt", Options{
+					Seed:     "seed",
+					Encrypt:  encrypt,
+					Password: password,
+				}, nil)
+				require.NoError(t, err)
+				return s
+			}
+
+			s := newService()
+
+			var updates []uint64
+			addrs, err := s.NewAddressesProgress("t.wlt", password, 250, func(done, total uint64) {
+				require.Equal(t, uint64(250), total)
+				updates = append(updates, done)
+
+				// The callback can use the service without deadlocking
+				_, err := s.GetWallet("t.wlt")
+				require.NoError(t, err)
+			})
+			require.NoError(t, err)
+			require.Len(t, addrs, 250)
+
+			require.NotEmpty(t, updates)
+			require.Equal(t, uint64(250), updates[len(updates)-1])
+			for i := 1; i < len(updates); i++ {
+				require.True(t, updates[i] > updates[i-1])
+			}
+
+			// The addresses are the same as those generated without progress updates
+			s2 := newService()
+			addrs2, err := s2.NewAddresses("t.wlt", password, 250)
+			require.NoError(t, err)
+			require.Equal(t, addrs2, addrs)
+
+			w, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			w2, err := s2.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, w2.Entries, w.Entries)
+			require.Equal(t, w2.lastSeed(), w.lastSeed())
+
+			_, err = s.NewAddressesProgress("foo.wlt", password, 1, func(done, total uint64) {})
+			require.Equal(t, ErrWalletNotExist, err)
+		})
+	}
+}
+
+func TestServiceNewAddressesBatched(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypt=%v", encrypt), func(t *testing.T) {
+			var password []byte
+			if encrypt {
+				password = []byte("pwd")
+			}
+
+			newService := func(batchSize uint64) *Service {
+				s, err := NewService(Config{
+					WalletDir:           prepareWltDir(),
+					CryptoType:          CryptoTypeSha256Xor,
+					EnableWalletAPI:     true,
+					AddressGenBatchSize: batchSize,
+				})
+				require.NoError(t, err)
+
+				_, err = s.CreateWallet("t.wlt", Options{
+					Seed:     "seed",
+					Encrypt:  encrypt,
+					Password: password,
+				}, nil)
+				require.NoError(t, err)
+				return s
+			}
+
+			s := newService(7)
+			addrs, err := s.NewAddresses("t.wlt", password, 30)
+			require.NoError(t, err)
+			require.Len(t, addrs, 30)
+
+			account, err := s.NewAccount("t.wlt", password, "savings")
+			require.NoError(t, err)
+			accountAddrs, err := s.NewAccountAddresses("t.wlt", account, password, 10)
+			require.NoError(t, err)
+			require.Len(t, accountAddrs, 10)
+
+			var updates []uint64
+			progressAddrs, err := s.NewAddressesProgress("t.wlt", password, 250, func(done, total uint64) {
+				require.Equal(t, uint64(250), total)
+				updates = append(updates, done)
+			})
+			require.NoError(t, err)
+			require.Len(t, progressAddrs, 250)
+			require.NotEmpty(t, updates)
+			require.Equal(t, uint64(250), updates[len(updates)-1])
+			for i := 1; i < len(updates); i++ {
+				require.True(t, updates[i] > updates[i-1])
+			}
+
+			// The addresses are the same as those generated without batching
+			s2 := newService(0)
+			addrs2, err := s2.NewAddresses("t.wlt", password, 30)
+			require.NoError(t, err)
+			require.Equal(t, addrs2, addrs)
+			account2, err := s2.NewAccount("t.wlt", password, "savings")
+			require.NoError(t, err)
+			require.Equal(t, account, account2)
+			accountAddrs2, err := s2.NewAccountAddresses("t.wlt", account2, password, 10)
+			require.NoError(t, err)
+			require.Equal(t, accountAddrs2, accountAddrs)
+			progressAddrs2, err := s2.NewAddresses("t.wlt", password, 250)
+			require.NoError(t, err)
+			require.Equal(t, progressAddrs2, progressAddrs)
+
+			w, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			w2, err := s2.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, w2.Entries, w.Entries)
+
+			// The saved wallet has all the batches
+			w, err = Load(filepath.Join(s.config.WalletDir, "t.wlt"))
+			require.NoError(t, err)
+			require.Equal(t, w2.Entries, w.Entries)
+
+			_, err = s.NewAddresses("foo.wlt", password, 30)
+			require.Equal(t, ErrWalletNotExist, err)
+		})
+	}
+}
+
+func TestServiceGetAddress(t *testing.T) {
+	for _, enableWalletAPI := range []bool{true, false} {
+		for ct := range cryptoTable {
+			t.Run(fmt.Sprintf("enable wallet api=%v crypto=%v", enableWalletAPI, ct), func(t *testing.T) {
 				dir := prepareWltDir()
 				s, err := NewService(Config{
 					WalletDir:       "./testdata",
@@ -501,6 +1278,135 @@ func TestServiceGetAddress(t *testing.T) {
 	}
 }
 
+func TestServiceCompareAddresses(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 4,
+	}, nil)
+	require.NoError(t, err)
+
+	addrs, err := w.GetSkycoinAddresses()
+	require.NoError(t, err)
+
+	other := testutil.MakeAddress()
+
+	tt := []struct {
+		name     string
+		external []cipher.Address
+		missing  []cipher.Address
+		extra    []cipher.Address
+	}{
+		{
+			name:    "empty list",
+			missing: addrs,
+		},
+		{
+			name:     "all addresses",
+			external: []cipher.Address{addrs[3], addrs[1], addrs[0], addrs[2]},
+		},
+		{
+			name:     "missing and extra",
+			external: []cipher.Address{other, addrs[2], other, addrs[0]},
+			missing:  []cipher.Address{addrs[1], addrs[3]},
+			extra:    []cipher.Address{other},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			missing, extra, err := s.CompareAddresses("t.wlt", tc.external)
+			require.NoError(t, err)
+			require.Equal(t, tc.missing, missing)
+			require.Equal(t, tc.extra, extra)
+		})
+	}
+
+	_, _, err = s.CompareAddresses("foo.wlt", nil)
+	require.Equal(t, ErrWalletNotExist, err)
+
+	s.config.EnableWalletAPI = false
+	_, _, err = s.CompareAddresses("t.wlt", nil)
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceGetWalletForAddress(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			c := Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			}
+			s, err := NewService(c)
+			require.NoError(t, err)
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed: "seed",
+			}, nil)
+			require.NoError(t, err)
+			_, err = s.CreateWallet("u.wlt", Options{
+				Seed: "seed2",
+			}, nil)
+			require.NoError(t, err)
+
+			addrs, err := s.NewAddresses("t.wlt", nil, 3)
+			require.NoError(t, err)
+			addrs2, err := s.GetSkycoinAddresses("u.wlt")
+			require.NoError(t, err)
+
+			for _, addr := range addrs {
+				id, err := s.GetWalletForAddress(addr)
+				require.NoError(t, err)
+				require.Equal(t, "t.wlt", id)
+			}
+			id, err := s.GetWalletForAddress(addrs2[0])
+			require.NoError(t, err)
+			require.Equal(t, "u.wlt", id)
+
+			_, err = s.GetWalletForAddress(testutil.MakeAddress())
+			require.Equal(t, ErrAddressNotFound, err)
+
+			// The index is rebuilt when the wallets are loaded again
+			s, err = NewService(c)
+			require.NoError(t, err)
+			id, err = s.GetWalletForAddress(addrs[2])
+			require.NoError(t, err)
+			require.Equal(t, "t.wlt", id)
+
+			_, err = s.RenameWallet("t.wlt", "v.wlt")
+			require.NoError(t, err)
+			id, err = s.GetWalletForAddress(addrs[2])
+			require.NoError(t, err)
+			require.Equal(t, "v.wlt", id)
+
+			require.NoError(t, s.UnloadWallet("v.wlt"))
+			for _, addr := range addrs {
+				_, err = s.GetWalletForAddress(addr)
+				require.Equal(t, ErrAddressNotFound, err)
+			}
+			require.NotContains(t, s.walletAddrs, "t.wlt")
+			require.NotContains(t, s.walletAddrs, "v.wlt")
+			id, err = s.GetWalletForAddress(addrs2[0])
+			require.NoError(t, err)
+			require.Equal(t, "u.wlt", id)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.GetWalletForAddress(addrs2[0])
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
 func TestServiceGetWallet(t *testing.T) {
 	for _, enableWalletAPI := range []bool{true, false} {
 		for ct := range cryptoTable {
@@ -604,6 +1510,76 @@ func TestServiceGetWallets(t *testing.T) {
 	}
 }
 
+func TestServiceListWallets(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			_, err = s.CreateWallet("a.wlt", Options{
+				Seed:      "seeda",
+				Label:     "savings",
+				GenerateN: 3,
+			}, nil)
+			require.NoError(t, err)
+			_, err = s.CreateWallet("b.wlt", Options{
+				Seed:     "seedb",
+				Label:    "old savings",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+			_, err = s.CreateWalletFromAddresses("c.wlt", []cipher.Address{testutil.MakeAddress()})
+			require.NoError(t, err)
+
+			// Index the wallets again, so that lazily loaded wallets are loaded by ListWallets
+			s, err = NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			encrypted := true
+			notEncrypted := false
+			for _, tc := range []struct {
+				name   string
+				filter WalletFilter
+				ids    []string
+			}{
+				{"all", WalletFilter{}, []string{"a.wlt", "b.wlt", "c.wlt"}},
+				{"label", WalletFilter{LabelContains: "savings"}, []string{"a.wlt", "b.wlt"}},
+				{"label no match", WalletFilter{LabelContains: "Savings"}, nil},
+				{"encrypted", WalletFilter{Encrypted: &encrypted}, []string{"b.wlt"}},
+				{"not encrypted", WalletFilter{Encrypted: &notEncrypted}, []string{"a.wlt", "c.wlt"}},
+				{"type", WalletFilter{Type: WalletTypeWatchOnly}, []string{"c.wlt"}},
+				{"min entries", WalletFilter{MinEntries: 2}, []string{"a.wlt"}},
+				{"combined", WalletFilter{LabelContains: "savings", Encrypted: &notEncrypted}, []string{"a.wlt"}},
+			} {
+				t.Run(tc.name, func(t *testing.T) {
+					wlts, err := s.ListWallets(tc.filter)
+					require.NoError(t, err)
+
+					var ids []string
+					for id, w := range wlts {
+						require.Equal(t, id, w.Filename())
+						ids = append(ids, id)
+					}
+					sort.Strings(ids)
+					require.Equal(t, tc.ids, ids)
+				})
+			}
+		})
+	}
+}
+
 func TestServiceUpdateWalletLabel(t *testing.T) {
 	tt := []struct {
 		name             string
@@ -686,90 +1662,520 @@ func TestServiceUpdateWalletLabel(t *testing.T) {
 	}
 }
 
-func TestServiceEncryptWallet(t *testing.T) {
-	tt := []struct {
-		name             string
-		wltName          string
-		opts             Options
-		encWltName       string
-		pwd              []byte
-		disableWalletAPI bool
-		err              error
-	}{
-		{
-			name:    "ok",
-			wltName: "t.wlt",
-			opts: Options{
-				Seed: "seed",
-			},
-			encWltName: "t.wlt",
-			pwd:        []byte("pwd"),
-		},
-		{
-			name:    "wallet doesn't exist",
-			wltName: "t.wlt",
-			opts: Options{
-				Seed: "seed",
-			},
-			encWltName: "t2.wlt",
-			err:        ErrWalletNotExist,
-		},
-		{
-			name:    "wallet already encrypted",
-			wltName: "t.wlt",
-			opts: Options{
-				Seed:     "seed",
-				Encrypt:  true,
-				Password: []byte("pwd"),
-			},
-			encWltName: "t.wlt",
-			pwd:        []byte("pwd"),
-			err:        ErrWalletEncrypted,
-		},
-		{
-			name:    "wallet api disabled",
-			wltName: "t.wlt",
-			opts: Options{
-				Seed:     "seed",
-				Encrypt:  true,
-				Password: []byte("pwd"),
-			},
-			encWltName:       "t.wlt",
-			pwd:              []byte("pwd"),
-			disableWalletAPI: true,
-			err:              ErrWalletAPIDisabled,
-		},
+func TestServiceUpdateWalletLabels(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		EnableWalletAPI: true,
+		UniqueLabels:    true,
+	})
+	require.NoError(t, err)
+
+	for i, id := range []string{"a.wlt", "b.wlt", "c.wlt", "d.wlt"} {
+		_, err := s.CreateWallet(id, Options{
+			Seed:  "seed-" + id,
+			Label: fmt.Sprintf("wallet-%d", i),
+		}, nil)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.SetWalletReadOnly("d.wlt", true))
+
+	updated, err := s.UpdateWalletLabels(map[string]string{
+		"a.wlt":   "prefix-wallet-0",
+		"b.wlt":   "prefix-wallet-1",
+		"c.wlt":   "prefix-wallet-0",
+		"d.wlt":   "prefix-wallet-3",
+		"foo.wlt": "prefix-foo",
+	})
+	require.Equal(t, []string{"a.wlt", "b.wlt"}, updated)
+	require.Equal(t, WalletErrors{
+		"c.wlt":   ErrLabelInUse,
+		"d.wlt":   ErrWalletReadOnly,
+		"foo.wlt": ErrWalletNotExist,
+	}, err)
+
+	// The updated labels are saved, the others are unchanged
+	s2, err := NewService(Config{
+		WalletDir:       dir,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+	for id, label := range map[string]string{
+		"a.wlt": "prefix-wallet-0",
+		"b.wlt": "prefix-wallet-1",
+		"c.wlt": "wallet-2",
+		"d.wlt": "wallet-3",
+	} {
+		w, err := s2.GetWallet(id)
+		require.NoError(t, err)
+		require.Equal(t, label, w.Label())
 	}
 
-	for _, tc := range tt {
-		for ct := range cryptoTable {
-			name := fmt.Sprintf("crypto=%v %v", ct, tc.name)
-			t.Run(name, func(t *testing.T) {
-				dir := prepareWltDir()
-				// Create the wallet service
-				s, err := NewService(Config{
-					WalletDir:       dir,
-					CryptoType:      ct,
-					EnableWalletAPI: !tc.disableWalletAPI,
-				})
-				require.NoError(t, err)
+	// Labels are updated in the order of the wallet ids
+	updated, err = s.UpdateWalletLabels(map[string]string{
+		"a.wlt": "wallet-0",
+		"c.wlt": "prefix-wallet-0",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.wlt", "c.wlt"}, updated)
 
-				if tc.disableWalletAPI {
-					_, err = s.EncryptWallet("", tc.pwd)
-					require.Equal(t, tc.err, err)
-					return
-				}
+	updated, err = s.UpdateWalletLabels(nil)
+	require.NoError(t, err)
+	require.Empty(t, updated)
 
-				// Create a new wallet
-				w, err := s.CreateWallet(tc.wltName, tc.opts, nil)
-				require.NoError(t, err)
+	s.config.EnableWalletAPI = false
+	_, err = s.UpdateWalletLabels(map[string]string{"a.wlt": "x"})
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
 
-				// Encrypt the wallet
-				encWlt, err := s.EncryptWallet(tc.encWltName, tc.pwd)
-				require.Equal(t, tc.err, err)
-				if err != nil {
-					return
-				}
+func TestServiceRenameWallet(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:  "seed",
+				Label: "label",
+			}, nil)
+			require.NoError(t, err)
+			_, err = s.CreateWallet("t2.wlt", Options{
+				Seed: "seed2",
+			}, nil)
+			require.NoError(t, err)
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "t.wlt.bak"), []byte("{}"), 0600))
+
+			for _, name := range []string{"", "t", "t.json", ".wlt", "../t3.wlt", "sub/t3.wlt"} {
+				_, err = s.RenameWallet("t.wlt", name)
+				require.Equal(t, ErrInvalidWalletFilename, err, name)
+			}
+
+			_, err = s.RenameWallet("t.wlt", "t2.wlt")
+			require.Equal(t, ErrWalletNameConflict, err)
+			_, err = s.RenameWallet("t4.wlt", "t3.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			w2, err := s.RenameWallet("t.wlt", "savings.wlt")
+			require.NoError(t, err)
+			require.Equal(t, "savings.wlt", w2.Filename())
+			require.Equal(t, "label", w2.Label())
+			require.Equal(t, w.StableID(), w2.StableID())
+			require.Equal(t, w.Entries, w2.Entries)
+
+			_, err = os.Stat(filepath.Join(dir, "savings.wlt"))
+			require.NoError(t, err)
+			for _, name := range []string{"t.wlt", "t.wlt.bak"} {
+				_, err = os.Stat(filepath.Join(dir, name))
+				require.True(t, os.IsNotExist(err))
+			}
+
+			_, err = s.GetWallet("t.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+			w2, err = s.GetWallet("savings.wlt")
+			require.NoError(t, err)
+			require.Equal(t, w.Entries, w2.Entries)
+			w2, err = s.GetWallet(w.StableID())
+			require.NoError(t, err)
+			require.Equal(t, "savings.wlt", w2.Filename())
+
+			// The first address is registered to the new id
+			_, err = s.CreateWallet("t3.wlt", Options{
+				Seed: "seed",
+			}, nil)
+			require.Equal(t, ErrSeedUsed, err)
+			require.NoError(t, s.UnloadWallet("savings.wlt"))
+			_, err = s.CreateWallet("t3.wlt", Options{
+				Seed: "seed",
+			}, nil)
+			require.NoError(t, err)
+
+			// The renamed wallet file is loaded on restart
+			_, err = NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.Error(t, err)
+			require.NoError(t, os.Remove(filepath.Join(dir, "t3.wlt")))
+			s, err = NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+			w2, err = s.GetWallet("savings.wlt")
+			require.NoError(t, err)
+			require.Equal(t, "savings.wlt", w2.Filename())
+			require.Equal(t, w.Entries, w2.Entries)
+		})
+	}
+}
+
+func TestServiceSeedPassphraseHint(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	hint, err := s.GetSeedPassphraseHint("t.wlt")
+	require.NoError(t, err)
+	require.Empty(t, hint)
+
+	err = s.SetSeedPassphraseHint("t.wlt", strings.Repeat("x", SeedPassphraseHintMaxLength+1))
+	require.Equal(t, ErrSeedPassphraseHintTooLong, err)
+
+	err = s.SetSeedPassphraseHint("foo.wlt", "hint")
+	require.Equal(t, ErrWalletNotExist, err)
+
+	// Multibyte characters are counted as one character
+	longHint := strings.Repeat("ü", SeedPassphraseHintMaxLength)
+	err = s.SetSeedPassphraseHint("t.wlt", longHint)
+	require.NoError(t, err)
+
+	// The hint is readable without decrypting the wallet and is persisted
+	hint, err = s.GetSeedPassphraseHint("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, longHint, hint)
+
+	w, err := Load(filepath.Join(dir, "t.wlt"))
+	require.NoError(t, err)
+	require.Equal(t, longHint, w.SeedPassphraseHint())
+
+	err = s.SetSeedPassphraseHint("t.wlt", "")
+	require.NoError(t, err)
+	hint, err = s.GetSeedPassphraseHint("t.wlt")
+	require.NoError(t, err)
+	require.Empty(t, hint)
+
+	err = s.SetWalletReadOnly("t.wlt", true)
+	require.NoError(t, err)
+	err = s.SetSeedPassphraseHint("t.wlt", "hint")
+	require.Equal(t, ErrWalletReadOnly, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.GetSeedPassphraseHint("t.wlt")
+	require.Equal(t, ErrWalletAPIDisabled, err)
+	err = s.SetSeedPassphraseHint("t.wlt", "hint")
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceWalletNotes(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Label:    "label",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	notes, err := s.GetWalletNotes("t.wlt")
+	require.NoError(t, err)
+	require.Empty(t, notes)
+
+	err = s.SetWalletNotes("t.wlt", strings.Repeat("x", DefaultWalletNotesMaxLength+1))
+	require.Equal(t, ErrWalletNotesTooLong, err)
+
+	err = s.SetWalletNotes("foo.wlt", "notes")
+	require.Equal(t, ErrWalletNotExist, err)
+
+	// Multibyte characters are counted as one character
+	longNotes := strings.Repeat("ü", DefaultWalletNotesMaxLength)
+	require.NoError(t, s.SetWalletNotes("t.wlt", longNotes))
+
+	notes = "Cold storage.\nMoved from the old laptop wallet."
+	require.NoError(t, s.SetWalletNotes("t.wlt", notes))
+
+	// The notes are readable without decrypting the wallet, persisted and kept apart from the label
+	n, err := s.GetWalletNotes("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, notes, n)
+
+	w, err := s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, "label", w.Label())
+	require.Equal(t, notes, w.clone().Notes())
+
+	w, err = Load(filepath.Join(dir, "t.wlt"))
+	require.NoError(t, err)
+	require.Equal(t, notes, w.Notes())
+	require.Equal(t, "label", w.Label())
+
+	require.NoError(t, s.UpdateWalletLabel("t.wlt", "new label"))
+	n, err = s.GetWalletNotes("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, notes, n)
+
+	require.NoError(t, s.SetWalletNotes("t.wlt", ""))
+	n, err = s.GetWalletNotes("t.wlt")
+	require.NoError(t, err)
+	require.Empty(t, n)
+	w, err = s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	_, ok := w.Meta[metaNotes]
+	require.False(t, ok)
+
+	// The maximum length is configurable
+	s.config.WalletNotesMaxLength = 5
+	require.Equal(t, ErrWalletNotesTooLong, s.SetWalletNotes("t.wlt", "123456"))
+	require.NoError(t, s.SetWalletNotes("t.wlt", "12345"))
+
+	require.NoError(t, s.SetWalletReadOnly("t.wlt", true))
+	err = s.SetWalletNotes("t.wlt", "notes")
+	require.Equal(t, ErrWalletReadOnly, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.GetWalletNotes("t.wlt")
+	require.Equal(t, ErrWalletAPIDisabled, err)
+	err = s.SetWalletNotes("t.wlt", "notes")
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceWalletTags(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	err = s.SetWalletTags("foo.wlt", []string{"a"})
+	require.Equal(t, ErrWalletNotExist, err)
+	err = s.SetWalletTags("t.wlt", []string{"a", ""})
+	require.Equal(t, ErrEmptyWalletTag, err)
+
+	// Duplicates are dropped, the tags are persisted
+	require.NoError(t, s.SetWalletTags("t.wlt", []string{"cold", "savings", "cold"}))
+	w, err := s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, []string{"cold", "savings"}, w.Tags())
+	w, err = Load(filepath.Join(dir, "t.wlt"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"cold", "savings"}, w.Tags())
+
+	require.NoError(t, s.SetWalletTags("t.wlt", nil))
+	w, err = s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.Empty(t, w.Tags())
+	_, ok := w.Meta[metaTags]
+	require.False(t, ok)
+
+	require.NoError(t, s.SetWalletReadOnly("t.wlt", true))
+	err = s.SetWalletTags("t.wlt", []string{"a"})
+	require.Equal(t, ErrWalletReadOnly, err)
+
+	s.config.EnableWalletAPI = false
+	err = s.SetWalletTags("t.wlt", []string{"a"})
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceSetWalletReadOnly(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:  "seed",
+		Label: "label",
+	}, nil)
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t2.wlt", Options{
+		Seed: "seed2",
+	}, nil)
+	require.NoError(t, err)
+
+	err = s.SetWalletReadOnly("foo.wlt", true)
+	require.Equal(t, ErrWalletNotExist, err)
+
+	err = s.SetWalletReadOnly("t.wlt", true)
+	require.NoError(t, err)
+
+	w, err := s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.True(t, w.IsReadOnly())
+
+	// The flag is persisted
+	w, err = Load(filepath.Join(dir, "t.wlt"))
+	require.NoError(t, err)
+	require.True(t, w.IsReadOnly())
+
+	// Modifications are rejected
+	_, err = s.NewAddresses("t.wlt", nil, 1)
+	require.Equal(t, ErrWalletReadOnly, err)
+	_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+	require.Equal(t, ErrWalletReadOnly, err)
+	err = s.UpdateWalletLabel("t.wlt", "new-label")
+	require.Equal(t, ErrWalletReadOnly, err)
+
+	// Other wallets are not affected
+	_, err = s.NewAddresses("t2.wlt", nil, 1)
+	require.NoError(t, err)
+
+	// The flag can't be cleared or set by Update
+	err = s.Update("t.wlt", func(w *Wallet) error {
+		delete(w.Meta, metaReadOnly)
+		return nil
+	})
+	require.Equal(t, ErrReadOnlyFlagChanged, err)
+	err = s.Update("t2.wlt", func(w *Wallet) error {
+		w.setReadOnly(true)
+		return nil
+	})
+	require.Equal(t, ErrReadOnlyFlagChanged, err)
+	err = s.UpdateSecrets("t.wlt", nil, func(w *Wallet) error {
+		w.setReadOnly(false)
+		return nil
+	})
+	require.Equal(t, ErrReadOnlyFlagChanged, err)
+
+	w, err = s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.True(t, w.IsReadOnly())
+	require.Equal(t, "label", w.Label())
+
+	// Clearing the flag allows modifications again
+	err = s.SetWalletReadOnly("t.wlt", false)
+	require.NoError(t, err)
+	err = s.UpdateWalletLabel("t.wlt", "new-label")
+	require.NoError(t, err)
+	_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+
+	// Encrypted read-only wallets can't be decrypted or recovered
+	err = s.SetWalletReadOnly("t.wlt", true)
+	require.NoError(t, err)
+	_, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+	require.Equal(t, ErrWalletReadOnly, err)
+	_, err = s.RecoverWallet("t.wlt", "seed", nil)
+	require.Equal(t, ErrWalletReadOnly, err)
+
+	s.config.EnableWalletAPI = false
+	err = s.SetWalletReadOnly("t.wlt", false)
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceEncryptWallet(t *testing.T) {
+	tt := []struct {
+		name             string
+		wltName          string
+		opts             Options
+		encWltName       string
+		pwd              []byte
+		disableWalletAPI bool
+		err              error
+	}{
+		{
+			name:    "ok",
+			wltName: "t.wlt",
+			opts: Options{
+				Seed: "seed",
+			},
+			encWltName: "t.wlt",
+			pwd:        []byte("pwd"),
+		},
+		{
+			name:    "wallet doesn't exist",
+			wltName: "t.wlt",
+			opts: Options{
+				Seed: "seed",
+			},
+			encWltName: "t2.wlt",
+			err:        ErrWalletNotExist,
+		},
+		{
+			name:    "wallet already encrypted",
+			wltName: "t.wlt",
+			opts: Options{
+				Seed:     "seed",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			},
+			encWltName: "t.wlt",
+			pwd:        []byte("pwd"),
+			err:        ErrWalletEncrypted,
+		},
+		{
+			name:    "wallet api disabled",
+			wltName: "t.wlt",
+			opts: Options{
+				Seed:     "seed",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			},
+			encWltName:       "t.wlt",
+			pwd:              []byte("pwd"),
+			disableWalletAPI: true,
+			err:              ErrWalletAPIDisabled,
+		},
+	}
+
+	for _, tc := range tt {
+		for ct := range cryptoTable {
+			name := fmt.Sprintf("crypto=%v %v", ct, tc.name)
+			t.Run(name, func(t *testing.T) {
+				dir := prepareWltDir()
+				// Create the wallet service
+				s, err := NewService(Config{
+					WalletDir:       dir,
+					CryptoType:      ct,
+					EnableWalletAPI: !tc.disableWalletAPI,
+				})
+				require.NoError(t, err)
+
+				if tc.disableWalletAPI {
+					_, err = s.EncryptWallet("", tc.pwd)
+					require.Equal(t, tc.err, err)
+					return
+				}
+
+				// Create a new wallet
+				w, err := s.CreateWallet(tc.wltName, tc.opts, nil)
+				require.NoError(t, err)
+
+				// Encrypt the wallet
+				encWlt, err := s.EncryptWallet(tc.encWltName, tc.pwd)
+				require.Equal(t, tc.err, err)
+				if err != nil {
+					return
+				}
 
 				encWlt1, err := s.getWallet(tc.encWltName)
 				require.NoError(t, err)
@@ -936,43 +2342,275 @@ func TestServiceDecryptWallet(t *testing.T) {
 	}
 }
 
-func TestServiceCreateWalletWithScan(t *testing.T) {
-	seed := "seed1"
-	addrs := make([]cipher.Address, 20)
-	childSeeds := make([]string, 20)
-	lastSeed := []byte(seed)
-	for i := range addrs {
-		s, pk, _, err := cipher.DeterministicKeyPairIterator(lastSeed)
-		require.NoError(t, err)
-		addrs[i] = cipher.AddressFromPubKey(pk)
-		childSeeds[i] = hex.EncodeToString(s)
-		lastSeed = s
-	}
+func TestServiceChangePassword(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+				PasswordValidator: func(password []byte) error {
+					if len(password) < 4 {
+						return errors.New("password too short")
+					}
+					return nil
+				},
+			})
+			require.NoError(t, err)
 
-	bg := make(mockBalanceGetter, 20)
-	for _, a := range addrs {
-		bg[a] = BalancePair{}
-	}
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:      "seed",
+				Encrypt:   true,
+				Password:  []byte("pwd1"),
+				GenerateN: 2,
+			}, nil)
+			require.NoError(t, err)
+			_, err = s.CreateWallet("u.wlt", Options{
+				Seed: "seed2",
+			}, nil)
+			require.NoError(t, err)
 
-	type exp struct {
-		err              error
-		seed             string
-		lastSeed         string
-		entryNum         int
-		confirmedBalance uint64
-		predictedBalance uint64
-	}
+			fn := filepath.Join(dir, "t.wlt")
+			b, err := ioutil.ReadFile(fn)
+			require.NoError(t, err)
 
-	tt := []struct {
-		name             string
-		opts             Options
-		balGetter        BalanceGetter
-		disableWalletAPI bool
-		expect           exp
-	}{
-		{
-			name: "no coins and scan 0, unencrypted",
-			opts: Options{
+			_, err = s.ChangePassword("u.wlt", []byte("pwd1"), []byte("pwd2"))
+			require.Equal(t, ErrWalletNotEncrypted, err)
+			_, err = s.ChangePassword("t.wlt", []byte("pwd1"), nil)
+			require.Equal(t, ErrMissingPassword, err)
+			_, err = s.ChangePassword("t.wlt", []byte("pwd1"), []byte("p2"))
+			require.EqualError(t, err, "password too short")
+
+			// A wrong password leaves the wallet file untouched
+			_, err = s.ChangePassword("t.wlt", []byte("wrong"), []byte("pwd2"))
+			require.Equal(t, ErrInvalidPassword, err)
+			b2, err := ioutil.ReadFile(fn)
+			require.NoError(t, err)
+			require.Equal(t, b, b2)
+
+			w2, err := s.ChangePassword("t.wlt", []byte("pwd1"), []byte("pwd2"))
+			require.NoError(t, err)
+			require.True(t, w2.IsEncrypted())
+			require.Equal(t, w.GetAddresses(), w2.GetAddresses())
+			checkNoSensitiveData(t, w2)
+
+			// Only the encrypted wallet is written
+			b2, err = ioutil.ReadFile(fn)
+			require.NoError(t, err)
+			require.NotContains(t, string(b2), `"seed": "seed"`)
+
+			err = s.ViewSecrets("t.wlt", []byte("pwd1"), func(*Wallet) error { return nil })
+			require.Equal(t, ErrInvalidPassword, err)
+			err = s.ViewSecrets("t.wlt", []byte("pwd2"), func(wlt *Wallet) error {
+				require.Equal(t, "seed", wlt.seed())
+				return nil
+			})
+			require.NoError(t, err)
+
+			w3, err := Load(fn)
+			require.NoError(t, err)
+			_, err = w3.Unlock([]byte("pwd1"))
+			require.Equal(t, ErrInvalidPassword, err)
+			w3, err = w3.Unlock([]byte("pwd2"))
+			require.NoError(t, err)
+			require.Equal(t, "seed", w3.seed())
+		})
+	}
+}
+
+func TestServiceSubscribe(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			events := make(chan WalletEvent, 16)
+			unsubscribe := s.Subscribe(func(ev WalletEvent) {
+				// Calling the service from the callback must not deadlock
+				if _, err := s.GetWallet(ev.WalletID); err != nil && err != ErrWalletNotExist {
+					panic(err)
+				}
+				events <- ev
+			})
+
+			start := time.Now()
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed: "seed",
+			}, nil)
+			require.NoError(t, err)
+			_, err = s.NewAddresses("t.wlt", nil, 2)
+			require.NoError(t, err)
+			_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			_, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			require.NoError(t, s.UpdateWalletLabel("t.wlt", "label"))
+
+			// Failed changes are not reported
+			_, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+			require.Equal(t, ErrWalletNotEncrypted, err)
+
+			_, err = s.ReloadWallet("t.wlt")
+			require.NoError(t, err)
+			require.NoError(t, s.UnloadWallet("t.wlt"))
+
+			for _, kind := range []WalletEventKind{
+				WalletCreated,
+				WalletAddressesAdded,
+				WalletEncrypted,
+				WalletDecrypted,
+				WalletLabelChanged,
+				WalletReloaded,
+				WalletUnloaded,
+			} {
+				select {
+				case ev := <-events:
+					require.Equal(t, kind, ev.Kind, "expected %s, got %s", kind, ev.Kind)
+					require.Equal(t, "t.wlt", ev.WalletID)
+					require.False(t, ev.Time.Before(start))
+				case <-time.After(time.Second):
+					t.Fatalf("timed out waiting for %s event", kind)
+				}
+			}
+
+			// Renaming reports the new and the previous id
+			_, err = s.CreateWallet("u.wlt", Options{
+				Seed: "seed2",
+			}, nil)
+			require.NoError(t, err)
+			_, err = s.RenameWallet("u.wlt", "v.wlt")
+			require.NoError(t, err)
+			for _, kind := range []WalletEventKind{WalletCreated, WalletRenamed} {
+				select {
+				case ev := <-events:
+					require.Equal(t, kind, ev.Kind, "expected %s, got %s", kind, ev.Kind)
+					if kind == WalletRenamed {
+						require.Equal(t, "v.wlt", ev.WalletID)
+						require.Equal(t, "u.wlt", ev.PreviousID)
+					} else {
+						require.Equal(t, "u.wlt", ev.WalletID)
+						require.Empty(t, ev.PreviousID)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("timed out waiting for %s event", kind)
+				}
+			}
+
+			unsubscribe()
+			_, err = s.CreateWallet("w.wlt", Options{
+				Seed: "seed3",
+			}, nil)
+			require.NoError(t, err)
+
+			select {
+			case ev := <-events:
+				t.Fatalf("unexpected event after unsubscribe: %s %s", ev.WalletID, ev.Kind)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestServiceSubscribeDebounced(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+		SaveDebounce:    time.Hour,
+	})
+	require.NoError(t, err)
+
+	events := make(chan WalletEvent, 16)
+	defer s.Subscribe(func(ev WalletEvent) {
+		events <- ev
+	})()
+
+	expectEvents := func(kinds ...WalletEventKind) {
+		for _, kind := range kinds {
+			select {
+			case ev := <-events:
+				require.Equal(t, kind, ev.Kind, "expected %s, got %s", kind, ev.Kind)
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for %s event", kind)
+			}
+		}
+
+		select {
+		case ev := <-events:
+			t.Fatalf("unexpected event: %s %s", ev.WalletID, ev.Kind)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// New wallet files are written immediately
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed: "seed",
+	}, nil)
+	require.NoError(t, err)
+	expectEvents(WalletCreated)
+
+	// Debounced changes are reported when the wallet is written
+	_, err = s.NewAddresses("t.wlt", nil, 2)
+	require.NoError(t, err)
+	require.NoError(t, s.UpdateWalletLabel("t.wlt", "label"))
+	expectEvents()
+	require.NoError(t, s.Flush())
+	expectEvents(WalletAddressesAdded, WalletLabelChanged)
+
+	// Discarded changes are not reported
+	_, err = s.NewAddresses("t.wlt", nil, 2)
+	require.NoError(t, err)
+	_, err = s.ReloadWallet("t.wlt")
+	require.NoError(t, err)
+	expectEvents(WalletReloaded)
+}
+
+func TestServiceCreateWalletWithScan(t *testing.T) {
+	seed := "seed1"
+	addrs := make([]cipher.Address, 20)
+	childSeeds := make([]string, 20)
+	lastSeed := []byte(seed)
+	for i := range addrs {
+		s, pk, _, err := cipher.DeterministicKeyPairIterator(lastSeed)
+		require.NoError(t, err)
+		addrs[i] = cipher.AddressFromPubKey(pk)
+		childSeeds[i] = hex.EncodeToString(s)
+		lastSeed = s
+	}
+
+	bg := make(mockBalanceGetter, 20)
+	for _, a := range addrs {
+		bg[a] = BalancePair{}
+	}
+
+	type exp struct {
+		err              error
+		seed             string
+		lastSeed         string
+		entryNum         int
+		confirmedBalance uint64
+		predictedBalance uint64
+	}
+
+	tt := []struct {
+		name             string
+		opts             Options
+		balGetter        BalanceGetter
+		disableWalletAPI bool
+		expect           exp
+	}{
+		{
+			name: "no coins and scan 0, unencrypted",
+			opts: Options{
 				Seed: "seed1",
 			},
 			balGetter: bg,
@@ -2062,9 +3700,6156 @@ func TestServiceUpdateSecrets(t *testing.T) {
 	}
 }
 
+func TestServiceGetWalletChecksum(t *testing.T) {
+	for ct := range cryptoTable {
+		t.Run(fmt.Sprintf("crypto=%v", ct), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      ct,
+				EnableWalletAPI: true,
+			})
+			require.NoError(t, err)
+
+			_, err = s.GetWalletChecksum("t.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:     "seed",
+				Label:    "label",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+
+			sum, err := s.GetWalletChecksum(w.Filename())
+			require.NoError(t, err)
+			require.NotEmpty(t, sum)
+
+			// Checksum is stable while the wallet is unchanged
+			sum2, err := s.GetWalletChecksum(w.Filename())
+			require.NoError(t, err)
+			require.Equal(t, sum, sum2)
+
+			// Checksum changes when the wallet is modified
+			err = s.UpdateWalletLabel(w.Filename(), "label2")
+			require.NoError(t, err)
+			sum3, err := s.GetWalletChecksum(w.Filename())
+			require.NoError(t, err)
+			require.NotEqual(t, sum, sum3)
+
+			_, err = s.NewAddresses(w.Filename(), []byte("pwd"), 1)
+			require.NoError(t, err)
+			sum4, err := s.GetWalletChecksum(w.Filename())
+			require.NoError(t, err)
+			require.NotEqual(t, sum3, sum4)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.GetWalletChecksum(w.Filename())
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceDerivationPath(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed: "seed",
+	}, nil)
+	require.NoError(t, err)
+
+	p, err := s.DerivationPath(w.Filename())
+	require.NoError(t, err)
+	require.Equal(t, DerivationPathDeterministic, p)
+
+	_, err = s.DerivationPath("foo.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+
+	s.wallets[w.Filename()].Meta[metaType] = "foo"
+	_, err = s.DerivationPath(w.Filename())
+	require.Equal(t, ErrUnknownWalletType, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.DerivationPath(w.Filename())
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceGetWalletCapabilities(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed: "seed",
+	}, nil)
+	require.NoError(t, err)
+
+	c, err := s.GetWalletCapabilities("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, Capabilities{
+		CanSign:     true,
+		CanGenerate: true,
+		HasSeed:     true,
+	}, c)
+
+	_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	c, err = s.GetWalletCapabilities("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, Capabilities{
+		CanSign:     true,
+		CanGenerate: true,
+		CanRecover:  true,
+		HasSeed:     true,
+	}, c)
+
+	require.NoError(t, s.SetWalletReadOnly("t.wlt", true))
+	c, err = s.GetWalletCapabilities("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, Capabilities{
+		CanSign: true,
+		HasSeed: true,
+	}, c)
+
+	_, err = s.GetWalletCapabilities("foo.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+
+	s.wallets["t.wlt"].Meta[metaType] = "foo"
+	_, err = s.GetWalletCapabilities("t.wlt")
+	require.Equal(t, ErrUnknownWalletType, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.GetWalletCapabilities("t.wlt")
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceGetWalletGraph(t *testing.T) {
+	s, err := NewService(Config{
+		WalletDir:       prepareWltDir(),
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 2,
+	}, nil)
+	require.NoError(t, err)
+
+	g, err := s.GetWalletGraph("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, WalletGraph{
+		Accounts: []AccountGraph{
+			{
+				Addresses: w.GetAddresses(),
+				NextIndex: 2,
+			},
+		},
+	}, g)
+
+	account, err := s.NewAccount("t.wlt", nil, "savings")
+	require.NoError(t, err)
+	accountAddrs, err := s.NewAccountAddresses("t.wlt", account, nil, 2)
+	require.NoError(t, err)
+	_, err = s.NewAccount("t.wlt", nil, "spending")
+	require.NoError(t, err)
+	mainAddrs, err := s.NewAddresses("t.wlt", nil, 1)
+	require.NoError(t, err)
+	_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+
+	w, err = s.GetWallet("t.wlt")
+	require.NoError(t, err)
+
+	g, err = s.GetWalletGraph("t.wlt")
+	require.NoError(t, err)
+	require.Len(t, g.Accounts, 3)
+
+	require.Equal(t, uint32(0), g.Accounts[0].Account)
+	require.Empty(t, g.Accounts[0].Label)
+	require.Equal(t, uint64(3), g.Accounts[0].NextIndex)
+	require.Equal(t, []cipher.Addresser{w.Entries[0].Address, w.Entries[1].Address, mainAddrs[0]}, g.Accounts[0].Addresses)
+
+	require.Equal(t, uint32(1), g.Accounts[1].Account)
+	require.Equal(t, "savings", g.Accounts[1].Label)
+	require.Equal(t, uint64(3), g.Accounts[1].NextIndex)
+	require.Len(t, g.Accounts[1].Addresses, 3)
+	require.Equal(t, accountAddrs[0], g.Accounts[1].Addresses[1])
+	require.Equal(t, accountAddrs[1], g.Accounts[1].Addresses[2])
+
+	require.Equal(t, uint32(2), g.Accounts[2].Account)
+	require.Equal(t, "spending", g.Accounts[2].Label)
+	require.Equal(t, uint64(1), g.Accounts[2].NextIndex)
+	require.Len(t, g.Accounts[2].Addresses, 1)
+
+	_, err = s.GetWalletGraph("foo.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.GetWalletGraph("t.wlt")
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceGetRecoveryChecklist(t *testing.T) {
+	s, err := NewService(Config{
+		WalletDir:       prepareWltDir(),
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 3,
+	}, nil)
+	require.NoError(t, err)
+
+	rc, err := s.GetRecoveryChecklist("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, RecoveryChecklist{
+		Deterministic: true,
+		AddressCount:  3,
+		Coin:          CoinTypeSkycoin,
+	}, rc)
+
+	account, err := s.NewAccount("t.wlt", nil, "savings")
+	require.NoError(t, err)
+	_, err = s.NewAccountAddresses("t.wlt", account, nil, 1)
+	require.NoError(t, err)
+	_, err = s.NewAccount("t.wlt", nil, "spending")
+	require.NoError(t, err)
+	require.NoError(t, s.SetSeedPassphraseHint("t.wlt", "hint"))
+	_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+
+	rc, err = s.GetRecoveryChecklist("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, RecoveryChecklist{
+		Deterministic:      true,
+		CanRecover:         true,
+		SeedPassphraseHint: "hint",
+		AddressCount:       3,
+		AccountAddresses:   []uint64{2, 1},
+		Coin:               CoinTypeSkycoin,
+	}, rc)
+
+	// The checklist matches what RecoverWallet regenerates
+	w, err := s.RecoverWallet("t.wlt", "seed", []byte("pwd"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), w.accountEntries(0))
+	require.Equal(t, uint64(2), w.accountEntries(1))
+	require.Equal(t, uint64(1), w.accountEntries(2))
+
+	require.NoError(t, s.SetWalletReadOnly("t.wlt", true))
+	rc, err = s.GetRecoveryChecklist("t.wlt")
+	require.NoError(t, err)
+	require.False(t, rc.CanRecover)
+	require.True(t, rc.Deterministic)
+
+	_, err = s.GetRecoveryChecklist("foo.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+
+	s.wallets["t.wlt"].Meta[metaType] = "foo"
+	_, err = s.GetRecoveryChecklist("t.wlt")
+	require.Equal(t, ErrUnknownWalletType, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.GetRecoveryChecklist("t.wlt")
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceBip39Wallet(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				EnableSeedAPI:   true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+			badMnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed:  badMnemonic,
+				Bip39: true,
+			}, nil)
+			require.Equal(t, ErrInvalidMnemonic, err)
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed:           mnemonic,
+				SeedPassphrase: "passphrase",
+			}, nil)
+			require.Equal(t, ErrSeedPassphraseNotBip39, err)
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:           mnemonic,
+				Bip39:          true,
+				SeedPassphrase: "passphrase",
+				Encrypt:        true,
+				Password:       []byte("pwd"),
+				GenerateN:      2,
+			}, nil)
+			require.NoError(t, err)
+			require.True(t, w.IsBip39())
+			checkNoSensitiveData(t, w)
+
+			// The address chain starts from the BIP39 seed of the mnemonic and passphrase
+			seed, err := bip39.NewSeed(mnemonic, "passphrase")
+			require.NoError(t, err)
+			_, seckeys := cipher.MustGenerateDeterministicKeyPairsSeed(seed, 1)
+			require.Equal(t, cipher.MustAddressFromSecKey(seckeys[0]), w.Entries[0].SkycoinAddress())
+
+			// A plain wallet of the same mnemonic has other addresses
+			w2, err := s.CreateWallet("t2.wlt", Options{
+				Seed: mnemonic,
+			}, nil)
+			require.NoError(t, err)
+			require.NotEqual(t, w.Entries[0].Address, w2.Entries[0].Address)
+
+			// The passphrase is kept in the encrypted secrets, and new addresses continue the chain
+			s, err = NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				EnableSeedAPI:   true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+			_, err = s.NewAddresses("t.wlt", []byte("pwd"), 1)
+			require.NoError(t, err)
+			w2, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			require.Equal(t, mnemonic, w2.seed())
+			require.Equal(t, "passphrase", w2.seedPassphrase())
+			_, seckeys = cipher.MustGenerateDeterministicKeyPairsSeed(seed, 3)
+			require.Len(t, w2.Entries, 3)
+			for i, sk := range seckeys {
+				require.Equal(t, cipher.MustAddressFromSecKey(sk), w2.Entries[i].SkycoinAddress())
+			}
+			_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+
+			// Recovery validates the mnemonic and needs the same passphrase
+			_, err = s.RecoverWalletWithPassphrase("t.wlt", badMnemonic, "passphrase", nil)
+			require.Equal(t, ErrInvalidMnemonic, err)
+			_, err = s.RecoverWallet("t.wlt", mnemonic, nil)
+			require.Equal(t, ErrWalletRecoverSeedWrong, err)
+			_, err = s.RecoverWalletWithPassphrase("t.wlt", mnemonic, "other", nil)
+			require.Equal(t, ErrWalletRecoverSeedWrong, err)
+
+			w3, err := s.RecoverWalletWithPassphrase("t.wlt", mnemonic, "passphrase", []byte("pwd2"))
+			require.NoError(t, err)
+			require.True(t, w3.IsBip39())
+			require.Equal(t, w2.GetAddresses(), w3.GetAddresses())
+			checkNoSensitiveData(t, w3)
+
+			_, err = s.ExportEncryptedSeedQR("t.wlt")
+			require.Equal(t, ErrSeedQRBip39, err)
+		})
+	}
+}
+
+func TestServiceExportEncryptedSeedQR(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("unencrypted.wlt", Options{
+		Seed: "seed1",
+	}, nil)
+	require.NoError(t, err)
+
+	_, err = s.ExportEncryptedSeedQR("unencrypted.wlt")
+	require.Equal(t, ErrWalletNotEncrypted, err)
+
+	_, err = s.ExportEncryptedSeedQR("foo.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+
+	w, err := s.CreateWallet("encrypted.wlt", Options{
+		Seed:     "seed2",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	b, err := s.ExportEncryptedSeedQR(w.Filename())
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	require.NotZero(t, img.Bounds().Dx())
+
+	// The payload contains the version, crypto type, coin type and encrypted secrets, but no plaintext seed
+	p, err := newSeedQRPayload(w)
+	require.NoError(t, err)
+	data := p.serialize()
+	require.Equal(t, SeedQRVersion, data[0])
+	require.True(t, bytes.Contains(data, []byte(CryptoTypeScryptChacha20poly1305)))
+	require.True(t, bytes.HasSuffix(data, []byte(w.secrets())))
+	require.False(t, bytes.Contains(data, []byte("seed2")))
+
+	s.config.EnableWalletAPI = false
+	_, err = s.ExportEncryptedSeedQR(w.Filename())
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceExportHWWatchOnly(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+	_, err = s.CreateWallet("deterministic.wlt", Options{
+		Seed: "seed",
+	}, nil)
+	require.NoError(t, err)
+	_, err = s.ExportHWWatchOnly("deterministic.wlt")
+	require.Equal(t, ErrWalletNotBip44, err)
+
+	_, err = s.ExportHWWatchOnly("foo.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+
+	// The wallet does not need to be decrypted
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:      mnemonic,
+		Bip39:     true,
+		Bip44:     true,
+		GenerateN: 5,
+		Encrypt:   true,
+		Password:  []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	b, err := s.ExportHWWatchOnly(w.Filename())
+	require.NoError(t, err)
+
+	fixture, err := ioutil.ReadFile("./testdata/hw_watch_only.json")
+	require.NoError(t, err)
+	require.Equal(t, strings.TrimSpace(string(fixture)), string(b))
+
+	// The fixture round-trips, and the addresses derived from its xpub are the wallet's addresses
+	hw, err := ParseHWWatchOnly(fixture)
+	require.NoError(t, err)
+	b2, err := json.MarshalIndent(hw, "", "    ")
+	require.NoError(t, err)
+	require.Equal(t, b, b2)
+
+	addrs, err := hw.Addresses(len(w.Entries))
+	require.NoError(t, err)
+	wltAddrs, err := w.GetSkycoinAddresses()
+	require.NoError(t, err)
+	require.Equal(t, wltAddrs, addrs)
+
+	// The recovered wallet keeps the BIP44 derivation
+	w, err = s.RecoverWallet(w.Filename(), mnemonic, []byte("pwd"))
+	require.NoError(t, err)
+	b3, err := s.ExportHWWatchOnly(w.Filename())
+	require.NoError(t, err)
+	require.Equal(t, b, b3)
+
+	// The addresses of hardened wallets can't be derived from the xpub, and hardening survives recovery
+	w, err = s.CreateWallet("hardened.wlt", Options{
+		Seed:     mnemonic,
+		Bip39:    true,
+		Bip44:    true,
+		Hardened: true,
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+	_, err = s.ExportHWWatchOnly(w.Filename())
+	require.Equal(t, ErrHardenedDerivation, err)
+
+	w2, err := s.RecoverWallet(w.Filename(), mnemonic, []byte("pwd"))
+	require.NoError(t, err)
+	require.True(t, w2.IsHardened())
+	require.Equal(t, w.Entries[0].Address, w2.Entries[0].Address)
+	_, err = s.ExportHWWatchOnly(w.Filename())
+	require.Equal(t, ErrHardenedDerivation, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.ExportHWWatchOnly(w.Filename())
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceImportWatchOnlyFromXPubFile(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	// The fixture is the export of this wallet, see TestServiceExportHWWatchOnly
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	w, err := NewWallet("t.wlt", Options{
+		Seed:      mnemonic,
+		Bip39:     true,
+		Bip44:     true,
+		GenerateN: 5,
+	})
+	require.NoError(t, err)
+	addrs, err := w.GetSkycoinAddresses()
+	require.NoError(t, err)
+
+	fixture := "./testdata/hw_watch_only.json"
+	_, err = s.ImportWatchOnlyFromXPubFile("w.wlt", fixture, 0)
+	require.Equal(t, ErrInvalidAddressCount, err)
+
+	w2, err := s.ImportWatchOnlyFromXPubFile("w.wlt", fixture, 5)
+	require.NoError(t, err)
+	require.True(t, w2.IsWatchOnly())
+	addrs2, err := w2.GetSkycoinAddresses()
+	require.NoError(t, err)
+	require.Equal(t, addrs, addrs2)
+
+	// The wallet is saved
+	w2, err = s.GetWallet("w.wlt")
+	require.NoError(t, err)
+	require.Len(t, w2.Entries, 5)
+
+	_, err = s.ImportWatchOnlyFromXPubFile("w2.wlt", fixture, 5)
+	require.Equal(t, ErrSeedUsed, err)
+
+	seed, err := bip39.NewSeed(mnemonic, "")
+	require.NoError(t, err)
+	xprv, err := bip32.NewPrivateKeyFromPath(seed, "m/44'/8000'/0'")
+	require.NoError(t, err)
+	xpub := xprv.PublicKey().String()
+	badChecksum := xpub[:len(xpub)-1] + "1"
+	if badChecksum == xpub {
+		badChecksum = xpub[:len(xpub)-1] + "2"
+	}
+
+	writeFile := func(data string) string {
+		fn := filepath.Join(dir, "xpub.json")
+		require.NoError(t, ioutil.WriteFile(fn, []byte(data), 0600))
+		return fn
+	}
+	hwJSON := func(version int, coin, xpub, path, addressType string) string {
+		return fmt.Sprintf(`{"version":%d,"coin":%q,"xpub":%q,"derivation_path":%q,"address_type":%q}`, version, coin, xpub, path, addressType)
+	}
+
+	cases := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{
+			name: "invalid json",
+			data: "{",
+			err:  "malformed hardware wallet watch-only data: unexpected end of JSON input",
+		},
+		{
+			name: "unsupported version",
+			data: hwJSON(2, "skycoin", xpub, "m/44'/8000'/0'", "p2pkh"),
+			err:  "malformed hardware wallet watch-only data: unsupported version 2",
+		},
+		{
+			name: "unsupported coin",
+			data: hwJSON(1, "bitcoin", xpub, "m/44'/8000'/0'", "p2pkh"),
+			err:  `malformed hardware wallet watch-only data: unsupported coin "bitcoin"`,
+		},
+		{
+			name: "unsupported address type",
+			data: hwJSON(1, "skycoin", xpub, "m/44'/8000'/0'", "p2sh"),
+			err:  `malformed hardware wallet watch-only data: unsupported address type "p2sh"`,
+		},
+		{
+			name: "not an account path",
+			data: hwJSON(1, "skycoin", xpub, "m/44'/0'/0'", "p2pkh"),
+			err:  `malformed hardware wallet watch-only data: derivation path "m/44'/0'/0'" is not a skycoin bip44 account path m/44'/8000'/<account>'`,
+		},
+		{
+			name: "xpub missing",
+			data: hwJSON(1, "skycoin", "", "m/44'/8000'/0'", "p2pkh"),
+			err:  "malformed hardware wallet watch-only data: invalid xpub: xpub missing",
+		},
+		{
+			name: "xpub checksum",
+			data: hwJSON(1, "skycoin", badChecksum, "m/44'/8000'/0'", "p2pkh"),
+			err:  "malformed hardware wallet watch-only data: invalid xpub: Checksum doesn't match",
+		},
+		{
+			name: "xprv",
+			data: hwJSON(1, "skycoin", xprv.String(), "m/44'/8000'/0'", "p2pkh"),
+			err:  "malformed hardware wallet watch-only data: invalid xpub: Invalid public key version",
+		},
+		{
+			name: "xpub of another path",
+			data: hwJSON(1, "skycoin", xpub, "m/44'/8000'/1'", "p2pkh"),
+			err:  "malformed hardware wallet watch-only data: xpub is not the key of derivation path m/44'/8000'/1'",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.ImportWatchOnlyFromXPubFile("x.wlt", writeFile(tc.data), 5)
+			testutil.RequireError(t, err, tc.err)
+		})
+	}
+
+	_, err = s.ImportWatchOnlyFromXPubFile("x.wlt", filepath.Join(dir, "missing.json"), 5)
+	require.True(t, os.IsNotExist(err))
+
+	s.config.EnableWalletAPI = false
+	_, err = s.ImportWatchOnlyFromXPubFile("x.wlt", fixture, 5)
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceImportEncryptedSeedQR(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:      "seed",
+		Encrypt:   true,
+		Password:  []byte("pwd"),
+		GenerateN: 3,
+	}, nil)
+	require.NoError(t, err)
+
+	p, err := newSeedQRPayload(w)
+	require.NoError(t, err)
+	blob := p.serialize()
+
+	// The seed is already used by the exported wallet
+	_, err = s.ImportEncryptedSeedQR("t2.wlt", blob, []byte("pwd"), mockBalanceGetter{})
+	require.Equal(t, ErrSeedUsed, err)
+
+	err = s.UnloadWallet(w.Filename())
+	require.NoError(t, err)
+
+	tt := []struct {
+		name     string
+		blob     []byte
+		password []byte
+		err      error
+	}{
+		{
+			name:     "empty",
+			password: []byte("pwd"),
+			err:      ErrMalformedSeedQR,
+		},
+		{
+			name:     "wrong version",
+			blob:     append([]byte{SeedQRVersion + 1}, blob[1:]...),
+			password: []byte("pwd"),
+			err:      ErrUnsupportedSeedQRVersion,
+		},
+		{
+			name:     "truncated",
+			blob:     blob[:5],
+			password: []byte("pwd"),
+			err:      ErrMalformedSeedQR,
+		},
+		{
+			name:     "unknown crypto type",
+			blob:     append([]byte{SeedQRVersion, 3, 'f', 'o', 'o'}, blob[2+len(CryptoTypeScryptChacha20poly1305):]...),
+			password: []byte("pwd"),
+			err:      ErrMalformedSeedQR,
+		},
+		{
+			name:     "wrong password",
+			blob:     blob,
+			password: []byte("wrong"),
+			err:      ErrInvalidPassword,
+		},
+		{
+			name: "missing password",
+			blob: blob,
+			err:  ErrMissingPassword,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.ImportEncryptedSeedQR("t2.wlt", tc.blob, tc.password, mockBalanceGetter{})
+			require.Equal(t, tc.err, err)
+		})
+	}
+
+	for _, name := range []string{"../t2.wlt", "t2", ".wlt"} {
+		_, err = s.ImportEncryptedSeedQR(name, blob, []byte("pwd"), mockBalanceGetter{})
+		require.Equal(t, ErrInvalidWalletFilename, err, name)
+	}
+
+	w2, err := s.ImportEncryptedSeedQR("t2.wlt", blob, []byte("pwd"), mockBalanceGetter{})
+	require.NoError(t, err)
+	require.True(t, w2.IsEncrypted())
+	require.Equal(t, w.Entries, w2.Entries)
+	checkNoSensitiveData(t, w2)
+
+	s.config.EnableSeedAPI = true
+	seed, err := s.GetWalletSeed("t2.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	require.Equal(t, "seed", seed)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.ImportEncryptedSeedQR("t3.wlt", blob, []byte("pwd"), mockBalanceGetter{})
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceExportImportWallet(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed:      "seed",
+				Label:     "label",
+				GenerateN: 3,
+			}, nil)
+			require.NoError(t, err)
+			_, err = s.CreateWallet("e.wlt", Options{
+				Seed:     "seed2",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+
+			// Unencrypted exports reveal the seed
+			_, err = s.ExportWallet("t.wlt", nil)
+			require.Equal(t, ErrSeedAPIDisabled, err)
+
+			// Encrypted on the fly, without changing the wallet
+			data, err := s.ExportWallet("t.wlt", []byte("pwd2"))
+			require.NoError(t, err)
+			require.NotContains(t, string(data), "seed\": \"seed")
+			w, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.False(t, w.IsEncrypted())
+
+			// The export is in the format of the wallet files
+			var rw ReadableWallet
+			require.NoError(t, json.Unmarshal(data, &rw))
+			w2, err := rw.ToWallet()
+			require.NoError(t, err)
+			require.True(t, w2.IsEncrypted())
+			w2, err = w2.Unlock([]byte("pwd2"))
+			require.NoError(t, err)
+			require.Equal(t, w.Entries, w2.Entries)
+
+			encData, err := s.ExportWallet("e.wlt", nil)
+			require.NoError(t, err)
+			b, err := ioutil.ReadFile(filepath.Join(dir, "e.wlt"))
+			require.NoError(t, err)
+			require.Equal(t, b, encData)
+			_, err = s.ExportWallet("e.wlt", []byte("pwd2"))
+			require.Equal(t, ErrWalletEncrypted, err)
+
+			// Importing a wallet with a seed that is used is rejected
+			_, err = s.ImportWallet("t2.wlt", data)
+			require.Equal(t, ErrSeedUsed, err)
+
+			for _, id := range []string{"t.wlt", "e.wlt"} {
+				require.NoError(t, s.UnloadWallet(id))
+				require.NoError(t, os.Remove(filepath.Join(dir, id)))
+			}
+
+			w2, err = s.ImportWallet("t2.wlt", data)
+			require.NoError(t, err)
+			require.Equal(t, "t2.wlt", w2.Filename())
+			require.Equal(t, "label", w2.Label())
+			require.True(t, w2.IsEncrypted())
+			require.Equal(t, w.StableID(), w2.StableID())
+			require.Equal(t, w.GetAddresses(), w2.GetAddresses())
+
+			w2, err = s.GetWallet(w.StableID())
+			require.NoError(t, err)
+			require.Equal(t, "t2.wlt", w2.Filename())
+			w2, err = Load(filepath.Join(dir, "t2.wlt"))
+			require.NoError(t, err)
+			require.Equal(t, "t2.wlt", w2.Filename())
+
+			_, err = s.ImportWallet("t2.wlt", encData)
+			require.Equal(t, ErrWalletNameConflict, err)
+			w2, err = s.ImportWallet("", encData)
+			require.NoError(t, err)
+			require.NotEqual(t, "t2.wlt", w2.Filename())
+			require.True(t, w2.IsEncrypted())
+
+			// The plaintext export of the decrypted wallet
+			s.config.EnableSeedAPI = true
+			_, err = s.DecryptWallet("t2.wlt", []byte("pwd2"))
+			require.NoError(t, err)
+			data, err = s.ExportWallet("t2.wlt", nil)
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(data, &rw))
+			require.Equal(t, "seed", rw.Meta[metaSeed])
+
+			_, err = s.ImportWallet("t3.wlt", []byte("foo"))
+			require.Error(t, err)
+			for _, name := range []string{"../t3.wlt", "t3", ".wlt"} {
+				_, err = s.ImportWallet(name, data)
+				require.Equal(t, ErrInvalidWalletFilename, err, name)
+			}
+			_, err = s.ExportWallet("foo.wlt", nil)
+			require.Equal(t, ErrWalletNotExist, err)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.ExportWallet("t2.wlt", nil)
+			require.Equal(t, ErrWalletAPIDisabled, err)
+			_, err = s.ImportWallet("t3.wlt", data)
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceEncryptedArchive(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:      "seed",
+				Label:     "label",
+				GenerateN: 3,
+			}, nil)
+			require.NoError(t, err)
+			e, err := s.CreateWallet("e.wlt", Options{
+				Seed:     "seed2",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+
+			_, err = s.ExportEncryptedArchive("t.wlt", nil)
+			require.Equal(t, ErrMissingPassword, err)
+			_, err = s.ExportEncryptedArchive("foo.wlt", []byte("apwd"))
+			require.Equal(t, ErrWalletNotExist, err)
+
+			// The seed of an unencrypted wallet is encrypted by the archive
+			archive, err := s.ExportEncryptedArchive("t.wlt", []byte("apwd"))
+			require.NoError(t, err)
+			require.Equal(t, WalletArchiveVersion, archive[0])
+			require.NotContains(t, string(archive), `"seed":"seed"`)
+			require.NotContains(t, string(archive), `"label":"label"`)
+
+			encArchive, err := s.ExportEncryptedArchive("e.wlt", []byte("apwd"))
+			require.NoError(t, err)
+
+			for _, id := range []string{"t.wlt", "e.wlt"} {
+				require.NoError(t, s.UnloadWallet(id))
+				require.NoError(t, os.Remove(filepath.Join(dir, id)))
+			}
+
+			_, err = s.ImportEncryptedArchive("t2.wlt", archive, []byte("wrong"))
+			require.Equal(t, ErrInvalidPassword, err)
+			_, err = s.ImportEncryptedArchive("t2.wlt", archive, nil)
+			require.Equal(t, ErrMissingPassword, err)
+			_, err = s.ImportEncryptedArchive("t2.wlt", nil, []byte("apwd"))
+			require.Equal(t, ErrMalformedArchive, err)
+			_, err = s.ImportEncryptedArchive("t2.wlt", append([]byte{WalletArchiveVersion + 1}, archive[1:]...), []byte("apwd"))
+			require.Equal(t, ErrUnsupportedArchiveVersion, err)
+			_, err = s.ImportEncryptedArchive("t2.wlt", archive[:3], []byte("apwd"))
+			require.Equal(t, ErrMalformedArchive, err)
+			for _, name := range []string{"../t2.wlt", "t2", ".wlt"} {
+				_, err = s.ImportEncryptedArchive(name, archive, []byte("apwd"))
+				require.Equal(t, ErrInvalidWalletFilename, err, name)
+			}
+
+			w2, err := s.ImportEncryptedArchive("t2.wlt", archive, []byte("apwd"))
+			require.NoError(t, err)
+			require.Equal(t, "t2.wlt", w2.Filename())
+			require.Equal(t, "label", w2.Label())
+			require.False(t, w2.IsEncrypted())
+			require.Equal(t, w.Entries, w2.Entries)
+
+			// An encrypted wallet keeps its encryption
+			e2, err := s.ImportEncryptedArchive("", encArchive, []byte("apwd"))
+			require.NoError(t, err)
+			require.True(t, e2.IsEncrypted())
+			require.Equal(t, e.GetAddresses(), e2.GetAddresses())
+			_, err = s.DecryptWallet(e2.Filename(), []byte("pwd"))
+			require.NoError(t, err)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.ExportEncryptedArchive("t2.wlt", []byte("apwd"))
+			require.Equal(t, ErrWalletAPIDisabled, err)
+			_, err = s.ImportEncryptedArchive("t3.wlt", archive, []byte("apwd"))
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceCreateWalletFromAddresses(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				EnableSeedAPI:   true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			_, err = s.CreateWalletFromAddresses("w.wlt", nil)
+			require.Equal(t, ErrWalletEmpty, err)
+
+			addrs := []cipher.Address{testutil.MakeAddress(), testutil.MakeAddress()}
+			_, err = s.CreateWalletFromAddresses("w.wlt", []cipher.Address{addrs[0], addrs[0]})
+			require.Error(t, err)
+
+			w, err := s.CreateWalletFromAddresses("w.wlt", addrs)
+			require.NoError(t, err)
+			require.True(t, w.IsWatchOnly())
+			require.False(t, w.IsEncrypted())
+			wAddrs, err := w.GetSkycoinAddresses()
+			require.NoError(t, err)
+			require.Equal(t, addrs, wAddrs)
+			for _, e := range w.Entries {
+				require.True(t, e.Secret.Null())
+				require.True(t, e.Public.Null())
+			}
+
+			c, err := s.GetWalletCapabilities("w.wlt")
+			require.NoError(t, err)
+			require.Equal(t, Capabilities{}, c)
+
+			_, err = s.CreateWalletFromAddresses("w2.wlt", addrs[:1])
+			require.Equal(t, ErrSeedUsed, err)
+			_, err = s.CreateWalletFromAddresses("w.wlt", []cipher.Address{testutil.MakeAddress()})
+			require.Equal(t, ErrWalletNameConflict, err)
+
+			_, err = s.NewAddresses("w.wlt", nil, 1)
+			require.Equal(t, ErrWalletIsWatchOnly, err)
+			_, err = s.GetWalletSeed("w.wlt", []byte("pwd"))
+			require.Equal(t, ErrWalletIsWatchOnly, err)
+			_, err = s.EncryptWallet("w.wlt", []byte("pwd"))
+			require.Equal(t, ErrWalletIsWatchOnly, err)
+
+			// The wallet is loaded back from disk as a watch-only wallet
+			s, err = NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+			w2, err := s.GetWallet("w.wlt")
+			require.NoError(t, err)
+			require.True(t, w2.IsWatchOnly())
+			require.Equal(t, w.Entries, w2.Entries)
+			_, err = s.NewAddresses("w.wlt", nil, 1)
+			require.Equal(t, ErrWalletIsWatchOnly, err)
+		})
+	}
+}
+
+func TestServiceCreateCollectionWallet(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				EnableSeedAPI:   true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			pk, sk := cipher.GenerateKeyPair()
+			entry := Entry{
+				Address: cipher.AddressFromPubKey(pk),
+				Public:  pk,
+				Secret:  sk,
+			}
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed:       "seed",
+				FirstEntry: &entry,
+			}, nil)
+			require.Equal(t, ErrFirstEntryNotCollection, err)
+			_, err = s.CreateWallet("t.wlt", Options{
+				Type:       WalletTypeCollection,
+				Seed:       "seed",
+				FirstEntry: &entry,
+			}, nil)
+			require.Equal(t, ErrCollectionSeed, err)
+			_, err = s.CreateWallet("t.wlt", Options{
+				Type: WalletTypeCollection,
+			}, nil)
+			require.Equal(t, ErrWalletEmpty, err)
+			_, err = s.CreateWallet("t.wlt", Options{
+				Type: "foo",
+				Seed: "seed",
+			}, nil)
+			require.Equal(t, ErrUnknownWalletType, err)
+
+			// The first entry must be self-consistent
+			_, otherSk := cipher.GenerateKeyPair()
+			for _, e := range []struct {
+				entry Entry
+				err   string
+			}{
+				{Entry{Public: pk}, "invalid first entry: address missing"},
+				{Entry{Address: entry.Address}, "invalid first entry: Invalid public key"},
+				{Entry{Address: testutil.MakeAddress(), Public: pk}, "invalid first entry: address does not belong to the public key"},
+				{Entry{Address: entry.Address, Public: pk, Secret: otherSk}, "invalid first entry: invalid public key for secret key"},
+			} {
+				e := e
+				_, err = s.CreateWallet("t.wlt", Options{
+					Type:       WalletTypeCollection,
+					FirstEntry: &e.entry,
+				}, nil)
+				testutil.RequireError(t, err, e.err)
+			}
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Type:       WalletTypeCollection,
+				Label:      "collection",
+				FirstEntry: &entry,
+			}, nil)
+			require.NoError(t, err)
+			require.True(t, w.IsCollection())
+			require.Len(t, w.Entries, 1)
+			require.Equal(t, entry.Address, w.Entries[0].Address)
+			require.Equal(t, sk, w.Entries[0].Secret)
+			require.True(t, w.Entries[0].Imported)
+			require.Equal(t, "", w.seed())
+
+			c, err := s.GetWalletCapabilities("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, Capabilities{CanSign: true, CanImportKey: true}, c)
+
+			_, err = s.NewAddresses("t.wlt", nil, 1)
+			require.Equal(t, ErrWalletNotDeterministic, err)
+			_, err = s.DerivationPath("t.wlt")
+			require.Equal(t, ErrWalletNotDeterministic, err)
+			_, err = s.NewAccount("t.wlt", nil, "savings")
+			require.Equal(t, ErrWalletNotDeterministic, err)
+
+			// The first address identifies the wallet like the first address of a seed
+			_, err = s.CreateWallet("t2.wlt", Options{
+				Type:       WalletTypeCollection,
+				FirstEntry: &entry,
+			}, nil)
+			require.Equal(t, ErrSeedUsed, err)
+
+			// The secret key of the first entry is optional
+			pk2 := testutil.MakePubKey()
+			w2, err := s.CreateWallet("t2.wlt", Options{
+				Type: WalletTypeCollection,
+				FirstEntry: &Entry{
+					Address: cipher.AddressFromPubKey(pk2),
+					Public:  pk2,
+				},
+			}, nil)
+			require.NoError(t, err)
+			require.True(t, w2.Entries[0].Secret.Null())
+
+			// The wallet can be encrypted and decrypted, and is loaded back from disk
+			w, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			require.True(t, w.Entries[0].Secret.Null())
+			_, err = s.GetWalletSeed("t.wlt", []byte("pwd"))
+			require.Equal(t, ErrWalletNotDeterministic, err)
+
+			s, err = NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+			w, err = s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.True(t, w.IsCollection())
+			require.True(t, w.IsEncrypted())
+			require.True(t, w.Entries[0].Imported)
+
+			w, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			require.Equal(t, sk, w.Entries[0].Secret)
+		})
+	}
+}
+
+func TestServiceImportKeysBatch(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			events := make(chan WalletEvent, 16)
+			defer s.Subscribe(func(ev WalletEvent) {
+				events <- ev
+			})()
+			nextEvent := func() WalletEventKind {
+				select {
+				case ev := <-events:
+					return ev.Kind
+				case <-time.After(time.Second):
+					t.Fatal("timed out waiting for events")
+					return 0
+				}
+			}
+
+			_, seckeys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte("keys"), 4)
+			first := Entry{
+				Address: cipher.MustAddressFromSecKey(seckeys[0]),
+				Public:  cipher.MustPubKeyFromSecKey(seckeys[0]),
+				Secret:  seckeys[0],
+			}
+			_, err = s.CreateWallet("t.wlt", Options{
+				Type:       WalletTypeCollection,
+				FirstEntry: &first,
+				Encrypt:    true,
+				Password:   []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+			require.Equal(t, WalletCreated, nextEvent())
+
+			// Keys already in the wallet or repeated in the batch are skipped
+			keys := []cipher.SecKey{seckeys[1], seckeys[0], seckeys[2], seckeys[1]}
+			_, _, err = s.ImportKeysBatch("t.wlt", nil, keys)
+			require.Equal(t, ErrMissingPassword, err)
+			_, _, err = s.ImportKeysBatch("t.wlt", []byte("wrong"), keys)
+			require.Equal(t, ErrInvalidPassword, err)
+
+			imported, skipped, err := s.ImportKeysBatch("t.wlt", []byte("pwd"), keys)
+			require.NoError(t, err)
+			require.Equal(t, 2, imported)
+			require.Equal(t, []cipher.Address{
+				cipher.MustAddressFromSecKey(seckeys[0]),
+				cipher.MustAddressFromSecKey(seckeys[1]),
+			}, skipped)
+			require.Equal(t, WalletAddressesAdded, nextEvent())
+
+			w, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.True(t, w.IsEncrypted())
+			require.Len(t, w.Entries, 3)
+			for i, e := range w.Entries {
+				require.Equal(t, cipher.MustAddressFromSecKey(seckeys[i]), e.Address)
+				require.True(t, e.Imported)
+				require.True(t, e.Secret.Null())
+			}
+
+			// The imported addresses belong to the wallet
+			id, err := s.GetWalletForAddress(cipher.MustAddressFromSecKey(seckeys[2]))
+			require.NoError(t, err)
+			require.Equal(t, "t.wlt", id)
+
+			// The secret keys are kept in the encrypted secrets
+			w, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			for i, e := range w.Entries {
+				require.Equal(t, seckeys[i], e.Secret)
+				require.NoError(t, e.Verify())
+			}
+
+			// Nothing is saved if every key is skipped
+			imported, skipped, err = s.ImportKeysBatch("t.wlt", nil, seckeys[:3])
+			require.NoError(t, err)
+			require.Zero(t, imported)
+			require.Len(t, skipped, 3)
+
+			// The batch is rejected as a whole if a key is invalid
+			_, _, err = s.ImportKeysBatch("t.wlt", nil, []cipher.SecKey{seckeys[3], {}})
+			testutil.RequireError(t, err, "invalid secret key at index 1: Attempt to load null seckey, unsafe")
+			w, err = s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.Len(t, w.Entries, 3)
+
+			// The wallet is loaded back with the imported entries
+			s, err = NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+			w2, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, w.Entries, w2.Entries)
+
+			// Only collection wallets import keys
+			_, err = s.CreateWallet("d.wlt", Options{
+				Seed: "seed",
+			}, nil)
+			require.NoError(t, err)
+			_, _, err = s.ImportKeysBatch("d.wlt", nil, seckeys[3:])
+			require.Equal(t, ErrWalletNotCollection, err)
+
+			_, _, err = s.ImportKeysBatch("foo.wlt", nil, seckeys[3:])
+			require.Equal(t, ErrWalletNotExist, err)
+
+			s.config.EnableWalletAPI = false
+			_, _, err = s.ImportKeysBatch("t.wlt", nil, seckeys[3:])
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+// hookBalanceGetter calls hook before returning the balances of mockBalanceGetter
+type hookBalanceGetter struct {
+	mockBalanceGetter
+	hook func()
+}
+
+func (bg hookBalanceGetter) GetBalanceOfAddrs(addrs []cipher.Address) ([]BalancePair, error) {
+	bg.hook()
+	return bg.mockBalanceGetter.GetBalanceOfAddrs(addrs)
+}
+
+// sizeBalanceGetter returns n more or fewer balances than it is asked for
+type sizeBalanceGetter struct {
+	mockBalanceGetter
+	n int
+}
+
+func (bg sizeBalanceGetter) GetBalanceOfAddrs(addrs []cipher.Address) ([]BalancePair, error) {
+	bals, err := bg.mockBalanceGetter.GetBalanceOfAddrs(addrs)
+	if err != nil || bg.n >= 0 {
+		return append(bals, make([]BalancePair, bg.n)...), err
+	}
+	return bals[:len(bals)+bg.n], nil
+}
+
+func TestServiceReapExpired(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			_, seckeys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte("keys"), 5)
+			addrs := make([]cipher.Address, len(seckeys))
+			for i, sk := range seckeys {
+				addrs[i] = cipher.MustAddressFromSecKey(sk)
+			}
+
+			first := Entry{
+				Address: addrs[0],
+				Public:  cipher.MustPubKeyFromSecKey(seckeys[0]),
+				Secret:  seckeys[0],
+			}
+			_, err = s.CreateWallet("t.wlt", Options{
+				Type:       WalletTypeCollection,
+				FirstEntry: &first,
+				Encrypt:    true,
+				Password:   []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+			_, _, err = s.ImportKeysBatch("t.wlt", []byte("pwd"), seckeys[1:])
+			require.NoError(t, err)
+
+			// Only imported entries other than the first one can expire
+			past := time.Unix(time.Now().Add(-time.Hour).Unix(), 0)
+			require.Equal(t, ErrExpireFirstEntry, s.SetEntryExpiry("t.wlt", addrs[0], past))
+			require.Equal(t, ErrUnknownAddress, s.SetEntryExpiry("t.wlt", testutil.MakeAddress(), past))
+			for _, a := range addrs[1:4] {
+				require.NoError(t, s.SetEntryExpiry("t.wlt", a, past))
+			}
+			require.NoError(t, s.SetEntryExpiry("t.wlt", addrs[4], time.Now().Add(time.Hour)))
+
+			// The expiry is saved
+			w, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, past, w.Entries[1].Expires)
+			require.True(t, w.Entries[0].Expires.IsZero())
+
+			_, err = s.ReapExpired("t.wlt", []byte("pwd"), nil)
+			require.Equal(t, ErrNilBalanceGetter, err)
+
+			// Addresses with a balance are kept, and the balances are requested without holding the lock,
+			// so the wallet can change meanwhile
+			bg := hookBalanceGetter{
+				mockBalanceGetter: mockBalanceGetter{
+					addrs[2]: BalancePair{Predicted: NewBalance(1e6, 0)},
+				},
+				hook: func() {
+					require.NoError(t, s.SetEntryExpiry("t.wlt", addrs[3], time.Time{}))
+				},
+			}
+
+			_, err = s.ReapExpired("t.wlt", []byte("wrong"), bg)
+			require.Equal(t, ErrInvalidPassword, err)
+
+			// Nothing is removed if the balances don't match the addresses
+			for _, n := range []int{1, -1} {
+				_, err = s.ReapExpired("t.wlt", []byte("pwd"), sizeBalanceGetter{
+					mockBalanceGetter: bg.mockBalanceGetter,
+					n:                 n,
+				})
+				testutil.RequireError(t, err, "balance getter returned wrong number of balances")
+			}
+			w, err = s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.Len(t, w.Entries, 5)
+
+			removed, err := s.ReapExpired("t.wlt", []byte("pwd"), bg)
+			require.NoError(t, err)
+			require.Equal(t, []cipher.Address{addrs[1]}, removed)
+
+			w, err = s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			wAddrs, err := w.GetSkycoinAddresses()
+			require.NoError(t, err)
+			require.Equal(t, []cipher.Address{addrs[0], addrs[2], addrs[3], addrs[4]}, wAddrs)
+
+			_, err = s.GetWalletForAddress(addrs[1])
+			require.Equal(t, ErrAddressNotFound, err)
+
+			// The secret key is removed from the encrypted secrets
+			sb, err := w.decryptSecrets([]byte("pwd"))
+			require.NoError(t, err)
+			ss := make(secrets)
+			require.NoError(t, ss.deserialize(sb))
+			_, ok := ss.get(addrs[1].String())
+			require.False(t, ok)
+			_, ok = ss.get(addrs[2].String())
+			require.True(t, ok)
+
+			w, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			require.Len(t, w.Entries, 4)
+
+			// The address is removed once it is empty
+			removed, err = s.ReapExpired("t.wlt", nil, mockBalanceGetter{})
+			require.NoError(t, err)
+			require.Equal(t, []cipher.Address{addrs[2]}, removed)
+
+			removed, err = s.ReapExpired("t.wlt", nil, mockBalanceGetter{})
+			require.NoError(t, err)
+			require.Empty(t, removed)
+
+			// Generated entries don't expire
+			_, err = s.CreateWallet("d.wlt", Options{
+				Seed: "seed",
+			}, nil)
+			require.NoError(t, err)
+			w, err = s.GetWallet("d.wlt")
+			require.NoError(t, err)
+			require.Equal(t, ErrEntryNotImported, s.SetEntryExpiry("d.wlt", w.Entries[0].SkycoinAddress(), past))
+
+			s.config.EnableWalletAPI = false
+			_, err = s.ReapExpired("t.wlt", nil, mockBalanceGetter{})
+			require.Equal(t, ErrWalletAPIDisabled, err)
+			require.Equal(t, ErrWalletAPIDisabled, s.SetEntryExpiry("t.wlt", addrs[4], time.Time{}))
+		})
+	}
+}
+
+func TestServiceAttachSeed(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			_, seckeys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte("keys"), 2)
+			_, seedKeys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte("hybrid"), 3)
+			first := Entry{
+				Address: cipher.MustAddressFromSecKey(seckeys[0]),
+				Public:  cipher.MustPubKeyFromSecKey(seckeys[0]),
+				Secret:  seckeys[0],
+			}
+			_, err = s.CreateWallet("t.wlt", Options{
+				Type:       WalletTypeCollection,
+				FirstEntry: &first,
+				Encrypt:    true,
+				Password:   []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+
+			// The second key of the seed is imported before the seed is attached
+			_, _, err = s.ImportKeysBatch("t.wlt", []byte("pwd"), seedKeys[1:2])
+			require.NoError(t, err)
+
+			_, err = s.NewAddresses("t.wlt", []byte("pwd"), 1)
+			require.Equal(t, ErrWalletNotDeterministic, err)
+
+			err = s.AttachSeed("t.wlt", "", []byte("pwd"))
+			require.Equal(t, ErrMissingSeed, err)
+			err = s.AttachSeed("t.wlt", "hybrid", nil)
+			require.Equal(t, ErrMissingPassword, err)
+			err = s.AttachSeed("t.wlt", "hybrid", []byte("wrong"))
+			require.Equal(t, ErrInvalidPassword, err)
+
+			events := make(chan WalletEvent, 16)
+			defer s.Subscribe(func(ev WalletEvent) {
+				events <- ev
+			})()
+
+			err = s.AttachSeed("t.wlt", "hybrid", []byte("pwd"))
+			require.NoError(t, err)
+			select {
+			case ev := <-events:
+				require.Equal(t, WalletSeedAttached, ev.Kind)
+			case <-time.After(time.Second):
+				t.Fatal("timed out waiting for events")
+			}
+
+			// A wallet that already has a seed is rejected
+			err = s.AttachSeed("t.wlt", "other", []byte("pwd"))
+			require.Equal(t, ErrWalletHasSeed, err)
+
+			w, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, WalletTypeDeterministic, w.Type())
+			require.True(t, w.IsEncrypted())
+			require.Len(t, w.Entries, 2)
+
+			// Addresses are generated from the seed, skipping the imported key of the seed
+			addrs, err := s.NewAddresses("t.wlt", []byte("pwd"), 2)
+			require.NoError(t, err)
+			require.Equal(t, []cipher.Address{
+				cipher.MustAddressFromSecKey(seedKeys[0]),
+				cipher.MustAddressFromSecKey(seedKeys[2]),
+			}, addrs)
+
+			w, err = s.ReloadWallet("t.wlt")
+			require.NoError(t, err)
+			require.Len(t, w.Entries, 4)
+			require.True(t, w.Entries[0].Imported)
+			require.True(t, w.Entries[1].Imported)
+			require.False(t, w.Entries[2].Imported)
+			require.False(t, w.Entries[3].Imported)
+
+			c, err := w.Capabilities()
+			require.NoError(t, err)
+			require.False(t, c.CanRecover)
+			_, err = s.RecoverWallet("t.wlt", "hybrid", nil)
+			require.Equal(t, ErrWalletHasImportedEntries, err)
+
+			w, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			require.Equal(t, "hybrid", w.seed())
+			require.Equal(t, seckeys[0], w.Entries[0].Secret)
+			require.Equal(t, seedKeys[1], w.Entries[1].Secret)
+
+			// The seed of another wallet can't be attached
+			_, err = s.CreateWallet("seed.wlt", Options{
+				Seed: "used",
+			}, nil)
+			require.NoError(t, err)
+			other := Entry{
+				Address: cipher.MustAddressFromSecKey(seckeys[1]),
+				Public:  cipher.MustPubKeyFromSecKey(seckeys[1]),
+				Secret:  seckeys[1],
+			}
+			_, err = s.CreateWallet("c.wlt", Options{
+				Type:       WalletTypeCollection,
+				FirstEntry: &other,
+			}, nil)
+			require.NoError(t, err)
+			err = s.AttachSeed("c.wlt", "used", nil)
+			require.Equal(t, ErrSeedUsed, err)
+		})
+	}
+}
+
+func TestServiceMergeMetadata(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:           dir,
+		CryptoType:          CryptoTypeSha256Xor,
+		EnableWalletAPI:     true,
+		AllowDuplicateSeeds: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("into.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	from, err := s.CreateWallet("from.wlt", Options{
+		Seed:      "seed",
+		Label:     "savings",
+		GenerateN: 3,
+	}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.SetSeedPassphraseHint("from.wlt", "from hint"))
+	require.NoError(t, s.SetSeedPassphraseHint("into.wlt", "into hint"))
+	require.NoError(t, s.SetWalletTags("from.wlt", []string{"cold", "savings"}))
+	require.NoError(t, s.SetWalletTags("into.wlt", []string{"savings", "ledger"}))
+
+	// Only the address that both wallets have gets the label
+	fromAddrs, err := from.GetSkycoinAddresses()
+	require.NoError(t, err)
+	require.NoError(t, s.SetAddressLabel("from.wlt", fromAddrs[0], "deposit"))
+	require.NoError(t, s.SetAddressLabel("from.wlt", fromAddrs[1], "change"))
+
+	err = s.MergeMetadata("into.wlt", "foo.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+	err = s.MergeMetadata("foo.wlt", "from.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+
+	err = s.MergeMetadata("into.wlt", "from.wlt")
+	require.NoError(t, err)
+
+	// The empty label is filled, the existing hint is kept, entries and secrets are untouched
+	w, err := s.GetWallet("into.wlt")
+	require.NoError(t, err)
+	require.Equal(t, "savings", w.Label())
+	require.Equal(t, "into hint", w.SeedPassphraseHint())
+	require.Equal(t, []string{"savings", "ledger", "cold"}, w.Tags())
+	require.Len(t, w.Entries, 1)
+	require.Equal(t, "deposit", w.Entries[0].Label)
+	require.True(t, w.IsEncrypted())
+
+	// The source wallet is not changed
+	w2, err := s.GetWallet("from.wlt")
+	require.NoError(t, err)
+	require.Equal(t, "from hint", w2.SeedPassphraseHint())
+	require.Equal(t, []string{"cold", "savings"}, w2.Tags())
+	require.Equal(t, from.GetAddresses(), w2.GetAddresses())
+
+	// The merged metadata is persisted
+	w3, err := Load(filepath.Join(dir, "into.wlt"))
+	require.NoError(t, err)
+	require.Equal(t, "savings", w3.Label())
+	require.Equal(t, "deposit", w3.Entries[0].Label)
+
+	// An existing address label is kept
+	require.NoError(t, s.SetAddressLabel("from.wlt", fromAddrs[0], "other"))
+	require.NoError(t, s.MergeMetadata("into.wlt", "from.wlt"))
+	w, err = s.GetWallet("into.wlt")
+	require.NoError(t, err)
+	require.Equal(t, "deposit", w.Entries[0].Label)
+
+	err = s.SetWalletReadOnly("into.wlt", true)
+	require.NoError(t, err)
+	err = s.MergeMetadata("into.wlt", "from.wlt")
+	require.Equal(t, ErrWalletReadOnly, err)
+
+	s.config.EnableWalletAPI = false
+	err = s.MergeMetadata("into.wlt", "from.wlt")
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceNextUnusedAddress(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	_, keys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte("seed"), 12)
+	addrs := make([]cipher.Address, len(keys))
+	for i, k := range keys {
+		addrs[i] = cipher.MustAddressFromSecKey(k)
+	}
+
+	bg := mockBalanceGetter{}
+
+	_, err = s.NextUnusedAddress("t.wlt", []byte("pwd"))
+	require.Equal(t, ErrNoAddressPoolPolicy, err)
+
+	err = s.SetAddressPoolPolicy("foo.wlt", 2, 4, bg)
+	require.Equal(t, ErrWalletNotExist, err)
+	err = s.SetAddressPoolPolicy("t.wlt", 0, 4, bg)
+	require.Equal(t, ErrInvalidAddressPoolPolicy, err)
+	err = s.SetAddressPoolPolicy("t.wlt", 3, 2, bg)
+	require.Equal(t, ErrInvalidAddressPoolPolicy, err)
+
+	err = s.SetAddressPoolPolicy("t.wlt", 2, 4, bg)
+	require.NoError(t, err)
+
+	// The pool has a single unused address, so it is refilled. This requires the password.
+	_, err = s.NextUnusedAddress("t.wlt", nil)
+	require.Equal(t, ErrMissingPassword, err)
+
+	addr, err := s.NextUnusedAddress("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	require.Equal(t, addrs[0], addr)
+	w, err := s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.Len(t, w.Entries, 4)
+
+	// Enough unused addresses remain after the first address receives coins
+	bg[addrs[0]] = BalancePair{Confirmed: Balance{Coins: 1e6}}
+	addr, err = s.NextUnusedAddress("t.wlt", nil)
+	require.NoError(t, err)
+	require.Equal(t, addrs[1], addr)
+
+	// Pending coins count as used
+	bg[addrs[2]] = BalancePair{Predicted: Balance{Coins: 1e6}}
+	addr, err = s.NextUnusedAddress("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	require.Equal(t, addrs[3], addr)
+	w, err = s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.Len(t, w.Entries, 7)
+
+	// The generated addresses are persisted
+	w, err = Load(filepath.Join(dir, "t.wlt"))
+	require.NoError(t, err)
+	require.Len(t, w.Entries, 7)
+
+	// Only the main chain is used, the addresses of other accounts are ignored
+	account, err := s.NewAccount("t.wlt", []byte("pwd"), "savings")
+	require.NoError(t, err)
+	w, err = s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.Len(t, w.Entries, 8)
+	require.Equal(t, account, w.Entries[7].Account)
+	bg[w.Entries[7].SkycoinAddress()] = BalancePair{Confirmed: Balance{Coins: 1e6}}
+	addr, err = s.NextUnusedAddress("t.wlt", nil)
+	require.NoError(t, err)
+	require.Equal(t, addrs[3], addr)
+
+	bg[addrs[6]] = BalancePair{Confirmed: Balance{Coins: 1e6}}
+	addr, err = s.NextUnusedAddress("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	require.Equal(t, addrs[7], addr)
+	w, err = s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.Len(t, w.Entries, 12)
+
+	// A read-only wallet can't be refilled
+	bg[addrs[9]] = BalancePair{Confirmed: Balance{Coins: 1e6}}
+	require.NoError(t, s.SetWalletReadOnly("t.wlt", true))
+	_, err = s.NextUnusedAddress("t.wlt", []byte("pwd"))
+	require.Equal(t, ErrWalletReadOnly, err)
+
+	// Removing the policy
+	err = s.SetAddressPoolPolicy("t.wlt", 0, 0, nil)
+	require.NoError(t, err)
+	_, err = s.NextUnusedAddress("t.wlt", []byte("pwd"))
+	require.Equal(t, ErrNoAddressPoolPolicy, err)
+
+	// Watch-only wallets have no address pool
+	_, err = s.CreateWalletFromAddresses("w.wlt", []cipher.Address{testutil.MakeAddress()})
+	require.NoError(t, err)
+	err = s.SetAddressPoolPolicy("w.wlt", 2, 4, bg)
+	require.NoError(t, err)
+	_, err = s.NextUnusedAddress("w.wlt", nil)
+	require.Equal(t, ErrWalletIsWatchOnly, err)
+
+	s.config.EnableWalletAPI = false
+	err = s.SetAddressPoolPolicy("t.wlt", 2, 4, bg)
+	require.Equal(t, ErrWalletAPIDisabled, err)
+	_, err = s.NextUnusedAddress("t.wlt", []byte("pwd"))
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceWalletIDNormalization(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			for i, name := range []string{"Savings.wlt", "dup.wlt", "DUP.wlt"} {
+				w, err := NewWallet(name, Options{
+					Seed: fmt.Sprintf("normalization-seed-%d", i),
+				})
+				require.NoError(t, err)
+				require.NoError(t, w.Save(dir))
+			}
+
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			for _, id := range []string{"Savings.wlt", " savings.WLT\n", "SAVINGS.wlt"} {
+				w, err := s.GetWallet(id)
+				require.NoError(t, err)
+				require.Equal(t, "Savings.wlt", w.Filename())
+			}
+
+			// Exact matches always win, otherwise ids differing only by case are ambiguous
+			w, err := s.GetWallet("dup.wlt")
+			require.NoError(t, err)
+			require.Equal(t, "dup.wlt", w.Filename())
+			w, err = s.GetWallet(" DUP.wlt ")
+			require.NoError(t, err)
+			require.Equal(t, "DUP.wlt", w.Filename())
+			_, err = s.GetWallet("Dup.wlt")
+			require.Equal(t, ErrAmbiguousWalletID, err)
+
+			_, err = s.GetWallet("foo.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			// Updates are stored under the wallet's real id
+			require.NoError(t, s.UpdateWalletLabel(" savings.wlt", "label"))
+			w, err = s.GetWallet("Savings.wlt")
+			require.NoError(t, err)
+			require.Equal(t, "label", w.Label())
+			_, err = s.GetWallet("savings.wlt")
+			require.NoError(t, err)
+
+			require.NoError(t, s.UnloadWallet("savings.wlt"))
+			_, err = s.GetWallet("Savings.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			// Strict wallet ids only match exactly
+			s, err = NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+				StrictWalletIDs: true,
+			})
+			require.NoError(t, err)
+
+			_, err = s.GetWallet("Savings.wlt")
+			require.NoError(t, err)
+			_, err = s.GetWallet(" savings.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+		})
+	}
+}
+
+func TestServiceGetWalletTimestamps(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed: "seed",
+	}, nil)
+	require.NoError(t, err)
+
+	created, modified, err := s.GetWalletTimestamps(" T.wlt")
+	require.NoError(t, err)
+	require.Equal(t, w.timestamp(), created)
+	require.True(t, modified >= created)
+
+	// The modification time is not read from the wallet file
+	mtime := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "t.wlt"), mtime, mtime))
+	created2, modified2, err := s.GetWalletTimestamps("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, created, created2)
+	require.Equal(t, modified, modified2)
+
+	// Saving the wallet updates the modification time, which is kept in the wallet file
+	time.Sleep(time.Second)
+	require.NoError(t, s.UpdateWalletLabel("t.wlt", "label"))
+	created3, modified3, err := s.GetWalletTimestamps("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, created, created3)
+	require.True(t, modified3 > modified2)
+	w, err = Load(filepath.Join(dir, "t.wlt"))
+	require.NoError(t, err)
+	require.Equal(t, modified3, w.modified())
+
+	// A wallet never saved by the service reports its creation time
+	w, err = NewWallet("u.wlt", Options{
+		Seed: "seed2",
+	})
+	require.NoError(t, err)
+	require.Equal(t, w.timestamp(), w.modified())
+
+	_, _, err = s.GetWalletTimestamps("foo.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+
+	s.config.EnableWalletAPI = false
+	_, _, err = s.GetWalletTimestamps("t.wlt")
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceRecomputeFirstAddrMap(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			for _, w := range []struct {
+				name string
+				seed string
+			}{
+				{"b.wlt", "recompute-seed-1"},
+				{"a.wlt", "recompute-seed-1"},
+				{"c.wlt", "recompute-seed-2"},
+			} {
+				wlt, err := NewWallet(w.name, Options{
+					Seed: w.seed,
+				})
+				require.NoError(t, err)
+				require.NoError(t, wlt.Save(dir))
+			}
+
+			s, err := NewService(Config{
+				WalletDir:           dir,
+				EnableWalletAPI:     true,
+				AllowDuplicateSeeds: true,
+				LazyLoad:            lazyLoad,
+			})
+			require.NoError(t, err)
+
+			// Load one of the wallets if lazy loading
+			_, err = s.GetWallet("b.wlt")
+			require.NoError(t, err)
+
+			addr1 := cipher.MustAddressFromSecKey(cipher.MustGenerateDeterministicKeyPairs([]byte("recompute-seed-1"), 1)[0]).String()
+			addr2 := cipher.MustAddressFromSecKey(cipher.MustGenerateDeterministicKeyPairs([]byte("recompute-seed-2"), 1)[0]).String()
+
+			// Desync the map
+			s.firstAddrIDMap = map[string]string{
+				"foo": "foo.wlt",
+			}
+
+			conflicts, err := s.RecomputeFirstAddrMap()
+			require.NoError(t, err)
+			require.Equal(t, map[string][]string{
+				addr1: {"a.wlt", "b.wlt"},
+			}, conflicts)
+			require.Equal(t, map[string]string{
+				addr1: "a.wlt",
+				addr2: "c.wlt",
+			}, s.firstAddrIDMap)
+
+			require.NoError(t, s.UnloadWallet("a.wlt"))
+			conflicts, err = s.RecomputeFirstAddrMap()
+			require.NoError(t, err)
+			require.Empty(t, conflicts)
+			require.Equal(t, map[string]string{
+				addr1: "b.wlt",
+				addr2: "c.wlt",
+			}, s.firstAddrIDMap)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.RecomputeFirstAddrMap()
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceSignMultipleMessages(t *testing.T) {
+	for ct := range cryptoTable {
+		t.Run(fmt.Sprintf("crypto=%v", ct), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      ct,
+				EnableWalletAPI: true,
+			})
+			require.NoError(t, err)
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed:      "seed",
+				Encrypt:   true,
+				Password:  []byte("pwd"),
+				GenerateN: 3,
+			}, nil)
+			require.NoError(t, err)
+
+			addrs, err := s.GetSkycoinAddresses("t.wlt")
+			require.NoError(t, err)
+
+			items := []MessageToSign{
+				{Address: addrs[2], Message: []byte("foo")},
+				{Address: addrs[0], Message: []byte("bar")},
+				{Address: addrs[2], Message: []byte("baz")},
+			}
+
+			sigs, err := s.SignMultipleMessages("t.wlt", []byte("pwd"), items)
+			require.NoError(t, err)
+			require.Len(t, sigs, len(items))
+			for i, item := range items {
+				err := cipher.VerifyAddressSignedHash(item.Address, sigs[i], cipher.SumSHA256(item.Message))
+				require.NoError(t, err)
+			}
+
+			// The wallet remains encrypted
+			w, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.True(t, w.IsEncrypted())
+			checkNoSensitiveData(t, w)
+
+			_, err = s.SignMultipleMessages("t.wlt", []byte("wrong"), items)
+			require.Equal(t, ErrInvalidPassword, err)
+			_, err = s.SignMultipleMessages("t.wlt", nil, items)
+			require.Equal(t, ErrMissingPassword, err)
+
+			// The whole batch fails if an address is not in the wallet
+			unknown := testutil.MakeAddress()
+			_, err = s.SignMultipleMessages("t.wlt", []byte("pwd"), append(items, MessageToSign{
+				Address: unknown,
+				Message: []byte("foo"),
+			}))
+			require.Equal(t, ErrUnknownAddress, err)
+
+			_, err = s.SignMultipleMessages("foo.wlt", []byte("pwd"), items)
+			require.Equal(t, ErrWalletNotExist, err)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.SignMultipleMessages("t.wlt", []byte("pwd"), items)
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+type countingBalanceGetter struct {
+	mockBalanceGetter
+	calls int
+}
+
+func (bg *countingBalanceGetter) GetBalanceOfAddrs(addrs []cipher.Address) ([]BalancePair, error) {
+	bg.calls++
+	return bg.mockBalanceGetter.GetBalanceOfAddrs(addrs)
+}
+
+func TestServiceExportBalancesCSV(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	w1, err := s.CreateWallet("b.wlt", Options{
+		Seed:      "seed-b",
+		Label:     "savings, main",
+		GenerateN: 2,
+	}, nil)
+	require.NoError(t, err)
+
+	w2, err := s.CreateWallet("a.wlt", Options{
+		Seed:  "seed-a",
+		Label: "spending",
+	}, nil)
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("c.wlt", Options{
+		Seed: "seed-c",
+	}, nil)
+	require.NoError(t, err)
+
+	bg := &countingBalanceGetter{
+		mockBalanceGetter: mockBalanceGetter{
+			w1.Entries[0].SkycoinAddress(): BalancePair{
+				Confirmed: Balance{Coins: 1e6, Hours: 10},
+				Predicted: Balance{Coins: 1e6, Hours: 10},
+			},
+			w1.Entries[1].SkycoinAddress(): BalancePair{
+				Confirmed: Balance{Coins: 2500000},
+				Predicted: Balance{Coins: 500000},
+			},
+			w2.Entries[0].SkycoinAddress(): BalancePair{
+				Confirmed: Balance{Coins: 1000},
+				Predicted: Balance{Coins: 1000},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	err = s.ExportBalancesCSV(bg, &buf)
+	require.NoError(t, err)
+	require.Equal(t, 1, bg.calls)
+	require.Equal(t, `wallet_id,label,confirmed,predicted
+a.wlt,spending,0.001000,0.001000
+b.wlt,"savings, main",3.500000,1.500000
+c.wlt,,0.000000,0.000000
+total,,3.501000,1.501000
+`, buf.String())
+
+	s.config.EnableWalletAPI = false
+	err = s.ExportBalancesCSV(bg, &buf)
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceWalletFileChanged(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			w, err := NewWallet("a.wlt", Options{
+				Seed: "file-changed-seed",
+			})
+			require.NoError(t, err)
+			require.NoError(t, w.Save(dir))
+
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			changed, err := s.WalletFileChanged("a.wlt")
+			require.NoError(t, err)
+			require.False(t, changed)
+
+			// Modify the file externally
+			w.setLabel("external")
+			require.NoError(t, w.Save(dir))
+			changed, err = s.WalletFileChanged("a.wlt")
+			require.NoError(t, err)
+			require.True(t, changed)
+
+			// Saving the wallet through the service updates the recorded hash
+			require.NoError(t, s.UpdateWalletLabel("a.wlt", "service"))
+			changed, err = s.WalletFileChanged("a.wlt")
+			require.NoError(t, err)
+			require.False(t, changed)
+
+			_, err = s.CreateWallet("b.wlt", Options{
+				Seed: "file-changed-seed-2",
+			}, nil)
+			require.NoError(t, err)
+			changed, err = s.WalletFileChanged("b.wlt")
+			require.NoError(t, err)
+			require.False(t, changed)
+
+			_, err = s.WalletFileChanged("foo.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			require.NoError(t, os.Remove(filepath.Join(dir, "b.wlt")))
+			_, err = s.WalletFileChanged("b.wlt")
+			require.True(t, os.IsNotExist(err))
+
+			require.NoError(t, s.UnloadWallet("b.wlt"))
+			_, ok := s.fileHashes["b.wlt"]
+			require.False(t, ok)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.WalletFileChanged("a.wlt")
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceUniqueLabels(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			w, err := NewWallet("a.wlt", Options{
+				Seed:  "unique-labels-seed-a",
+				Label: "savings",
+			})
+			require.NoError(t, err)
+			require.NoError(t, w.Save(dir))
+
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+				UniqueLabels:    true,
+			})
+			require.NoError(t, err)
+
+			_, err = s.CreateWallet("b.wlt", Options{
+				Seed:  "unique-labels-seed-b",
+				Label: "savings",
+			}, nil)
+			require.Equal(t, ErrLabelInUse, err)
+			_, err = s.GetWallet("b.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			_, err = s.CreateWallet("b.wlt", Options{
+				Seed:  "unique-labels-seed-b",
+				Label: "spending",
+			}, nil)
+			require.NoError(t, err)
+
+			// Empty labels may be repeated
+			for _, name := range []string{"c.wlt", "d.wlt"} {
+				_, err = s.CreateWallet(name, Options{
+					Seed: "unique-labels-seed-" + name,
+				}, nil)
+				require.NoError(t, err)
+			}
+
+			err = s.UpdateWalletLabel("b.wlt", "savings")
+			require.Equal(t, ErrLabelInUse, err)
+			err = s.UpdateWalletLabel("c.wlt", "spending")
+			require.Equal(t, ErrLabelInUse, err)
+
+			// A wallet can keep its own label
+			err = s.UpdateWalletLabel("a.wlt", "savings")
+			require.NoError(t, err)
+
+			// A label can be reused once it is released
+			err = s.UpdateWalletLabel("a.wlt", "old savings")
+			require.NoError(t, err)
+			err = s.UpdateWalletLabel("b.wlt", "savings")
+			require.NoError(t, err)
+
+			// Duplicate labels are allowed by default
+			s.config.UniqueLabels = false
+			err = s.UpdateWalletLabel("c.wlt", "savings")
+			require.NoError(t, err)
+		})
+	}
+}
+
+type mockFaucet struct {
+	funded []cipher.Address
+	err    error
+}
+
+func (f *mockFaucet) Fund(addr cipher.Address) error {
+	f.funded = append(f.funded, addr)
+	return f.err
+}
+
+func TestServiceGenerateAndFund(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	faucet := &mockFaucet{}
+	_, _, err = s.GenerateAndFund("t.wlt", faucet)
+	require.Equal(t, ErrDevAPIDisabled, err)
+	require.Empty(t, faucet.funded)
+
+	s.config.EnableDevAPI = true
+
+	// No wallet is created without a faucet
+	_, _, err = s.GenerateAndFund("t.wlt", nil)
+	require.Equal(t, ErrNilFaucet, err)
+	_, err = s.GetWallet("t.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+
+	w, addr, err := s.GenerateAndFund("t.wlt", faucet)
+	require.NoError(t, err)
+	require.Equal(t, "t.wlt", w.Filename())
+	require.False(t, w.IsEncrypted())
+	require.Len(t, w.Entries, 1)
+	require.Equal(t, w.Entries[0].SkycoinAddress(), addr)
+	require.Equal(t, []cipher.Address{addr}, faucet.funded)
+
+	w2, err := s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, w, w2)
+
+	// The wallet is kept if the faucet fails
+	faucet.err = errors.New("faucet is empty")
+	w, addr, err = s.GenerateAndFund("t2.wlt", faucet)
+	require.Equal(t, faucet.err, err)
+	require.NotNil(t, w)
+	require.Equal(t, addr, faucet.funded[1])
+	_, err = s.GetWallet("t2.wlt")
+	require.NoError(t, err)
+
+	_, _, err = s.GenerateAndFund("t.wlt", faucet)
+	require.Equal(t, ErrWalletNameConflict, err)
+}
+
+func TestServiceCreateWalletScanProgress(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, keys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte("seed"), 10)
+	bg := mockBalanceGetter{
+		cipher.MustAddressFromSecKey(keys[2]): BalancePair{Confirmed: Balance{Coins: 1e6}},
+		cipher.MustAddressFromSecKey(keys[5]): BalancePair{Predicted: Balance{Coins: 1e6}},
+	}
+
+	events := make(chan WalletEvent, 16)
+	defer s.Subscribe(func(ev WalletEvent) {
+		events <- ev
+	})()
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:  "seed",
+		ScanN: 5,
+	}, bg)
+	require.NoError(t, err)
+	require.Len(t, w.Entries, 6)
+
+	// Scan events may be coalesced, but the last one is always delivered before the wallet is created
+	var updates [][2]uint64
+	for done := false; !done; {
+		select {
+		case ev := <-events:
+			require.Equal(t, "t.wlt", ev.WalletID)
+			switch ev.Kind {
+			case WalletAddressScanned:
+				updates = append(updates, [2]uint64{ev.Scanned, ev.Found})
+			case WalletCreated:
+				done = true
+			default:
+				t.Fatalf("unexpected event %s", ev.Kind)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for events")
+		}
+	}
+
+	require.NotEmpty(t, updates)
+	require.Equal(t, [2]uint64{10, 2}, updates[len(updates)-1])
+	for i := 1; i < len(updates); i++ {
+		require.True(t, updates[i][0] > updates[i-1][0])
+	}
+}
+
+func TestServiceResolveWallet(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			for i, w := range []struct {
+				name  string
+				label string
+			}{
+				{"a.wlt", "savings"},
+				{"b.wlt", "a.wlt"},
+				{"c.wlt", "shared"},
+				{"d.wlt", "shared"},
+				{"e.wlt", "e.wlt"},
+			} {
+				wlt, err := NewWallet(w.name, Options{
+					Seed:  fmt.Sprintf("resolve-seed-%d", i),
+					Label: w.label,
+				})
+				require.NoError(t, err)
+				require.NoError(t, wlt.Save(dir))
+			}
+
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			tt := []struct {
+				ref string
+				id  string
+				err error
+			}{
+				{"c.wlt", "c.wlt", nil},
+				{" C.WLT ", "c.wlt", nil},
+				{"savings", "a.wlt", nil},
+				{"e.wlt", "e.wlt", nil},
+				{"a.wlt", "", ErrAmbiguousReference},
+				{"shared", "", ErrAmbiguousReference},
+				{"foo", "", ErrWalletNotExist},
+				{"", "", ErrWalletNotExist},
+			}
+
+			for _, tc := range tt {
+				w, err := s.ResolveWallet(tc.ref)
+				require.Equal(t, tc.err, err, tc.ref)
+				if err != nil {
+					continue
+				}
+				require.Equal(t, tc.id, w.Filename())
+			}
+
+			// Updated labels are resolved
+			require.NoError(t, s.UpdateWalletLabel("a.wlt", "old savings"))
+			_, err = s.ResolveWallet("savings")
+			require.Equal(t, ErrWalletNotExist, err)
+			w, err := s.ResolveWallet("old savings")
+			require.NoError(t, err)
+			require.Equal(t, "a.wlt", w.Filename())
+
+			s.config.EnableWalletAPI = false
+			_, err = s.ResolveWallet("c.wlt")
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceRepairEmptyWallet(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypt=%v", encrypt), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+			})
+			require.NoError(t, err)
+
+			var password []byte
+			if encrypt {
+				password = []byte("pwd")
+			}
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:      "seed",
+				Encrypt:   encrypt,
+				Password:  password,
+				GenerateN: 3,
+			}, nil)
+			require.NoError(t, err)
+
+			err = s.RepairEmptyWallet("t.wlt", password)
+			require.Equal(t, ErrWalletNotEmpty, err)
+
+			// Empty the wallet
+			addr := w.Entries[0].Address.String()
+			require.NoError(t, s.UpdateSecrets("t.wlt", password, func(w *Wallet) error {
+				w.Entries = nil
+				return nil
+			}))
+
+			if encrypt {
+				err = s.RepairEmptyWallet("t.wlt", []byte("wrong"))
+				require.Equal(t, ErrInvalidPassword, err)
+			} else {
+				err = s.RepairEmptyWallet("t.wlt", []byte("pwd"))
+				require.Equal(t, ErrWalletNotEncrypted, err)
+			}
+
+			err = s.RepairEmptyWallet("t.wlt", password)
+			require.NoError(t, err)
+
+			w2, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.Len(t, w2.Entries, 1)
+			require.Equal(t, w.Entries[0].Address, w2.Entries[0].Address)
+			require.Equal(t, encrypt, w2.IsEncrypted())
+			require.Equal(t, "t.wlt", s.firstAddrIDMap[addr])
+
+			// The next generated address continues the chain
+			addrs, err := s.NewAddresses("t.wlt", password, 1)
+			require.NoError(t, err)
+			require.Equal(t, w.Entries[1].Address, addrs[0])
+
+			// The repaired wallet is saved
+			w3, err := Load(filepath.Join(dir, "t.wlt"))
+			require.NoError(t, err)
+			require.Len(t, w3.Entries, 2)
+
+			_, err = s.CreateWallet("t2.wlt", Options{
+				Seed: "seed2",
+			}, nil)
+			require.NoError(t, err)
+			require.NoError(t, s.Update("t2.wlt", func(w *Wallet) error {
+				w.Entries = nil
+				return nil
+			}))
+			require.NoError(t, s.SetWalletReadOnly("t2.wlt", true))
+			err = s.RepairEmptyWallet("t2.wlt", nil)
+			require.Equal(t, ErrWalletReadOnly, err)
+
+			err = s.RepairEmptyWallet("foo.wlt", password)
+			require.Equal(t, ErrWalletNotExist, err)
+
+			s.config.EnableWalletAPI = false
+			err = s.RepairEmptyWallet("t.wlt", password)
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceRepairEmptyWalletFile(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			b, err := ioutil.ReadFile("./testdata/empty_wallet/empty.wlt")
+			require.NoError(t, err)
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "empty.wlt"), b, 0600))
+
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			w, err := s.GetWallet("empty.wlt")
+			require.NoError(t, err)
+			require.Empty(t, w.Entries)
+
+			// Reloading the empty wallet file keeps it
+			w, err = s.ReloadWallet("empty.wlt")
+			require.NoError(t, err)
+			require.Empty(t, w.Entries)
+
+			require.NoError(t, s.RepairEmptyWallet("empty.wlt", nil))
+
+			w2, err := NewWallet("w.wlt", Options{
+				Seed: w.seed(),
+			})
+			require.NoError(t, err)
+			addr := w2.Entries[0].SkycoinAddress()
+
+			w, err = s.GetWallet("empty.wlt")
+			require.NoError(t, err)
+			require.Len(t, w.Entries, 1)
+			require.Equal(t, addr, w.Entries[0].SkycoinAddress())
+			require.Equal(t, "empty.wlt", s.firstAddrIDMap[addr.String()])
+			id, err := s.GetWalletForAddress(addr)
+			require.NoError(t, err)
+			require.Equal(t, "empty.wlt", id)
+
+			// The repaired wallet file is loaded and indexed
+			s, err = NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+			require.Equal(t, "empty.wlt", s.firstAddrIDMap[addr.String()])
+			w, err = s.GetWallet("empty.wlt")
+			require.NoError(t, err)
+			require.Len(t, w.Entries, 1)
+		})
+	}
+}
+
+func TestServiceSetWalletsEmptyWallet(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	wlts, err := LoadWallets("./testdata/empty_wallet")
+	require.NoError(t, err)
+	require.Len(t, wlts["empty.wlt"].Entries, 0)
+
+	require.NotPanics(t, func() {
+		s.setWallets(wlts)
+	})
+	require.Empty(t, s.firstAddrIDMap)
+	require.Empty(t, s.addrIDsMap)
+
+	w, err := s.GetWallet("empty.wlt")
+	require.NoError(t, err)
+	require.Empty(t, w.Entries)
+
+	require.NotPanics(t, func() {
+		require.NoError(t, s.UnloadWallet("empty.wlt"))
+	})
+	_, err = s.GetWallet("empty.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+}
+
+func TestServiceEmptyWalletNoPanic(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			for _, w := range []struct {
+				name     string
+				password []byte
+			}{
+				{"t.wlt", nil},
+				{"e.wlt", []byte("pwd")},
+			} {
+				_, err = s.CreateWallet(w.name, Options{
+					Seed:     w.name,
+					Encrypt:  len(w.password) != 0,
+					Password: w.password,
+				}, nil)
+				require.NoError(t, err)
+				require.NoError(t, s.UpdateSecrets(w.name, w.password, func(w *Wallet) error {
+					w.Entries = nil
+					return nil
+				}))
+			}
+
+			_, err = s.RecoverWallet("t.wlt", "seed", nil)
+			require.Equal(t, ErrWalletNotEncrypted, err)
+
+			// Encrypted empty wallets can't be recovered since the seed can't be verified
+			_, err = s.RecoverWallet("e.wlt", "seed", nil)
+			require.Equal(t, ErrWalletEmpty, err)
+
+			require.NotPanics(t, func() {
+				require.NoError(t, s.UnloadWallet("t.wlt"))
+			})
+			_, err = s.GetWallet("t.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			// Loading an empty wallet file doesn't panic
+			require.NoError(t, os.Remove(filepath.Join(dir, "t.wlt")))
+			require.NotPanics(t, func() {
+				s, err = NewService(Config{
+					WalletDir:       dir,
+					EnableWalletAPI: true,
+					LazyLoad:        lazyLoad,
+				})
+			})
+			require.NoError(t, err)
+			require.NotPanics(t, func() {
+				require.NoError(t, s.UnloadWallet("e.wlt"))
+			})
+		})
+	}
+}
+
+func TestServiceIndexFilter(t *testing.T) {
+	// Odd indexes are reserved
+	filter := func(i uint64) bool {
+		return i%2 == 0
+	}
+
+	_, keys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte("seed"), 11)
+	addrAt := func(i int) cipher.Address {
+		return cipher.MustAddressFromSecKey(keys[i])
+	}
+
+	dir := prepareWltDir()
+	config := Config{
+		WalletDir:        dir,
+		CryptoType:       CryptoTypeSha256Xor,
+		EnableWalletAPI:  true,
+		MaxCachedWallets: 1,
+		IndexFilter:      filter,
+	}
+	s, err := NewService(config)
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:        "seed",
+		IndexFilter: filter,
+	}, nil)
+	require.Equal(t, ErrIndexFilterOption, err)
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 2,
+		Encrypt:   true,
+		Password:  []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+	wAddrs, err := w.GetSkycoinAddresses()
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Address{addrAt(0), addrAt(2)}, wAddrs)
+
+	// The filter is applied again after the wallet is dropped from the cache
+	_, err = s.CreateWallet("o.wlt", Options{
+		Seed: "other",
+	}, nil)
+	require.NoError(t, err)
+	require.Nil(t, s.lazyWallets["t.wlt"].w)
+
+	addrs, err := s.NewAddresses("t.wlt", []byte("pwd"), 1)
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Address{addrAt(4)}, addrs)
+
+	// and after it is reloaded
+	_, err = s.ReloadWallet("t.wlt")
+	require.NoError(t, err)
+	addrs, err = s.NewAddresses("t.wlt", []byte("pwd"), 1)
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Address{addrAt(6)}, addrs)
+
+	// and after a restart
+	s, err = NewService(config)
+	require.NoError(t, err)
+	addrs, err = s.NewAddresses("t.wlt", []byte("pwd"), 1)
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Address{addrAt(8)}, addrs)
+
+	// Recovery regenerates the same addresses
+	w, err = s.RecoverWallet("t.wlt", "seed", nil)
+	require.NoError(t, err)
+	wAddrs, err = w.GetSkycoinAddresses()
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Address{addrAt(0), addrAt(2), addrAt(4), addrAt(6), addrAt(8)}, wAddrs)
+	addrs, err = s.NewAddresses("t.wlt", nil, 1)
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Address{addrAt(10)}, addrs)
+}
+
+func TestServiceSeedDeriver(t *testing.T) {
+	deriver := func(seed []byte) (cipher.PubKey, cipher.SecKey, error) {
+		h := cipher.SumSHA256(seed)
+		return cipher.GenerateDeterministicKeyPair(h[:])
+	}
+
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeScryptChacha20poly1305,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+				SeedDeriver:     deriver,
+			})
+			require.NoError(t, err)
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:     "seed",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+
+			addrs, err := s.NewAddresses("t.wlt", []byte("pwd"), 1)
+			require.NoError(t, err)
+			require.Len(t, addrs, 1)
+
+			seed := []byte("seed")
+			p, _, err := deriver(seed)
+			require.NoError(t, err)
+			require.Equal(t, cipher.AddressFromPubKey(p), w.Entries[0].Address)
+			p, _, err = deriver(secp256k1.Secp256k1Hash(seed))
+			require.NoError(t, err)
+			require.Equal(t, cipher.AddressFromPubKey(p), addrs[0])
+
+			// Recovery derives the first address with the same function
+			_, err = s.RecoverWallet("t.wlt", "seed", []byte("new-pwd"))
+			require.NoError(t, err)
+			_, err = s.RecoverWallet("t.wlt", "other-seed", []byte("new-pwd"))
+			require.Equal(t, ErrWalletRecoverSeedWrong, err)
+
+			// Recovering all wallets of a seed scans the addresses derived with the same function
+			p, _, err = deriver([]byte("seed2"))
+			require.NoError(t, err)
+			wlts, err := s.RecoverAllFromSeedPhrase("seed2", nil, mockBalanceGetter{
+				cipher.AddressFromPubKey(p): BalancePair{Confirmed: Balance{Coins: 1e6}},
+			})
+			require.NoError(t, err)
+			require.Len(t, wlts, 1)
+			require.Len(t, wlts[0].Entries, 1)
+			require.Equal(t, cipher.AddressFromPubKey(p), wlts[0].Entries[0].Address)
+
+			// A service using the standard derivation derives different addresses from the same seed
+			s2, err := NewService(Config{
+				WalletDir:       prepareWltDir(),
+				EnableWalletAPI: true,
+			})
+			require.NoError(t, err)
+			w2, err := s2.CreateWallet("t.wlt", Options{
+				Seed: "seed",
+			}, nil)
+			require.NoError(t, err)
+			require.NotEqual(t, w.Entries[0].Address, w2.Entries[0].Address)
+		})
+	}
+}
+
+func TestServiceDecryptToMemory(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeScryptChacha20poly1305,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed:     "seed",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+
+			w, err := s.DecryptToMemory("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			require.False(t, w.IsEncrypted())
+			require.Equal(t, "seed", w.seed())
+			require.False(t, w.Entries[0].Secret == cipher.SecKey{})
+
+			// The wallet stays encrypted in memory and on disk
+			w2, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.True(t, w2.IsEncrypted())
+			checkNoSensitiveData(t, w2)
+
+			w3, err := Load(filepath.Join(dir, "t.wlt"))
+			require.NoError(t, err)
+			require.True(t, w3.IsEncrypted())
+			checkNoSensitiveData(t, w3)
+
+			_, err = s.DecryptToMemory("t.wlt", []byte("wrong"))
+			require.Equal(t, ErrInvalidPassword, err)
+
+			_, err = s.DecryptToMemory("t.wlt", nil)
+			require.Equal(t, ErrMissingPassword, err)
+
+			_, err = s.CreateWallet("u.wlt", Options{
+				Seed: "seed2",
+			}, nil)
+			require.NoError(t, err)
+			_, err = s.DecryptToMemory("u.wlt", []byte("pwd"))
+			require.Equal(t, ErrWalletNotEncrypted, err)
+
+			_, err = s.DecryptToMemory("foo.wlt", []byte("pwd"))
+			require.Equal(t, ErrWalletNotExist, err)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.DecryptToMemory("t.wlt", []byte("pwd"))
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+type errBalanceGetter struct {
+	err error
+}
+
+func (bg errBalanceGetter) GetBalanceOfAddrs(addrs []cipher.Address) ([]BalancePair, error) {
+	return nil, bg.err
+}
+
+func TestServiceNewAddressesWithBalances(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	_, keys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte("seed"), 5)
+	addrs := make([]cipher.Address, len(keys))
+	for i, k := range keys {
+		addrs[i] = cipher.MustAddressFromSecKey(k)
+	}
+
+	bal := BalancePair{
+		Confirmed: NewBalance(1e6, 10),
+		Predicted: NewBalance(2e6, 10),
+	}
+	bg := &countingBalanceGetter{
+		mockBalanceGetter: mockBalanceGetter{
+			addrs[2]: bal,
+		},
+	}
+
+	addrBals, err := s.NewAddressesWithBalances("t.wlt", []byte("pwd"), 3, bg)
+	require.NoError(t, err)
+	require.Equal(t, 1, bg.calls)
+	require.Equal(t, []AddressBalance{
+		{Address: addrs[1]},
+		{Address: addrs[2], Balance: bal},
+		{Address: addrs[3]},
+	}, addrBals)
+
+	w, err := Load(filepath.Join(dir, "t.wlt"))
+	require.NoError(t, err)
+	require.Len(t, w.Entries, 4)
+
+	_, err = s.NewAddressesWithBalances("t.wlt", []byte("wrong"), 1, bg)
+	require.Equal(t, ErrInvalidPassword, err)
+	require.Equal(t, 1, bg.calls)
+
+	// The addresses are kept if the balances can't be fetched
+	berr := errors.New("node unavailable")
+	_, err = s.NewAddressesWithBalances("t.wlt", []byte("pwd"), 1, errBalanceGetter{berr})
+	require.Equal(t, berr, err)
+	w, err = s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.Len(t, w.Entries, 5)
+	require.Equal(t, addrs[4], w.Entries[4].Address)
+
+	_, err = s.NewAddressesWithBalances("foo.wlt", nil, 1, bg)
+	require.Equal(t, ErrWalletNotExist, err)
+}
+
+func TestServiceFreezeAddress(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeScryptChacha20poly1305,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:      "seed",
+				Encrypt:   true,
+				Password:  []byte("pwd"),
+				GenerateN: 3,
+			}, nil)
+			require.NoError(t, err)
+			addrs, err := w.GetSkycoinAddresses()
+			require.NoError(t, err)
+
+			// Encrypted wallets don't need the password
+			require.NoError(t, s.FreezeAddress("t.wlt", addrs[0], true))
+			require.NoError(t, s.FreezeAddress("t.wlt", addrs[2], true))
+			require.NoError(t, s.FreezeAddress("t.wlt", addrs[0], false))
+
+			frozen, err := s.GetFrozenAddresses("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, []cipher.Address{addrs[2]}, frozen)
+
+			w, err = Load(filepath.Join(dir, "t.wlt"))
+			require.NoError(t, err)
+			require.Equal(t, []cipher.Address{addrs[2]}, w.FrozenAddresses())
+
+			// Updating the secrets keeps the flags
+			_, err = s.NewAddresses("t.wlt", []byte("pwd"), 1)
+			require.NoError(t, err)
+			frozen, err = s.GetFrozenAddresses("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, []cipher.Address{addrs[2]}, frozen)
+
+			// Recovering the wallet keeps the flags
+			_, err = s.RecoverWallet("t.wlt", "seed", []byte("new-pwd"))
+			require.NoError(t, err)
+			frozen, err = s.GetFrozenAddresses("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, []cipher.Address{addrs[2]}, frozen)
+
+			err = s.FreezeAddress("t.wlt", testutil.MakeAddress(), true)
+			require.Equal(t, ErrUnknownAddress, err)
+
+			require.NoError(t, s.SetWalletReadOnly("t.wlt", true))
+			err = s.FreezeAddress("t.wlt", addrs[1], true)
+			require.Equal(t, ErrWalletReadOnly, err)
+
+			err = s.FreezeAddress("foo.wlt", addrs[1], true)
+			require.Equal(t, ErrWalletNotExist, err)
+			_, err = s.GetFrozenAddresses("foo.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			s.config.EnableWalletAPI = false
+			err = s.FreezeAddress("t.wlt", addrs[1], true)
+			require.Equal(t, ErrWalletAPIDisabled, err)
+			_, err = s.GetFrozenAddresses("t.wlt")
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceListFrozenAddresses(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			frozen, err := s.ListFrozenAddresses()
+			require.NoError(t, err)
+			require.Empty(t, frozen)
+
+			addrs := make(map[string][]cipher.Address)
+			for _, id := range []string{"a.wlt", "b.wlt", "c.wlt"} {
+				w, err := s.CreateWallet(id, Options{
+					Seed:      "seed-" + id,
+					GenerateN: 3,
+				}, nil)
+				require.NoError(t, err)
+				addrs[id], err = w.GetSkycoinAddresses()
+				require.NoError(t, err)
+			}
+
+			require.NoError(t, s.FreezeAddress("a.wlt", addrs["a.wlt"][0], true))
+			require.NoError(t, s.FreezeAddress("a.wlt", addrs["a.wlt"][2], true))
+			require.NoError(t, s.FreezeAddress("c.wlt", addrs["c.wlt"][1], true))
+
+			frozen, err = s.ListFrozenAddresses()
+			require.NoError(t, err)
+			require.Equal(t, map[string][]cipher.Address{
+				"a.wlt": {addrs["a.wlt"][0], addrs["a.wlt"][2]},
+				"c.wlt": {addrs["c.wlt"][1]},
+			}, frozen)
+
+			// Wallets loaded from disk are included
+			s2, err := NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+			frozen2, err := s2.ListFrozenAddresses()
+			require.NoError(t, err)
+			require.Equal(t, frozen, frozen2)
+
+			require.NoError(t, s.FreezeAddress("c.wlt", addrs["c.wlt"][1], false))
+			frozen, err = s.ListFrozenAddresses()
+			require.NoError(t, err)
+			require.Equal(t, map[string][]cipher.Address{
+				"a.wlt": {addrs["a.wlt"][0], addrs["a.wlt"][2]},
+			}, frozen)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.ListFrozenAddresses()
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceExportMnemonicImage(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+		EnableSeedAPI:   true,
+	})
+	require.NoError(t, err)
+
+	seed := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:     seed,
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	b, err := s.ExportMnemonicImage("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	require.NotZero(t, img.Bounds().Dx())
+
+	sheet := mnemonicSheet{
+		Coin:           CoinTypeSkycoin,
+		DerivationPath: DerivationPathDeterministic,
+		FirstAddr:      w.Entries[0].Address.String(),
+		Words:          strings.Fields(seed),
+	}
+	require.Equal(t, []string{
+		"Wallet recovery sheet",
+		"",
+		"Coin: skycoin",
+		"Derivation path: deterministic",
+		"First address:",
+		w.Entries[0].Address.String(),
+		"",
+		"Recovery words:",
+		" 1. abandon    2. abandon    3. abandon",
+		" 4. abandon    5. abandon    6. abandon",
+		" 7. abandon    8. abandon    9. abandon",
+		"10. abandon   11. abandon   12. about",
+	}, sheet.lines())
+	expect, err := sheet.png()
+	require.NoError(t, err)
+	require.Equal(t, expect, b)
+
+	// The plaintext seed is not written to disk
+	fs, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	for _, f := range fs {
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(data, []byte("abandon")), f.Name())
+	}
+	w, err = s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.True(t, w.IsEncrypted())
+
+	_, err = s.ExportMnemonicImage("t.wlt", []byte("wrong"))
+	require.Equal(t, ErrInvalidPassword, err)
+
+	_, err = s.CreateWallet("u.wlt", Options{
+		Seed: "seed2",
+	}, nil)
+	require.NoError(t, err)
+	_, err = s.ExportMnemonicImage("u.wlt", nil)
+	require.Equal(t, ErrWalletNotEncrypted, err)
+
+	_, err = s.ExportMnemonicImage("foo.wlt", []byte("pwd"))
+	require.Equal(t, ErrWalletNotExist, err)
+
+	s.config.EnableSeedAPI = false
+	_, err = s.ExportMnemonicImage("t.wlt", []byte("pwd"))
+	require.Equal(t, ErrSeedAPIDisabled, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.ExportMnemonicImage("t.wlt", []byte("pwd"))
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+// batchBalanceGetter records the batches requested from it and the maximum number of concurrent requests
+type batchBalanceGetter struct {
+	mockBalanceGetter
+	failAt int
+
+	mu          sync.Mutex
+	batches     [][]cipher.Address
+	inFlight    int
+	maxInFlight int
+}
+
+func (bg *batchBalanceGetter) GetBalanceOfAddrs(addrs []cipher.Address) ([]BalancePair, error) {
+	bg.mu.Lock()
+	bg.batches = append(bg.batches, addrs)
+	n := len(bg.batches)
+	bg.inFlight++
+	if bg.inFlight > bg.maxInFlight {
+		bg.maxInFlight = bg.inFlight
+	}
+	bg.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	bg.mu.Lock()
+	bg.inFlight--
+	bg.mu.Unlock()
+
+	if n == bg.failAt {
+		return nil, errors.New("batch failed")
+	}
+	return bg.mockBalanceGetter.GetBalanceOfAddrs(addrs)
+}
+
+func TestServiceGetBalancesBatches(t *testing.T) {
+	addrs := make([]cipher.Address, 10)
+	mb := make(mockBalanceGetter)
+	expect := make([]BalancePair, len(addrs))
+	for i := range addrs {
+		addrs[i] = testutil.MakeAddress()
+		expect[i] = BalancePair{
+			Confirmed: NewBalance(uint64(i+1)*1e6, uint64(i)),
+			Predicted: NewBalance(uint64(i+1)*1e6, uint64(i)),
+		}
+		mb[addrs[i]] = expect[i]
+	}
+
+	tt := []struct {
+		name        string
+		batchSize   int
+		concurrency int
+		failAt      int
+		batches     int
+		maxInFlight int
+		err         error
+	}{
+		{
+			name:        "single call",
+			batches:     1,
+			maxInFlight: 1,
+		},
+		{
+			name:        "batch size larger than request",
+			batchSize:   20,
+			concurrency: 4,
+			batches:     1,
+			maxInFlight: 1,
+		},
+		{
+			name:        "sequential batches",
+			batchSize:   3,
+			batches:     4,
+			maxInFlight: 1,
+		},
+		{
+			name:        "concurrent batches",
+			batchSize:   3,
+			concurrency: 2,
+			batches:     4,
+			maxInFlight: 2,
+		},
+		{
+			name:        "more workers than batches",
+			batchSize:   5,
+			concurrency: 8,
+			batches:     2,
+			maxInFlight: 2,
+		},
+		{
+			name:        "failed batch",
+			batchSize:   3,
+			failAt:      1,
+			batches:     1,
+			maxInFlight: 1,
+			err:         errors.New("batch failed"),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewService(Config{
+				WalletDir:               prepareWltDir(),
+				EnableWalletAPI:         true,
+				BalanceFetchBatchSize:   tc.batchSize,
+				BalanceFetchConcurrency: tc.concurrency,
+			})
+			require.NoError(t, err)
+
+			bg := &batchBalanceGetter{
+				mockBalanceGetter: mb,
+				failAt:            tc.failAt,
+			}
+			bals, err := s.getBalances(bg, addrs)
+			require.Equal(t, tc.err, err)
+			require.Len(t, bg.batches, tc.batches)
+			require.Equal(t, tc.maxInFlight, bg.maxInFlight)
+			if tc.err != nil {
+				return
+			}
+			require.Equal(t, expect, bals)
+
+			requested := make(map[cipher.Address]int)
+			for _, b := range bg.batches {
+				if tc.batchSize > 0 {
+					require.True(t, len(b) <= tc.batchSize)
+				}
+				for _, a := range b {
+					requested[a]++
+				}
+			}
+			require.Len(t, requested, len(addrs))
+			for _, a := range addrs {
+				require.Equal(t, 1, requested[a])
+			}
+		})
+	}
+}
+
+func TestServiceExportBalancesCSVBatches(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:             dir,
+		EnableWalletAPI:       true,
+		BalanceFetchBatchSize: 2,
+	})
+	require.NoError(t, err)
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 5,
+	}, nil)
+	require.NoError(t, err)
+
+	mb := mockBalanceGetter{
+		w.Entries[4].SkycoinAddress(): BalancePair{
+			Confirmed: NewBalance(1e6, 1),
+			Predicted: NewBalance(2e6, 1),
+		},
+	}
+	bg := &batchBalanceGetter{
+		mockBalanceGetter: mb,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, s.ExportBalancesCSV(bg, &buf))
+	require.Len(t, bg.batches, 3)
+
+	var expect bytes.Buffer
+	s.config.BalanceFetchBatchSize = 0
+	require.NoError(t, s.ExportBalancesCSV(mb, &expect))
+	require.Equal(t, expect.String(), buf.String())
+}
+
+func TestServiceExportPublicKeysJSON(t *testing.T) {
+	s, err := NewService(Config{
+		WalletDir:       prepareWltDir(),
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 2,
+		Encrypt:   true,
+		Password:  []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+	_, err = s.NewAccount("t.wlt", []byte("pwd"), "savings")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, s.ExportPublicKeysJSON("t.wlt", &buf))
+	require.NotContains(t, buf.String(), "secret")
+
+	var keys []ExportedPublicKey
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &keys))
+
+	w, err := s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.Len(t, keys, 3)
+	for i, e := range w.Entries {
+		require.Equal(t, e.Address.String(), keys[i].Address)
+		require.Equal(t, e.Public.Hex(), keys[i].PublicKey)
+		require.Equal(t, e.Account, keys[i].Account)
+	}
+	require.Equal(t, uint64(0), keys[0].Index)
+	require.Equal(t, uint64(1), keys[1].Index)
+	require.Equal(t, uint32(1), keys[2].Account)
+	require.Equal(t, uint64(0), keys[2].Index)
+
+	// The unlocked wallet still has its secrets
+	require.NoError(t, s.ViewSecrets("t.wlt", []byte("pwd"), func(w *Wallet) error {
+		require.False(t, w.Entries[0].Secret.Null())
+		return nil
+	}))
+
+	buf.Reset()
+	require.Equal(t, ErrWalletNotExist, s.ExportPublicKeysJSON("foo.wlt", &buf))
+	require.Empty(t, buf.String())
+
+	s.config.EnableWalletAPI = false
+	require.Equal(t, ErrWalletAPIDisabled, s.ExportPublicKeysJSON("t.wlt", &buf))
+}
+
+func TestServiceCreateWalletGenerateN(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeScryptChacha20poly1305,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			_, keys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte("seed"), 5)
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:      "seed",
+				Encrypt:   true,
+				Password:  []byte("pwd"),
+				GenerateN: 5,
+			}, nil)
+			require.NoError(t, err)
+			require.Len(t, w.Entries, 5)
+			for i, e := range w.Entries {
+				require.Equal(t, cipher.MustAddressFromSecKey(keys[i]), e.Address)
+			}
+
+			w2, err := Load(filepath.Join(dir, "t.wlt"))
+			require.NoError(t, err)
+			require.Equal(t, w.Entries, w2.Entries)
+
+			// The generated addresses continue the chain
+			addrs, err := s.NewAddresses("t.wlt", []byte("pwd"), 1)
+			require.NoError(t, err)
+			_, keys = cipher.MustGenerateDeterministicKeyPairsSeed([]byte("seed"), 6)
+			require.Equal(t, cipher.MustAddressFromSecKey(keys[5]), addrs[0])
+
+			// Zero generates a single address
+			w, err = s.CreateWallet("u.wlt", Options{
+				Seed: "seed2",
+			}, nil)
+			require.NoError(t, err)
+			require.Len(t, w.Entries, 1)
+		})
+	}
+}
+
+func TestServiceListWalletsByCoin(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	wlts, err := s.ListWalletsByCoin()
+	require.NoError(t, err)
+	require.Empty(t, wlts)
+
+	for _, tc := range []struct {
+		id   string
+		coin CoinType
+	}{
+		{"c.wlt", CoinTypeSkycoin},
+		{"a.wlt", CoinTypeSkycoin},
+		{"b.wlt", CoinTypeBitcoin},
+	} {
+		_, err := s.CreateWallet(tc.id, Options{
+			Seed: "seed-" + tc.id,
+			Coin: tc.coin,
+		}, nil)
+		require.NoError(t, err)
+	}
+
+	// Older wallets used a different name for the coin type
+	fn := filepath.Join(dir, "c.wlt")
+	b, err := ioutil.ReadFile(fn)
+	require.NoError(t, err)
+	require.True(t, bytes.Contains(b, []byte(`"coin": "skycoin"`)))
+	b = bytes.Replace(b, []byte(`"coin": "skycoin"`), []byte(`"coin": "sky"`), 1)
+	require.NoError(t, ioutil.WriteFile(fn, b, 0600))
+
+	expect := map[CoinType][]string{
+		CoinTypeSkycoin: {"a.wlt", "c.wlt"},
+		CoinTypeBitcoin: {"b.wlt"},
+	}
+
+	wlts, err = s.ListWalletsByCoin()
+	require.NoError(t, err)
+	require.Equal(t, expect, wlts)
+
+	// Bitcoin wallets can't be loaded from the wallet dir
+	require.NoError(t, os.Remove(filepath.Join(dir, "b.wlt")))
+	expect = map[CoinType][]string{
+		CoinTypeSkycoin: {"a.wlt", "c.wlt"},
+	}
+
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			wlts, err := s.ListWalletsByCoin()
+			require.NoError(t, err)
+			require.Equal(t, expect, wlts)
+
+			// The wallets are not loaded
+			for _, lw := range s.lazyWallets {
+				require.Nil(t, lw.w)
+			}
+
+			_, err = s.CreateWallet("d.wlt", Options{
+				Seed: "seed-d.wlt",
+				Coin: CoinTypeBitcoin,
+			}, nil)
+			require.NoError(t, err)
+			wlts, err = s.ListWalletsByCoin()
+			require.NoError(t, err)
+			require.Equal(t, []string{"d.wlt"}, wlts[CoinTypeBitcoin])
+			require.NoError(t, os.Remove(filepath.Join(dir, "d.wlt")))
+
+			s.config.EnableWalletAPI = false
+			_, err = s.ListWalletsByCoin()
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceTestEncrypt(t *testing.T) {
+	for ct := range cryptoTable {
+		t.Run(string(ct), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      ct,
+				EnableWalletAPI: true,
+			})
+			require.NoError(t, err)
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:      "seed",
+				GenerateN: 3,
+			}, nil)
+			require.NoError(t, err)
+
+			fn := filepath.Join(dir, "t.wlt")
+			b, err := ioutil.ReadFile(fn)
+			require.NoError(t, err)
+
+			require.NoError(t, s.TestEncrypt("t.wlt", []byte("pwd")))
+
+			// The wallet is not changed
+			w2, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.False(t, w2.IsEncrypted())
+			require.Equal(t, w, w2)
+			b2, err := ioutil.ReadFile(fn)
+			require.NoError(t, err)
+			require.Equal(t, b, b2)
+
+			require.Equal(t, ErrMissingPassword, s.TestEncrypt("t.wlt", nil))
+			require.Equal(t, ErrWalletNotExist, s.TestEncrypt("foo.wlt", []byte("pwd")))
+
+			_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			require.Equal(t, ErrWalletEncrypted, s.TestEncrypt("t.wlt", []byte("pwd")))
+
+			_, err = s.CreateWallet("r.wlt", Options{
+				Seed: "seed2",
+			}, nil)
+			require.NoError(t, err)
+			require.NoError(t, s.SetWalletReadOnly("r.wlt", true))
+			require.Equal(t, ErrWalletReadOnly, s.TestEncrypt("r.wlt", []byte("pwd")))
+
+			s.config.EnableWalletAPI = false
+			require.Equal(t, ErrWalletAPIDisabled, s.TestEncrypt("t.wlt", []byte("pwd")))
+		})
+	}
+}
+
+func TestServicePasswordValidator(t *testing.T) {
+	errWeak := errors.New("password is too weak")
+	var validated [][]byte
+	s, err := NewService(Config{
+		WalletDir:       prepareWltDir(),
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+		PasswordValidator: func(password []byte) error {
+			validated = append(validated, password)
+			if len(password) < 8 {
+				return errWeak
+			}
+			return nil
+		},
+	})
+	require.NoError(t, err)
+
+	// CreateWallet
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.Equal(t, errWeak, err)
+	_, err = s.GetWallet("t.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("password"),
+	}, nil)
+	require.NoError(t, err)
+	require.True(t, w.IsEncrypted())
+
+	// Unencrypted wallets have no password to validate
+	_, err = s.CreateWallet("t2.wlt", Options{
+		Seed: "seed2",
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("pwd"), []byte("password")}, validated)
+
+	// EncryptWallet and TestEncrypt
+	require.Equal(t, errWeak, s.TestEncrypt("t2.wlt", []byte("pwd")))
+	require.NoError(t, s.TestEncrypt("t2.wlt", []byte("password")))
+	_, err = s.EncryptWallet("t2.wlt", []byte("pwd"))
+	require.Equal(t, errWeak, err)
+	w, err = s.GetWallet("t2.wlt")
+	require.NoError(t, err)
+	require.False(t, w.IsEncrypted())
+	_, err = s.EncryptWallet("t2.wlt", []byte("password"))
+	require.NoError(t, err)
+
+	// RecoverWallet with a new password
+	_, err = s.RecoverWallet("t.wlt", "seed", []byte("pwd"))
+	require.Equal(t, errWeak, err)
+	_, err = s.RecoverWallet("t.wlt", "seed", []byte("password2"))
+	require.NoError(t, err)
+
+	// Existing passwords are not validated
+	validated = nil
+	_, err = s.DecryptWallet("t.wlt", []byte("password2"))
+	require.NoError(t, err)
+	_, err = s.RecoverWallet("t2.wlt", "seed2", nil)
+	require.NoError(t, err)
+	require.Empty(t, validated)
+}
+
+func TestServiceGetWalletVersion(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			b, err := ioutil.ReadFile("./testdata/test1.wlt")
+			require.NoError(t, err)
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "test1.wlt"), b, 0600))
+
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			v, err := s.GetWalletVersion("test1.wlt")
+			require.NoError(t, err)
+			require.Equal(t, "0.1", v)
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed: "seed",
+			}, nil)
+			require.NoError(t, err)
+			v, err = s.GetWalletVersion("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, Version, v)
+
+			// Encrypting a wallet upgrades its file to the current version
+			_, err = s.EncryptWallet("test1.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			v, err = s.GetWalletVersion("test1.wlt")
+			require.NoError(t, err)
+			require.Equal(t, Version, v)
+			w, err := Load(filepath.Join(dir, "test1.wlt"))
+			require.NoError(t, err)
+			require.Equal(t, Version, w.Version())
+
+			_, err = s.GetWalletVersion("foo.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.GetWalletVersion("t.wlt")
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceGetAddressIndex(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:      "seed",
+				GenerateN: 5,
+			}, nil)
+			require.NoError(t, err)
+
+			for i, e := range w.Entries {
+				idx, err := s.GetAddressIndex("t.wlt", e.SkycoinAddress())
+				require.NoError(t, err)
+				require.Equal(t, uint64(i), idx)
+			}
+
+			_, err = s.GetAddressIndex("t.wlt", testutil.MakeAddress())
+			require.Equal(t, ErrEntryNotFound, err)
+
+			_, err = s.GetAddressIndex("foo.wlt", w.Entries[0].SkycoinAddress())
+			require.Equal(t, ErrWalletNotExist, err)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.GetAddressIndex("t.wlt", w.Entries[0].SkycoinAddress())
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceAddressBelongsTo(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			w1, err := s.CreateWallet("t1.wlt", Options{
+				Seed:      "seed1",
+				GenerateN: 3,
+			}, nil)
+			require.NoError(t, err)
+			w2, err := s.CreateWallet("t2.wlt", Options{
+				Seed: "seed2",
+			}, nil)
+			require.NoError(t, err)
+
+			for _, e := range w1.Entries {
+				ok, err := s.AddressBelongsTo("t1.wlt", e.SkycoinAddress())
+				require.NoError(t, err)
+				require.True(t, ok)
+			}
+
+			// An address of another wallet
+			ok, err := s.AddressBelongsTo("t1.wlt", w2.Entries[0].SkycoinAddress())
+			require.NoError(t, err)
+			require.False(t, ok)
+
+			ok, err = s.AddressBelongsTo("t1.wlt", testutil.MakeAddress())
+			require.NoError(t, err)
+			require.False(t, ok)
+
+			_, err = s.AddressBelongsTo("foo.wlt", w1.Entries[0].SkycoinAddress())
+			require.Equal(t, ErrWalletNotExist, err)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.AddressBelongsTo("t1.wlt", w1.Entries[0].SkycoinAddress())
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceUnlockCache(t *testing.T) {
+	for ct := range cryptoTable {
+		t.Run(string(ct), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      ct,
+				EnableWalletAPI: true,
+				UnlockCacheTTL:  time.Hour,
+			})
+			require.NoError(t, err)
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed:     "seed",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+
+			var seed string
+			view := func(password string) error {
+				return s.ViewSecrets("t.wlt", []byte(password), func(w *Wallet) error {
+					seed = w.seed()
+					return nil
+				})
+			}
+
+			// The decrypted secrets are kept in the default in-memory store
+			store := s.unlockCache.store.(*memorySecretStore)
+			cachedSeed := func(wltID string) string {
+				sb, err := store.Get(wltID)
+				if err == ErrSecretsNotFound {
+					return ""
+				}
+				require.NoError(t, err)
+				ss := make(secrets)
+				require.NoError(t, ss.deserialize(sb))
+				return ss[secretSeed]
+			}
+
+			require.NoError(t, view("pwd"))
+			require.Equal(t, "seed", seed)
+			require.Len(t, s.unlockCache.entries, 1)
+			require.Equal(t, "seed", cachedSeed("t.wlt"))
+
+			// Mark the cached secrets to detect cache hits
+			ss := make(secrets)
+			require.NoError(t, ss.deserialize(store.secrets["t.wlt"]))
+			ss.set(secretSeed, "cached")
+			sb, err := ss.serialize()
+			require.NoError(t, err)
+			require.NoError(t, store.Put("t.wlt", sb))
+			require.NoError(t, view("pwd"))
+			require.Equal(t, "cached", seed)
+
+			// The copy passed to f is erased, not the cached secrets
+			require.Equal(t, "cached", cachedSeed("t.wlt"))
+
+			// A wrong password is not served from the cache
+			require.Equal(t, ErrInvalidPassword, view("wrong"))
+			require.Equal(t, ErrMissingPassword, view(""))
+			require.Equal(t, "cached", cachedSeed("t.wlt"))
+
+			// Changing the wallet's secrets invalidates the entry, and the stale secrets are erased
+			cached := store.secrets["t.wlt"]
+			_, err = s.NewAddresses("t.wlt", []byte("pwd"), 1)
+			require.NoError(t, err)
+			require.NoError(t, view("pwd"))
+			require.Equal(t, "seed", seed)
+			require.Equal(t, "seed", cachedSeed("t.wlt"))
+			require.Equal(t, make([]byte, len(cached)), cached)
+
+			// Decrypting the wallet removes the entry
+			_, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			require.Empty(t, s.unlockCache.entries)
+
+			_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			require.NoError(t, view("pwd"))
+			require.Len(t, s.unlockCache.entries, 1)
+
+			// Unloading the wallet removes the entry
+			require.NoError(t, s.UnloadWallet("t.wlt"))
+			require.Empty(t, s.unlockCache.entries)
+
+			// Close erases all entries
+			_, err = s.CreateWallet("t2.wlt", Options{
+				Seed:     "seed2",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+			require.NoError(t, s.ViewSecrets("t2.wlt", []byte("pwd"), func(w *Wallet) error {
+				return nil
+			}))
+			cached = store.secrets["t2.wlt"]
+			require.NoError(t, s.Close())
+			require.Empty(t, s.unlockCache.entries)
+			require.Empty(t, store.secrets)
+			require.Equal(t, make([]byte, len(cached)), cached)
+		})
+	}
+}
+
+func TestServiceUnlockCacheExpiry(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+		UnlockCacheTTL:  50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, s.ViewSecrets("t.wlt", []byte("pwd"), func(w *Wallet) error {
+		return nil
+	}))
+
+	store := s.unlockCache.store.(*memorySecretStore)
+	s.unlockCache.Lock()
+	require.NotNil(t, s.unlockCache.entries["t.wlt"])
+	s.unlockCache.Unlock()
+	store.Lock()
+	cached := store.secrets["t.wlt"]
+	store.Unlock()
+	require.NotEmpty(t, cached)
+
+	time.Sleep(200 * time.Millisecond)
+
+	s.unlockCache.Lock()
+	defer s.unlockCache.Unlock()
+	require.Empty(t, s.unlockCache.entries)
+	store.Lock()
+	defer store.Unlock()
+	require.Empty(t, store.secrets)
+	require.Equal(t, make([]byte, len(cached)), cached)
+}
+
+// recordingSecretStore is a SecretStore recording its calls, and failing them if err is set
+type recordingSecretStore struct {
+	SecretStore
+	calls []string
+	err   error
+}
+
+func (s *recordingSecretStore) Put(wltID string, data []byte) error {
+	s.calls = append(s.calls, "put "+wltID)
+	if s.err != nil {
+		return s.err
+	}
+	return s.SecretStore.Put(wltID, data)
+}
+
+func (s *recordingSecretStore) Get(wltID string) ([]byte, error) {
+	s.calls = append(s.calls, "get "+wltID)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.SecretStore.Get(wltID)
+}
+
+func (s *recordingSecretStore) Erase(wltID string) error {
+	s.calls = append(s.calls, "erase "+wltID)
+	if s.err != nil {
+		return s.err
+	}
+	return s.SecretStore.Erase(wltID)
+}
+
+func TestServiceUnlockCacheSecretStore(t *testing.T) {
+	store := &recordingSecretStore{
+		SecretStore: NewMemorySecretStore(),
+	}
+
+	s, err := NewService(Config{
+		WalletDir:       prepareWltDir(),
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+		UnlockCacheTTL:  time.Hour,
+		SecretStore:     store,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	var seed string
+	view := func() error {
+		seed = ""
+		return s.ViewSecrets("t.wlt", []byte("pwd"), func(w *Wallet) error {
+			seed = w.seed()
+			return nil
+		})
+	}
+
+	require.NoError(t, view())
+	require.Equal(t, "seed", seed)
+	require.NoError(t, view())
+	require.Equal(t, "seed", seed)
+	require.NoError(t, s.UnloadWallet("t.wlt"))
+	require.Equal(t, []string{"put t.wlt", "get t.wlt", "erase t.wlt"}, store.calls)
+
+	sb, err := store.SecretStore.Get("t.wlt")
+	require.Equal(t, ErrSecretsNotFound, err)
+	require.Nil(t, sb)
+
+	// Failures of the store only disable caching
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	store.calls = nil
+	store.err = errors.New("store failed")
+	require.NoError(t, view())
+	require.Equal(t, "seed", seed)
+	require.Empty(t, s.unlockCache.entries)
+
+	store.err = nil
+	require.NoError(t, view())
+	store.err = errors.New("store failed")
+	require.NoError(t, view())
+	require.Equal(t, "seed", seed)
+	require.Equal(t, []string{"put t.wlt", "put t.wlt", "get t.wlt", "erase t.wlt", "put t.wlt"}, store.calls)
+}
+
+func TestServiceGuardSecretStore(t *testing.T) {
+	store := &recordingSecretStore{
+		SecretStore: NewMemorySecretStore(),
+	}
+
+	s, err := NewService(Config{
+		WalletDir:       prepareWltDir(),
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+		SecretStore:     store,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+	require.Empty(t, store.calls)
+
+	requireErased := func() {
+		_, err := store.SecretStore.Get("t.wlt")
+		require.Equal(t, ErrSecretsNotFound, err)
+	}
+
+	// The secrets are held by the store while a plain GuardView runs
+	w, err := s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.NoError(t, w.GuardView([]byte("pwd"), func(w *Wallet) error {
+		require.Equal(t, []string{"put t.wlt", "get t.wlt"}, store.calls)
+		sb, err := store.SecretStore.Get("t.wlt")
+		require.NoError(t, err)
+		require.NotEmpty(t, sb)
+		require.Equal(t, "seed", w.seed())
+		return nil
+	}))
+	require.Equal(t, []string{"put t.wlt", "get t.wlt", "erase t.wlt"}, store.calls)
+	requireErased()
+
+	// The stored secrets are erased when the function fails
+	store.calls = nil
+	fErr := errors.New("failed")
+	require.Equal(t, fErr, w.GuardUpdate([]byte("pwd"), func(w *Wallet) error {
+		return fErr
+	}))
+	require.Equal(t, []string{"put t.wlt", "get t.wlt", "erase t.wlt"}, store.calls)
+	requireErased()
+
+	// ViewSecrets and UpdateSecrets
+	store.calls = nil
+	require.NoError(t, s.ViewSecrets("t.wlt", []byte("pwd"), func(w *Wallet) error {
+		return nil
+	}))
+	addrs, err := s.NewAddresses("t.wlt", []byte("pwd"), 1)
+	require.NoError(t, err)
+	require.Len(t, addrs, 1)
+	require.Equal(t, []string{
+		"put t.wlt", "get t.wlt", "erase t.wlt",
+		"put t.wlt", "get t.wlt", "erase t.wlt",
+	}, store.calls)
+	requireErased()
+
+	// Nothing is stored with a wrong password
+	store.calls = nil
+	require.Equal(t, ErrInvalidPassword, s.ViewSecrets("t.wlt", []byte("wrong"), func(w *Wallet) error {
+		return nil
+	}))
+	require.Empty(t, store.calls)
+
+	// The wallet is not unlocked if the store fails
+	store.err = errors.New("store failed")
+	require.Equal(t, store.err, s.ViewSecrets("t.wlt", []byte("pwd"), func(w *Wallet) error {
+		t.Fatal("unlocked without the store")
+		return nil
+	}))
+	store.err = nil
+
+	// Concurrent guarded calls of the same wallet share the stored secrets
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, s.ViewSecrets("t.wlt", []byte("pwd"), func(w *Wallet) error {
+				if w.seed() != "seed" {
+					return errors.New("wrong seed")
+				}
+				return nil
+			}))
+		}()
+	}
+	wg.Wait()
+	requireErased()
+}
+
+func TestServiceGuardSecretStoreUnlockCache(t *testing.T) {
+	store := &recordingSecretStore{
+		SecretStore: NewMemorySecretStore(),
+	}
+
+	s, err := NewService(Config{
+		WalletDir:       prepareWltDir(),
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+		UnlockCacheTTL:  time.Hour,
+		SecretStore:     store,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, s.ViewSecrets("t.wlt", []byte("pwd"), func(w *Wallet) error {
+		return nil
+	}))
+
+	// A guarded call doesn't erase the secrets cached by ViewSecrets
+	_, err = s.NewAddresses("t.wlt", []byte("pwd"), 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"put t.wlt", "put t.wlt", "get t.wlt"}, store.calls)
+	_, err = store.SecretStore.Get("t.wlt")
+	require.NoError(t, err)
+
+	require.NoError(t, s.UnloadWallet("t.wlt"))
+	require.Equal(t, []string{"put t.wlt", "put t.wlt", "get t.wlt", "erase t.wlt"}, store.calls)
+	_, err = store.SecretStore.Get("t.wlt")
+	require.Equal(t, ErrSecretsNotFound, err)
+}
+
+func TestServiceUnlockCacheDisabled(t *testing.T) {
+	s, err := NewService(Config{
+		WalletDir:       prepareWltDir(),
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+	require.Nil(t, s.unlockCache)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, s.ViewSecrets("t.wlt", []byte("pwd"), func(w *Wallet) error {
+		require.Equal(t, "seed", w.seed())
+		return nil
+	}))
+	require.Equal(t, ErrMissingPassword, s.ViewSecrets("t.wlt", nil, func(w *Wallet) error {
+		return nil
+	}))
+	require.NoError(t, s.Close())
+}
+
+func TestServiceSaveDebounce(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+				SaveDebounce:    time.Hour,
+			})
+			require.NoError(t, err)
+
+			fn := filepath.Join(dir, "t.wlt")
+			loadEntries := func() int {
+				w, err := Load(fn)
+				require.NoError(t, err)
+				return len(w.Entries)
+			}
+
+			// New wallets are written immediately
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed: "seed",
+			}, nil)
+			require.NoError(t, err)
+			require.Equal(t, 1, loadEntries())
+			require.Empty(t, s.pendingSaves)
+
+			// Changes are coalesced into a single pending save
+			for i := 0; i < 3; i++ {
+				_, err = s.NewAddresses("t.wlt", nil, 1)
+				require.NoError(t, err)
+			}
+			require.NoError(t, s.UpdateWalletLabel("t.wlt", "foo"))
+			require.Len(t, s.pendingSaves, 1)
+			require.Equal(t, 1, loadEntries())
+
+			// Reads see the latest changes
+			w, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.Len(t, w.Entries, 4)
+			require.Equal(t, "foo", w.Label())
+
+			changed, err := s.WalletFileChanged("t.wlt")
+			require.NoError(t, err)
+			require.False(t, changed)
+
+			require.NoError(t, s.Flush())
+			require.Empty(t, s.pendingSaves)
+			require.Equal(t, 4, loadEntries())
+			w2, err := Load(fn)
+			require.NoError(t, err)
+			require.Equal(t, "foo", w2.Label())
+
+			changed, err = s.WalletFileChanged("t.wlt")
+			require.NoError(t, err)
+			require.False(t, changed)
+
+			// Encrypting a wallet writes it immediately, including the pending changes
+			_, err = s.NewAddresses("t.wlt", nil, 1)
+			require.NoError(t, err)
+			require.Len(t, s.pendingSaves, 1)
+			_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			require.Empty(t, s.pendingSaves)
+			w2, err = Load(fn)
+			require.NoError(t, err)
+			require.True(t, w2.IsEncrypted())
+			require.Len(t, w2.Entries, 5)
+
+			_, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			require.Empty(t, s.pendingSaves)
+			w2, err = Load(fn)
+			require.NoError(t, err)
+			require.False(t, w2.IsEncrypted())
+
+			// Close writes the pending changes
+			_, err = s.NewAddresses("t.wlt", nil, 1)
+			require.NoError(t, err)
+			require.Equal(t, 5, loadEntries())
+			require.NoError(t, s.Close())
+			require.Empty(t, s.pendingSaves)
+			require.Equal(t, 6, loadEntries())
+
+			// Unloading a wallet writes its pending changes
+			_, err = s.NewAddresses("t.wlt", nil, 1)
+			require.NoError(t, err)
+			require.NoError(t, s.UnloadWallet("t.wlt"))
+			require.Empty(t, s.pendingSaves)
+			require.Equal(t, 7, loadEntries())
+		})
+	}
+}
+
+func TestServiceSaveDebounceTimer(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+		SaveDebounce:    50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed: "seed",
+	}, nil)
+	require.NoError(t, err)
+
+	_, err = s.NewAddresses("t.wlt", nil, 2)
+	require.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+
+	s.Lock()
+	require.Empty(t, s.pendingSaves)
+	s.Unlock()
+
+	w, err := Load(filepath.Join(dir, "t.wlt"))
+	require.NoError(t, err)
+	require.Len(t, w.Entries, 3)
+}
+
+func TestServiceSaveDebounceEvict(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:        dir,
+		CryptoType:       CryptoTypeSha256Xor,
+		EnableWalletAPI:  true,
+		MaxCachedWallets: 1,
+		SaveDebounce:     time.Hour,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t1.wlt", Options{
+		Seed: "seed1",
+	}, nil)
+	require.NoError(t, err)
+	_, err = s.CreateWallet("t2.wlt", Options{
+		Seed: "seed2",
+	}, nil)
+	require.NoError(t, err)
+
+	_, err = s.NewAddresses("t1.wlt", nil, 2)
+	require.NoError(t, err)
+	require.Len(t, s.pendingSaves, 1)
+
+	// Evicting t1.wlt from memory writes its pending changes
+	_, err = s.GetWallet("t2.wlt")
+	require.NoError(t, err)
+	require.Empty(t, s.pendingSaves)
+
+	w, err := s.GetWallet("t1.wlt")
+	require.NoError(t, err)
+	require.Len(t, w.Entries, 3)
+}
+
+func TestServiceConcurrentReadsEvict(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:        dir,
+		CryptoType:       CryptoTypeSha256Xor,
+		EnableWalletAPI:  true,
+		MaxCachedWallets: 1,
+		SaveDebounce:     time.Hour,
+	})
+	require.NoError(t, err)
+
+	ids := []string{"t1.wlt", "t2.wlt", "t3.wlt"}
+	for i, id := range ids {
+		_, err = s.CreateWallet(id, Options{
+			Seed: fmt.Sprintf("seed%d", i),
+		}, nil)
+		require.NoError(t, err)
+		_, err = s.NewAddresses(id, nil, 2)
+		require.NoError(t, err)
+	}
+
+	// Reads evict wallets and write their pending saves after releasing the read lock
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w, err := s.GetWallet(ids[i%len(ids)])
+			require.NoError(t, err)
+			require.Len(t, w.Entries, 3)
+		}(i)
+	}
+	wg.Wait()
+
+	s.Lock()
+	defer s.Unlock()
+	require.False(t, s.cache.hasEvicted())
+	for _, id := range ids {
+		if _, ok := s.pendingSaves[id]; ok {
+			require.NotNil(t, s.lazyWallets[id].w)
+		}
+	}
+}
+
+func TestServiceGetWalletCoinHours(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 3,
+	}, nil)
+	require.NoError(t, err)
+
+	bg := &countingBalanceGetter{
+		mockBalanceGetter: mockBalanceGetter{
+			w.Entries[0].SkycoinAddress(): BalancePair{
+				Confirmed: NewBalance(1e6, 10),
+				Predicted: NewBalance(1e6, 15),
+			},
+			w.Entries[2].SkycoinAddress(): BalancePair{
+				Confirmed: NewBalance(2e6, 20),
+				Predicted: NewBalance(1e6, 5),
+			},
+		},
+	}
+
+	confirmed, predicted, err := s.GetWalletCoinHours("t.wlt", bg)
+	require.NoError(t, err)
+	require.Equal(t, uint64(30), confirmed)
+	require.Equal(t, uint64(20), predicted)
+	require.Equal(t, 1, bg.calls)
+
+	// Overflow
+	_, _, err = s.GetWalletCoinHours("t.wlt", mockBalanceGetter{
+		w.Entries[0].SkycoinAddress(): BalancePair{
+			Confirmed: NewBalance(0, math.MaxUint64),
+		},
+		w.Entries[1].SkycoinAddress(): BalancePair{
+			Confirmed: NewBalance(0, 1),
+		},
+	})
+	require.Equal(t, mathutil.ErrUint64AddOverflow, err)
+
+	testErr := errors.New("balance failed")
+	_, _, err = s.GetWalletCoinHours("t.wlt", errBalanceGetter{testErr})
+	require.Equal(t, testErr, err)
+
+	_, _, err = s.GetWalletCoinHours("foo.wlt", bg)
+	require.Equal(t, ErrWalletNotExist, err)
+
+	s.config.EnableWalletAPI = false
+	_, _, err = s.GetWalletCoinHours("t.wlt", bg)
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceGetWalletBalance(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 3,
+	}, nil)
+	require.NoError(t, err)
+
+	bg := &countingBalanceGetter{
+		mockBalanceGetter: mockBalanceGetter{
+			w.Entries[0].SkycoinAddress(): BalancePair{
+				Confirmed: NewBalance(1e6, 10),
+				Predicted: NewBalance(1e6, 15),
+			},
+			w.Entries[2].SkycoinAddress(): BalancePair{
+				Confirmed: NewBalance(2e6, 20),
+				Predicted: NewBalance(1e6, 5),
+			},
+		},
+	}
+
+	total, addrBals, err := s.GetWalletBalance("t.wlt", bg)
+	require.NoError(t, err)
+	require.Equal(t, BalancePair{
+		Confirmed: NewBalance(3e6, 30),
+		Predicted: NewBalance(2e6, 20),
+	}, total)
+	require.Equal(t, []AddressBalance{
+		{Address: w.Entries[0].SkycoinAddress(), Balance: bg.mockBalanceGetter[w.Entries[0].SkycoinAddress()]},
+		{Address: w.Entries[1].SkycoinAddress()},
+		{Address: w.Entries[2].SkycoinAddress(), Balance: bg.mockBalanceGetter[w.Entries[2].SkycoinAddress()]},
+	}, addrBals)
+	require.Equal(t, 1, bg.calls)
+
+	// Overflow
+	_, _, err = s.GetWalletBalance("t.wlt", mockBalanceGetter{
+		w.Entries[0].SkycoinAddress(): BalancePair{
+			Confirmed: NewBalance(math.MaxUint64, 0),
+		},
+		w.Entries[1].SkycoinAddress(): BalancePair{
+			Confirmed: NewBalance(1, 0),
+		},
+	})
+	require.Equal(t, mathutil.ErrUint64AddOverflow, err)
+
+	testErr := errors.New("balance failed")
+	total, addrBals, err = s.GetWalletBalance("t.wlt", errBalanceGetter{testErr})
+	require.Equal(t, testErr, err)
+	require.Equal(t, BalancePair{}, total)
+	require.Nil(t, addrBals)
+
+	// The balance getter is not called for an empty wallet
+	require.NoError(t, s.UpdateSecrets("t.wlt", nil, func(w *Wallet) error {
+		w.Entries = nil
+		return nil
+	}))
+	bg.calls = 0
+	total, addrBals, err = s.GetWalletBalance("t.wlt", bg)
+	require.NoError(t, err)
+	require.Equal(t, BalancePair{}, total)
+	require.Empty(t, addrBals)
+	require.Equal(t, 0, bg.calls)
+
+	_, _, err = s.GetWalletBalance("foo.wlt", bg)
+	require.Equal(t, ErrWalletNotExist, err)
+
+	s.config.EnableWalletAPI = false
+	_, _, err = s.GetWalletBalance("t.wlt", bg)
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceReloadWallet(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+				SaveDebounce:    time.Hour,
+			})
+			require.NoError(t, err)
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed: "seed",
+			}, nil)
+			require.NoError(t, err)
+
+			// The wallet file is changed by another program
+			w, err := NewWallet("t.wlt", Options{
+				Seed:      "seed",
+				Label:     "foo",
+				GenerateN: 3,
+			})
+			require.NoError(t, err)
+			require.NoError(t, w.Save(dir))
+
+			changed, err := s.WalletFileChanged("t.wlt")
+			require.NoError(t, err)
+			require.True(t, changed)
+
+			// Pending changes are discarded
+			_, err = s.NewAddresses("t.wlt", nil, 5)
+			require.NoError(t, err)
+			require.Len(t, s.pendingSaves, 1)
+
+			w2, err := s.ReloadWallet("t.wlt")
+			require.NoError(t, err)
+			require.Len(t, w2.Entries, 3)
+			require.Equal(t, "foo", w2.Label())
+			require.Empty(t, s.pendingSaves)
+			w2, err = s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.Len(t, w2.Entries, 3)
+			require.Equal(t, "foo", w2.Label())
+
+			changed, err = s.WalletFileChanged("t.wlt")
+			require.NoError(t, err)
+			require.False(t, changed)
+
+			// A wallet file that is not loaded yet is loaded
+			w, err = NewWallet("t2.wlt", Options{
+				Seed: "seed2",
+			})
+			require.NoError(t, err)
+			require.NoError(t, w.Save(dir))
+			_, err = s.GetWallet("t2.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			w2, err = s.ReloadWallet("t2.wlt")
+			require.NoError(t, err)
+			require.Equal(t, w.Entries, w2.Entries)
+			w2, err = s.GetWallet("t2.wlt")
+			require.NoError(t, err)
+			require.Equal(t, w.Entries, w2.Entries)
+			require.Equal(t, "t2.wlt", s.firstAddrIDMap[w.Entries[0].Address.String()])
+
+			// A wallet file with the seed of another wallet is not loaded
+			w, err = NewWallet("t3.wlt", Options{
+				Seed: "seed2",
+			})
+			require.NoError(t, err)
+			require.NoError(t, w.Save(dir))
+			_, err = s.ReloadWallet("t3.wlt")
+			require.Equal(t, ErrSeedUsed, err)
+			_, err = s.GetWallet("t3.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			// A wallet whose file was deleted is unloaded
+			addr := w.Entries[0].Address.String()
+			require.NoError(t, os.Remove(filepath.Join(dir, "t2.wlt")))
+			w2, err = s.ReloadWallet("t2.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+			require.Nil(t, w2)
+			_, err = s.GetWallet("t2.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+			_, ok := s.firstAddrIDMap[addr]
+			require.False(t, ok)
+
+			for _, id := range []string{"t2.wlt", "foo.wlt", "../t.wlt"} {
+				_, err = s.ReloadWallet(id)
+				require.Equal(t, ErrWalletNotExist, err)
+			}
+
+			s.config.EnableWalletAPI = false
+			_, err = s.ReloadWallet("t.wlt")
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceReloadWalletFirstAddress(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:           dir,
+				CryptoType:          CryptoTypeSha256Xor,
+				EnableWalletAPI:     true,
+				LazyLoad:            lazyLoad,
+				AllowDuplicateSeeds: true,
+				AutoReencryptAfter:  time.Hour,
+			})
+			require.NoError(t, err)
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:     "seed",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+			oldAddr := w.Entries[0].Address.String()
+
+			_, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			require.Len(t, s.pendingReencrypts, 1)
+
+			// The wallet file is replaced by a wallet of another seed
+			w, err = NewWallet("t.wlt", Options{
+				Seed: "seed2",
+			})
+			require.NoError(t, err)
+			require.NoError(t, w.Save(dir))
+
+			w2, err := s.ReloadWallet("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, w.Entries, w2.Entries)
+			require.Empty(t, s.pendingReencrypts)
+
+			// The old first address no longer belongs to any wallet
+			_, ok := s.firstAddrIDMap[oldAddr]
+			require.False(t, ok)
+			require.Equal(t, "t.wlt", s.firstAddrIDMap[w.Entries[0].Address.String()])
+		})
+	}
+}
+
+func TestServiceGetMultiWalletBalances(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	w1, err := s.CreateWallet("t1.wlt", Options{
+		Seed:      "seed1",
+		GenerateN: 2,
+	}, nil)
+	require.NoError(t, err)
+	w2, err := s.CreateWallet("t2.wlt", Options{
+		Seed: "seed2",
+	}, nil)
+	require.NoError(t, err)
+	w3, err := s.CreateWallet("t3.wlt", Options{
+		Seed: "seed3",
+	}, nil)
+	require.NoError(t, err)
+	_, err = s.CreateWallet("b.wlt", Options{
+		Seed: "seed4",
+		Coin: CoinTypeBitcoin,
+	}, nil)
+	require.NoError(t, err)
+
+	bg := &countingBalanceGetter{
+		mockBalanceGetter: mockBalanceGetter{
+			w1.Entries[0].SkycoinAddress(): BalancePair{
+				Confirmed: NewBalance(1e6, 10),
+				Predicted: NewBalance(1e6, 10),
+			},
+			w1.Entries[1].SkycoinAddress(): BalancePair{
+				Confirmed: NewBalance(2e6, 20),
+				Predicted: NewBalance(1e6, 5),
+			},
+			w2.Entries[0].SkycoinAddress(): BalancePair{
+				Confirmed: NewBalance(4e6, 40),
+				Predicted: NewBalance(4e6, 40),
+			},
+			w3.Entries[0].SkycoinAddress(): BalancePair{
+				Confirmed: NewBalance(8e6, 80),
+				Predicted: NewBalance(8e6, 80),
+			},
+		},
+	}
+
+	bals, err := s.GetMultiWalletBalances([]string{"t1.wlt", "t2.wlt", "t1.wlt"}, bg)
+	require.NoError(t, err)
+	require.Equal(t, 1, bg.calls)
+	require.Equal(t, map[string]BalancePair{
+		"t1.wlt": {
+			Confirmed: NewBalance(3e6, 30),
+			Predicted: NewBalance(2e6, 15),
+		},
+		"t2.wlt": {
+			Confirmed: NewBalance(4e6, 40),
+			Predicted: NewBalance(4e6, 40),
+		},
+	}, bals)
+
+	// Unknown and non-Skycoin wallets are reported per wallet
+	bals, err = s.GetMultiWalletBalances([]string{"t2.wlt", "foo.wlt", "b.wlt"}, bg)
+	require.Equal(t, map[string]BalancePair{
+		"t2.wlt": {
+			Confirmed: NewBalance(4e6, 40),
+			Predicted: NewBalance(4e6, 40),
+		},
+	}, bals)
+	wltErrs, ok := err.(WalletErrors)
+	require.True(t, ok)
+	require.Len(t, wltErrs, 2)
+	require.Equal(t, ErrWalletNotExist, wltErrs["foo.wlt"])
+	require.Error(t, wltErrs["b.wlt"])
+	require.Equal(t, fmt.Sprintf("b.wlt: %v; foo.wlt: %v", wltErrs["b.wlt"], ErrWalletNotExist), err.Error())
+
+	bals, err = s.GetMultiWalletBalances(nil, bg)
+	require.NoError(t, err)
+	require.Empty(t, bals)
+
+	testErr := errors.New("balance failed")
+	_, err = s.GetMultiWalletBalances([]string{"t1.wlt"}, errBalanceGetter{testErr})
+	require.Equal(t, testErr, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.GetMultiWalletBalances([]string{"t1.wlt"}, bg)
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServicePbkdf2CryptoType(t *testing.T) {
+	ct, err := CryptoTypeFromString("pbkdf2-chacha20poly1305")
+	require.NoError(t, err)
+	require.Equal(t, CryptoTypePbkdf2Chacha20poly1305, ct)
+
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+		EnableSeedAPI:   true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("scrypt.wlt", Options{
+		Seed:     "seed1",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	// Wallets encrypted with scrypt and PBKDF2 coexist
+	s, err = NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypePbkdf2Chacha20poly1305,
+		EnableWalletAPI: true,
+		EnableSeedAPI:   true,
+	})
+	require.NoError(t, err)
+
+	w, err := s.CreateWallet("pbkdf2.wlt", Options{
+		Seed:     "seed2",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, CryptoTypePbkdf2Chacha20poly1305, w.cryptoType())
+
+	_, err = s.CreateWallet("plain.wlt", Options{
+		Seed: "seed3",
+	}, nil)
+	require.NoError(t, err)
+	w, err = s.EncryptWallet("plain.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	require.Equal(t, CryptoTypePbkdf2Chacha20poly1305, w.cryptoType())
+
+	for id, seed := range map[string]string{
+		"scrypt.wlt": "seed1",
+		"pbkdf2.wlt": "seed2",
+		"plain.wlt":  "seed3",
+	} {
+		s2, err := s.GetWalletSeed(id, []byte("pwd"))
+		require.NoError(t, err)
+		require.Equal(t, seed, s2)
+	}
+
+	_, err = s.GetWalletSeed("pbkdf2.wlt", []byte("wrong"))
+	require.Equal(t, ErrInvalidPassword, err)
+
+	// The iterations are stored in the wallet, so changing them doesn't break existing wallets
+	defer func(c cryptor) {
+		cryptoTable[CryptoTypePbkdf2Chacha20poly1305] = c
+	}(cryptoTable[CryptoTypePbkdf2Chacha20poly1305])
+	cryptoTable[CryptoTypePbkdf2Chacha20poly1305] = encrypt.Pbkdf2Chacha20poly1305{
+		Iterations: 2000,
+		KeyLen:     encrypt.Pbkdf2KeyLen,
+	}
+	seed, err := s.GetWalletSeed("pbkdf2.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	require.Equal(t, "seed2", seed)
+}
+
+func TestServiceDiffAgainstDisk(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			b, err := ioutil.ReadFile("./testdata/test1.wlt")
+			require.NoError(t, err)
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "test1.wlt"), b, 0600))
+
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+				SaveDebounce:    time.Hour,
+			})
+			require.NoError(t, err)
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed:     "seed",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+
+			diff, err := s.DiffAgainstDisk("test1.wlt")
+			require.NoError(t, err)
+			require.False(t, diff)
+
+			diff, err = s.DiffAgainstDisk("t.wlt")
+			require.NoError(t, err)
+			require.False(t, diff)
+
+			// Pending saves
+			require.NoError(t, s.UpdateWalletLabel("t.wlt", "foo"))
+			diff, err = s.DiffAgainstDisk("t.wlt")
+			require.NoError(t, err)
+			require.True(t, diff)
+
+			require.NoError(t, s.Flush())
+			diff, err = s.DiffAgainstDisk("t.wlt")
+			require.NoError(t, err)
+			require.False(t, diff)
+
+			// The wallet file is changed by another program
+			fn := filepath.Join(dir, "t.wlt")
+			rw, err := LoadReadableWallet(fn)
+			require.NoError(t, err)
+			rw.Meta[metaLabel] = "bar"
+			require.NoError(t, rw.Save(fn))
+			diff, err = s.DiffAgainstDisk("t.wlt")
+			require.NoError(t, err)
+			require.True(t, diff)
+
+			// The wallet file is deleted
+			require.NoError(t, os.Remove(fn))
+			diff, err = s.DiffAgainstDisk("t.wlt")
+			require.NoError(t, err)
+			require.True(t, diff)
+
+			_, err = s.DiffAgainstDisk("foo.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.DiffAgainstDisk("t.wlt")
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceNewAccount(t *testing.T) {
+	for ct := range cryptoTable {
+		t.Run(string(ct), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      ct,
+				EnableWalletAPI: true,
+			})
+			require.NoError(t, err)
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed:     "seed",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+
+			account, err := s.NewAccount("t.wlt", []byte("pwd"), "savings")
+			require.NoError(t, err)
+			require.Equal(t, uint32(1), account)
+
+			addrs, err := s.NewAccountAddresses("t.wlt", account, []byte("pwd"), 2)
+			require.NoError(t, err)
+			require.Len(t, addrs, 2)
+
+			w, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			checkNoSensitiveData(t, w)
+			require.Equal(t, []string{"savings"}, w.Accounts())
+			require.Len(t, w.Entries, 4)
+			require.Equal(t, uint64(3), w.accountEntries(account))
+
+			// The account addresses are indexed in their own chain
+			idx, err := s.GetAddressIndex("t.wlt", addrs[1])
+			require.NoError(t, err)
+			require.Equal(t, uint64(2), idx)
+
+			// Account 0 is the main chain
+			addrs, err = s.NewAccountAddresses("t.wlt", 0, []byte("pwd"), 1)
+			require.NoError(t, err)
+			idx, err = s.GetAddressIndex("t.wlt", addrs[0])
+			require.NoError(t, err)
+			require.Equal(t, uint64(1), idx)
+
+			// The accounts are saved
+			w2, err := Load(filepath.Join(dir, "t.wlt"))
+			require.NoError(t, err)
+			require.Equal(t, w.Accounts(), w2.Accounts())
+			require.Len(t, w2.Entries, 5)
+
+			// Recovering the wallet regenerates the account addresses
+			w, err = s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			w3, err := s.RecoverWallet("t.wlt", "seed", []byte("pwd2"))
+			require.NoError(t, err)
+			require.True(t, w3.IsEncrypted())
+			require.Equal(t, w.Accounts(), w3.Accounts())
+			require.Len(t, w3.Entries, len(w.Entries))
+			accountAddrs := func(w *Wallet) map[uint32][]string {
+				addrs := make(map[uint32][]string)
+				for _, e := range w.Entries {
+					addrs[e.Account] = append(addrs[e.Account], e.Address.String())
+				}
+				return addrs
+			}
+			require.Equal(t, accountAddrs(w), accountAddrs(w3))
+
+			_, err = s.NewAccountAddresses("t.wlt", 2, []byte("pwd2"), 1)
+			require.Equal(t, ErrUnknownAccount, err)
+			_, err = s.NewAccount("t.wlt", []byte("wrong"), "foo")
+			require.Equal(t, ErrInvalidPassword, err)
+			_, err = s.NewAccount("t.wlt", nil, "foo")
+			require.Equal(t, ErrMissingPassword, err)
+			_, err = s.NewAccount("foo.wlt", nil, "foo")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			_, err = s.CreateWallet("u.wlt", Options{
+				Seed: "seed2",
+			}, nil)
+			require.NoError(t, err)
+			_, err = s.NewAccount("u.wlt", []byte("pwd"), "foo")
+			require.Equal(t, ErrWalletNotEncrypted, err)
+			account, err = s.NewAccount("u.wlt", nil, "foo")
+			require.NoError(t, err)
+			require.Equal(t, uint32(1), account)
+
+			require.NoError(t, s.SetWalletReadOnly("u.wlt", true))
+			_, err = s.NewAccount("u.wlt", nil, "bar")
+			require.Equal(t, ErrWalletReadOnly, err)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.NewAccount("u.wlt", nil, "bar")
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceSearchAddresses(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+			})
+			require.NoError(t, err)
+
+			addrs := make(map[string][]cipher.Address)
+			for _, id := range []string{"b.wlt", "a.wlt"} {
+				w, err := s.CreateWallet(id, Options{
+					Seed:      "seed-" + id,
+					GenerateN: 3,
+				}, nil)
+				require.NoError(t, err)
+				addrs[id], err = w.GetSkycoinAddresses()
+				require.NoError(t, err)
+			}
+
+			s, err = NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			addr := addrs["b.wlt"][2]
+			matches, err := s.SearchAddresses(" " + addr.String()[3:12] + " ")
+			require.NoError(t, err)
+			require.Equal(t, []AddressMatch{{
+				WalletID: "b.wlt",
+				Address:  addr,
+				Index:    2,
+			}}, matches)
+
+			matches, err = s.SearchAddresses("zzzzzzzzzz")
+			require.NoError(t, err)
+			require.Empty(t, matches)
+
+			// A single character matches many addresses, sorted by wallet id and position
+			var expect []AddressMatch
+			for _, id := range []string{"a.wlt", "b.wlt"} {
+				for i, a := range addrs[id] {
+					if strings.Contains(a.String(), "2") {
+						expect = append(expect, AddressMatch{
+							WalletID: id,
+							Address:  a,
+							Index:    uint64(i),
+						})
+					}
+				}
+			}
+			require.True(t, len(expect) > 2)
+			matches, err = s.SearchAddresses("2")
+			require.NoError(t, err)
+			require.Equal(t, expect, matches)
+
+			// The number of results is limited
+			s.config.MaxSearchResults = 2
+			matches, err = s.SearchAddresses("2")
+			require.NoError(t, err)
+			require.Equal(t, expect[:2], matches)
+
+			_, err = s.SearchAddresses("")
+			require.Equal(t, ErrEmptySearchFragment, err)
+			_, err = s.SearchAddresses("  ")
+			require.Equal(t, ErrEmptySearchFragment, err)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.SearchAddresses("2")
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceImportRenameOnCollision(t *testing.T) {
+	for _, rename := range []bool{false, true} {
+		t.Run(fmt.Sprintf("rename=%v", rename), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:               dir,
+				CryptoType:              CryptoTypeScryptChacha20poly1305,
+				EnableWalletAPI:         true,
+				ImportRenameOnCollision: rename,
+			})
+			require.NoError(t, err)
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:     "seed",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+
+			p, err := newSeedQRPayload(w)
+			require.NoError(t, err)
+			blob := p.serialize()
+
+			_, err = s.CreateWallet("t2.wlt", Options{
+				Seed: "seed2",
+			}, nil)
+			require.NoError(t, err)
+
+			// A duplicate seed is rejected whether or not the filename is renamed
+			_, err = s.ImportEncryptedSeedQR("t2.wlt", blob, []byte("pwd"), mockBalanceGetter{})
+			require.Equal(t, ErrSeedUsed, err)
+
+			require.NoError(t, s.UnloadWallet("t.wlt"))
+
+			w2, err := s.ImportEncryptedSeedQR("t2.wlt", blob, []byte("pwd"), mockBalanceGetter{})
+			if !rename {
+				require.Equal(t, ErrWalletNameConflict, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotEqual(t, "t2.wlt", w2.Filename())
+			require.NotEqual(t, "t.wlt", w2.Filename())
+			require.Equal(t, w.GetAddresses(), w2.GetAddresses())
+
+			_, err = os.Stat(filepath.Join(dir, w2.Filename()))
+			require.NoError(t, err)
+			w3, err := s.GetWallet("t2.wlt")
+			require.NoError(t, err)
+			require.Equal(t, "seed2", w3.seed())
+		})
+	}
+}
+
+func TestServiceWalletDerivationState(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:      "seed",
+		Encrypt:   true,
+		Password:  []byte("pwd"),
+		GenerateN: 3,
+	}, nil)
+	require.NoError(t, err)
+
+	state, err := s.GetWalletDerivationState("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, DerivationState{NextIndex: 3}, state)
+
+	// The wallet does not need to be decrypted
+	state.ScanIndex = 2
+	state.ScannedHeight = 100
+	require.NoError(t, s.SetWalletDerivationState("t.wlt", state))
+
+	// The state is saved
+	s2, err := NewService(Config{
+		WalletDir:       dir,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+	state2, err := s2.GetWalletDerivationState("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, state, state2)
+
+	// Generating addresses advances the next index, the stale state is rejected
+	_, err = s.NewAddresses("t.wlt", []byte("pwd"), 1)
+	require.NoError(t, err)
+	state2, err = s.GetWalletDerivationState("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, DerivationState{NextIndex: 4, ScanIndex: 2, ScannedHeight: 100}, state2)
+
+	err = s.SetWalletDerivationState("t.wlt", state)
+	require.Equal(t, ErrInvalidDerivationState, err)
+	err = s.SetWalletDerivationState("t.wlt", DerivationState{NextIndex: 4, ScanIndex: 5})
+	require.Equal(t, ErrInvalidDerivationState, err)
+
+	// Resetting the scan progress removes it from the wallet
+	require.NoError(t, s.SetWalletDerivationState("t.wlt", DerivationState{NextIndex: 4}))
+	w, err := s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	_, ok := w.Meta[metaScanIndex]
+	require.False(t, ok)
+	_, ok = w.Meta[metaScanHeight]
+	require.False(t, ok)
+
+	_, err = s.GetWalletDerivationState("foo.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+	err = s.SetWalletDerivationState("foo.wlt", state)
+	require.Equal(t, ErrWalletNotExist, err)
+
+	require.NoError(t, s.SetWalletReadOnly("t.wlt", true))
+	err = s.SetWalletDerivationState("t.wlt", DerivationState{NextIndex: 4, ScanIndex: 4})
+	require.Equal(t, ErrWalletReadOnly, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.GetWalletDerivationState("t.wlt")
+	require.Equal(t, ErrWalletAPIDisabled, err)
+	err = s.SetWalletDerivationState("t.wlt", state)
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceGetWalletEncryptionInfo(t *testing.T) {
+	tt := []struct {
+		cryptoType CryptoType
+		info       EncryptionInfo
+	}{
+		{
+			cryptoType: CryptoTypeSha256Xor,
+			info: EncryptionInfo{
+				Encrypted:  true,
+				CryptoType: CryptoTypeSha256Xor,
+				KDF:        KDFSha256,
+			},
+		},
+		{
+			cryptoType: CryptoTypeScryptChacha20poly1305,
+			info: EncryptionInfo{
+				Encrypted:  true,
+				CryptoType: CryptoTypeScryptChacha20poly1305,
+				KDF:        KDFScrypt,
+				ScryptN:    1 << 15,
+				ScryptR:    encrypt.ScryptR,
+				ScryptP:    encrypt.ScryptP,
+				KeyLen:     encrypt.ScryptKeyLen,
+			},
+		},
+		{
+			cryptoType: CryptoTypePbkdf2Chacha20poly1305,
+			info: EncryptionInfo{
+				Encrypted:  true,
+				CryptoType: CryptoTypePbkdf2Chacha20poly1305,
+				KDF:        KDFPbkdf2HmacSha256,
+				Iterations: 1000,
+				KeyLen:     encrypt.Pbkdf2KeyLen,
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(string(tc.cryptoType), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      tc.cryptoType,
+				EnableWalletAPI: true,
+			})
+			require.NoError(t, err)
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed:     "seed",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+
+			info, err := s.GetWalletEncryptionInfo("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, tc.info, info)
+
+			_, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			info, err = s.GetWalletEncryptionInfo("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, EncryptionInfo{}, info)
+
+			_, err = s.GetWalletEncryptionInfo("foo.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.GetWalletEncryptionInfo("t.wlt")
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceScryptParams(t *testing.T) {
+	c := NewConfig()
+	require.Equal(t, encrypt.ScryptN, c.ScryptN)
+	require.Equal(t, encrypt.ScryptR, c.ScryptR)
+	require.Equal(t, encrypt.ScryptP, c.ScryptP)
+	require.Equal(t, encrypt.ScryptKeyLen, c.ScryptKeyLen)
+
+	for _, c := range []Config{
+		{ScryptN: 1000},
+		{ScryptN: 1},
+		{ScryptR: -1},
+		{ScryptR: 1 << 15, ScryptP: 1 << 15},
+		{ScryptKeyLen: -1},
+	} {
+		c.WalletDir = prepareWltDir()
+		c.CryptoType = CryptoTypeScryptChacha20poly1305
+		c.EnableWalletAPI = true
+		_, err := NewService(c)
+		require.Error(t, err)
+	}
+
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+		ScryptN:         1 << 10,
+		ScryptR:         4,
+		ScryptKeyLen:    32,
+	})
+	require.NoError(t, err)
+
+	expect := EncryptionInfo{
+		Encrypted:  true,
+		CryptoType: CryptoTypeScryptChacha20poly1305,
+		KDF:        KDFScrypt,
+		ScryptN:    1 << 10,
+		ScryptR:    4,
+		ScryptP:    encrypt.ScryptP,
+		KeyLen:     32,
+	}
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+	info, err := s.GetWalletEncryptionInfo("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, expect, info)
+
+	_, err = s.CreateWallet("t2.wlt", Options{
+		Seed: "seed2",
+	}, nil)
+	require.NoError(t, err)
+	_, err = s.EncryptWallet("t2.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	info, err = s.GetWalletEncryptionInfo("t2.wlt")
+	require.NoError(t, err)
+	require.Equal(t, expect, info)
+
+	// Wallets encrypted with other parameters are decrypted with the parameters they were encrypted with
+	s, err = NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+		ScryptN:         1 << 11,
+		ScryptP:         2,
+	})
+	require.NoError(t, err)
+
+	_, err = s.ChangePassword("t.wlt", []byte("pwd"), []byte("pwd2"))
+	require.NoError(t, err)
+	info, err = s.GetWalletEncryptionInfo("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, EncryptionInfo{
+		Encrypted:  true,
+		CryptoType: CryptoTypeScryptChacha20poly1305,
+		KDF:        KDFScrypt,
+		ScryptN:    1 << 11,
+		ScryptR:    encrypt.ScryptR,
+		ScryptP:    2,
+		KeyLen:     encrypt.ScryptKeyLen,
+	}, info)
+
+	w, err := s.DecryptWallet("t2.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	require.Equal(t, "seed2", w.seed())
+}
+
+func TestServiceVerifyTransactionInputsOwned(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed:      "seed",
+				GenerateN: 2,
+			}, nil)
+			require.NoError(t, err)
+			addrs, err := w.GetSkycoinAddresses()
+			require.NoError(t, err)
+
+			foreign := testutil.MakeAddress()
+			tx := &coin.Transaction{
+				In: []cipher.SHA256{
+					testutil.RandSHA256(t),
+					testutil.RandSHA256(t),
+					testutil.RandSHA256(t),
+					testutil.RandSHA256(t),
+				},
+			}
+
+			owned, notOwned, err := s.VerifyTransactionInputsOwned("t.wlt", tx, []cipher.Address{addrs[1], foreign, addrs[0], addrs[1]})
+			require.NoError(t, err)
+			require.Equal(t, []cipher.Address{addrs[1], addrs[0]}, owned)
+			require.Equal(t, []cipher.Address{foreign}, notOwned)
+
+			owned, notOwned, err = s.VerifyTransactionInputsOwned("t.wlt", tx, []cipher.Address{foreign, foreign, foreign, foreign})
+			require.NoError(t, err)
+			require.Empty(t, owned)
+			require.Equal(t, []cipher.Address{foreign}, notOwned)
+
+			_, _, err = s.VerifyTransactionInputsOwned("t.wlt", tx, addrs)
+			require.Equal(t, errors.New("len(inputAddrs) != len(tx.In)"), err)
+
+			_, _, err = s.VerifyTransactionInputsOwned("foo.wlt", tx, []cipher.Address{foreign, foreign, foreign, foreign})
+			require.Equal(t, ErrWalletNotExist, err)
+
+			s.config.EnableWalletAPI = false
+			_, _, err = s.VerifyTransactionInputsOwned("t.wlt", tx, []cipher.Address{foreign, foreign, foreign, foreign})
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServicePendingTransactions(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			_, err = s.CreateWallet("t.wlt", Options{
+				Seed:     "seed",
+				Encrypt:  true,
+				Password: []byte("pwd"),
+			}, nil)
+			require.NoError(t, err)
+
+			makeTxn := func() *coin.Transaction {
+				var txn coin.Transaction
+				require.NoError(t, txn.PushInput(testutil.RandSHA256(t)))
+				require.NoError(t, txn.PushOutput(testutil.MakeAddress(), 1e6, 1, nil))
+				_, sk := cipher.GenerateKeyPair()
+				txn.SignInputs([]cipher.SecKey{sk})
+				require.NoError(t, txn.UpdateHeader())
+				return &txn
+			}
+
+			txns, err := s.PendingTransactions("t.wlt")
+			require.NoError(t, err)
+			require.Empty(t, txns)
+
+			txn1 := makeTxn()
+			txn2 := makeTxn()
+			require.NoError(t, s.RecordSignedTransaction("t.wlt", txn1))
+			require.NoError(t, s.RecordSignedTransaction("t.wlt", txn2))
+			require.NoError(t, s.RecordSignedTransaction("t.wlt", txn1))
+
+			txns, err = s.PendingTransactions("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, []*coin.Transaction{txn1, txn2}, txns)
+
+			// Unsigned transactions are rejected
+			unsigned := makeTxn()
+			unsigned.Sigs = []cipher.Sig{{}}
+			require.Equal(t, ErrTransactionNotSigned, s.RecordSignedTransaction("t.wlt", unsigned))
+
+			// The pending transactions are saved, and kept when recovering the wallet
+			s, err = NewService(Config{
+				WalletDir:       dir,
+				CryptoType:      CryptoTypeSha256Xor,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+			_, err = s.RecoverWallet("t.wlt", "seed", []byte("pwd"))
+			require.NoError(t, err)
+			txns, err = s.PendingTransactions("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, []*coin.Transaction{txn1, txn2}, txns)
+
+			require.NoError(t, s.RemovePendingTransaction("t.wlt", txn1.Hash()))
+			require.Equal(t, ErrPendingTransactionNotFound, s.RemovePendingTransaction("t.wlt", txn1.Hash()))
+			txns, err = s.PendingTransactions("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, []*coin.Transaction{txn2}, txns)
+
+			require.NoError(t, s.RemovePendingTransaction("t.wlt", txn2.Hash()))
+			w, err := s.GetWallet("t.wlt")
+			require.NoError(t, err)
+			require.NotContains(t, w.Meta, metaPendingTxns)
+
+			require.NoError(t, s.SetWalletReadOnly("t.wlt", true))
+			require.Equal(t, ErrWalletReadOnly, s.RecordSignedTransaction("t.wlt", txn1))
+
+			require.Equal(t, ErrWalletNotExist, s.RecordSignedTransaction("foo.wlt", txn1))
+			_, err = s.PendingTransactions("foo.wlt")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			s.config.EnableWalletAPI = false
+			require.Equal(t, ErrWalletAPIDisabled, s.RecordSignedTransaction("t.wlt", txn1))
+			_, err = s.PendingTransactions("t.wlt")
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServicePruneConfirmedPending(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed: "seed",
+	}, nil)
+	require.NoError(t, err)
+
+	var txns []*coin.Transaction
+	for i := 0; i < 3; i++ {
+		var txn coin.Transaction
+		require.NoError(t, txn.PushInput(testutil.RandSHA256(t)))
+		require.NoError(t, txn.PushOutput(testutil.MakeAddress(), 1e6, 1, nil))
+		_, sk := cipher.GenerateKeyPair()
+		txn.SignInputs([]cipher.SecKey{sk})
+		require.NoError(t, txn.UpdateHeader())
+		require.NoError(t, s.RecordSignedTransaction("t.wlt", &txn))
+		txns = append(txns, &txn)
+	}
+
+	n, err := s.PruneConfirmedPending("t.wlt", func(txid string) bool {
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	var checked []string
+	n, err = s.PruneConfirmedPending("t.wlt", func(txid string) bool {
+		checked = append(checked, txid)
+		return txid != txns[1].Hash().Hex()
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []string{txns[0].Hash().Hex(), txns[1].Hash().Hex(), txns[2].Hash().Hex()}, checked)
+
+	// The pruned transactions are removed from the wallet file
+	w, err := Load(filepath.Join(dir, "t.wlt"))
+	require.NoError(t, err)
+	require.Equal(t, []*coin.Transaction{txns[1]}, w.PendingTransactions())
+
+	n, err = s.PruneConfirmedPending("t.wlt", func(txid string) bool {
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	pending, err := s.PendingTransactions("t.wlt")
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	_, err = s.PruneConfirmedPending("foo.wlt", func(txid string) bool {
+		return true
+	})
+	require.Equal(t, ErrWalletNotExist, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.PruneConfirmedPending("t.wlt", func(txid string) bool {
+		return true
+	})
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceAutoReencrypt(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:          dir,
+		CryptoType:         CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI:    true,
+		AutoReencryptAfter: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:      "seed",
+		Encrypt:   true,
+		Password:  []byte("pwd"),
+		GenerateN: 2,
+	}, nil)
+	require.NoError(t, err)
+
+	isEncrypted := func(wltID string) bool {
+		w, err := s.GetWallet(wltID)
+		require.NoError(t, err)
+		w2, err := Load(filepath.Join(dir, wltID))
+		require.NoError(t, err)
+		require.Equal(t, w.IsEncrypted(), w2.IsEncrypted())
+		return w.IsEncrypted()
+	}
+
+	password := []byte("pwd")
+	_, err = s.DecryptWallet("t.wlt", password)
+	require.NoError(t, err)
+	require.False(t, isEncrypted("t.wlt"))
+
+	// The remembered password is a copy
+	password[0] = 'x'
+
+	time.Sleep(200 * time.Millisecond)
+	require.True(t, isEncrypted("t.wlt"))
+	s.Lock()
+	require.Empty(t, s.pendingReencrypts)
+	s.Unlock()
+
+	w2, err := s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, w.GetAddresses(), w2.GetAddresses())
+	require.NoError(t, s.ViewSecrets("t.wlt", []byte("pwd"), func(w *Wallet) error {
+		require.Equal(t, "seed", w.seed())
+		return nil
+	}))
+
+	// Canceled re-encryption
+	_, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	require.NoError(t, s.CancelAutoReencrypt("t.wlt"))
+	time.Sleep(200 * time.Millisecond)
+	require.False(t, isEncrypted("t.wlt"))
+
+	require.NoError(t, s.CancelAutoReencrypt("t.wlt"))
+	require.Equal(t, ErrWalletNotExist, s.CancelAutoReencrypt("foo.wlt"))
+
+	// Encrypting the wallet cancels the re-encryption
+	_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	_, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	_, err = s.EncryptWallet("t.wlt", []byte("pwd2"))
+	require.NoError(t, err)
+	s.Lock()
+	require.Empty(t, s.pendingReencrypts)
+	s.Unlock()
+
+	// Read-only wallets are not re-encrypted
+	_, err = s.DecryptWallet("t.wlt", []byte("pwd2"))
+	require.NoError(t, err)
+	require.NoError(t, s.SetWalletReadOnly("t.wlt", true))
+	time.Sleep(200 * time.Millisecond)
+	require.False(t, isEncrypted("t.wlt"))
+	s.Lock()
+	require.Empty(t, s.pendingReencrypts)
+	s.Unlock()
+	require.NoError(t, s.SetWalletReadOnly("t.wlt", false))
+
+	// Close re-encrypts the wallets immediately
+	_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	s.config.AutoReencryptAfter = time.Hour
+	_, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	require.True(t, isEncrypted("t.wlt"))
+	require.Empty(t, s.pendingReencrypts)
+
+	s.config.EnableWalletAPI = false
+	require.Equal(t, ErrWalletAPIDisabled, s.CancelAutoReencrypt("t.wlt"))
+}
+
+func TestServiceAutoReencryptDisabled(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:     "seed",
+		Encrypt:  true,
+		Password: []byte("pwd"),
+	}, nil)
+	require.NoError(t, err)
+
+	_, err = s.DecryptWallet("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	require.Empty(t, s.pendingReencrypts)
+	require.NoError(t, s.Close())
+
+	w, err := s.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.False(t, w.IsEncrypted())
+}
+
+func TestServiceListAddressesWithLabels(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:      "seed",
+		Encrypt:   true,
+		Password:  []byte("pwd"),
+		GenerateN: 3,
+	}, nil)
+	require.NoError(t, err)
+	addrs, err := w.GetSkycoinAddresses()
+	require.NoError(t, err)
+
+	// The wallet does not need to be decrypted
+	require.NoError(t, s.SetAddressLabel("t.wlt", addrs[0], "rent"))
+	require.NoError(t, s.SetAddressLabel("t.wlt", addrs[2], "savings"))
+
+	expect := []LabeledAddress{
+		{Address: addrs[0], Index: 0, Label: "rent"},
+		{Address: addrs[1], Index: 1},
+		{Address: addrs[2], Index: 2, Label: "savings"},
+	}
+	labeled, err := s.ListAddressesWithLabels("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, expect, labeled)
+
+	// The labels are saved
+	s2, err := NewService(Config{
+		WalletDir:       dir,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+	labeled, err = s2.ListAddressesWithLabels("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, expect, labeled)
+
+	// The labels are kept when recovering the wallet
+	_, err = s.RecoverWallet("t.wlt", "seed", []byte("pwd"))
+	require.NoError(t, err)
+	labeled, err = s.ListAddressesWithLabels("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, expect, labeled)
+
+	// An empty label removes it
+	require.NoError(t, s.SetAddressLabel("t.wlt", addrs[0], ""))
+	labeled, err = s.ListAddressesWithLabels("t.wlt")
+	require.NoError(t, err)
+	require.Empty(t, labeled[0].Label)
+
+	err = s.SetAddressLabel("t.wlt", testutil.MakeAddress(), "foo")
+	require.Equal(t, ErrUnknownAddress, err)
+	err = s.SetAddressLabel("foo.wlt", addrs[0], "foo")
+	require.Equal(t, ErrWalletNotExist, err)
+	_, err = s.ListAddressesWithLabels("foo.wlt")
+	require.Equal(t, ErrWalletNotExist, err)
+
+	require.NoError(t, s.SetWalletReadOnly("t.wlt", true))
+	err = s.SetAddressLabel("t.wlt", addrs[0], "foo")
+	require.Equal(t, ErrWalletReadOnly, err)
+
+	s.config.EnableWalletAPI = false
+	err = s.SetAddressLabel("t.wlt", addrs[0], "foo")
+	require.Equal(t, ErrWalletAPIDisabled, err)
+	_, err = s.ListAddressesWithLabels("t.wlt")
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceGetAuxKey(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("t.wlt", Options{
+		Seed:          "seed",
+		Encrypt:       true,
+		Password:      []byte("pwd"),
+		GenerateN:     3,
+		AuxDerivation: "hours",
+	}, nil)
+	require.NoError(t, err)
+
+	key0, err := s.GetAuxKey("t.wlt", []byte("pwd"), 0)
+	require.NoError(t, err)
+	require.NoError(t, key0.Verify())
+	key1, err := s.GetAuxKey("t.wlt", []byte("pwd"), 1)
+	require.NoError(t, err)
+	require.NotEqual(t, key0, key1)
+
+	// The keys are derived from the seed and the derivation path
+	seed := cipher.SumSHA256([]byte("seed/aux/hours/1"))
+	_, expect := cipher.MustGenerateDeterministicKeyPair(seed[:])
+	require.Equal(t, expect, key1)
+
+	// The keys are isolated from the address chain
+	_, seckeys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte("seed"), 3)
+	for _, sk := range seckeys {
+		require.NotEqual(t, key0, sk)
+		require.NotEqual(t, key1, sk)
+	}
+
+	// The derivation path is saved and kept when recovering the wallet
+	s2, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeScryptChacha20poly1305,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+	w, err := s2.GetWallet("t.wlt")
+	require.NoError(t, err)
+	require.Equal(t, "hours", w.AuxDerivation())
+
+	_, err = s2.RecoverWallet("t.wlt", "seed", []byte("pwd2"))
+	require.NoError(t, err)
+	key, err := s2.GetAuxKey("t.wlt", []byte("pwd2"), 1)
+	require.NoError(t, err)
+	require.Equal(t, key1, key)
+
+	_, err = s.GetAuxKey("t.wlt", []byte("wrong"), 0)
+	require.Equal(t, ErrInvalidPassword, err)
+	_, err = s.GetAuxKey("t.wlt", nil, 0)
+	require.Equal(t, ErrMissingPassword, err)
+	_, err = s.GetAuxKey("foo.wlt", nil, 0)
+	require.Equal(t, ErrWalletNotExist, err)
+
+	// Unencrypted wallets don't need a password
+	_, err = s.CreateWallet("u.wlt", Options{
+		Seed:          "seed2",
+		AuxDerivation: "hours",
+	}, nil)
+	require.NoError(t, err)
+	key, err = s.GetAuxKey("u.wlt", nil, 0)
+	require.NoError(t, err)
+	require.NotEqual(t, key0, key)
+	_, err = s.GetAuxKey("u.wlt", []byte("pwd"), 0)
+	require.Equal(t, ErrWalletNotEncrypted, err)
+
+	_, err = s.CreateWallet("v.wlt", Options{
+		Seed: "seed3",
+	}, nil)
+	require.NoError(t, err)
+	_, err = s.GetAuxKey("v.wlt", nil, 0)
+	require.Equal(t, ErrNoAuxDerivation, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.GetAuxKey("u.wlt", nil, 0)
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceMasterPublicKey(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+		// The same mnemonic is used with and without encryption
+		AllowDuplicateSeeds: true,
+	})
+	require.NoError(t, err)
+
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:      mnemonic,
+		Bip39:     true,
+		Bip44:     true,
+		Encrypt:   true,
+		Password:  []byte("pwd"),
+		GenerateN: 3,
+	}, nil)
+	require.NoError(t, err)
+
+	pk, err := s.MasterPublicKey("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	require.NoError(t, pk.Verify())
+
+	// The key is the root of the BIP32 tree of the BIP39 seed, not a key of the wallet's addresses
+	seed, err := bip39.NewSeed(mnemonic, "")
+	require.NoError(t, err)
+	k, err := bip32.NewMasterKey(seed)
+	require.NoError(t, err)
+	require.Equal(t, k.PublicKey().Key, pk[:])
+	for _, e := range w.Entries {
+		require.NotEqual(t, e.Public, pk)
+	}
+
+	_, err = s.MasterPublicKey("t.wlt", []byte("wrong"))
+	require.Equal(t, ErrInvalidPassword, err)
+	_, err = s.MasterPublicKey("t.wlt", nil)
+	require.Equal(t, ErrMissingPassword, err)
+	_, err = s.MasterPublicKey("foo.wlt", nil)
+	require.Equal(t, ErrWalletNotExist, err)
+
+	// Unencrypted wallets don't need a password
+	_, err = s.CreateWallet("u.wlt", Options{
+		Seed:  mnemonic,
+		Bip39: true,
+		Bip44: true,
+	}, nil)
+	require.NoError(t, err)
+	pk2, err := s.MasterPublicKey("u.wlt", nil)
+	require.NoError(t, err)
+	require.Equal(t, pk, pk2)
+	_, err = s.MasterPublicKey("u.wlt", []byte("pwd"))
+	require.Equal(t, ErrWalletNotEncrypted, err)
+
+	// Only BIP44 wallets have a BIP32 master key
+	_, err = s.CreateWallet("d.wlt", Options{
+		Seed: "seed",
+	}, nil)
+	require.NoError(t, err)
+	_, err = s.MasterPublicKey("d.wlt", nil)
+	require.Equal(t, ErrWalletNotBip44, err)
+
+	_, err = s.CreateWalletFromAddresses("w.wlt", []cipher.Address{testutil.MakeAddress()})
+	require.NoError(t, err)
+	_, err = s.MasterPublicKey("w.wlt", nil)
+	require.Equal(t, ErrWalletIsWatchOnly, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.MasterPublicKey("t.wlt", []byte("pwd"))
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestServiceStableID(t *testing.T) {
+	for _, lazyLoad := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazyLoad=%v", lazyLoad), func(t *testing.T) {
+			dir := prepareWltDir()
+			s, err := NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			w, err := s.CreateWallet("t.wlt", Options{
+				Seed: "seed",
+			}, nil)
+			require.NoError(t, err)
+			require.Regexp(t, "^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$", w.StableID())
+
+			id, err := s.StableID("t.wlt")
+			require.NoError(t, err)
+			require.Equal(t, w.StableID(), id)
+
+			// Wallets created before stable ids get one on the first save
+			w2, err := NewWallet("old.wlt", Options{
+				Seed: "seed2",
+			})
+			require.NoError(t, err)
+			w2.setStableID("")
+			r := NewReadableWallet(w2)
+			require.NoError(t, r.Save(filepath.Join(dir, "old.wlt")))
+
+			s, err = NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+			})
+			require.NoError(t, err)
+
+			w2, err = s.GetWallet("old.wlt")
+			require.NoError(t, err)
+			require.Empty(t, w2.StableID())
+			oldID, err := s.StableID("old.wlt")
+			require.NoError(t, err)
+			require.NotEmpty(t, oldID)
+			require.NotEqual(t, id, oldID)
+
+			w2, err = Load(filepath.Join(dir, "old.wlt"))
+			require.NoError(t, err)
+			require.Equal(t, oldID, w2.StableID())
+
+			// The stable id is accepted in place of the wallet id
+			s, err = NewService(Config{
+				WalletDir:       dir,
+				EnableWalletAPI: true,
+				LazyLoad:        lazyLoad,
+				StrictWalletIDs: true,
+				CryptoType:      CryptoTypeSha256Xor,
+			})
+			require.NoError(t, err)
+			w, err = s.GetWallet(id)
+			require.NoError(t, err)
+			require.Equal(t, "t.wlt", w.Filename())
+			require.NoError(t, s.UpdateWalletLabel(oldID, "old"))
+			w2, err = s.GetWallet("old.wlt")
+			require.NoError(t, err)
+			require.Equal(t, "old", w2.Label())
+			require.Equal(t, oldID, w2.StableID())
+
+			// The stable id is kept when recovering the wallet, and can't be changed
+			_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+			require.NoError(t, err)
+			w, err = s.RecoverWallet(id, "seed", nil)
+			require.NoError(t, err)
+			require.Equal(t, id, w.StableID())
+
+			err = s.Update("t.wlt", func(w *Wallet) error {
+				w.setStableID("foo")
+				return nil
+			})
+			require.Equal(t, ErrStableIDChanged, err)
+			_, err = s.GetWallet("foo")
+			require.Equal(t, ErrWalletNotExist, err)
+
+			// A copied wallet file makes the stable id ambiguous
+			b, err := ioutil.ReadFile(filepath.Join(dir, "t.wlt"))
+			require.NoError(t, err)
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "copy.wlt"), b, 0600))
+			s, err = NewService(Config{
+				WalletDir:           dir,
+				EnableWalletAPI:     true,
+				LazyLoad:            lazyLoad,
+				AllowDuplicateSeeds: true,
+			})
+			require.NoError(t, err)
+			_, err = s.GetWallet(id)
+			require.Equal(t, ErrAmbiguousWalletID, err)
+
+			s.config.EnableWalletAPI = false
+			_, err = s.StableID("t.wlt")
+			require.Equal(t, ErrWalletAPIDisabled, err)
+		})
+	}
+}
+
+func TestServiceGetWalletProto(t *testing.T) {
+	s, err := NewService(Config{
+		WalletDir:       prepareWltDir(),
+		CryptoType:      CryptoTypeSha256Xor,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	w, err := s.CreateWallet("t.wlt", Options{
+		Seed:  "seed",
+		Label: "label",
+	}, nil)
+	require.NoError(t, err)
+	_, err = s.NewAddresses("t.wlt", nil, 2)
+	require.NoError(t, err)
+	require.NoError(t, s.SetAddressLabel("t.wlt", w.Entries[0].SkycoinAddress(), "first"))
+	w, err = s.GetWallet("t.wlt")
+	require.NoError(t, err)
+
+	m, err := s.GetWalletProto("t.wlt", false)
+	require.NoError(t, err)
+	require.Equal(t, "t.wlt", m.Filename)
+	require.Equal(t, w.Version(), m.Version)
+	require.Equal(t, "label", m.Label)
+	require.Equal(t, string(CoinTypeSkycoin), m.Coin)
+	require.Equal(t, WalletTypeDeterministic, m.Type)
+	require.False(t, m.Encrypted)
+	require.Equal(t, w.StableID(), m.StableId)
+	require.Empty(t, m.Seed)
+	require.Empty(t, m.LastSeed)
+	require.Len(t, m.Entries, 3)
+	for i, e := range w.Entries {
+		require.Equal(t, e.Address.String(), m.Entries[i].Address)
+		require.Equal(t, e.Public.Hex(), m.Entries[i].PublicKey)
+		require.Empty(t, m.Entries[i].SecretKey)
+	}
+	require.Equal(t, "first", m.Entries[0].Label)
+
+	// The message survives the wire encoding
+	b, err := proto.Marshal(m)
+	require.NoError(t, err)
+	var m2 WalletProto
+	require.NoError(t, proto.Unmarshal(b, &m2))
+	require.True(t, proto.Equal(m, &m2))
+
+	// Secrets require the seed API
+	_, err = s.GetWalletProto("t.wlt", true)
+	require.Equal(t, ErrSeedAPIDisabled, err)
+
+	s.config.EnableSeedAPI = true
+	m, err = s.GetWalletProto("t.wlt", true)
+	require.NoError(t, err)
+	require.Equal(t, "seed", m.Seed)
+	require.Equal(t, w.lastSeed(), m.LastSeed)
+	for i, e := range w.Entries {
+		require.Equal(t, e.Secret.Hex(), m.Entries[i].SecretKey)
+	}
+
+	b, err = proto.Marshal(m)
+	require.NoError(t, err)
+	m2.Reset()
+	require.NoError(t, proto.Unmarshal(b, &m2))
+	require.True(t, proto.Equal(m, &m2))
+
+	// The secrets of encrypted wallets are not available
+	_, err = s.EncryptWallet("t.wlt", []byte("pwd"))
+	require.NoError(t, err)
+	_, err = s.GetWalletProto("t.wlt", true)
+	require.Equal(t, ErrWalletEncrypted, err)
+	m, err = s.GetWalletProto("t.wlt", false)
+	require.NoError(t, err)
+	require.True(t, m.Encrypted)
+	require.Equal(t, string(CryptoTypeSha256Xor), m.CryptoType)
+	require.Empty(t, m.Seed)
+	for _, e := range m.Entries {
+		require.Empty(t, e.SecretKey)
+	}
+
+	_, err = s.GetWalletProto("foo.wlt", false)
+	require.Equal(t, ErrWalletNotExist, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.GetWalletProto("t.wlt", false)
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
+func TestWalletProtoSchema(t *testing.T) {
+	// The generated types match wallet.proto
+	b, err := ioutil.ReadFile("wallet.proto")
+	require.NoError(t, err)
+
+	msgRe := regexp.MustCompile(`^message (\w+) \{$`)
+	fieldRe := regexp.MustCompile(`^(repeated )?(\w+) (\w+) = (\d+);$`)
+	wireTypes := map[string]string{
+		"string": "bytes",
+		"bool":   "varint",
+		"uint32": "varint",
+	}
+	types := map[string]reflect.Type{
+		"WalletProto": reflect.TypeOf(WalletProto{}),
+		"EntryProto":  reflect.TypeOf(EntryProto{}),
+	}
+
+	schema := make(map[string]map[string]string)
+	var msg string
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSpace(l)
+		if m := msgRe.FindStringSubmatch(l); m != nil {
+			msg = m[1]
+			schema[msg] = make(map[string]string)
+		} else if m := fieldRe.FindStringSubmatch(l); m != nil {
+			wire, ok := wireTypes[m[2]]
+			if !ok {
+				wire = "bytes"
+			}
+			label := "opt"
+			if m[1] != "" {
+				label = "rep"
+			}
+			schema[msg][m[3]] = fmt.Sprintf("%s,%s,%s", wire, m[4], label)
+		}
+	}
+	require.Len(t, schema, len(types))
+
+	zr, err := gzip.NewReader(bytes.NewReader(proto.FileDescriptor("wallet.proto")))
+	require.NoError(t, err)
+	desc, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+
+	for name, typ := range types {
+		require.Contains(t, schema, name)
+		fields := make(map[string]string)
+		for _, p := range proto.GetProperties(typ).Prop {
+			if p.Tag == 0 {
+				continue
+			}
+			label := "opt"
+			if p.Repeated {
+				label = "rep"
+			}
+			fields[p.OrigName] = fmt.Sprintf("%s,%d,%s", p.Wire, p.Tag, label)
+		}
+		require.Equal(t, schema[name], fields, name)
+
+		// The message is registered with the descriptor of wallet.proto
+		require.Equal(t, reflect.PtrTo(typ), proto.MessageType("wallet."+name))
+		require.Contains(t, string(desc), name)
+		for f := range schema[name] {
+			require.Contains(t, string(desc), f)
+		}
+	}
+}
+
+func TestServiceCompactStorage(t *testing.T) {
+	dir := prepareWltDir()
+	s, err := NewService(Config{
+		WalletDir:       dir,
+		EnableWalletAPI: true,
+	})
+	require.NoError(t, err)
+
+	_, err = s.CreateWallet("a.wlt", Options{
+		Seed: "seed",
+	}, nil)
+	require.NoError(t, err)
+
+	qdir := filepath.Join(dir, QuarantineDir)
+	require.NoError(t, os.MkdirAll(qdir, 0700))
+
+	// Files with their age in hours and size
+	now := time.Now()
+	files := []struct {
+		path string
+		age  int
+		size int
+	}{
+		{filepath.Join(dir, "a.wlt.bak.3"), 50, 10},
+		{filepath.Join(dir, "a.wlt.bak.2"), 30, 10},
+		{filepath.Join(dir, "a.wlt.bak.1"), 20, 10},
+		{filepath.Join(dir, "a.wlt.bak"), 10, 10},
+		{filepath.Join(dir, "b.wlt.bak"), 40, 100},
+		{filepath.Join(qdir, "a.wlt"), 25, 10},
+		{filepath.Join(qdir, "a.wlt.1"), 5, 10},
+		{filepath.Join(qdir, "notes.txt"), 100, 10},
+		{filepath.Join(dir, "notes.txt"), 100, 10},
+	}
+	for _, f := range files {
+		require.NoError(t, ioutil.WriteFile(f.path, make([]byte, f.size), 0600))
+		tm := now.Add(-time.Duration(f.age) * time.Hour)
+		require.NoError(t, os.Chtimes(f.path, tm, tm))
+	}
+
+	// No limits
+	report, err := s.CompactStorage(CompactionPolicy{})
+	require.NoError(t, err)
+	require.Empty(t, report.Removed)
+
+	report, err = s.CompactStorage(CompactionPolicy{
+		MaxAge: 45 * time.Hour,
+	})
+	require.NoError(t, err)
+	require.Equal(t, CompactionReport{
+		Removed:      []string{filepath.Join(dir, "a.wlt.bak.3")},
+		RemovedBytes: 10,
+	}, report)
+
+	// The per wallet limit applies to the backups and the quarantined files separately
+	report, err = s.CompactStorage(CompactionPolicy{
+		MaxPerWallet: 1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, CompactionReport{
+		Removed: []string{
+			filepath.Join(dir, "a.wlt.bak.2"),
+			filepath.Join(qdir, "a.wlt"),
+			filepath.Join(dir, "a.wlt.bak.1"),
+		},
+		RemovedBytes: 30,
+	}, report)
+
+	report, err = s.CompactStorage(CompactionPolicy{
+		MaxTotalSize: 20,
+	})
+	require.NoError(t, err)
+	require.Equal(t, CompactionReport{
+		Removed:      []string{filepath.Join(dir, "b.wlt.bak")},
+		RemovedBytes: 100,
+	}, report)
+
+	report, err = s.CompactStorage(CompactionPolicy{
+		MaxTotalSize: 15,
+	})
+	require.NoError(t, err)
+	require.Equal(t, CompactionReport{
+		Removed:      []string{filepath.Join(dir, "a.wlt.bak")},
+		RemovedBytes: 10,
+	}, report)
+
+	// The wallets and unrelated files are kept
+	for _, f := range []string{
+		filepath.Join(dir, "a.wlt"),
+		filepath.Join(qdir, "a.wlt.1"),
+		filepath.Join(qdir, "notes.txt"),
+		filepath.Join(dir, "notes.txt"),
+	} {
+		_, err := os.Stat(f)
+		require.NoError(t, err)
+	}
+	_, err = s.GetWallet("a.wlt")
+	require.NoError(t, err)
+
+	s.config.EnableWalletAPI = false
+	_, err = s.CompactStorage(CompactionPolicy{})
+	require.Equal(t, ErrWalletAPIDisabled, err)
+}
+
 func checkNoSensitiveData(t *testing.T, w *Wallet) {
 	require.Empty(t, w.seed())
 	require.Empty(t, w.lastSeed())
+	require.Empty(t, w.seedPassphrase())
 	var empty cipher.SecKey
 	for _, e := range w.Entries {
 		require.Equal(t, empty, e.Secret)
@@ -69,6 +69,10 @@ func (w *Wallet) SignTransaction(txn *coin.Transaction, signIndexes []int, uxOut
 	signedTxn := copyTransaction(txn)
 	txnInnerHash := signedTxn.HashInner()
 
+	if w.IsWatchOnly() {
+		return nil, ErrWalletIsWatchOnly
+	}
+
 	if w.IsEncrypted() {
 		return nil, ErrWalletEncrypted
 	}
@@ -203,6 +207,10 @@ func (w *Wallet) CreateTransaction(p transaction.Params, auxs coin.AddressUxOuts
 // Set the password as nil if the wallet is not encrypted, otherwise the password must be provided.
 // Refer to CreateTransaction for information about transaction creation.
 func (w *Wallet) CreateTransactionSigned(p transaction.Params, auxs coin.AddressUxOuts, headTime uint64) (*coin.Transaction, []transaction.UxBalance, error) {
+	if w.IsWatchOnly() {
+		return nil, nil, ErrWalletIsWatchOnly
+	}
+
 	txn, uxb, err := w.CreateTransaction(p, auxs, headTime)
 	if err != nil {
 		return nil, nil, err
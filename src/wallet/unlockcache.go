@@ -0,0 +1,165 @@
+package wallet
+
+import (
+	"crypto/subtle"
+	"sync"
+	"time"
+
+	"github.com/amherag/skycoin/src/cipher"
+)
+
+// unlockCache keeps the decrypted secrets of encrypted wallets for a short time, so that repeated
+// operations with the same password don't run the expensive key derivation again, see Config.UnlockCacheTTL.
+// Entries are keyed by wallet id and a salted hash of the password, and are erased when they expire.
+// The decrypted secrets are held by a SecretStore, see Config.SecretStore.
+// A nil *unlockCache doesn't cache anything.
+type unlockCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	salt    []byte
+	store   SecretStore
+	entries map[string]*unlockCacheEntry
+}
+
+type unlockCacheEntry struct {
+	key     cipher.SHA256 // salted hash of the wallet id and password
+	secrets string        // encrypted secrets of the wallet the entry was decrypted from
+	timer   *time.Timer
+}
+
+// newUnlockCache creates an unlockCache keeping the decrypted secrets in store, or in memory if store is nil.
+// Returns nil if ttl is zero or less.
+func newUnlockCache(ttl time.Duration, store SecretStore) *unlockCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	if store == nil {
+		store = NewMemorySecretStore()
+	}
+
+	return &unlockCache{
+		ttl:     ttl,
+		salt:    cipher.RandByte(32),
+		store:   store,
+		entries: make(map[string]*unlockCacheEntry),
+	}
+}
+
+func (c *unlockCache) key(wltID string, password []byte) cipher.SHA256 {
+	b := make([]byte, 0, len(c.salt)+len(wltID)+1+len(password))
+	b = append(b, c.salt...)
+	b = append(b, wltID...)
+	b = append(b, 0)
+	b = append(b, password...)
+	defer func() {
+		for i := range b {
+			b[i] = 0
+		}
+	}()
+	return cipher.SumSHA256(b)
+}
+
+// get returns a decrypted copy of the encrypted wallet w made from the cached secrets, if they were decrypted
+// from the current secrets of w with the same password. The caller must erase the copy.
+func (c *unlockCache) get(w *Wallet, password []byte) *Wallet {
+	if c == nil {
+		return nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.entries[w.Filename()]
+	if !ok || e.secrets != w.secrets() {
+		return nil
+	}
+
+	key := c.key(w.Filename(), password)
+	if subtle.ConstantTimeCompare(e.key[:], key[:]) != 1 {
+		return nil
+	}
+
+	sb, err := c.store.Get(w.Filename())
+	if err != nil {
+		logger.WithError(err).Warningf("Get cached secrets of wallet %s failed", w.Filename())
+		return nil
+	}
+	defer eraseBytes(sb)
+
+	wlt, err := w.unlockSecrets(sb)
+	if err != nil {
+		logger.WithError(err).Warningf("Unlock wallet %s with cached secrets failed", w.Filename())
+		return nil
+	}
+
+	return wlt
+}
+
+// put caches sb, the serialized decrypted secrets of the encrypted wallet w, replacing any previous entry of the wallet
+func (c *unlockCache) put(w *Wallet, password []byte, sb []byte) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	wltID := w.Filename()
+	c.removeLocked(wltID)
+
+	if err := c.store.Put(wltID, sb); err != nil {
+		logger.WithError(err).Warningf("Store secrets of wallet %s failed, not caching them", wltID)
+		return
+	}
+
+	e := &unlockCacheEntry{
+		key:     c.key(wltID, password),
+		secrets: w.secrets(),
+	}
+	e.timer = time.AfterFunc(c.ttl, func() {
+		c.Lock()
+		defer c.Unlock()
+		if c.entries[wltID] == e {
+			c.removeLocked(wltID)
+		}
+	})
+	c.entries[wltID] = e
+}
+
+// remove erases the cached entry of a wallet
+func (c *unlockCache) remove(wltID string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.removeLocked(wltID)
+}
+
+func (c *unlockCache) removeLocked(wltID string) {
+	e, ok := c.entries[wltID]
+	if !ok {
+		return
+	}
+
+	e.timer.Stop()
+	if err := c.store.Erase(wltID); err != nil {
+		logger.WithError(err).Errorf("Erase cached secrets of wallet %s failed", wltID)
+	}
+	delete(c.entries, wltID)
+}
+
+// clear erases all cached entries
+func (c *unlockCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for wltID := range c.entries {
+		c.removeLocked(wltID)
+	}
+}
@@ -4,6 +4,7 @@ Package wallet implements wallets and the wallet database service
 package wallet
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -16,6 +17,9 @@ import (
 	"encoding/hex"
 	
 	"github.com/amherag/skycoin/src/cipher"
+	"github.com/amherag/skycoin/src/cipher/bip39"
+	secp256k1 "github.com/amherag/skycoin/src/cipher/secp256k1-go"
+	"github.com/amherag/skycoin/src/coin"
 	"github.com/amherag/skycoin/src/util/logging"
 )
 
@@ -63,6 +67,10 @@ var (
 	ErrSeedUsed = NewError(errors.New("a wallet already exists with this seed"))
 	// ErrWalletAPIDisabled is returned when trying to do wallet actions while the EnableWalletAPI option is false
 	ErrWalletAPIDisabled = NewError(errors.New("wallet api is disabled"))
+	// ErrWalletAPIEnabled is returned when trying to enable the wallet API if it is already enabled
+	ErrWalletAPIEnabled = NewError(errors.New("wallet api is already enabled"))
+	// ErrWalletLimitReached is returned when adding a wallet would exceed Config.MaxWallets
+	ErrWalletLimitReached = NewError(errors.New("maximum number of wallets reached"))
 	// ErrSeedAPIDisabled is returned when trying to get seed of wallet while the EnableWalletAPI or EnableSeedAPI is false
 	ErrSeedAPIDisabled = NewError(errors.New("wallet seed api is disabled"))
 	// ErrWalletNameConflict represents the wallet name conflict error
@@ -75,12 +83,99 @@ var (
 	ErrWalletNotDeterministic = NewError(errors.New("wallet type is not deterministic"))
 	// ErrInvalidCoinType is returned for invalid coin types
 	ErrInvalidCoinType = NewError(errors.New("invalid coin type"))
+	// ErrUnknownCoinType is returned if a coin type is not registered, see RegisterCoinType
+	ErrUnknownCoinType = NewError(errors.New("unknown coin type"))
+	// ErrWalletReadOnly is returned when trying to modify a wallet that is flagged as read-only
+	ErrWalletReadOnly = NewError(errors.New("wallet is read-only"))
+	// ErrReadOnlyFlagChanged is returned if a wallet update changes the read-only flag
+	ErrReadOnlyFlagChanged = NewError(errors.New("wallet read-only flag can only be changed with SetWalletReadOnly"))
+	// ErrStableIDChanged is returned if a wallet update changes the stable id, which is immutable
+	ErrStableIDChanged = NewError(errors.New("wallet stable id can't be changed"))
+	// ErrSeedPassphraseHintTooLong is returned if a seed passphrase hint is longer than SeedPassphraseHintMaxLength
+	ErrSeedPassphraseHintTooLong = NewError(fmt.Errorf("seed passphrase hint is longer than %d characters", SeedPassphraseHintMaxLength))
+	// ErrUnknownWalletType is returned if a wallet's type is not recognized
+	ErrUnknownWalletType = NewError(errors.New("unknown wallet type"))
+	// ErrInvalidAddressPoolPolicy is returned if an address pool policy has minUnused == 0 or refillTo < minUnused
+	ErrInvalidAddressPoolPolicy = NewError(errors.New("invalid address pool policy, minUnused must be > 0 and refillTo >= minUnused"))
+	// ErrNoAddressPoolPolicy is returned by NextUnusedAddress if no address pool policy is set for the wallet
+	ErrNoAddressPoolPolicy = NewError(errors.New("wallet has no address pool policy"))
+	// ErrUnsupportedWalletVersion is returned by ValidateWalletFile if the wallet file version is not supported
+	ErrUnsupportedWalletVersion = NewError(errors.New("unsupported wallet version"))
+	// ErrWalletEmpty is returned if a wallet has no addresses
+	ErrWalletEmpty = NewError(errors.New("wallet has no addresses"))
+	// ErrAmbiguousWalletID is returned if a wallet id matches several wallets that differ only by case
+	ErrAmbiguousWalletID = NewError(errors.New("wallet id matches multiple wallets"))
+	// ErrLabelInUse is returned if Config.UniqueLabels is set and a wallet label is already used by another wallet
+	ErrLabelInUse = NewError(errors.New("wallet label is already in use"))
+	// ErrDevAPIDisabled is returned when trying to use a development helper while Config.EnableDevAPI is false
+	ErrDevAPIDisabled = NewError(errors.New("wallet dev api is disabled"))
+	// ErrNilFaucet is returned by Service.GenerateAndFund if the faucet is nil
+	ErrNilFaucet = NewError(errors.New("faucet is nil"))
+	// ErrIndexFilterOption is returned when creating a wallet in the service with Options.IndexFilter, see Config.IndexFilter
+	ErrIndexFilterOption = NewError(errors.New("index filter of service wallets must be set with Config.IndexFilter"))
+	// ErrAmbiguousReference is returned by ResolveWallet if a reference matches more than one wallet
+	ErrAmbiguousReference = NewError(errors.New("wallet reference matches multiple wallets"))
+	// ErrWalletNotEmpty is returned by RepairEmptyWallet if the wallet has addresses
+	ErrWalletNotEmpty = NewError(errors.New("wallet is not empty"))
+	// ErrWalletNotesTooLong is returned if wallet notes are longer than the configured maximum length
+	ErrWalletNotesTooLong = NewError(errors.New("wallet notes are too long"))
+	// ErrEmptyWalletTag is returned when setting a wallet tag that is empty
+	ErrEmptyWalletTag = NewError(errors.New("wallet tag is empty"))
+	// ErrEncryptRoundTrip is returned by TestEncrypt if the decrypted wallet doesn't match the wallet
+	ErrEncryptRoundTrip = NewError(errors.New("decrypted wallet doesn't match the wallet"))
+	// ErrInvalidDerivedKeyPair is returned if a SeedDeriver returns a public key that does not match its secret key
+	ErrInvalidDerivedKeyPair = NewError(errors.New("derived public key does not match secret key"))
+	// ErrUnknownAccount is returned if a wallet has no account with the given index
+	ErrUnknownAccount = NewError(errors.New("wallet has no such account"))
+	// ErrEntryNotFound is returned when looking up the entry of an address that is not in the wallet
+	ErrEntryNotFound = NewError(errors.New("wallet has no entry for the address"))
+	// ErrEmptySearchFragment is returned when searching addresses with an empty fragment
+	ErrEmptySearchFragment = NewError(errors.New("search fragment is empty"))
+	// ErrInvalidDerivationState is returned when restoring a DerivationState that does not match the wallet
+	ErrInvalidDerivationState = NewError(errors.New("derivation state does not match the wallet"))
+	// ErrNoAuxDerivation is returned when deriving an auxiliary key of a wallet created without Options.AuxDerivation
+	ErrNoAuxDerivation = NewError(errors.New("wallet has no auxiliary key derivation path"))
+	// ErrSecretsNotFound is returned by a SecretStore that has no secrets of the wallet
+	ErrSecretsNotFound = NewError(errors.New("secrets of the wallet are not stored"))
+	// ErrTransactionNotSigned is returned when recording a transaction that is not fully signed
+	ErrTransactionNotSigned = NewError(errors.New("transaction is not fully signed"))
+	// ErrPendingTransactionNotFound is returned if a wallet has no pending transaction with the given id
+	ErrPendingTransactionNotFound = NewError(errors.New("wallet has no such pending transaction"))
+	// ErrWalletIsWatchOnly is returned when trying to generate addresses, sign, encrypt or read the seed of a watch-only wallet
+	ErrWalletIsWatchOnly = NewError(errors.New("wallet is watch-only"))
+	// ErrInvalidWalletFilename is returned if a wallet filename is not the name of a .wlt file in the wallet directory
+	ErrInvalidWalletFilename = NewError(errors.New("wallet filename must be a file name ending in .wlt"))
+	// ErrInvalidMnemonic is returned if the seed of a BIP39 wallet is not a valid BIP39 mnemonic, e.g. its checksum is wrong
+	ErrInvalidMnemonic = NewError(errors.New("seed is not a valid bip39 mnemonic"))
+	// ErrSeedPassphraseNotBip39 is returned when creating a wallet with a seed passphrase, but without Options.Bip39
+	ErrSeedPassphraseNotBip39 = NewError(errors.New("seed passphrase is only supported for bip39 seeds"))
+	// ErrFirstEntryNotCollection is returned when creating a wallet with Options.FirstEntry, but not of WalletTypeCollection
+	ErrFirstEntryNotCollection = NewError(errors.New("first entry is only supported for collection wallets"))
+	// ErrCollectionSeed is returned when creating a collection wallet with a seed
+	ErrCollectionSeed = NewError(errors.New("collection wallets have no seed"))
+	// ErrWalletNotCollection is returned when importing secret keys into a wallet that is not a collection wallet
+	ErrWalletNotCollection = NewError(errors.New("wallet is not a collection wallet"))
+	// ErrEntryNotImported is returned when setting the expiry of an entry that was not imported, see Entry.Expires
+	ErrEntryNotImported = NewError(errors.New("only imported entries can expire"))
+	// ErrExpireFirstEntry is returned when setting the expiry of the first entry of a wallet, which identifies the wallet
+	ErrExpireFirstEntry = NewError(errors.New("the first entry of a wallet can't expire"))
+	// ErrWalletHasSeed is returned when attaching a seed to a wallet that already has one, see Service.AttachSeed
+	ErrWalletHasSeed = NewError(errors.New("wallet already has a seed"))
+	// ErrWalletHasImportedEntries is returned when recovering a wallet from its seed, if it has imported entries
+	// whose secret keys can't be recovered from the seed
+	ErrWalletHasImportedEntries = NewError(errors.New("wallet has imported entries that can't be recovered from the seed"))
+	// ErrAddressNotFound is returned if no loaded wallet contains an address
+	ErrAddressNotFound = NewError(errors.New("address not found in any wallet"))
 )
 
 const (
 	// WalletExt wallet file extension
 	WalletExt = "wlt"
 
+	// QuarantineDir is the subdirectory of the wallet directory that duplicate wallet files are moved to,
+	// see Config.MergeDuplicatesOnLoad
+	QuarantineDir = "quarantine"
+
 	// WalletTimestampFormat wallet timestamp layout
 	WalletTimestampFormat = "2006_01_02"
 
@@ -91,6 +186,21 @@ const (
 
 	// WalletTypeDeterministic deterministic wallet type
 	WalletTypeDeterministic = "deterministic"
+	// WalletTypeWatchOnly watch-only wallet type, the wallet has addresses but no seed or secret keys
+	WalletTypeWatchOnly = "watch-only"
+	// WalletTypeCollection collection wallet type, the wallet has imported secret keys but no seed
+	WalletTypeCollection = "collection"
+
+	// SeedPassphraseHintMaxLength is the maximum length in characters of a seed passphrase hint
+	SeedPassphraseHintMaxLength = 128
+	// DefaultWalletNotesMaxLength is the default maximum length in characters of wallet notes, see Config.WalletNotesMaxLength
+	DefaultWalletNotesMaxLength = 4096
+	// DefaultMaxSearchResults is the default maximum number of addresses returned by SearchAddresses, see Config.MaxSearchResults
+	DefaultMaxSearchResults = 100
+
+	// DerivationPathDeterministic is reported as the derivation path of deterministic wallets without Options.Bip44.
+	// These wallets derive each key by hashing the previous seed and do not follow a BIP32 path.
+	DerivationPathDeterministic = "deterministic"
 )
 
 // ResolveCoinType normalizes a coin type string to a CoinType constant
@@ -101,6 +211,9 @@ func ResolveCoinType(s string) (CoinType, error) {
 	case "btc", "bitcoin":
 		return CoinTypeBitcoin, nil
 	default:
+		if validateCoinType(CoinType(s)) == nil {
+			return CoinType(s), nil
+		}
 		return CoinType(""), ErrInvalidCoinType
 	}
 }
@@ -111,6 +224,7 @@ const (
 	metaFilename   = "filename"   // wallet file name
 	metaLabel      = "label"      // wallet label
 	metaTimestamp  = "tm"         // the timestamp when creating the wallet
+	metaModified   = "modified"   // the timestamp when the wallet was last saved by the service
 	metaType       = "type"       // wallet type
 	metaCoin       = "coin"       // coin type
 	metaEncrypted  = "encrypted"  // whether the wallet is encrypted
@@ -118,6 +232,22 @@ const (
 	metaSeed       = "seed"       // wallet seed
 	metaLastSeed   = "lastSeed"   // seed for generating next address
 	metaSecrets    = "secrets"    // secrets which records the encrypted seeds and secrets of address entries
+	metaReadOnly   = "readOnly"   // whether the wallet is protected against modification
+
+	metaSeedPassphraseHint = "seedPassphraseHint" // hint to remember the seed passphrase, not secret
+	metaBip39              = "bip39"              // whether the seed is a BIP39 mnemonic, see Options.Bip39
+	metaSeedPassphrase     = "seedPassphrase"     // BIP39 seed passphrase, stored in the secrets when encrypted
+	metaNextIndex          = "nextIndex"          // chain index of the next address, set when indexes were skipped
+	metaNotes              = "notes"              // free-text notes about the wallet, not secret
+	metaAccounts           = "accounts"           // JSON encoded labels of the wallet's accounts, see NewAccount
+	metaTags               = "tags"               // JSON encoded tags of the wallet, not secret, see Tags
+	metaScanIndex          = "scanIndex"          // chain index of the next address to scan, see DerivationState
+	metaScanHeight         = "scanHeight"         // height of the last block scanned, see DerivationState
+	metaAuxDerivation      = "auxDerivation"      // derivation path of auxiliary keys, see Options.AuxDerivation
+	metaStableID           = "stableID"           // immutable identifier of the wallet, see StableID
+	metaPendingTxns        = "pendingTxns"        // JSON encoded signed transactions not known to be confirmed, see PendingTransactions
+	metaBip44              = "bip44"              // extended public key of the first BIP44 account, set if Options.Bip44, not secret
+	metaHardened           = "hardened"           // whether the BIP44 change and address levels are hardened, see Options.Hardened
 )
 
 // CoinType represents the wallet coin type
@@ -131,16 +261,75 @@ func NewWalletFilename() string {
 	return fmt.Sprintf("%s_%s.%s", timestamp, padding, WalletExt)
 }
 
+// validateWalletFilename returns ErrInvalidWalletFilename if name is not the name of a .wlt file, without directories
+func validateWalletFilename(name string) error {
+	if filepath.Base(name) != name || !strings.HasSuffix(name, "."+WalletExt) || name == "."+WalletExt {
+		return ErrInvalidWalletFilename
+	}
+	return nil
+}
+
 // Options options that could be used when creating a wallet
 type Options struct {
+	Type       string     // wallet type, WalletTypeDeterministic if empty, or WalletTypeCollection
 	Coin       CoinType   // coin type, skycoin, bitcoin, etc.
 	Label      string     // wallet label.
 	Seed       string     // wallet seed.
 	Encrypt    bool       // whether the wallet need to be encrypted.
 	Password   []byte     // password that would be used for encryption, and would only be used when 'Encrypt' is true.
-	CryptoType CryptoType // wallet encryption type, scrypt-chacha20poly1305 or sha256-xor.
+	CryptoType CryptoType // wallet encryption type, scrypt-chacha20poly1305, pbkdf2-chacha20poly1305 or sha256-xor.
 	ScanN      uint64     // number of addresses that're going to be scanned for a balance. The highest address with a balance will be used.
-	GenerateN  uint64     // number of addresses to generate, regardless of balance
+	GenerateN  uint64     // number of addresses to generate, regardless of balance. Zero generates a single address.
+
+	// IndexFilter is consulted for every chain index before its address is stored.
+	// Returning false skips the index: the deterministic chain still advances past it,
+	// but no entry is created. GenerateN and ScanN count stored addresses only, so skipped
+	// indexes do not count towards the scan gap limit. The filter is kept in memory only;
+	// the wallet persists the next chain index so that a reloaded wallet resumes at the
+	// correct position, but the filter must be deterministic and supplied again to
+	// reproduce the same entries when regenerating or recovering the wallet.
+	// The filter must not reject every index. Wallets of a Service use Config.IndexFilter instead.
+	IndexFilter func(index uint64) bool
+
+	// SeedDeriver derives the key pair for each seed in the deterministic chain,
+	// replacing cipher.GenerateDeterministicKeyPair. The chain itself still advances
+	// by the standard seed hash. Like IndexFilter it is kept in memory only and must
+	// be supplied again to reproduce the same entries. Nil uses the standard derivation.
+	SeedDeriver func(seed []byte) (cipher.PubKey, cipher.SecKey, error)
+
+	// AuxDerivation is the derivation path of auxiliary keys derived from the seed with Wallet.AuxKey,
+	// for features outside of the wallet's addresses. The keys are isolated from the address chains of the
+	// wallet and its accounts. The path is stored in the wallet. Empty disables auxiliary keys.
+	AuxDerivation string
+
+	// Bip39 indicates that Seed is a BIP39 mnemonic. The mnemonic checksum is validated, and the address chain
+	// starts from the BIP39 seed of the mnemonic and SeedPassphrase instead of the mnemonic itself.
+	Bip39 bool
+	// SeedPassphrase is the BIP39 passphrase of the mnemonic, only used with Bip39. It is a secret like the seed,
+	// and is needed again to recover the wallet, see Service.RecoverWalletWithPassphrase.
+	SeedPassphrase string
+	// Bip44 derives the addresses with BIP32 along the BIP44 path m/44'/8000'/account'/0/index from the BIP39 seed,
+	// instead of the deterministic seed chain, so that they match hardware wallets restored from the same mnemonic.
+	// The main address chain is account 0, and the accounts created with Service.NewAccount follow it.
+	// It requires Bip39, is only supported for Skycoin wallets and can't be combined with SeedDeriver.
+	Bip44 bool
+	// Hardened derives the change and address levels of the BIP44 path as hardened children,
+	// m/44'/8000'/account'/0'/index', instead of the default m/44'/8000'/account'/0/index. It requires Bip44.
+	// With normal derivation, anyone holding the account's extended public key can derive all of its addresses,
+	// which allows watch-only wallets, but the xpub together with any single leaked address secret key reveals
+	// the account's extended private key and so every secret key of the account. Hardened derivation removes
+	// that risk, at the cost that the addresses can't be derived from the xpub: the wallet can't be exported with
+	// Service.ExportHWWatchOnly, and hardware wallets following the standard path derive different addresses.
+	// The setting is saved in the wallet and used again when generating and recovering addresses.
+	Hardened bool
+
+	// FirstEntry is the first entry of a collection wallet, which has no seed to derive it from, e.g. to create
+	// a wallet with a known first address for tests. Address and Public are required and must match, Secret is
+	// optional and must match Public if set. It is rejected for other wallet types.
+	FirstEntry *Entry
+
+	cryptor      cryptor                     // encrypts the wallet instead of the cryptor of CryptoType, set by the service, see Config.ScryptN
+	scanProgress func(scanned, found uint64) // called after each batch scanned with ScanN, set by the service, see WalletAddressScanned
 }
 
 // Wallet is consisted of meta and entries.
@@ -152,10 +341,25 @@ type Options struct {
 type Wallet struct {
 	Meta    map[string]string
 	Entries []Entry
+
+	indexFilter func(index uint64) bool // see Options.IndexFilter, not persisted
+	seedDeriver func(seed []byte) (cipher.PubKey, cipher.SecKey, error) // see Options.SeedDeriver, not persisted
+	secretStore SecretStore                                             // see Config.SecretStore, not persisted
 }
 
 // newWallet creates a wallet instance with given name and options.
 func newWallet(wltName string, opts Options, bg BalanceGetter) (*Wallet, error) {
+	switch opts.Type {
+	case "", WalletTypeDeterministic:
+		if opts.FirstEntry != nil {
+			return nil, ErrFirstEntryNotCollection
+		}
+	case WalletTypeCollection:
+		return newCollectionWallet(wltName, opts)
+	default:
+		return nil, ErrUnknownWalletType
+	}
+
 	if opts.Seed == "" {
 		return nil, ErrMissingSeed
 	}
@@ -164,15 +368,34 @@ func newWallet(wltName string, opts Options, bg BalanceGetter) (*Wallet, error)
 		return nil, ErrNilBalanceGetter
 	}
 
+	if opts.Bip39 {
+		if err := bip39.ValidateMnemonic(opts.Seed); err != nil {
+			return nil, ErrInvalidMnemonic
+		}
+	} else if opts.SeedPassphrase != "" {
+		return nil, ErrSeedPassphraseNotBip39
+	}
+
+	if opts.Bip44 {
+		switch {
+		case !opts.Bip39:
+			return nil, ErrBip44NotBip39
+		case opts.Coin != "" && opts.Coin != CoinTypeSkycoin:
+			return nil, ErrBip44Coin
+		case opts.SeedDeriver != nil:
+			return nil, ErrBip44SeedDeriver
+		}
+	} else if opts.Hardened {
+		return nil, ErrHardenedNotBip44
+	}
+
 	coin := opts.Coin
 	if coin == "" {
 		coin = CoinTypeSkycoin
 	}
 
-	switch coin {
-	case CoinTypeSkycoin, CoinTypeBitcoin:
-	default:
-		return nil, fmt.Errorf("Invalid coin type %q", coin)
+	if err := validateCoinType(coin); err != nil {
+		return nil, err
 	}
 
 	w := &Wallet{
@@ -188,7 +411,30 @@ func newWallet(wltName string, opts Options, bg BalanceGetter) (*Wallet, error)
 			metaEncrypted:  "false",
 			metaCryptoType: "",
 			metaSecrets:    "",
+			metaStableID:   newStableID(),
 		},
+		indexFilter: opts.IndexFilter,
+		seedDeriver: opts.SeedDeriver,
+	}
+
+	if opts.AuxDerivation != "" {
+		w.Meta[metaAuxDerivation] = opts.AuxDerivation
+	}
+
+	if opts.Bip39 {
+		w.Meta[metaBip39] = "true"
+		w.setSeedPassphrase(opts.SeedPassphrase)
+	}
+
+	if opts.Bip44 {
+		k, err := w.bip44AccountKey(0)
+		if err != nil {
+			return nil, err
+		}
+		w.Meta[metaBip44] = k.PublicKey().String()
+		if opts.Hardened {
+			w.Meta[metaHardened] = "true"
+		}
 	}
 
 	// Create a default wallet
@@ -206,7 +452,7 @@ func newWallet(wltName string, opts Options, bg BalanceGetter) (*Wallet, error)
 
 	if opts.ScanN > generateN {
 		// Scan for addresses with balances
-		if _, err := w.ScanAddresses(opts.ScanN, bg); err != nil {
+		if _, err := w.scanAddresses(opts.ScanN, bg, opts.scanProgress); err != nil {
 			return nil, err
 		}
 	}
@@ -225,12 +471,17 @@ func newWallet(wltName string, opts Options, bg BalanceGetter) (*Wallet, error)
 	}
 
 	// Checks crypto type
-	if _, err := getCrypto(opts.CryptoType); err != nil {
-		return nil, err
+	crypto := opts.cryptor
+	if crypto == nil {
+		var err error
+		crypto, err = getCrypto(opts.CryptoType)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Encrypt the wallet
-	if err := w.Lock(opts.Password, opts.CryptoType); err != nil {
+	if err := w.lock(opts.Password, opts.CryptoType, crypto); err != nil {
 		return nil, err
 	}
 
@@ -242,6 +493,190 @@ func newWallet(wltName string, opts Options, bg BalanceGetter) (*Wallet, error)
 	return w, nil
 }
 
+// newCollectionWallet creates a collection wallet with the first entry of the options
+func newCollectionWallet(wltName string, opts Options) (*Wallet, error) {
+	if opts.Seed != "" || opts.Bip39 || opts.Bip44 {
+		return nil, ErrCollectionSeed
+	}
+
+	if opts.FirstEntry == nil {
+		return nil, ErrWalletEmpty
+	}
+
+	coin := opts.Coin
+	if coin == "" {
+		coin = CoinTypeSkycoin
+	}
+
+	if err := validateCoinType(coin); err != nil {
+		return nil, err
+	}
+
+	w := &Wallet{
+		Meta: map[string]string{
+			metaFilename:   wltName,
+			metaVersion:    Version,
+			metaLabel:      opts.Label,
+			metaTimestamp:  strconv.FormatInt(time.Now().Unix(), 10),
+			metaType:       WalletTypeCollection,
+			metaCoin:       string(coin),
+			metaEncrypted:  "false",
+			metaCryptoType: "",
+			metaSecrets:    "",
+			metaStableID:   newStableID(),
+		},
+	}
+
+	e := *opts.FirstEntry
+	if err := w.verifyImportedEntry(e); err != nil {
+		return nil, NewError(fmt.Errorf("invalid first entry: %v", err))
+	}
+	e.Imported = true
+	w.Entries = append(w.Entries, e)
+
+	if !opts.Encrypt {
+		if len(opts.Password) != 0 {
+			return nil, ErrMissingEncrypt
+		}
+		return w, nil
+	}
+
+	if len(opts.Password) == 0 {
+		return nil, ErrMissingPassword
+	}
+
+	crypto := opts.cryptor
+	if crypto == nil {
+		var err error
+		crypto, err = getCrypto(opts.CryptoType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.lock(opts.Password, opts.CryptoType, crypto); err != nil {
+		return nil, err
+	}
+
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// AttachSeed sets the seed of a collection wallet, converting it to a deterministic wallet that keeps its imported
+// entries. Addresses generated from the seed follow the imported entries, and keys of the seed that were imported
+// are not generated again. The seed is used like Options.Seed without Options.Bip39.
+// Returns ErrWalletHasSeed if the wallet is not a collection wallet.
+func (w *Wallet) AttachSeed(seed string) error {
+	if w.IsWatchOnly() {
+		return ErrWalletIsWatchOnly
+	}
+
+	if !w.IsCollection() {
+		return ErrWalletHasSeed
+	}
+
+	if seed == "" {
+		return ErrMissingSeed
+	}
+
+	if w.IsEncrypted() {
+		return ErrWalletEncrypted
+	}
+
+	w.setSeed(seed)
+	w.setLastSeed(seed)
+	w.Meta[metaType] = WalletTypeDeterministic
+	return nil
+}
+
+// importedAddresses returns the set of addresses of the imported entries
+func (w *Wallet) importedAddresses() map[cipher.Addresser]struct{} {
+	var addrs map[cipher.Addresser]struct{}
+	for _, e := range w.Entries {
+		if !e.Imported {
+			continue
+		}
+		if addrs == nil {
+			addrs = make(map[cipher.Addresser]struct{})
+		}
+		addrs[e.Address] = struct{}{}
+	}
+	return addrs
+}
+
+// verifyImportedEntry checks that an entry to import into the wallet is self-consistent:
+// the address is the wallet coin's address of the public key, and the secret key, if any, is the public key's
+func (w *Wallet) verifyImportedEntry(e Entry) error {
+	if e.Address == nil || e.Address.Null() {
+		return errors.New("address missing")
+	}
+
+	if err := e.Public.Verify(); err != nil {
+		return err
+	}
+
+	if w.addressConstructor()(e.Public) != e.Address {
+		return errors.New("address does not belong to the public key")
+	}
+
+	if e.Secret.Null() {
+		return nil
+	}
+
+	pk, err := cipher.PubKeyFromSecKey(e.Secret)
+	if err != nil {
+		return err
+	}
+	if pk != e.Public {
+		return errors.New("invalid public key for secret key")
+	}
+
+	return nil
+}
+
+// newWatchOnlyWallet creates a watch-only wallet with the given addresses
+func newWatchOnlyWallet(wltName string, addrs []cipher.Address) (*Wallet, error) {
+	if len(addrs) == 0 {
+		return nil, ErrWalletEmpty
+	}
+
+	w := &Wallet{
+		Meta: map[string]string{
+			metaFilename:   wltName,
+			metaVersion:    Version,
+			metaTimestamp:  strconv.FormatInt(time.Now().Unix(), 10),
+			metaType:       WalletTypeWatchOnly,
+			metaCoin:       string(CoinTypeSkycoin),
+			metaEncrypted:  "false",
+			metaCryptoType: "",
+			metaSecrets:    "",
+			metaStableID:   newStableID(),
+		},
+	}
+
+	seen := make(map[cipher.Address]struct{}, len(addrs))
+	for _, a := range addrs {
+		if a.Null() {
+			return nil, errors.New("watch-only wallet address is null")
+		}
+		if _, ok := seen[a]; ok {
+			return nil, fmt.Errorf("duplicate address %s", a)
+		}
+		seen[a] = struct{}{}
+
+		w.Entries = append(w.Entries, Entry{
+			Address: a,
+		})
+	}
+
+	w.setNextIndex(uint64(len(w.Entries)))
+
+	return w, nil
+}
+
 // NewWallet creates wallet without scanning addresses
 func NewWallet(wltName string, opts Options) (*Wallet, error) {
 	return newWallet(wltName, opts, nil)
@@ -254,6 +689,20 @@ func NewWalletScanAhead(wltName string, opts Options, bg BalanceGetter) (*Wallet
 
 // Lock encrypts the wallet with the given password and specific crypto type
 func (w *Wallet) Lock(password []byte, cryptoType CryptoType) error {
+	crypto, err := getCrypto(cryptoType)
+	if err != nil {
+		return err
+	}
+
+	return w.lock(password, cryptoType, crypto)
+}
+
+// lock encrypts the wallet with the given password and cryptor of the crypto type
+func (w *Wallet) lock(password []byte, cryptoType CryptoType, crypto cryptor) error {
+	if w.IsWatchOnly() {
+		return ErrWalletIsWatchOnly
+	}
+
 	if len(password) == 0 {
 		return ErrMissingPassword
 	}
@@ -274,6 +723,9 @@ func (w *Wallet) Lock(password []byte, cryptoType CryptoType) error {
 
 	ss.set(secretSeed, wlt.seed())
 	ss.set(secretLastSeed, wlt.lastSeed())
+	if p := wlt.seedPassphrase(); p != "" {
+		ss.set(secretSeedPassphrase, p)
+	}
 
 	// Saves address's secret keys in secrets
 	for _, e := range wlt.Entries {
@@ -285,11 +737,6 @@ func (w *Wallet) Lock(password []byte, cryptoType CryptoType) error {
 		return err
 	}
 
-	crypto, err := getCrypto(cryptoType)
-	if err != nil {
-		return err
-	}
-
 	// Encrypts the secrets
 	encSecret, err := crypto.Encrypt(sb, password)
 	if err != nil {
@@ -323,6 +770,18 @@ func (w *Wallet) Lock(password []byte, cryptoType CryptoType) error {
 // Returns error if the decryption fails
 // The temporary decrypted wallet should be erased from memory when done.
 func (w *Wallet) Unlock(password []byte) (*Wallet, error) {
+	sb, err := w.decryptSecrets(password)
+	if err != nil {
+		return nil, err
+	}
+	defer eraseBytes(sb)
+
+	return w.unlockSecrets(sb)
+}
+
+// decryptSecrets decrypts the secrets of the encrypted wallet, returning them serialized.
+// The decrypted secrets should be erased from memory when done.
+func (w *Wallet) decryptSecrets(password []byte) ([]byte, error) {
 	if !w.IsEncrypted() {
 		return nil, ErrWalletNotEncrypted
 	}
@@ -331,10 +790,8 @@ func (w *Wallet) Unlock(password []byte) (*Wallet, error) {
 		return nil, ErrMissingPassword
 	}
 
-	wlt := w.clone()
-
 	// Gets the secrets string
-	sstr := wlt.secrets()
+	sstr := w.secrets()
 	if sstr == "" {
 		return nil, errors.New("secrets doesn't exsit")
 	}
@@ -356,6 +813,14 @@ func (w *Wallet) Unlock(password []byte) (*Wallet, error) {
 		return nil, ErrInvalidPassword
 	}
 
+	return sb, nil
+}
+
+// unlockSecrets creates a temporary decrypted copy of the encrypted wallet from its
+// serialized decrypted secrets, see decryptSecrets
+func (w *Wallet) unlockSecrets(sb []byte) (*Wallet, error) {
+	wlt := w.clone()
+
 	// Deserialize into secrets
 	ss := make(secrets)
 	defer ss.erase()
@@ -375,6 +840,10 @@ func (w *Wallet) Unlock(password []byte) (*Wallet, error) {
 	}
 	wlt.setLastSeed(lastSeed)
 
+	if p, ok := ss.get(secretSeedPassphrase); ok {
+		wlt.setSeedPassphrase(p)
+	}
+
 	// Gets addresses related secrets
 	for i, e := range wlt.Entries {
 		sstr, ok := ss.get(e.Address.String())
@@ -412,9 +881,10 @@ func (w *Wallet) copyFrom(src *Wallet) {
 
 // Erase wipes secret fields in wallet
 func (w *Wallet) Erase() {
-	// Wipes the seed and last seed
+	// Wipes the seed, last seed and seed passphrase
 	w.setSeed("")
 	w.setLastSeed("")
+	w.setSeedPassphrase("")
 
 	// Wipes private keys in entries
 	for i := range w.Entries {
@@ -438,12 +908,12 @@ func (w *Wallet) GuardUpdate(password []byte, fn func(w *Wallet) error) error {
 	}
 
 	cryptoType := w.cryptoType()
-	wlt, err := w.Unlock(password)
+	wlt, release, err := w.unlockGuarded(password)
 	if err != nil {
 		return err
 	}
 
-	defer wlt.Erase()
+	defer release()
 
 	if err := fn(wlt); err != nil {
 		return err
@@ -470,16 +940,66 @@ func (w *Wallet) GuardView(password []byte, f func(w *Wallet) error) error {
 		return ErrMissingPassword
 	}
 
-	wlt, err := w.Unlock(password)
+	wlt, release, err := w.unlockGuarded(password)
 	if err != nil {
 		return err
 	}
 
-	defer wlt.Erase()
+	defer release()
 
 	return f(wlt)
 }
 
+// unlockGuarded decrypts the wallet for GuardView and GuardUpdate. If the wallet has a SecretStore, see Config.SecretStore,
+// the decrypted secrets are held by the store under the wallet id while the wallet is unlocked, and the decrypted copy
+// is made from the stored secrets. The returned function erases the decrypted copy and the stored secrets.
+func (w *Wallet) unlockGuarded(password []byte) (*Wallet, func(), error) {
+	if w.secretStore == nil {
+		wlt, err := w.Unlock(password)
+		if err != nil {
+			return nil, nil, err
+		}
+		return wlt, wlt.Erase, nil
+	}
+
+	sb, err := w.decryptSecrets(password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store := w.secretStore
+	wltID := w.Filename()
+	err = store.Put(wltID, sb)
+	eraseBytes(sb)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	erase := func() {
+		if err := store.Erase(wltID); err != nil {
+			logger.WithError(err).Errorf("Erase secrets of wallet %s failed", wltID)
+		}
+	}
+
+	sb, err = store.Get(wltID)
+	if err != nil {
+		erase()
+		return nil, nil, err
+	}
+	defer eraseBytes(sb)
+
+	wlt, err := w.unlockSecrets(sb)
+	if err != nil {
+		erase()
+		return nil, nil, err
+	}
+
+	return wlt, func() {
+		wlt.Erase()
+		erase()
+	}, nil
+}
+
 // Load loads wallet from a given file
 func Load(wltFile string) (*Wallet, error) {
 	if _, err := os.Stat(wltFile); os.IsNotExist(err) {
@@ -496,14 +1016,77 @@ func Load(wltFile string) (*Wallet, error) {
 	return r.ToWallet()
 }
 
+// supportedWalletVersions are the wallet file versions that can be loaded
+var supportedWalletVersions = map[string]struct{}{
+	"":    {},
+	"0.1": {},
+	"0.2": {},
+}
+
+// WalletFileInfo describes a wallet file, see ValidateWalletFile
+type WalletFileInfo struct {
+	Type         string
+	Version      string
+	Coin         CoinType
+	Encrypted    bool
+	AddressCount int
+}
+
+// ValidateWalletFile checks whether a wallet file can be loaded, without loading it into a Service.
+// It returns a description of the wallet, which doesn't contain any secret data.
+func ValidateWalletFile(wltFile string) (WalletFileInfo, error) {
+	if _, err := os.Stat(wltFile); os.IsNotExist(err) {
+		return WalletFileInfo{}, fmt.Errorf("wallet %s doesn't exist", wltFile)
+	}
+
+	r := &ReadableWallet{}
+	if err := r.Load(wltFile); err != nil {
+		return WalletFileInfo{}, NewError(fmt.Errorf("wallet file is corrupted: %v", err))
+	}
+
+	if r.Meta == nil {
+		return WalletFileInfo{}, NewError(errors.New("wallet file is corrupted: meta field missing"))
+	}
+
+	if _, ok := supportedWalletVersions[r.Meta[metaVersion]]; !ok {
+		return WalletFileInfo{}, ErrUnsupportedWalletVersion
+	}
+
+	r.normalizeCoinType()
+	r.Meta[metaFilename] = filepath.Base(wltFile)
+
+	w, err := r.ToWallet()
+	if err != nil {
+		return WalletFileInfo{}, NewError(err)
+	}
+
+	if err := validateCoinType(w.coin()); err != nil {
+		return WalletFileInfo{}, err
+	}
+
+	if len(w.Entries) == 0 {
+		return WalletFileInfo{}, ErrWalletEmpty
+	}
+
+	return WalletFileInfo{
+		Type:         w.Type(),
+		Version:      w.Version(),
+		Coin:         w.coin(),
+		Encrypted:    w.IsEncrypted(),
+		AddressCount: len(w.Entries),
+	}, nil
+}
+
 // Save saves the wallet to given dir
 func (w *Wallet) Save(dir string) error {
+	w.assignStableID()
 	r := NewReadableWallet(w)
 	return r.Save(filepath.Join(dir, w.Filename()))
 }
 
-// removeBackupFiles removes any *.wlt.bak files whom have version 0.1 and *.wlt matched in the given directory
-func removeBackupFiles(dir string) error {
+// removeBackupFiles removes any *.wlt.bak files whom have version 0.1 and *.wlt matched in the given directory.
+// If keep is greater than zero, the *.wlt.bak files are rotated instead of removed, see rotateBackupFile.
+func removeBackupFiles(dir string, keep int) error {
 	fs, err := filterDir(dir, ".wlt")
 	if err != nil {
 		return err
@@ -532,6 +1115,13 @@ func removeBackupFiles(dir string) error {
 			}
 
 			if w.Version() == "0.1" {
+				if keep > 0 {
+					if err := rotateBackupFile(bf, keep); err != nil {
+						return err
+					}
+					continue
+				}
+
 				if err := os.Remove(bf); err != nil {
 					return err
 				}
@@ -542,6 +1132,45 @@ func removeBackupFiles(dir string) error {
 	return nil
 }
 
+// rotateBackupFile renames bak to bak.1, after renaming the older backups bak.1, bak.2, etc. to bak.2, bak.3, etc.
+// Only the keep most recent backups are retained, older ones are removed.
+func rotateBackupFile(bak string, keep int) error {
+	// Removes the backups that would be rotated beyond keep
+	rotated, err := filterDir(filepath.Dir(bak), "")
+	if err != nil {
+		return err
+	}
+
+	prefix := bak + "."
+	for _, f := range rotated {
+		if !strings.HasPrefix(f, prefix) {
+			continue
+		}
+
+		n, err := strconv.Atoi(strings.TrimPrefix(f, prefix))
+		if err != nil || n < keep {
+			continue
+		}
+
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+
+	for i := keep - 1; i > 0; i-- {
+		f := fmt.Sprintf("%s.%d", bak, i)
+		if _, err := os.Stat(f); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := os.Rename(f, fmt.Sprintf("%s.%d", bak, i+1)); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(bak, bak+".1")
+}
+
 func filterDir(dir string, suffix string) ([]string, error) {
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
@@ -556,10 +1185,17 @@ func filterDir(dir string, suffix string) ([]string, error) {
 	return res, nil
 }
 
-// reset resets the wallet entries and move the lastSeed to origin
+// reset resets the wallet entries and move the lastSeed to origin, keeping the imported entries
 func (w *Wallet) reset() {
-	w.Entries = []Entry{}
+	entries := []Entry{}
+	for _, e := range w.Entries {
+		if e.Imported {
+			entries = append(entries, e)
+		}
+	}
+	w.Entries = entries
 	w.setLastSeed(w.seed())
+	w.setNextIndex(0)
 }
 
 // Validate validates the wallet
@@ -579,7 +1215,7 @@ func (w *Wallet) Validate() error {
 	if !ok {
 		return errors.New("type field not set")
 	}
-	if walletType != WalletTypeDeterministic {
+	if walletType != WalletTypeDeterministic && walletType != WalletTypeWatchOnly && walletType != WalletTypeCollection {
 		return errors.New("wallet type invalid")
 	}
 
@@ -598,7 +1234,12 @@ func (w *Wallet) Validate() error {
 	}
 
 	// checks if the secrets field is empty
-	if isEncrypted {
+	switch {
+	case walletType == WalletTypeWatchOnly:
+		if isEncrypted {
+			return errors.New("watch-only wallet can't be encrypted")
+		}
+	case isEncrypted:
 		cryptoType, ok := w.Meta[metaCryptoType]
 		if !ok {
 			return errors.New("crypto type field not set")
@@ -611,7 +1252,9 @@ func (w *Wallet) Validate() error {
 		if s := w.Meta[metaSecrets]; s == "" {
 			return errors.New("wallet is encrypted, but secrets field not set")
 		}
-	} else {
+	case walletType == WalletTypeCollection:
+		// Collection wallets have no seed
+	default:
 		if s := w.Meta[metaSeed]; s == "" {
 			return errors.New("seed missing in unencrypted wallet")
 		}
@@ -621,14 +1264,158 @@ func (w *Wallet) Validate() error {
 		}
 	}
 
-	return nil
-}
-
-// Type gets the wallet type
+	if s, ok := w.Meta[metaNextIndex]; ok {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return errors.New("invalid nextIndex")
+		}
+		if n < w.accountEntries(0) {
+			return errors.New("nextIndex is less than the number of entries")
+		}
+	}
+
+	for _, k := range []string{metaScanIndex, metaScanHeight} {
+		if s, ok := w.Meta[k]; ok {
+			if _, err := strconv.ParseUint(s, 10, 64); err != nil {
+				return fmt.Errorf("invalid %s", k)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Type gets the wallet type
 func (w *Wallet) Type() string {
 	return w.Meta[metaType]
 }
 
+// DerivationPath returns the derivation path used to generate the wallet's addresses
+func (w *Wallet) DerivationPath() (string, error) {
+	switch w.Type() {
+	case WalletTypeDeterministic:
+		if w.IsBip44() {
+			return w.bip44ChainPath(0), nil
+		}
+		return DerivationPathDeterministic, nil
+	case WalletTypeWatchOnly:
+		return "", ErrWalletIsWatchOnly
+	case WalletTypeCollection:
+		return "", ErrWalletNotDeterministic
+	default:
+		return "", ErrUnknownWalletType
+	}
+}
+
+// Capabilities describes the operations supported by a wallet
+type Capabilities struct {
+	CanSign      bool // the wallet has the secret keys of its addresses
+	CanGenerate  bool // new addresses can be generated
+	CanRecover   bool // the wallet can be recovered from its seed with Service.RecoverWallet
+	HasSeed      bool // the wallet addresses are derived from a seed
+	CanImportKey bool // individual secret keys can be imported
+}
+
+// Capabilities returns the operations supported by the wallet, taking its type,
+// encryption and read-only flag into account
+func (w *Wallet) Capabilities() (Capabilities, error) {
+	switch w.Type() {
+	case WalletTypeDeterministic:
+		return Capabilities{
+			CanSign:     true,
+			CanGenerate: !w.IsReadOnly(),
+			CanRecover:  w.IsEncrypted() && !w.IsReadOnly() && len(w.importedAddresses()) == 0,
+			HasSeed:     true,
+		}, nil
+	case WalletTypeWatchOnly:
+		return Capabilities{}, nil
+	case WalletTypeCollection:
+		return Capabilities{
+			CanSign:      true,
+			CanImportKey: !w.IsReadOnly(),
+		}, nil
+	default:
+		return Capabilities{}, ErrUnknownWalletType
+	}
+}
+
+// RecoveryChecklist describes what is needed to recover a wallet with Service.RecoverWallet
+type RecoveryChecklist struct {
+	Deterministic      bool     // the wallet addresses are derived from its seed, so the seed is enough to recover them
+	CanRecover         bool     // RecoverWallet accepts the wallet as it is, see Capabilities.CanRecover
+	SeedPassphraseHint string   // hint to the seed passphrase, empty if none was set
+	AddressCount       uint64   // number of addresses of the main address chain that are regenerated
+	AccountAddresses   []uint64 // number of addresses of each account that are regenerated, account i is at index i-1
+	Coin               CoinType // coin type of the wallet
+}
+
+// RecoveryChecklist returns what is needed to recover the wallet from its seed.
+// The wallet does not need to be decrypted.
+func (w *Wallet) RecoveryChecklist() (RecoveryChecklist, error) {
+	c, err := w.Capabilities()
+	if err != nil {
+		return RecoveryChecklist{}, err
+	}
+
+	rc := RecoveryChecklist{
+		Deterministic:      w.Type() == WalletTypeDeterministic,
+		CanRecover:         c.CanRecover && len(w.Entries) > 0,
+		SeedPassphraseHint: w.SeedPassphraseHint(),
+		AddressCount:       w.accountEntries(0),
+		Coin:               w.coin(),
+	}
+
+	for i := range w.Accounts() {
+		rc.AccountAddresses = append(rc.AccountAddresses, w.accountEntries(uint32(i+1)))
+	}
+
+	return rc, nil
+}
+
+// DerivationState records how far the addresses of a wallet were generated and scanned,
+// so that scanning can be resumed where it left off
+type DerivationState struct {
+	// NextIndex is the chain index of the next address the wallet generates
+	NextIndex uint64
+	// ScanIndex is the chain index of the next address to scan
+	ScanIndex uint64
+	// ScannedHeight is the height of the last block scanned
+	ScannedHeight uint64
+}
+
+// DerivationState returns the derivation state of the wallet's main address chain
+func (w *Wallet) DerivationState() DerivationState {
+	// These values are validated by wallet.Validate()
+	scanIndex, _ := strconv.ParseUint(w.Meta[metaScanIndex], 10, 64)   // nolint: errcheck
+	scanHeight, _ := strconv.ParseUint(w.Meta[metaScanHeight], 10, 64) // nolint: errcheck
+	return DerivationState{
+		NextIndex:     w.nextIndex(),
+		ScanIndex:     scanIndex,
+		ScannedHeight: scanHeight,
+	}
+}
+
+// setDerivationState records the scan progress of the wallet.
+// The next index is determined by the wallet's addresses and must match the wallet's.
+func (w *Wallet) setDerivationState(s DerivationState) error {
+	if s.NextIndex != w.nextIndex() || s.ScanIndex > s.NextIndex {
+		return ErrInvalidDerivationState
+	}
+
+	for k, v := range map[string]uint64{
+		metaScanIndex:  s.ScanIndex,
+		metaScanHeight: s.ScannedHeight,
+	} {
+		if v == 0 {
+			delete(w.Meta, k)
+		} else {
+			w.Meta[k] = strconv.FormatUint(v, 10)
+		}
+	}
+
+	return nil
+}
+
 // Version gets the wallet version
 func (w *Wallet) Version() string {
 	return w.Meta[metaVersion]
@@ -658,6 +1445,60 @@ func (w *Wallet) setLabel(label string) {
 	w.Meta[metaLabel] = label
 }
 
+// StableID returns the immutable identifier of the wallet, which unlike the filename never changes.
+// Wallets created before stable ids were introduced get one when they are saved.
+func (w *Wallet) StableID() string {
+	return w.Meta[metaStableID]
+}
+
+func (w *Wallet) setStableID(id string) {
+	if id == "" {
+		delete(w.Meta, metaStableID)
+		return
+	}
+	w.Meta[metaStableID] = id
+}
+
+// assignStableID gives the wallet a stable id if it has none
+func (w *Wallet) assignStableID() {
+	if w.StableID() == "" {
+		w.setStableID(newStableID())
+	}
+}
+
+// newStableID generates a random (version 4) UUID
+func newStableID() string {
+	return formatStableID(cipher.RandByte(16))
+}
+
+// formatStableID formats 16 random bytes as a version 4 UUID
+func formatStableID(b []byte) string {
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// nextIndex returns the chain index of the next address to generate
+func (w *Wallet) nextIndex() uint64 {
+	s, ok := w.Meta[metaNextIndex]
+	if !ok {
+		return w.accountEntries(0)
+	}
+	// This value is validated by wallet.Validate()
+	n, _ := strconv.ParseUint(s, 10, 64) // nolint: errcheck
+	return n
+}
+
+// setNextIndex records the chain index of the next address to generate.
+// The field is only stored when it differs from the number of entries of the wallet's main chain.
+func (w *Wallet) setNextIndex(n uint64) {
+	if n == w.accountEntries(0) {
+		delete(w.Meta, metaNextIndex)
+		return
+	}
+	w.Meta[metaNextIndex] = strconv.FormatUint(n, 10)
+}
+
 // lastSeed returns the last seed
 func (w *Wallet) lastSeed() string {
 	return w.Meta[metaLastSeed]
@@ -675,24 +1516,53 @@ func (w *Wallet) setSeed(seed string) {
 	w.Meta[metaSeed] = seed
 }
 
+// IsBip39 checks whether the wallet seed is a BIP39 mnemonic, see Options.Bip39
+func (w *Wallet) IsBip39() bool {
+	b, _ := strconv.ParseBool(w.Meta[metaBip39]) // nolint: errcheck
+	return b
+}
+
+func (w *Wallet) seedPassphrase() string {
+	return w.Meta[metaSeedPassphrase]
+}
+
+func (w *Wallet) setSeedPassphrase(passphrase string) {
+	if passphrase == "" {
+		delete(w.Meta, metaSeedPassphrase)
+		return
+	}
+	w.Meta[metaSeedPassphrase] = passphrase
+}
+
+// chainSeed returns the seed that the address chains of the wallet start from, which is the BIP39 seed
+// of the mnemonic and seed passphrase for BIP39 wallets, and the wallet seed itself otherwise
+func (w *Wallet) chainSeed() ([]byte, error) {
+	if !w.IsBip39() {
+		return []byte(w.seed()), nil
+	}
+
+	seed, err := bip39.NewSeed(w.seed(), w.seedPassphrase())
+	if err != nil {
+		return nil, ErrInvalidMnemonic
+	}
+	return seed, nil
+}
+
+// Coin returns the coin type of the wallet
+func (w *Wallet) Coin() CoinType {
+	return w.coin()
+}
+
 func (w *Wallet) coin() CoinType {
 	return CoinType(w.Meta[metaCoin])
 }
 
 func (w *Wallet) addressConstructor() func(cipher.PubKey) cipher.Addresser {
-	switch w.coin() {
-	case CoinTypeSkycoin:
-		return func(pk cipher.PubKey) cipher.Addresser {
-			return cipher.AddressFromPubKey(pk)
-		}
-	case CoinTypeBitcoin:
-		return func(pk cipher.PubKey) cipher.Addresser {
-			return cipher.BitcoinAddressFromPubKey(pk)
-		}
-	default:
+	f, err := getAddressConstructor(w.coin())
+	if err != nil {
 		logger.Panicf("Invalid wallet coin type %q", w.coin())
-		return nil
 	}
+	return f
 }
 
 func (w *Wallet) setEncrypted(encrypt bool) {
@@ -717,6 +1587,491 @@ func (w *Wallet) IsEncrypted() bool {
 	return b
 }
 
+// SeedPassphraseHint returns the seed passphrase hint
+func (w *Wallet) SeedPassphraseHint() string {
+	return w.Meta[metaSeedPassphraseHint]
+}
+
+func (w *Wallet) setSeedPassphraseHint(hint string) {
+	if hint == "" {
+		delete(w.Meta, metaSeedPassphraseHint)
+		return
+	}
+	w.Meta[metaSeedPassphraseHint] = hint
+}
+
+// Notes returns the wallet notes
+func (w *Wallet) Notes() string {
+	return w.Meta[metaNotes]
+}
+
+func (w *Wallet) setNotes(notes string) {
+	if notes == "" {
+		delete(w.Meta, metaNotes)
+		return
+	}
+	w.Meta[metaNotes] = notes
+}
+
+// Tags returns the tags of the wallet, in the order they were set
+func (w *Wallet) Tags() []string {
+	v := w.Meta[metaTags]
+	if v == "" {
+		return nil
+	}
+
+	var tags []string
+	if err := json.Unmarshal([]byte(v), &tags); err != nil {
+		logger.WithError(err).Errorf("Invalid tags of wallet %s", w.Filename())
+		return nil
+	}
+	return tags
+}
+
+func (w *Wallet) setTags(tags []string) {
+	if len(tags) == 0 {
+		delete(w.Meta, metaTags)
+		return
+	}
+
+	b, err := json.Marshal(tags)
+	if err != nil {
+		logger.Panicf("json.Marshal of wallet tags failed: %v", err)
+	}
+	w.Meta[metaTags] = string(b)
+}
+
+// Accounts returns the labels of the wallet's accounts, the label of account i is at index i-1.
+// Account 0 is the wallet's main address chain and is not included.
+func (w *Wallet) Accounts() []string {
+	v := w.Meta[metaAccounts]
+	if v == "" {
+		return nil
+	}
+
+	var labels []string
+	if err := json.Unmarshal([]byte(v), &labels); err != nil {
+		logger.WithError(err).Errorf("Invalid accounts of wallet %s", w.Filename())
+		return nil
+	}
+	return labels
+}
+
+func (w *Wallet) setAccounts(labels []string) {
+	if len(labels) == 0 {
+		delete(w.Meta, metaAccounts)
+		return
+	}
+
+	b, err := json.Marshal(labels)
+	if err != nil {
+		logger.Panicf("json.Marshal of account labels failed: %v", err)
+	}
+	w.Meta[metaAccounts] = string(b)
+}
+
+// WalletGraph is the structure of the address chains of a wallet, see Wallet.Graph
+type WalletGraph struct {
+	// Accounts are the wallet's main address chain, account 0, followed by the accounts added with NewAccount
+	Accounts []AccountGraph
+}
+
+// AccountGraph is an account of a WalletGraph with its address chain.
+// Deterministic wallets have a single chain per account, which is also used for change addresses.
+type AccountGraph struct {
+	Account uint32
+	// Label is the label of the account, empty for account 0
+	Label string
+	// Addresses are the addresses of the chain, in derivation order
+	Addresses []cipher.Addresser
+	// NextIndex is the chain index of the next address the account generates
+	NextIndex uint64
+}
+
+// Graph returns the accounts of the wallet and their address chains
+func (w *Wallet) Graph() WalletGraph {
+	labels := w.Accounts()
+	g := WalletGraph{
+		Accounts: make([]AccountGraph, len(labels)+1),
+	}
+
+	g.Accounts[0].NextIndex = w.nextIndex()
+	for i, label := range labels {
+		account := uint32(i + 1)
+		g.Accounts[account] = AccountGraph{
+			Account:   account,
+			Label:     label,
+			NextIndex: w.accountEntries(account),
+		}
+	}
+
+	for _, e := range w.Entries {
+		if int(e.Account) >= len(g.Accounts) {
+			continue
+		}
+		g.Accounts[e.Account].Addresses = append(g.Accounts[e.Account].Addresses, e.Address)
+	}
+
+	return g
+}
+
+// PendingTransactions returns the signed transactions recorded for the wallet that are not known to be confirmed,
+// in the order they were recorded
+func (w *Wallet) PendingTransactions() []*coin.Transaction {
+	v := w.Meta[metaPendingTxns]
+	if v == "" {
+		return nil
+	}
+
+	var hexTxns []string
+	if err := json.Unmarshal([]byte(v), &hexTxns); err != nil {
+		logger.WithError(err).Errorf("Invalid pending transactions of wallet %s", w.Filename())
+		return nil
+	}
+
+	txns := make([]*coin.Transaction, 0, len(hexTxns))
+	for _, h := range hexTxns {
+		txn, err := coin.DeserializeTransactionHex(h)
+		if err != nil {
+			logger.WithError(err).Errorf("Invalid pending transaction of wallet %s", w.Filename())
+			continue
+		}
+		txns = append(txns, &txn)
+	}
+	return txns
+}
+
+func (w *Wallet) setPendingTransactions(txns []*coin.Transaction) {
+	if len(txns) == 0 {
+		delete(w.Meta, metaPendingTxns)
+		return
+	}
+
+	hexTxns := make([]string, len(txns))
+	for i, txn := range txns {
+		hexTxns[i] = txn.MustSerializeHex()
+	}
+
+	b, err := json.Marshal(hexTxns)
+	if err != nil {
+		logger.Panicf("json.Marshal of pending transactions failed: %v", err)
+	}
+	w.Meta[metaPendingTxns] = string(b)
+}
+
+// AddPendingTransaction records a signed transaction as pending. A transaction that is already pending is not added again.
+// Returns ErrTransactionNotSigned if the transaction is not fully signed.
+func (w *Wallet) AddPendingTransaction(txn *coin.Transaction) error {
+	if !txn.IsFullySigned() {
+		return ErrTransactionNotSigned
+	}
+
+	if err := txn.Verify(); err != nil {
+		return err
+	}
+
+	txns := w.PendingTransactions()
+	txid := txn.Hash()
+	for _, t := range txns {
+		if t.Hash() == txid {
+			return nil
+		}
+	}
+
+	w.setPendingTransactions(append(txns, txn))
+	return nil
+}
+
+// RemovePendingTransaction removes a pending transaction, e.g. once it is confirmed.
+// Returns ErrPendingTransactionNotFound if there is no pending transaction with the given id.
+func (w *Wallet) RemovePendingTransaction(txid cipher.SHA256) error {
+	txns := w.PendingTransactions()
+	for i, t := range txns {
+		if t.Hash() == txid {
+			w.setPendingTransactions(append(txns[:i], txns[i+1:]...))
+			return nil
+		}
+	}
+
+	return ErrPendingTransactionNotFound
+}
+
+// PrunePendingTransactions removes the pending transactions for which confirmed returns true,
+// and returns the number of transactions removed
+func (w *Wallet) PrunePendingTransactions(confirmed func(txid cipher.SHA256) bool) int {
+	txns := w.PendingTransactions()
+	kept := txns[:0]
+	for _, t := range txns {
+		if !confirmed(t.Hash()) {
+			kept = append(kept, t)
+		}
+	}
+
+	n := len(txns) - len(kept)
+	if n > 0 {
+		w.setPendingTransactions(kept)
+	}
+	return n
+}
+
+// NewAccount adds an account with its own address chain derived from the wallet's seed, and returns its index.
+// The account has no addresses until GenerateAccountAddresses is called.
+func (w *Wallet) NewAccount(label string) (uint32, error) {
+	if w.IsWatchOnly() {
+		return 0, ErrWalletIsWatchOnly
+	}
+
+	if w.IsCollection() {
+		return 0, ErrWalletNotDeterministic
+	}
+
+	if w.IsEncrypted() {
+		return 0, ErrWalletEncrypted
+	}
+
+	labels := append(w.Accounts(), label)
+	w.setAccounts(labels)
+	return uint32(len(labels)), nil
+}
+
+// accountSeed returns the seed of the address chain of an account
+func (w *Wallet) accountSeed(account uint32) ([]byte, error) {
+	seed, err := w.chainSeed()
+	if err != nil {
+		return nil, err
+	}
+
+	h := cipher.SumSHA256([]byte(fmt.Sprintf("%s/account/%d", seed, account)))
+	return h[:], nil
+}
+
+// AuxDerivation returns the derivation path of the wallet's auxiliary keys, see Options.AuxDerivation
+func (w *Wallet) AuxDerivation() string {
+	return w.Meta[metaAuxDerivation]
+}
+
+// AuxKey derives the auxiliary key of given index from the seed, see Options.AuxDerivation
+func (w *Wallet) AuxKey(index uint64) (cipher.SecKey, error) {
+	if w.IsWatchOnly() {
+		return cipher.SecKey{}, ErrWalletIsWatchOnly
+	}
+
+	if w.IsCollection() {
+		return cipher.SecKey{}, ErrWalletNotDeterministic
+	}
+
+	if w.IsEncrypted() {
+		return cipher.SecKey{}, ErrWalletEncrypted
+	}
+
+	path := w.AuxDerivation()
+	if path == "" {
+		return cipher.SecKey{}, ErrNoAuxDerivation
+	}
+
+	chainSeed, err := w.chainSeed()
+	if err != nil {
+		return cipher.SecKey{}, err
+	}
+
+	seed := cipher.SumSHA256([]byte(fmt.Sprintf("%s/aux/%s/%d", chainSeed, path, index)))
+	_, sk, err := cipher.GenerateDeterministicKeyPair(seed[:])
+	return sk, err
+}
+
+// accountEntries returns the number of entries of an account, not counting imported entries
+func (w *Wallet) accountEntries(account uint32) uint64 {
+	var n uint64
+	for _, e := range w.Entries {
+		if e.Account == account && !e.Imported {
+			n++
+		}
+	}
+	return n
+}
+
+// accountSkycoinAddresses returns the addresses of an account's generated entries, in the order they were generated
+func (w *Wallet) accountSkycoinAddresses(account uint32) ([]cipher.Address, error) {
+	if w.coin() != CoinTypeSkycoin {
+		return nil, errors.New("Wallet coin type is not Skycoin")
+	}
+
+	var addrs []cipher.Address
+	for _, e := range w.Entries {
+		if e.Account == account && !e.Imported {
+			addrs = append(addrs, e.SkycoinAddress())
+		}
+	}
+	return addrs, nil
+}
+
+// GenerateAccountAddresses generates addresses in the address chain of an account.
+// Account 0 is the wallet's main chain, for which this is the same as GenerateAddresses.
+func (w *Wallet) GenerateAccountAddresses(account uint32, num uint64) ([]cipher.Addresser, error) {
+	if account == 0 {
+		return w.GenerateAddresses(num)
+	}
+
+	if account > uint32(len(w.Accounts())) {
+		return nil, ErrUnknownAccount
+	}
+
+	if w.IsWatchOnly() {
+		return nil, ErrWalletIsWatchOnly
+	}
+
+	if num == 0 {
+		return nil, nil
+	}
+
+	if w.IsEncrypted() {
+		return nil, ErrWalletEncrypted
+	}
+
+	// Derive the chain up to the new addresses, the chain state of accounts is not stored
+	n := w.accountEntries(account)
+	if w.IsBip44() {
+		addrs, _, err := w.generateBip44Addresses(account, n, num)
+		return addrs, err
+	}
+
+	seed, err := w.accountSeed(account)
+	if err != nil {
+		return nil, err
+	}
+
+	_, pubkeys, seckeys, err := w.deriveKeyPairs(seed, int(n+num))
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]cipher.Addresser, 0, num)
+	makeAddress := w.addressConstructor()
+	for i := n; i < n+num; i++ {
+		a := makeAddress(pubkeys[i])
+		addrs = append(addrs, a)
+		w.Entries = append(w.Entries, Entry{
+			Address: a,
+			Secret:  seckeys[i],
+			Public:  pubkeys[i],
+			Account: account,
+		})
+	}
+
+	return addrs, nil
+}
+
+// generateSkycoinAccountAddresses is GenerateAccountAddresses for Skycoin wallets, like GenerateSkycoinAddresses
+func (w *Wallet) generateSkycoinAccountAddresses(account uint32, num uint64) ([]cipher.Address, error) {
+	if account == 0 {
+		return w.GenerateSkycoinAddresses(num)
+	}
+
+	if w.coin() != CoinTypeSkycoin {
+		return nil, errors.New("GenerateSkycoinAddresses called for non-skycoin wallet")
+	}
+
+	addrs, err := w.GenerateAccountAddresses(account, num)
+	if err != nil {
+		return nil, err
+	}
+
+	skyAddrs := make([]cipher.Address, len(addrs))
+	for i, a := range addrs {
+		skyAddrs[i] = a.(cipher.Address)
+	}
+
+	return skyAddrs, nil
+}
+
+// regenerateAccounts regenerates the account entries of w2 in w, which has the same seed
+func (w *Wallet) regenerateAccounts(w2 *Wallet) error {
+	w.setAccounts(w2.Accounts())
+	for account := uint32(1); account <= uint32(len(w2.Accounts())); account++ {
+		if _, err := w.GenerateAccountAddresses(account, w2.accountEntries(account)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// annotationMetaFields are the meta fields holding user annotations, which can be merged between wallets
+var annotationMetaFields = []string{
+	metaLabel,
+	metaSeedPassphraseHint,
+	metaNotes,
+}
+
+// mergeMetadata copies the annotations of w2 that are empty in w: the meta fields in annotationMetaFields,
+// and the labels of the addresses that both wallets have. The tags of w2 that w doesn't have are added after
+// the tags of w. Returns whether w was changed.
+func (w *Wallet) mergeMetadata(w2 *Wallet) bool {
+	var changed bool
+	for _, k := range annotationMetaFields {
+		if w.Meta[k] != "" || w2.Meta[k] == "" {
+			continue
+		}
+		w.Meta[k] = w2.Meta[k]
+		changed = true
+	}
+
+	labels := make(map[cipher.Addresser]string, len(w2.Entries))
+	for _, e := range w2.Entries {
+		if e.Label != "" {
+			labels[e.Address] = e.Label
+		}
+	}
+	for i, e := range w.Entries {
+		if label, ok := labels[e.Address]; ok && e.Label == "" {
+			w.Entries[i].Label = label
+			changed = true
+		}
+	}
+
+	tags := w.Tags()
+	hasTag := make(map[string]struct{}, len(tags))
+	for _, t := range tags {
+		hasTag[t] = struct{}{}
+	}
+	n := len(tags)
+	for _, t := range w2.Tags() {
+		if _, ok := hasTag[t]; !ok {
+			hasTag[t] = struct{}{}
+			tags = append(tags, t)
+		}
+	}
+	if len(tags) != n {
+		w.setTags(tags)
+		changed = true
+	}
+
+	return changed
+}
+
+// IsWatchOnly checks whether the wallet is a watch-only wallet, which has addresses but no seed or secret keys
+func (w *Wallet) IsWatchOnly() bool {
+	return w.Type() == WalletTypeWatchOnly
+}
+
+// IsCollection checks whether the wallet is a collection wallet, which has imported secret keys but no seed
+func (w *Wallet) IsCollection() bool {
+	return w.Type() == WalletTypeCollection
+}
+
+// IsReadOnly checks whether the wallet is flagged as read-only
+func (w *Wallet) IsReadOnly() bool {
+	b, _ := strconv.ParseBool(w.Meta[metaReadOnly]) // nolint: errcheck
+	return b
+}
+
+func (w *Wallet) setReadOnly(readOnly bool) {
+	if !readOnly {
+		delete(w.Meta, metaReadOnly)
+		return
+	}
+	w.Meta[metaReadOnly] = strconv.FormatBool(readOnly)
+}
+
 func (w *Wallet) setCryptoType(tp CryptoType) {
 	w.Meta[metaCryptoType] = string(tp)
 }
@@ -745,8 +2100,30 @@ func (w *Wallet) setTimestamp(t int64) {
 	w.Meta[metaTimestamp] = strconv.FormatInt(t, 10)
 }
 
+// modified returns the timestamp when the wallet was last saved by the service,
+// or the creation timestamp if it was never saved by the service
+func (w *Wallet) modified() int64 {
+	x, err := strconv.ParseInt(w.Meta[metaModified], 10, 64)
+	if err != nil {
+		return w.timestamp()
+	}
+	return x
+}
+
+func (w *Wallet) setModified(t int64) {
+	w.Meta[metaModified] = strconv.FormatInt(t, 10)
+}
+
 // GenerateAddresses generates addresses
 func (w *Wallet) GenerateAddresses(num uint64) ([]cipher.Addresser, error) {
+	if w.IsWatchOnly() {
+		return nil, ErrWalletIsWatchOnly
+	}
+
+	if w.IsCollection() {
+		return nil, ErrWalletNotDeterministic
+	}
+
 	if num == 0 {
 		return nil, nil
 	}
@@ -755,35 +2132,107 @@ func (w *Wallet) GenerateAddresses(num uint64) ([]cipher.Addresser, error) {
 		return nil, ErrWalletEncrypted
 	}
 
-	var seckeys []cipher.SecKey
+	if w.IsBip44() {
+		addrs, index, err := w.generateBip44Addresses(0, w.nextIndex(), num)
+		if err != nil {
+			return nil, err
+		}
+		w.setNextIndex(index)
+		return addrs, nil
+	}
+
+	// The chain starts from the seed until the first address is generated. Imported entries are not part of the chain.
+	index := w.nextIndex()
 	var seed []byte
-	if len(w.Entries) == 0 {
-		seed, seckeys = cipher.MustGenerateDeterministicKeyPairsSeed([]byte(w.seed()), int(num))
+	if index == 0 {
+		sd, err := w.chainSeed()
+		if err != nil {
+			return nil, err
+		}
+		seed = sd
 	} else {
 		sd, err := hex.DecodeString(w.lastSeed())
 		if err != nil {
 			return nil, fmt.Errorf("decode hex seed failed: %v", err)
 		}
-		seed, seckeys = cipher.MustGenerateDeterministicKeyPairsSeed(sd, int(num))
+		seed = sd
 	}
 
-	w.setLastSeed(hex.EncodeToString(seed))
-
-	addrs := make([]cipher.Addresser, len(seckeys))
+	imported := w.importedAddresses()
+	addrs := make([]cipher.Addresser, 0, num)
 	makeAddress := w.addressConstructor()
-	for i, s := range seckeys {
-		p := cipher.MustPubKeyFromSecKey(s)
-		a := makeAddress(p)
-		addrs[i] = a
-		w.Entries = append(w.Entries, Entry{
-			Address: a,
-			Secret:  s,
-			Public:  p,
-		})
+	for uint64(len(addrs)) < num {
+		var pubkeys []cipher.PubKey
+		var seckeys []cipher.SecKey
+		var err error
+		seed, pubkeys, seckeys, err = w.deriveKeyPairs(seed, int(num-uint64(len(addrs))))
+		if err != nil {
+			return nil, err
+		}
+
+		for j, s := range seckeys {
+			i := index
+			index++
+			if w.indexFilter != nil && !w.indexFilter(i) {
+				continue
+			}
+
+			p := pubkeys[j]
+			a := makeAddress(p)
+
+			// The key was imported before the seed was attached, it is skipped like an index rejected by the filter
+			if _, ok := imported[a]; ok {
+				continue
+			}
+
+			addrs = append(addrs, a)
+			w.Entries = append(w.Entries, Entry{
+				Address: a,
+				Secret:  s,
+				Public:  p,
+			})
+		}
 	}
+
+	w.setLastSeed(hex.EncodeToString(seed))
+	w.setNextIndex(index)
+
 	return addrs, nil
 }
 
+// deriveKeyPairs derives n key pairs from the seed chain, returning the next seed in the chain
+func (w *Wallet) deriveKeyPairs(seed []byte, n int) ([]byte, []cipher.PubKey, []cipher.SecKey, error) {
+	pubkeys := make([]cipher.PubKey, n)
+	seckeys := make([]cipher.SecKey, n)
+	for i := 0; i < n; i++ {
+		if w.seedDeriver == nil {
+			next, p, s, err := cipher.DeterministicKeyPairIterator(seed)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			seed, pubkeys[i], seckeys[i] = next, p, s
+			continue
+		}
+
+		p, s, err := w.seedDeriver(seed)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		pk, err := cipher.PubKeyFromSecKey(s)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if pk != p {
+			return nil, nil, nil, ErrInvalidDerivedKeyPair
+		}
+
+		seed, pubkeys[i], seckeys[i] = secp256k1.Secp256k1Hash(seed), p, s
+	}
+
+	return seed, pubkeys, seckeys, nil
+}
+
 // GenerateSkycoinAddresses generates Skycoin addresses. If the wallet's coin type is not Skycoin, returns an error
 func (w *Wallet) GenerateSkycoinAddresses(num uint64) ([]cipher.Address, error) {
 	if w.coin() != CoinTypeSkycoin {
@@ -807,6 +2256,11 @@ func (w *Wallet) GenerateSkycoinAddresses(num uint64) ([]cipher.Address, error)
 // If any address has a nonzero balance, it rescans N more addresses from that point, until a entire
 // sequence of N addresses has no balance.
 func (w *Wallet) ScanAddresses(scanN uint64, bg BalanceGetter) (uint64, error) {
+	return w.scanAddresses(scanN, bg, nil)
+}
+
+// scanAddresses is ScanAddresses, calling progress after each scanned batch if not nil
+func (w *Wallet) scanAddresses(scanN uint64, bg BalanceGetter, progress func(scanned, found uint64)) (uint64, error) {
 	if w.IsEncrypted() {
 		return 0, ErrWalletEncrypted
 	}
@@ -817,10 +2271,11 @@ func (w *Wallet) ScanAddresses(scanN uint64, bg BalanceGetter) (uint64, error) {
 
 	w2 := w.clone()
 
-	nExistingAddrs := uint64(len(w2.Entries))
+	nExistingAddrs := w2.accountEntries(0)
 	nAddAddrs := uint64(0)
 	n := scanN
 	extraScan := uint64(0)
+	var scanned, found uint64
 
 	for {
 		// Generate the addresses to scan
@@ -835,6 +2290,16 @@ func (w *Wallet) ScanAddresses(scanN uint64, bg BalanceGetter) (uint64, error) {
 			return 0, err
 		}
 
+		if progress != nil {
+			scanned += uint64(len(bals))
+			for _, b := range bals {
+				if b.Confirmed.Coins > 0 || b.Predicted.Coins > 0 {
+					found++
+				}
+			}
+			progress(scanned, found)
+		}
+
 		// Check balance from the last one until we find the address that has coins
 		var keepNum uint64
 		for i := len(bals) - 1; i >= 0; i-- {
@@ -864,6 +2329,10 @@ func (w *Wallet) ScanAddresses(scanN uint64, bg BalanceGetter) (uint64, error) {
 	if _, err := w2.GenerateSkycoinAddresses(nExistingAddrs + nAddAddrs); err != nil {
 		return 0, err
 	}
+	if err := w2.regenerateAccounts(w); err != nil {
+		return 0, err
+	}
+	w2.copyEntryMeta(w)
 
 	*w = *w2
 
@@ -892,6 +2361,114 @@ func (w *Wallet) GetSkycoinAddresses() ([]cipher.Address, error) {
 	return addrs, nil
 }
 
+// FrozenAddresses returns the addresses that are excluded from automatic coin selection
+func (w *Wallet) FrozenAddresses() []cipher.Address {
+	var addrs []cipher.Address
+	for _, e := range w.Entries {
+		if e.Frozen {
+			addrs = append(addrs, e.SkycoinAddress())
+		}
+	}
+	return addrs
+}
+
+// SetFrozen sets whether an address is excluded from automatic coin selection
+func (w *Wallet) SetFrozen(a cipher.Address, frozen bool) error {
+	for i, e := range w.Entries {
+		if e.Address == cipher.Addresser(a) {
+			w.Entries[i].Frozen = frozen
+			return nil
+		}
+	}
+	return ErrUnknownAddress
+}
+
+// SetAddressLabel sets the label of an address, an empty label removes it
+func (w *Wallet) SetAddressLabel(a cipher.Address, label string) error {
+	for i, e := range w.Entries {
+		if e.Address == cipher.Addresser(a) {
+			w.Entries[i].Label = label
+			return nil
+		}
+	}
+	return ErrUnknownAddress
+}
+
+// SetEntryExpiry sets the time after which an imported entry is removed by Service.ReapExpired,
+// a zero time removes the expiry. The first entry of the wallet identifies it and can't expire.
+func (w *Wallet) SetEntryExpiry(a cipher.Address, at time.Time) error {
+	for i, e := range w.Entries {
+		if e.Address != cipher.Addresser(a) {
+			continue
+		}
+
+		if !e.Imported {
+			return ErrEntryNotImported
+		}
+
+		if i == 0 {
+			return ErrExpireFirstEntry
+		}
+
+		w.Entries[i].Expires = at
+		return nil
+	}
+	return ErrUnknownAddress
+}
+
+// expired returns true if the entry expired at or before now
+func (we *Entry) expired(now time.Time) bool {
+	return we.Imported && !we.Expires.IsZero() && !we.Expires.After(now)
+}
+
+// expiredAddresses returns the addresses of the entries that expired at or before now
+func (w *Wallet) expiredAddresses(now time.Time) []cipher.Address {
+	var addrs []cipher.Address
+	for _, e := range w.Entries {
+		if e.expired(now) {
+			addrs = append(addrs, e.SkycoinAddress())
+		}
+	}
+	return addrs
+}
+
+// removeExpiredEntries removes the entries of addrs that expired at or before now, with their secret keys,
+// and returns their addresses
+func (w *Wallet) removeExpiredEntries(now time.Time, addrs map[cipher.Address]struct{}) []cipher.Address {
+	var removed []cipher.Address
+	entries := w.Entries[:0]
+	for i, e := range w.Entries {
+		if _, ok := addrs[e.SkycoinAddress()]; ok && i != 0 && e.expired(now) {
+			removed = append(removed, e.SkycoinAddress())
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	// Wipe the secret keys left behind the remaining entries
+	for i := len(entries); i < len(w.Entries); i++ {
+		w.Entries[i] = Entry{}
+	}
+	w.Entries = entries
+
+	return removed
+}
+
+// copyEntryMeta copies the frozen flags and labels of the entries of w2 to the entries of w with the same address
+func (w *Wallet) copyEntryMeta(w2 *Wallet) {
+	entries := make(map[cipher.Addresser]Entry, len(w2.Entries))
+	for _, e := range w2.Entries {
+		entries[e.Address] = e
+	}
+
+	for i, e := range w.Entries {
+		if e2, ok := entries[e.Address]; ok {
+			w.Entries[i].Frozen = e2.Frozen
+			w.Entries[i].Label = e2.Label
+		}
+	}
+}
+
 // GetEntry returns entry of given address
 func (w *Wallet) GetEntry(a cipher.Address) (Entry, bool) {
 	for _, e := range w.Entries {
@@ -914,6 +2491,95 @@ func (w *Wallet) HasEntry(a cipher.Address) bool {
 	return false
 }
 
+// MessageToSign is a message to be signed with the secret key of an address, see SignMessages
+type MessageToSign struct {
+	Address cipher.Address
+	Message []byte
+}
+
+// SignMessages signs the SHA256 hash of each message with the secret key of its address.
+// The signatures are returned in the same order as the messages.
+// No message is signed if any of the addresses is not in the wallet.
+func (w *Wallet) SignMessages(items []MessageToSign) ([]cipher.Sig, error) {
+	if w.IsWatchOnly() {
+		return nil, ErrWalletIsWatchOnly
+	}
+
+	if w.IsEncrypted() {
+		return nil, ErrWalletEncrypted
+	}
+
+	keys := make(map[cipher.Address]cipher.SecKey, len(items))
+	for _, e := range w.Entries {
+		keys[e.SkycoinAddress()] = e.Secret
+	}
+
+	for _, item := range items {
+		if _, ok := keys[item.Address]; !ok {
+			return nil, ErrUnknownAddress
+		}
+	}
+
+	sigs := make([]cipher.Sig, len(items))
+	for i, item := range items {
+		sig, err := cipher.SignHash(cipher.SumSHA256(item.Message), keys[item.Address])
+		if err != nil {
+			return nil, err
+		}
+		sigs[i] = sig
+	}
+
+	return sigs, nil
+}
+
+// ImportKeys adds entries for secret keys to a collection wallet. Keys whose address is already in the wallet,
+// or repeated in keys, are skipped and their addresses are returned. The wallet is not changed if a key is invalid.
+func (w *Wallet) ImportKeys(keys []cipher.SecKey) (int, []cipher.Addresser, error) {
+	if w.IsWatchOnly() {
+		return 0, nil, ErrWalletIsWatchOnly
+	}
+
+	if !w.IsCollection() {
+		return 0, nil, ErrWalletNotCollection
+	}
+
+	if w.IsEncrypted() {
+		return 0, nil, ErrWalletEncrypted
+	}
+
+	existing := make(map[cipher.Addresser]struct{}, len(w.Entries)+len(keys))
+	for _, e := range w.Entries {
+		existing[e.Address] = struct{}{}
+	}
+
+	var entries []Entry
+	var skipped []cipher.Addresser
+	makeAddress := w.addressConstructor()
+	for i, k := range keys {
+		p, err := cipher.PubKeyFromSecKey(k)
+		if err != nil {
+			return 0, nil, NewError(fmt.Errorf("invalid secret key at index %d: %v", i, err))
+		}
+
+		a := makeAddress(p)
+		if _, ok := existing[a]; ok {
+			skipped = append(skipped, a)
+			continue
+		}
+		existing[a] = struct{}{}
+
+		entries = append(entries, Entry{
+			Address:  a,
+			Public:   p,
+			Secret:   k,
+			Imported: true,
+		})
+	}
+
+	w.Entries = append(w.Entries, entries...)
+	return len(entries), skipped, nil
+}
+
 // AddEntry adds new entry
 func (w *Wallet) AddEntry(entry Entry) error {
 	// dup check
@@ -927,6 +2593,20 @@ func (w *Wallet) AddEntry(entry Entry) error {
 	return nil
 }
 
+// checksum returns the SHA256 hash of the wallet's serialized non-secret data.
+// The wallet does not need to be decrypted.
+func (w *Wallet) checksum() (cipher.SHA256, error) {
+	rw := NewReadableWallet(w)
+	rw.Erase()
+
+	b, err := json.Marshal(rw)
+	if err != nil {
+		return cipher.SHA256{}, err
+	}
+
+	return cipher.SumSHA256(b), nil
+}
+
 // clone returns the clone of self
 func (w *Wallet) clone() *Wallet {
 	wlt := Wallet{
@@ -937,6 +2617,9 @@ func (w *Wallet) clone() *Wallet {
 	}
 
 	wlt.Entries = append(wlt.Entries, w.Entries...)
+	wlt.indexFilter = w.indexFilter
+	wlt.seedDeriver = w.seedDeriver
+	wlt.secretStore = w.secretStore
 
 	return &wlt
 }
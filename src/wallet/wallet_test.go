@@ -7,13 +7,20 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/amherag/skycoin/src/cipher"
+	"github.com/amherag/skycoin/src/cipher/bip32"
+	"github.com/amherag/skycoin/src/cipher/bip39"
 	"github.com/amherag/skycoin/src/cipher/encrypt"
+	secp256k1 "github.com/amherag/skycoin/src/cipher/secp256k1-go"
+	"github.com/amherag/skycoin/src/testutil"
 	"github.com/amherag/skycoin/src/util/logging"
 )
 
@@ -41,6 +48,10 @@ func init() {
 		P:      encrypt.ScryptP,
 		KeyLen: encrypt.ScryptKeyLen,
 	}
+	cryptoTable[CryptoTypePbkdf2Chacha20poly1305] = encrypt.Pbkdf2Chacha20poly1305{
+		Iterations: 1000,
+		KeyLen:     encrypt.Pbkdf2KeyLen,
+	}
 
 	// When -u flag is specified, update the following wallet files:
 	//     - ./testdata/scrypt-chacha20poly1305-encrypted.wlt
@@ -629,6 +640,101 @@ func TestLoadWallet(t *testing.T) {
 	}
 }
 
+func TestValidateWalletFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate-wallet-file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeFile := func(name, content string) string {
+		fn := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(fn, []byte(content), 0600))
+		return fn
+	}
+
+	test1, err := ioutil.ReadFile("./testdata/test1.wlt")
+	require.NoError(t, err)
+
+	tt := []struct {
+		name   string
+		file   string
+		expect WalletFileInfo
+		err    error
+	}{
+		{
+			name: "unencrypted v0.1",
+			file: "./testdata/test1.wlt",
+			expect: WalletFileInfo{
+				Type:         WalletTypeDeterministic,
+				Version:      "0.1",
+				Coin:         CoinTypeSkycoin,
+				AddressCount: 1,
+			},
+		},
+		{
+			name: "encrypted v0.2",
+			file: "./testdata/sha256xor-encrypted.wlt",
+			expect: WalletFileInfo{
+				Type:         WalletTypeDeterministic,
+				Version:      "0.2",
+				Coin:         CoinTypeSkycoin,
+				Encrypted:    true,
+				AddressCount: 1,
+			},
+		},
+		{
+			name: "old coin type name",
+			file: writeFile("sky.wlt", strings.Replace(string(test1), `"coin": "skycoin"`, `"coin": "sky"`, 1)),
+			expect: WalletFileInfo{
+				Type:         WalletTypeDeterministic,
+				Version:      "0.1",
+				Coin:         CoinTypeSkycoin,
+				AddressCount: 1,
+			},
+		},
+		{
+			name: "empty wallet",
+			file: "./testdata/empty_wallet/empty.wlt",
+			err:  ErrWalletEmpty,
+		},
+		{
+			name: "not json",
+			file: writeFile("corrupt.wlt", `{"meta": {`),
+			err:  NewError(errors.New("wallet file is corrupted: unexpected EOF")),
+		},
+		{
+			name: "no meta",
+			file: writeFile("nometa.wlt", `{"entries": []}`),
+			err:  NewError(errors.New("wallet file is corrupted: meta field missing")),
+		},
+		{
+			name: "unsupported version",
+			file: writeFile("version.wlt", `{"meta": {"version": "9.9"}}`),
+			err:  ErrUnsupportedWalletVersion,
+		},
+		{
+			name: "no type",
+			file: "./testdata/invalid_wallets/no_type.wlt",
+			err:  NewError(errors.New("invalid wallet no_type.wlt: type field not set")),
+		},
+		{
+			name: "not exist",
+			file: "./testdata/foo.wlt",
+			err:  errors.New("wallet ./testdata/foo.wlt doesn't exist"),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := ValidateWalletFile(tc.file)
+			require.Equal(t, tc.err, err)
+			if err != nil {
+				return
+			}
+			require.Equal(t, tc.expect, info)
+		})
+	}
+}
+
 func TestWalletGenerateAddress(t *testing.T) {
 	tt := []struct {
 		name               string
@@ -721,6 +827,377 @@ func TestWalletGenerateAddress(t *testing.T) {
 	}
 }
 
+func TestWalletGenerateAddressSeedDeriver(t *testing.T) {
+	deriver := func(seed []byte) (cipher.PubKey, cipher.SecKey, error) {
+		h := cipher.SumSHA256(seed)
+		return cipher.GenerateDeterministicKeyPair(h[:])
+	}
+
+	w, err := NewWallet("test.wlt", Options{
+		Seed:        "seed",
+		GenerateN:   2,
+		SeedDeriver: deriver,
+	})
+	require.NoError(t, err)
+	_, err = w.GenerateAddresses(1)
+	require.NoError(t, err)
+	require.Len(t, w.Entries, 3)
+
+	// The chain advances by the standard seed hash, only the key pairs differ
+	seed := []byte("seed")
+	for i, e := range w.Entries {
+		p, s, err := deriver(seed)
+		require.NoError(t, err)
+		require.Equal(t, p, e.Public, "entry %d", i)
+		require.Equal(t, s, e.Secret, "entry %d", i)
+		seed = secp256k1.Secp256k1Hash(seed)
+	}
+	require.Equal(t, hex.EncodeToString(seed), w.lastSeed())
+
+	// The default derivation is cipher.GenerateDeterministicKeyPair
+	w2, err := NewWallet("test.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 1,
+	})
+	require.NoError(t, err)
+	p, s := cipher.MustGenerateDeterministicKeyPair([]byte("seed"))
+	require.Equal(t, p, w2.Entries[0].Public)
+	require.Equal(t, s, w2.Entries[0].Secret)
+	require.NotEqual(t, w.Entries[0].Address, w2.Entries[0].Address)
+
+	// Mismatched key pairs are rejected
+	_, err = NewWallet("test.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 1,
+		SeedDeriver: func(seed []byte) (cipher.PubKey, cipher.SecKey, error) {
+			_, s, err := deriver(seed)
+			return p, s, err
+		},
+	})
+	require.Equal(t, ErrInvalidDerivedKeyPair, err)
+
+	// Deriver errors are returned
+	derr := errors.New("derive failed")
+	_, err = NewWallet("test.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 1,
+		SeedDeriver: func(seed []byte) (cipher.PubKey, cipher.SecKey, error) {
+			return cipher.PubKey{}, cipher.SecKey{}, derr
+		},
+	})
+	require.Equal(t, derr, err)
+}
+
+func TestWalletFrozenAddresses(t *testing.T) {
+	w, err := NewWallet("test.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 3,
+	})
+	require.NoError(t, err)
+	require.Empty(t, w.FrozenAddresses())
+
+	addrs, err := w.GetSkycoinAddresses()
+	require.NoError(t, err)
+	require.NoError(t, w.SetFrozen(addrs[1], true))
+	require.NoError(t, w.SetFrozen(addrs[2], true))
+	require.Equal(t, []cipher.Address{addrs[1], addrs[2]}, w.FrozenAddresses())
+	require.NoError(t, w.SetFrozen(addrs[2], false))
+	require.Equal(t, []cipher.Address{addrs[1]}, w.FrozenAddresses())
+
+	require.Equal(t, ErrUnknownAddress, w.SetFrozen(testutil.MakeAddress(), true))
+
+	// The flag is kept by clones, saved to disk and kept by address scans
+	require.Equal(t, []cipher.Address{addrs[1]}, w.clone().FrozenAddresses())
+
+	dir := prepareWltDir()
+	require.NoError(t, w.Save(dir))
+	w2, err := Load(filepath.Join(dir, "test.wlt"))
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Address{addrs[1]}, w2.FrozenAddresses())
+
+	_, keys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte("seed"), 4)
+	bg := mockBalanceGetter{
+		cipher.MustAddressFromSecKey(keys[3]): BalancePair{Confirmed: NewBalance(1, 1)},
+	}
+	n, err := w2.ScanAddresses(2, bg)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), n)
+	require.Equal(t, []cipher.Address{addrs[1]}, w2.FrozenAddresses())
+
+	// Unfrozen entries are saved without the flag
+	b, err := ioutil.ReadFile(filepath.Join(dir, "test.wlt"))
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(b), `"frozen"`))
+}
+
+func TestWalletAccounts(t *testing.T) {
+	w, err := NewWallet("test.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 2,
+	})
+	require.NoError(t, err)
+	require.Empty(t, w.Accounts())
+
+	account, err := w.NewAccount("savings")
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), account)
+	account, err = w.NewAccount("")
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), account)
+	require.Equal(t, []string{"savings", ""}, w.Accounts())
+
+	// Each account has its own chain, derived from the wallet seed and the account index
+	h := cipher.SumSHA256([]byte("seed/account/1"))
+	_, accountKeys := cipher.MustGenerateDeterministicKeyPairsSeed(h[:], 3)
+	_, mainKeys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte("seed"), 3)
+
+	addrs, err := w.GenerateAccountAddresses(1, 2)
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Addresser{
+		cipher.MustAddressFromSecKey(accountKeys[0]),
+		cipher.MustAddressFromSecKey(accountKeys[1]),
+	}, addrs)
+
+	// The main chain continues after the account entries
+	addrs, err = w.GenerateAddresses(1)
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Addresser{cipher.MustAddressFromSecKey(mainKeys[2])}, addrs)
+	require.Equal(t, uint64(3), w.nextIndex())
+	_, ok := w.Meta[metaNextIndex]
+	require.False(t, ok)
+
+	addrs, err = w.GenerateAccountAddresses(1, 1)
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Addresser{cipher.MustAddressFromSecKey(accountKeys[2])}, addrs)
+
+	require.Len(t, w.Entries, 6)
+	for i, account := range []uint32{0, 0, 1, 1, 0, 1} {
+		require.Equal(t, account, w.Entries[i].Account)
+		require.NoError(t, w.Entries[i].Verify())
+	}
+
+	addrs, err = w.GenerateAccountAddresses(2, 0)
+	require.NoError(t, err)
+	require.Empty(t, addrs)
+	_, err = w.GenerateAccountAddresses(3, 1)
+	require.Equal(t, ErrUnknownAccount, err)
+
+	// The accounts are saved to disk and kept by clones, encryption and address scans
+	require.Equal(t, w, w.clone())
+
+	dir := prepareWltDir()
+	require.NoError(t, w.Save(dir))
+	w2, err := Load(filepath.Join(dir, "test.wlt"))
+	require.NoError(t, err)
+	require.Equal(t, w.Entries, w2.Entries)
+	require.Equal(t, w.Accounts(), w2.Accounts())
+
+	w3 := w.clone()
+	require.NoError(t, w3.Lock([]byte("pwd"), CryptoTypeSha256Xor))
+	_, err = w3.NewAccount("foo")
+	require.Equal(t, ErrWalletEncrypted, err)
+	_, err = w3.GenerateAccountAddresses(1, 1)
+	require.Equal(t, ErrWalletEncrypted, err)
+	w3, err = w3.Unlock([]byte("pwd"))
+	require.NoError(t, err)
+	require.Equal(t, w.Entries, w3.Entries)
+
+	require.NoError(t, w2.SetFrozen(w.Entries[3].SkycoinAddress(), true))
+	_, mainKeys = cipher.MustGenerateDeterministicKeyPairsSeed([]byte("seed"), 5)
+	bg := mockBalanceGetter{
+		cipher.MustAddressFromSecKey(mainKeys[4]): BalancePair{Confirmed: NewBalance(1, 1)},
+	}
+	n, err := w2.ScanAddresses(2, bg)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), n)
+	require.Len(t, w2.Entries, 8)
+	require.Equal(t, uint64(5), w2.accountEntries(0))
+	require.Equal(t, uint64(3), w2.accountEntries(1))
+	require.Equal(t, []cipher.Address{w.Entries[3].SkycoinAddress()}, w2.FrozenAddresses())
+	for _, e := range w.Entries {
+		require.True(t, w2.HasEntry(e.SkycoinAddress()))
+	}
+}
+
+func TestWalletBip44(t *testing.T) {
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+	_, err := NewWallet("test.wlt", Options{Seed: mnemonic, Bip44: true})
+	require.Equal(t, ErrBip44NotBip39, err)
+	_, err = NewWallet("test.wlt", Options{Seed: mnemonic, Bip39: true, Bip44: true, Coin: CoinTypeBitcoin})
+	require.Equal(t, ErrBip44Coin, err)
+	_, err = NewWallet("test.wlt", Options{
+		Seed:  mnemonic,
+		Bip39: true,
+		Bip44: true,
+		SeedDeriver: func(seed []byte) (cipher.PubKey, cipher.SecKey, error) {
+			return cipher.GenerateDeterministicKeyPair(seed)
+		},
+	})
+	require.Equal(t, ErrBip44SeedDeriver, err)
+
+	w, err := NewWallet("test.wlt", Options{
+		Seed:      mnemonic,
+		Bip39:     true,
+		Bip44:     true,
+		GenerateN: 2,
+	})
+	require.NoError(t, err)
+	require.True(t, w.IsBip44())
+
+	// The addresses are the receive chain m/44'/8000'/account'/0/i of the BIP39 seed
+	seed, err := bip39.NewSeed(mnemonic, "")
+	require.NoError(t, err)
+	bip44Address := func(account, i uint32) cipher.Addresser {
+		k, err := bip32.NewPrivateKeyFromPath(seed, fmt.Sprintf("m/44'/8000'/%d'/0/%d", account, i))
+		require.NoError(t, err)
+		return cipher.MustAddressFromSecKey(cipher.MustNewSecKey(k.Key))
+	}
+
+	account, err := w.NewAccount("savings")
+	require.NoError(t, err)
+	addrs, err := w.GenerateAccountAddresses(account, 2)
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Addresser{bip44Address(1, 0), bip44Address(1, 1)}, addrs)
+
+	addrs, err = w.GenerateAddresses(1)
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Addresser{bip44Address(0, 2)}, addrs)
+	for i, e := range w.Entries[:2] {
+		require.Equal(t, bip44Address(0, uint32(i)), e.Address)
+	}
+	for _, e := range w.Entries {
+		require.NoError(t, e.Verify())
+	}
+
+	p, err := w.DerivationPath()
+	require.NoError(t, err)
+	require.Equal(t, "m/44'/8000'/0'/0", p)
+
+	k, err := bip32.NewPrivateKeyFromPath(seed, "m/44'/8000'/0'")
+	require.NoError(t, err)
+	require.Equal(t, k.PublicKey().String(), w.XPub())
+
+	// The extended public key is kept when the wallet is encrypted, and the wallet continues its chain when unlocked
+	w2 := w.clone()
+	require.NoError(t, w2.Lock([]byte("pwd"), CryptoTypeSha256Xor))
+	require.Equal(t, w.XPub(), w2.XPub())
+	w2, err = w2.Unlock([]byte("pwd"))
+	require.NoError(t, err)
+	addrs, err = w2.GenerateAddresses(1)
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Addresser{bip44Address(0, 3)}, addrs)
+
+	dir := prepareWltDir()
+	require.NoError(t, w.Save(dir))
+	w3, err := Load(filepath.Join(dir, "test.wlt"))
+	require.NoError(t, err)
+	require.Equal(t, w.Entries, w3.Entries)
+	require.True(t, w3.IsBip44())
+	require.False(t, w3.IsHardened())
+
+	// Hardened derivation hardens the change and address levels, and is saved in the wallet
+	_, err = NewWallet("test.wlt", Options{Seed: mnemonic, Bip39: true, Hardened: true})
+	require.Equal(t, ErrHardenedNotBip44, err)
+
+	w, err = NewWallet("test.wlt", Options{
+		Seed:      mnemonic,
+		Bip39:     true,
+		Bip44:     true,
+		Hardened:  true,
+		GenerateN: 2,
+	})
+	require.NoError(t, err)
+	require.True(t, w.IsHardened())
+	for i, e := range w.Entries {
+		k, err := bip32.NewPrivateKeyFromPath(seed, fmt.Sprintf("m/44'/8000'/0'/0'/%d'", i))
+		require.NoError(t, err)
+		require.Equal(t, cipher.MustAddressFromSecKey(cipher.MustNewSecKey(k.Key)), e.Address)
+		require.NotEqual(t, bip44Address(0, uint32(i)), e.Address)
+	}
+
+	p, err = w.DerivationPath()
+	require.NoError(t, err)
+	require.Equal(t, "m/44'/8000'/0'/0'", p)
+
+	_, err = w.hwWatchOnly()
+	require.Equal(t, ErrHardenedDerivation, err)
+
+	require.NoError(t, w.Save(dir))
+	w3, err = Load(filepath.Join(dir, "test.wlt"))
+	require.NoError(t, err)
+	require.True(t, w3.IsHardened())
+	addrs, err = w3.GenerateAddresses(1)
+	require.NoError(t, err)
+	k, err = bip32.NewPrivateKeyFromPath(seed, "m/44'/8000'/0'/0'/2'")
+	require.NoError(t, err)
+	require.Equal(t, []cipher.Addresser{cipher.MustAddressFromSecKey(cipher.MustNewSecKey(k.Key))}, addrs)
+}
+
+func TestWalletGenerateAddressIndexFilter(t *testing.T) {
+	skipOdd := func(i uint64) bool {
+		return i%2 == 0
+	}
+
+	w, err := NewWallet("test.wlt", Options{
+		Seed:        "seed",
+		GenerateN:   2,
+		IndexFilter: skipOdd,
+	})
+	require.NoError(t, err)
+
+	// Generate more addresses, one batch at a time
+	_, err = w.GenerateAddresses(1)
+	require.NoError(t, err)
+	addrs, err := w.GenerateAddresses(2)
+	require.NoError(t, err)
+	require.Len(t, addrs, 2)
+
+	require.Len(t, w.Entries, 5)
+	require.Equal(t, uint64(9), w.nextIndex())
+	require.Equal(t, "9", w.Meta[metaNextIndex])
+	require.NoError(t, w.Validate())
+
+	_, keys := cipher.MustGenerateDeterministicKeyPairsSeed([]byte("seed"), 10)
+	for i, e := range w.Entries {
+		require.Equal(t, cipher.MustAddressFromSecKey(keys[i*2]), e.Address)
+	}
+
+	// A wallet reloaded without the filter resumes at the persisted chain index
+	dir := prepareWltDir()
+	require.NoError(t, w.Save(dir))
+	w2, err := Load(filepath.Join(dir, "test.wlt"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), w2.nextIndex())
+
+	_, err = w2.GenerateAddresses(1)
+	require.NoError(t, err)
+	require.Len(t, w2.Entries, 6)
+	require.Equal(t, cipher.MustAddressFromSecKey(keys[9]), w2.Entries[5].Address)
+	_, ok := w2.Meta[metaNextIndex]
+	require.True(t, ok)
+
+	// Resetting the wallet and regenerating with the filter reproduces the entries
+	w3 := w.clone()
+	w3.reset()
+	_, ok = w3.Meta[metaNextIndex]
+	require.False(t, ok)
+	_, err = w3.GenerateAddresses(5)
+	require.NoError(t, err)
+	require.Equal(t, w.Entries, w3.Entries)
+	require.Equal(t, w.lastSeed(), w3.lastSeed())
+
+	// Without skipped indexes the chain index is not stored
+	w4, err := NewWallet("test.wlt", Options{
+		Seed:      "seed",
+		GenerateN: 3,
+	})
+	require.NoError(t, err)
+	_, ok = w4.Meta[metaNextIndex]
+	require.False(t, ok)
+	require.Equal(t, uint64(3), w4.nextIndex())
+}
+
 func TestWalletGetEntry(t *testing.T) {
 	tt := []struct {
 		name    string
@@ -1135,7 +1612,7 @@ func TestRemoveBackupFiles(t *testing.T) {
 				require.NoError(t, w.Save(dir))
 			}
 
-			require.NoError(t, removeBackupFiles(dir))
+			require.NoError(t, removeBackupFiles(dir, 0))
 
 			// Get all remaining files
 			fs, err := ioutil.ReadDir(dir)
@@ -1149,6 +1626,65 @@ func TestRemoveBackupFiles(t *testing.T) {
 	}
 }
 
+func TestRemoveBackupFilesKeepBackups(t *testing.T) {
+	dir := prepareWltDir()
+
+	save := func(name, label string) {
+		w, err := NewWallet(name, Options{
+			Seed:  "s1",
+			Label: label,
+		})
+		require.NoError(t, err)
+		w.setVersion("0.1")
+		require.NoError(t, w.Save(dir))
+	}
+
+	label := func(name string) string {
+		w, err := Load(filepath.Join(dir, name))
+		require.NoError(t, err)
+		return w.Label()
+	}
+
+	save("t1.wlt", "")
+	save("t2.wlt", "")
+	save("t2.wlt.bak", "bak")
+
+	// Each run rotates the current backup
+	for i := 0; i < 4; i++ {
+		save("t1.wlt.bak", fmt.Sprint(i))
+		require.NoError(t, removeBackupFiles(dir, 3))
+	}
+
+	fs, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, f := range fs {
+		names = append(names, f.Name())
+	}
+	require.Equal(t, []string{
+		"t1.wlt",
+		"t1.wlt.bak.1",
+		"t1.wlt.bak.2",
+		"t1.wlt.bak.3",
+		"t2.wlt",
+		"t2.wlt.bak.1",
+	}, names)
+
+	require.Equal(t, "3", label("t1.wlt.bak.1"))
+	require.Equal(t, "2", label("t1.wlt.bak.2"))
+	require.Equal(t, "1", label("t1.wlt.bak.3"))
+	require.Equal(t, "bak", label("t2.wlt.bak.1"))
+
+	// Reducing the number of backups prunes the oldest ones
+	save("t1.wlt.bak", "4")
+	require.NoError(t, removeBackupFiles(dir, 1))
+	_, err = os.Stat(filepath.Join(dir, "t1.wlt.bak.2"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "t1.wlt.bak.3"))
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, "4", label("t1.wlt.bak.1"))
+}
+
 func TestWalletValidate(t *testing.T) {
 	goodMetaUnencrypted := map[string]string{
 		"filename":  "foo.wlt",
@@ -1270,3 +1806,44 @@ func TestWalletValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateTestWallets(t *testing.T) {
+	opts := Options{
+		Label:     "load",
+		Seed:      "corpus",
+		GenerateN: 3,
+	}
+
+	wlts, err := GenerateTestWallets(5, opts)
+	require.NoError(t, err)
+	require.Len(t, wlts, 5)
+
+	seeds := make(map[string]struct{})
+	for i := 0; i < 5; i++ {
+		w, ok := wlts[fmt.Sprintf("test_%04d.wlt", i)]
+		require.True(t, ok)
+		require.Equal(t, fmt.Sprintf("load %d", i), w.Label())
+		require.Len(t, w.Entries, 3)
+		require.NoError(t, w.Validate())
+		seeds[w.seed()] = struct{}{}
+	}
+	require.Len(t, seeds, 5)
+
+	// The corpus is reproducible
+	wlts2, err := GenerateTestWallets(5, opts)
+	require.NoError(t, err)
+	require.Equal(t, wlts, wlts2)
+
+	// A different seed produces a different corpus
+	opts.Seed = "other"
+	wlts3, err := GenerateTestWallets(5, opts)
+	require.NoError(t, err)
+	require.NotEqual(t, wlts["test_0000.wlt"].seed(), wlts3["test_0000.wlt"].seed())
+
+	wlts, err = GenerateTestWallets(0, opts)
+	require.NoError(t, err)
+	require.Empty(t, wlts)
+
+	_, err = GenerateTestWallets(-1, opts)
+	require.Error(t, err)
+}
@@ -0,0 +1,42 @@
+package wallet
+
+//go:generate protoc --go_out=paths=source_relative:. wallet.proto
+
+// NewWalletProto creates the protobuf message of a wallet.
+// The seeds and secret keys are only included if includeSecrets is set and the wallet is not encrypted.
+func NewWalletProto(w *Wallet, includeSecrets bool) *WalletProto {
+	includeSecrets = includeSecrets && !w.IsEncrypted()
+
+	m := &WalletProto{
+		Filename:   w.Filename(),
+		Version:    w.Version(),
+		Label:      w.Label(),
+		Coin:       string(w.coin()),
+		Type:       w.Type(),
+		CryptoType: w.Meta[metaCryptoType],
+		Encrypted:  w.IsEncrypted(),
+		StableId:   w.StableID(),
+		Entries:    make([]*EntryProto, len(w.Entries)),
+	}
+
+	if includeSecrets {
+		m.Seed = w.seed()
+		m.LastSeed = w.lastSeed()
+	}
+
+	for i, e := range w.Entries {
+		re := NewReadableEntry(w.coin(), e)
+		m.Entries[i] = &EntryProto{
+			Address:   re.Address,
+			PublicKey: re.Public,
+			Frozen:    re.Frozen,
+			Account:   re.Account,
+			Label:     re.Label,
+		}
+		if includeSecrets {
+			m.Entries[i].SecretKey = re.Secret
+		}
+	}
+
+	return m
+}
@@ -1,13 +1,20 @@
 package wallet
 
 import (
+	"container/list"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/amherag/skycoin/src/cipher"
+	"github.com/amherag/skycoin/src/util/file"
 )
 
 // Wallets wallets map
@@ -50,13 +57,7 @@ func loadWallet(fn string) (*Wallet, error) {
 		return nil, err
 	}
 
-	// Normalize coin types (older wallets used different names for the coin type)
-	switch strings.ToLower(rw.Meta[metaCoin]) {
-	case "sky", "skycoin":
-		rw.Meta[metaCoin] = string(CoinTypeSkycoin)
-	case "btc", "bitcoin":
-		rw.Meta[metaCoin] = string(CoinTypeBitcoin)
-	}
+	rw.normalizeCoinType()
 
 	w, err := rw.ToWallet()
 	if err != nil {
@@ -120,6 +121,52 @@ func (wlts Wallets) ToReadable() []*ReadableWallet {
 	return rw
 }
 
+// testWalletsEpoch is the creation timestamp of the first wallet generated by GenerateTestWallets
+const testWalletsEpoch = 1514764800
+
+// GenerateTestWallets deterministically generates n wallets for load testing and benchmarks.
+// The wallets are named test_0000.wlt, test_0001.wlt, etc. and are labeled with opts.Label
+// followed by their number. Their seeds are drawn from a RNG seeded with opts.Seed, so the same
+// n and opts always produce the same corpus. The remaining options are applied to every wallet,
+// e.g. opts.GenerateN sets the number of addresses of each wallet.
+// The generated seeds are not secure and must not be used to hold real coins.
+func GenerateTestWallets(n int, opts Options) (Wallets, error) {
+	if n < 0 {
+		return nil, errors.New("number of wallets must not be negative")
+	}
+
+	h := cipher.SumSHA256([]byte(opts.Seed))
+	rng := rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(h[:8]))))     // nolint: gosec
+	idRng := rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(h[8:16])))) // nolint: gosec
+
+	wlts := make(Wallets, n)
+	for i := 0; i < n; i++ {
+		seed := make([]byte, 32)
+		rng.Read(seed) // nolint: errcheck
+
+		o := opts
+		o.Seed = hex.EncodeToString(seed)
+		o.Label = strings.TrimSpace(fmt.Sprintf("%s %d", opts.Label, i))
+
+		w, err := NewWallet(fmt.Sprintf("test_%04d.%s", i, WalletExt), o)
+		if err != nil {
+			return nil, err
+		}
+
+		w.setTimestamp(testWalletsEpoch + int64(i))
+
+		id := make([]byte, 16)
+		idRng.Read(id) // nolint: errcheck
+		w.setStableID(formatStableID(id))
+
+		if err := wlts.add(w); err != nil {
+			return nil, err
+		}
+	}
+
+	return wlts, nil
+}
+
 // containsDuplicate returns true if there is a duplicate wallet
 // (identified by the first address in the wallet) and return the ID of that wallet
 // and the first address if true
@@ -140,12 +187,300 @@ func (wlts Wallets) containsDuplicate() (string, cipher.Address, bool) {
 	return "", cipher.Address{}, false
 }
 
-// containsEmpty returns true there is an empty wallet and the ID of that wallet if true
-func (wlts Wallets) containsEmpty() (string, bool) {
+// lazyWallet is a wallet file that has been indexed, but is only parsed when first accessed
+type lazyWallet struct {
+	sync.Mutex
+	path      string
+	firstAddr string
+	addrs     []string // addresses in the wallet file when it was indexed
+	label     string
+	coin      CoinType
+	stableID  string
+	w         *Wallet
+}
+
+// load parses the wallet file on first call and returns the cached wallet afterwards
+func (lw *lazyWallet) load() (*Wallet, error) {
+	lw.Lock()
+	defer lw.Unlock()
+
+	if lw.w == nil {
+		w, err := loadWallet(lw.path)
+		if err != nil {
+			return nil, err
+		}
+		lw.w = w
+	}
+
+	return lw.w, nil
+}
+
+// set replaces the parsed wallet, after the wallet file was saved
+func (lw *lazyWallet) set(w *Wallet) {
+	lw.Lock()
+	defer lw.Unlock()
+
+	lw.w = w
+	lw.firstAddr = ""
+	if len(w.Entries) > 0 {
+		lw.firstAddr = w.Entries[0].Address.String()
+	}
+	lw.label = w.Label()
+	lw.coin = w.coin()
+	lw.stableID = w.StableID()
+}
+
+// unload drops the parsed wallet, the wallet file is parsed again on the next call to load
+func (lw *lazyWallet) unload() {
+	lw.Lock()
+	defer lw.Unlock()
+
+	lw.w = nil
+}
+
+// walletCache tracks the least recently used lazily loaded wallets, see Config.MaxCachedWallets.
+// A nil *walletCache doesn't evict any wallet.
+type walletCache struct {
+	sync.Mutex
+	max     int
+	lru     *list.List // wallet ids, most recently used first
+	elems   map[string]*list.Element
+	evicted map[string]struct{} // ids of the wallets evicted from the cache that are still in memory, see drain
+}
+
+func newWalletCache(max int) *walletCache {
+	if max <= 0 {
+		return nil
+	}
+
+	return &walletCache{
+		max:     max,
+		lru:     list.New(),
+		elems:   make(map[string]*list.Element),
+		evicted: make(map[string]struct{}),
+	}
+}
+
+// touch marks the wallet as the most recently used. The wallets evicted from the cache are queued
+// until drain is called, so that touch can be called with the service read lock held.
+func (c *walletCache) touch(wltID string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.evicted, wltID)
+
+	if e, ok := c.elems[wltID]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+
+	c.elems[wltID] = c.lru.PushFront(wltID)
+
+	for c.lru.Len() > c.max {
+		e := c.lru.Back()
+		id := c.lru.Remove(e).(string)
+		delete(c.elems, id)
+		c.evicted[id] = struct{}{}
+	}
+}
+
+// hasEvicted returns true if wallets were evicted from the cache since the last call to drain
+func (c *walletCache) hasEvicted() bool {
+	if c == nil {
+		return false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return len(c.evicted) != 0
+}
+
+// drain returns and forgets the ids of the wallets evicted from the cache since the last call
+func (c *walletCache) drain() []string {
+	if c == nil {
+		return nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	ids := make([]string, 0, len(c.evicted))
+	for id := range c.evicted {
+		ids = append(ids, id)
+	}
+	c.evicted = make(map[string]struct{})
+
+	return ids
+}
+
+// remove removes the wallet from the cache
+func (c *walletCache) remove(wltID string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.elems[wltID]; ok {
+		c.lru.Remove(e)
+		delete(c.elems, wltID)
+	}
+	delete(c.evicted, wltID)
+}
+
+// lazyWallets lazily loaded wallets map
+type lazyWallets map[string]*lazyWallet
+
+// walletIndex contains the minimal wallet data that is read when indexing a wallet file
+type walletIndex struct {
+	Meta struct {
+		Label    string `json:"label"`
+		Coin     string `json:"coin"`
+		StableID string `json:"stableID"`
+	} `json:"meta"`
+	Entries []struct {
+		Address string `json:"address"`
+	} `json:"entries"`
+}
+
+// indexWallets indexes all wallets contained in wallet dir by filename, first address, label, coin type and stable id,
+// without parsing and verifying the wallet files.
+// Only files with extension WalletExt are considered.
+func indexWallets(dir string) (lazyWallets, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	wallets := lazyWallets{}
+	for _, e := range entries {
+		if e.Mode().IsRegular() {
+			name := e.Name()
+			if !strings.HasSuffix(name, WalletExt) {
+				continue
+			}
+
+			fullpath := filepath.Join(dir, name)
+			var wi walletIndex
+			if err := file.LoadJSON(fullpath, &wi); err != nil {
+				return nil, fmt.Errorf("load wallet %s failed: %v", fullpath, err)
+			}
+
+			lw := &lazyWallet{
+				path:     fullpath,
+				label:    wi.Meta.Label,
+				coin:     normalizeCoinType(wi.Meta.Coin),
+				stableID: wi.Meta.StableID,
+			}
+			if len(wi.Entries) > 0 {
+				lw.firstAddr = wi.Entries[0].Address
+			}
+			for _, e := range wi.Entries {
+				lw.addrs = append(lw.addrs, e.Address)
+			}
+
+			wallets[name] = lw
+		}
+	}
+	return wallets, nil
+}
+
+// containsDuplicate returns true if there is a duplicate wallet
+// (identified by the first address in the wallet) and return the ID of that wallet
+// and the first address if true
+func (wlts lazyWallets) containsDuplicate() (string, string, bool) {
+	m := make(map[string]struct{}, len(wlts))
 	for wltID, wlt := range wlts {
-		if len(wlt.Entries) == 0 {
-			return wltID, true
+		if wlt.firstAddr == "" {
+			continue
+		}
+		if _, ok := m[wlt.firstAddr]; ok {
+			return wltID, wlt.firstAddr, true
+		}
+
+		m[wlt.firstAddr] = struct{}{}
+	}
+
+	return "", "", false
+}
+
+// walletSummary contains the wallet data that is read to pick which of duplicate wallet files to keep
+type walletSummary struct {
+	Meta    map[string]string `json:"meta"`
+	Entries []struct {
+		Address string `json:"address"`
+	} `json:"entries"`
+}
+
+// richness returns the number of addresses and the number of set metadata fields of the wallet
+func (ws walletSummary) richness() (int, int) {
+	n := 0
+	for _, v := range ws.Meta {
+		if v != "" {
+			n++
 		}
 	}
-	return "", false
+	return len(ws.Entries), n
+}
+
+// findDuplicateWallets finds the wallet files in dir that share their first address with another wallet file.
+// Of each set of duplicates, the wallet with the most addresses is kept, then the one with the most metadata,
+// then the one with the lowest filename. Returns the filenames of the wallets to drop by the filename of the wallet kept.
+// Only files with extension WalletExt are considered.
+func findDuplicateWallets(dir string) (map[string][]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byAddr := make(map[string][]string)
+	summaries := make(map[string]walletSummary)
+	for _, e := range entries {
+		if !e.Mode().IsRegular() || !strings.HasSuffix(e.Name(), WalletExt) {
+			continue
+		}
+
+		fullpath := filepath.Join(dir, e.Name())
+		var ws walletSummary
+		if err := file.LoadJSON(fullpath, &ws); err != nil {
+			return nil, fmt.Errorf("load wallet %s failed: %v", fullpath, err)
+		}
+
+		if len(ws.Entries) == 0 {
+			continue
+		}
+
+		addr := ws.Entries[0].Address
+		byAddr[addr] = append(byAddr[addr], e.Name())
+		summaries[e.Name()] = ws
+	}
+
+	dups := make(map[string][]string)
+	for _, names := range byAddr {
+		if len(names) < 2 {
+			continue
+		}
+
+		sort.Slice(names, func(i, j int) bool {
+			ei, mi := summaries[names[i]].richness()
+			ej, mj := summaries[names[j]].richness()
+			if ei != ej {
+				return ei > ej
+			}
+			if mi != mj {
+				return mi > mj
+			}
+			return names[i] < names[j]
+		})
+
+		dups[names[0]] = names[1:]
+	}
+
+	return dups, nil
 }